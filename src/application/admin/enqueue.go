@@ -0,0 +1,88 @@
+package admin
+
+import (
+	"chord-paper-be-workers/src/application/jobs/start"
+	"chord-paper-be-workers/src/application/outbox"
+	"chord-paper-be-workers/src/application/resume"
+	"chord-paper-be-workers/src/application/tracks/entity"
+	"chord-paper-be-workers/src/lib/cerr"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/streadway/amqp"
+)
+
+// ErrNotResumable is behind the errors of tracks that can't take a job in the state they're in, e.g. still processing
+var ErrNotResumable = errors.New("Track can't take the job in its current state")
+
+// Enqueue puts an errored track back into processing and hands its job to the outbox in the same write,
+// so the job can't get lost between the two. Finished tracks can only be reopened when the job picks up
+// from stored artifacts, any other job would expect state that they no longer have
+func Enqueue(ctx context.Context, trackStore entity.TrackStore, jobs outbox.Outbox, tracklistID string, trackID string, publishing amqp.Publishing, reopen bool) error {
+	stage := publishing.Type
+
+	updater := func(track entity.Track) (entity.Track, error) {
+		splitStemTrack, err := resumableTrack(track, reopen)
+		if err != nil {
+			return entity.BaseTrack{}, err
+		}
+
+		// the start job only picks up requested tracks, the later stages expect it to have done so
+		if stage == start.JobType {
+			splitStemTrack.JobStatus = entity.RequestedStatus
+			splitStemTrack.JobStatusMessage = RetryStatusMessage
+			splitStemTrack.JobProgress = 0
+		} else {
+			splitStemTrack.JobStatus = entity.ProcessingStatus
+			splitStemTrack.JobStatusMessage = fmt.Sprintf("Resuming from the %s stage", stage)
+		}
+
+		splitStemTrack.JobStatusDebugLog = ""
+		splitStemTrack.JobLease = entity.JobLease{}
+		splitStemTrack.Outbox = append(splitStemTrack.Outbox, outbox.NewMessage(publishing))
+
+		return splitStemTrack, nil
+	}
+
+	if err := trackStore.UpdateTrack(ctx, tracklistID, trackID, updater); err != nil {
+		return cerr.Field("tracklist_id", tracklistID).
+			Field("track_id", trackID).
+			Wrap(err).Error("Failed to update track")
+	}
+
+	// the relay publishes it later if we can't right now
+	if err := jobs.Flush(ctx, tracklistID, trackID); err != nil {
+		cerr.LogContext(ctx, cerr.Field("job_type", stage).Wrap(err).Error("Failed to publish job, leaving it to the outbox relay"))
+	}
+
+	return nil
+}
+
+func resumableTrack(track entity.Track, reopen bool) (entity.SplitStemTrack, error) {
+	switch typedTrack := track.(type) {
+	case entity.SplitStemTrack:
+		if typedTrack.JobStatus != entity.ErrorStatus {
+			return entity.SplitStemTrack{}, cerr.Field("status", typedTrack.JobStatus).
+				Wrap(ErrNotResumable).Error("Only errored tracks can be retried or resumed")
+		}
+
+		return typedTrack, nil
+
+	case entity.StemTrack:
+		if !reopen {
+			return entity.SplitStemTrack{}, cerr.Wrap(ErrNotResumable).Error("Finished tracks can only be resumed from stored artifacts")
+		}
+
+		reopened, err := resume.Reopen(typedTrack)
+		if err != nil {
+			return entity.SplitStemTrack{}, cerr.Field("reason", err.Error()).
+				Wrap(ErrNotResumable).Error("Finished track can't be reopened")
+		}
+
+		return reopened, nil
+
+	default:
+		return entity.SplitStemTrack{}, cerr.Wrap(ErrNotResumable).Error("Track is not a split stem track")
+	}
+}
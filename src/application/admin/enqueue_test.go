@@ -0,0 +1,95 @@
+package admin_test
+
+import (
+	"chord-paper-be-workers/src/application/admin"
+	"chord-paper-be-workers/src/application/integration_test/dummy"
+	"chord-paper-be-workers/src/application/jobs/split"
+	"chord-paper-be-workers/src/application/outbox"
+	"chord-paper-be-workers/src/application/tracks/entity"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Enqueue", func() {
+	var (
+		trackStore *dummy.TrackStore
+		rabbitMQ   *dummy.RabbitMQ
+		jobs       outbox.Outbox
+		track      entity.SplitStemTrack
+		publishing amqp.Publishing
+
+		getTrack = func() entity.SplitStemTrack {
+			stored, err := trackStore.GetTrack(context.Background(), "tracklist-1", "track-1")
+			Expect(err).NotTo(HaveOccurred())
+			return stored.(entity.SplitStemTrack)
+		}
+	)
+
+	BeforeEach(func() {
+		trackStore = dummy.NewDummyTrackStore()
+		rabbitMQ = dummy.NewRabbitMQ()
+		jobs = outbox.NewOutbox(trackStore, rabbitMQ)
+
+		track = entity.SplitStemTrack{
+			BaseTrack:         entity.BaseTrack{TrackType: entity.SplitFourStemsType},
+			JobStatus:         entity.ErrorStatus,
+			JobStatusMessage:  split.ErrorMessage,
+			JobStatusDebugLog: `{"error":"spleeter ran out of memory"}`,
+			JobProgress:       30,
+			JobLease: entity.JobLease{
+				JobType:   split.JobType,
+				ExpiresAt: time.Now().Add(-time.Minute),
+			},
+		}
+
+		publishing = amqp.Publishing{
+			MessageId: "split-message-id",
+			Type:      split.JobType,
+			Body:      []byte(`{"tracklist_id":"tracklist-1","track_id":"track-1"}`),
+		}
+	})
+
+	JustBeforeEach(func() {
+		Expect(trackStore.SetTrack(context.Background(), "tracklist-1", "track-1", track)).To(Succeed())
+	})
+
+	It("puts an errored track back into processing along with its job", func() {
+		Expect(admin.Enqueue(context.Background(), trackStore, jobs, "tracklist-1", "track-1", publishing, false)).To(Succeed())
+
+		reset := getTrack()
+		Expect(reset.JobStatus).To(Equal(entity.ProcessingStatus))
+		Expect(reset.JobStatusMessage).To(ContainSubstring(split.JobType))
+		Expect(reset.JobStatusDebugLog).To(BeEmpty())
+		Expect(reset.JobLease.IsHeld()).To(BeFalse())
+
+		Expect(rabbitMQ.MessageChannel).To(HaveLen(1))
+		job := <-rabbitMQ.MessageChannel
+		Expect(job.MessageId).To(Equal("split-message-id"))
+	})
+
+	Describe("When the track hasn't errored", func() {
+		BeforeEach(func() {
+			track.JobStatus = entity.ProcessingStatus
+		})
+
+		It("leaves it alone and says it can't be resumed", func() {
+			err := admin.Enqueue(context.Background(), trackStore, jobs, "tracklist-1", "track-1", publishing, false)
+			Expect(errors.Is(err, admin.ErrNotResumable)).To(BeTrue())
+
+			Expect(getTrack().JobStatusMessage).To(Equal(split.ErrorMessage))
+			Expect(rabbitMQ.MessageChannel).To(BeEmpty())
+		})
+	})
+
+	It("doesn't take a missing track for one that can't be resumed", func() {
+		err := admin.Enqueue(context.Background(), trackStore, jobs, "tracklist-1", "other-track", publishing, false)
+		Expect(err).To(HaveOccurred())
+		Expect(errors.Is(err, admin.ErrNotResumable)).To(BeFalse())
+	})
+})
@@ -0,0 +1,370 @@
+package admin
+
+import (
+	"chord-paper-be-workers/src/application/jobs/job_message"
+	"chord-paper-be-workers/src/application/jobs/start"
+	"chord-paper-be-workers/src/application/outbox"
+	"chord-paper-be-workers/src/application/pipeline"
+	"chord-paper-be-workers/src/application/publish"
+	"chord-paper-be-workers/src/application/resume"
+	"chord-paper-be-workers/src/application/tracks/entity"
+	"chord-paper-be-workers/src/lib/cerr"
+	"chord-paper-be-workers/src/lib/ids"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	defaultFailuresLimit = 20
+	maxFailuresLimit     = 200
+
+	maxRequestBodySize = 1 << 20
+
+	RetryStatusMessage = "Retrying the split"
+)
+
+// NewHandler serves the admin API under /admin/, every request needs the token as a bearer token:
+//
+//	GET  /admin/tracks/{tracklist_id}/{track_id}         the track's processing state
+//	POST /admin/tracks/{tracklist_id}/{track_id}/retry   reset an errored track and start it over
+//	POST /admin/tracks/{tracklist_id}/{track_id}/resume  enqueue an errored track's job for a stage,
+//	                                                     with a body of {"stage": ..., "params": {...}},
+//	                                                     or {} to resume from the furthest stored artifacts
+//	GET  /admin/failures?limit=20                        the most recently failed tracks
+//
+// A track can be resumed from any of the stages. Resuming from one of the artifacts' stages without params
+// builds them from the files already in the file store, and also works for finished tracks,
+// which go back to being split requests
+func NewHandler(trackStore entity.TrackStore, publisher publish.Publisher, stages pipeline.Registry, artifacts resume.Artifacts, token string) http.Handler {
+	h := handler{
+		trackStore: trackStore,
+		outbox:     outbox.NewOutbox(trackStore, publisher),
+		stages:     stages,
+		artifacts:  artifacts,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/tracks/", h.tracks)
+	mux.HandleFunc("/admin/failures", h.failures)
+
+	return requireToken(token, mux)
+}
+
+type handler struct {
+	trackStore entity.TrackStore
+	outbox     outbox.Outbox
+	stages     pipeline.Registry
+	artifacts  resume.Artifacts
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type resumeRequest struct {
+	Stage  string                 `json:"stage"`
+	Params map[string]interface{} `json:"params"`
+}
+
+type enqueuedResponse struct {
+	TrackListID string `json:"tracklist_id"`
+	TrackID     string `json:"track_id"`
+	JobType     string `json:"job_type"`
+	MessageID   string `json:"message_id"`
+}
+
+type failureResponse struct {
+	TrackListID   string          `json:"tracklist_id"`
+	TrackID       string          `json:"track_id"`
+	Stage         string          `json:"stage,omitempty"`
+	FailedAt      *time.Time      `json:"failed_at,omitempty"`
+	StatusMessage string          `json:"status_message"`
+	DebugLog      json.RawMessage `json:"debug_log,omitempty"`
+}
+
+type failuresResponse struct {
+	Failures []failureResponse `json:"failures"`
+}
+
+// requireToken turns away requests without the shared secret, an empty token turns everyone away
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		if token == "" || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, cerr.Error("Missing or invalid admin token"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tracks routes /admin/tracks/{tracklist_id}/{track_id}[/action]
+func (h handler) tracks(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/tracks/"), "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		writeError(w, http.StatusNotFound, cerr.Error("Expected /admin/tracks/{tracklist_id}/{track_id}"))
+		return
+	}
+
+	tracklistID, trackID := parts[0], parts[1]
+	action := ""
+	if len(parts) == 3 {
+		action = parts[2]
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		h.getTrack(w, r, tracklistID, trackID)
+	case action == "retry" && r.Method == http.MethodPost:
+		h.retry(w, r, tracklistID, trackID)
+	case action == "resume" && r.Method == http.MethodPost:
+		h.resume(w, r, tracklistID, trackID)
+	case action == "" || action == "retry" || action == "resume":
+		writeError(w, http.StatusMethodNotAllowed, cerr.Field("method", r.Method).Error("Method not allowed"))
+	default:
+		writeError(w, http.StatusNotFound, cerr.Field("action", action).Error("Unknown track action"))
+	}
+}
+
+func (h handler) getTrack(w http.ResponseWriter, r *http.Request, tracklistID string, trackID string) {
+	track, err := h.trackStore.GetTrack(r.Context(), tracklistID, trackID)
+	if err != nil {
+		writeError(w, storeErrorStatus(err), cerr.Wrap(err).Error("Failed to get track"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, NewTrackResponse(track))
+}
+
+func (h handler) retry(w http.ResponseWriter, r *http.Request, tracklistID string, trackID string) {
+	publishing, err := newJob(r.Context(), tracklistID, trackID, start.JobType, nil)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, cerr.Wrap(err).Error("Failed to create job"))
+		return
+	}
+
+	h.enqueue(w, r, tracklistID, trackID, publishing, false)
+}
+
+func (h handler) resume(w http.ResponseWriter, r *http.Request, tracklistID string, trackID string) {
+	var request resumeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize)).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, cerr.Wrap(err).Error("Failed to parse request body"))
+		return
+	}
+
+	// without a stage, the track picks up from whatever is furthest along in the file store
+	if request.Stage == "" && request.Params == nil {
+		h.resumeFromArtifacts(w, r, tracklistID, trackID, "")
+		return
+	}
+
+	if _, ok := h.stages.Stage(request.Stage); !ok {
+		writeError(w, http.StatusBadRequest, cerr.Field("stage", request.Stage).
+			Field("stages", h.stages.JobTypes()).
+			Error("Unknown stage"))
+		return
+	}
+
+	if request.Params == nil && h.artifacts.IsStage(request.Stage) {
+		h.resumeFromArtifacts(w, r, tracklistID, trackID, request.Stage)
+		return
+	}
+
+	publishing, err := newJob(r.Context(), tracklistID, trackID, request.Stage, request.Params)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, cerr.Wrap(err).Error("Failed to create job"))
+		return
+	}
+
+	h.enqueue(w, r, tracklistID, trackID, publishing, false)
+}
+
+func (h handler) resumeFromArtifacts(w http.ResponseWriter, r *http.Request, tracklistID string, trackID string, stage string) {
+	ctx := r.Context()
+
+	track, err := h.trackStore.GetTrack(ctx, tracklistID, trackID)
+	if err != nil {
+		writeError(w, storeErrorStatus(err), cerr.Wrap(err).Error("Failed to get track"))
+		return
+	}
+
+	var params interface{}
+	if stage == "" {
+		stage, params, err = h.artifacts.Latest(ctx, tracklistID, trackID, track)
+	} else {
+		params, err = h.artifacts.Params(ctx, tracklistID, trackID, track, stage)
+	}
+
+	if err != nil {
+		writeError(w, artifactErrorStatus(err), cerr.Wrap(err).Error("Failed to find the stored artifacts to resume from"))
+		return
+	}
+
+	// a resumed job is a deliberate rerun, it mustn't look like a duplicate of the job it resumes
+	publishing, err := job_message.NewPublishing(ctx, stage, ids.New(), params)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, cerr.Wrap(err).Error("Failed to create job"))
+		return
+	}
+
+	// a finished track has no original to start over from, it can only be reopened to pick up from its artifacts
+	h.enqueue(w, r, tracklistID, trackID, publishing, h.artifacts.IsStage(stage))
+}
+
+func (h handler) enqueue(w http.ResponseWriter, r *http.Request, tracklistID string, trackID string, publishing amqp.Publishing, reopen bool) {
+	if err := Enqueue(r.Context(), h.trackStore, h.outbox, tracklistID, trackID, publishing, reopen); err != nil {
+		status := storeErrorStatus(err)
+		if errors.Is(err, ErrNotResumable) {
+			status = http.StatusConflict
+		}
+
+		writeError(w, status, cerr.Wrap(err).Error("Failed to enqueue job"))
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, enqueuedResponse{
+		TrackListID: tracklistID,
+		TrackID:     trackID,
+		JobType:     publishing.Type,
+		MessageID:   publishing.MessageId,
+	})
+}
+
+func (h handler) failures(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, cerr.Field("method", r.Method).Error("Method not allowed"))
+		return
+	}
+
+	limit := defaultFailuresLimit
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		parsed, err := strconv.Atoi(limitParam)
+		if err != nil || parsed < 1 {
+			writeError(w, http.StatusBadRequest, cerr.Field("limit", limitParam).Error("Limit must be a positive number"))
+			return
+		}
+
+		limit = parsed
+		if limit > maxFailuresLimit {
+			limit = maxFailuresLimit
+		}
+	}
+
+	failures := []failureResponse{}
+	err := h.trackStore.ScanTracks(r.Context(), func(tracklistID string, trackID string, track entity.Track) error {
+		splitStemTrack, ok := track.(entity.SplitStemTrack)
+		if !ok || splitStemTrack.JobStatus != entity.ErrorStatus {
+			return nil
+		}
+
+		failures = append(failures, newFailureResponse(tracklistID, trackID, splitStemTrack))
+		return nil
+	})
+
+	if err != nil {
+		writeError(w, storeErrorStatus(err), cerr.Wrap(err).Error("Failed to scan tracks"))
+		return
+	}
+
+	// most recent first, tracks that failed before there was a job history last
+	sort.SliceStable(failures, func(i, j int) bool {
+		if failures[i].FailedAt == nil || failures[j].FailedAt == nil {
+			return failures[j].FailedAt == nil && failures[i].FailedAt != nil
+		}
+
+		return failures[i].FailedAt.After(*failures[j].FailedAt)
+	})
+
+	if len(failures) > limit {
+		failures = failures[:limit]
+	}
+
+	writeJSON(w, http.StatusOK, failuresResponse{Failures: failures})
+}
+
+func newFailureResponse(tracklistID string, trackID string, track entity.SplitStemTrack) failureResponse {
+	failure := failureResponse{
+		TrackListID:   tracklistID,
+		TrackID:       trackID,
+		StatusMessage: track.JobStatusMessage,
+		DebugLog:      debugLogJSON(track.JobStatusDebugLog),
+	}
+
+	if len(track.JobHistory) > 0 {
+		last := track.JobHistory[len(track.JobHistory)-1]
+		failure.Stage = last.Stage
+		failure.FailedAt = &last.FinishedAt
+	}
+
+	return failure
+}
+
+// newJob builds the stage's job message, the params are the job's JSON fields besides the track identifier
+func newJob(ctx context.Context, tracklistID string, trackID string, stage string, params map[string]interface{}) (amqp.Publishing, error) {
+	body := map[string]interface{}{}
+	for k, v := range params {
+		body[k] = v
+	}
+
+	identifier := job_message.TrackIdentifier{
+		TrackListID: tracklistID,
+		TrackID:     trackID,
+	}
+	body["tracklist_id"] = identifier.TrackListID
+	body["track_id"] = identifier.TrackID
+
+	return job_message.NewPublishing(ctx, stage, ids.New(), body)
+}
+
+// storeErrorStatus tells a track that doesn't exist apart from the store failing
+func storeErrorStatus(err error) int {
+	switch cerr.CategoryOf(err) {
+	case cerr.Permanent:
+		return http.StatusNotFound
+	case cerr.Transient, cerr.Infrastructure:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// artifactErrorStatus tells missing artifacts apart from the file store failing
+func artifactErrorStatus(err error) int {
+	switch cerr.CategoryOf(err) {
+	case cerr.Permanent:
+		return http.StatusConflict
+	case cerr.Transient, cerr.Infrastructure:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	if status >= http.StatusInternalServerError {
+		cerr.Log(err)
+	}
+
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		cerr.Log(cerr.Wrap(err).Error("Failed to write response"))
+	}
+}
@@ -0,0 +1,371 @@
+package admin_test
+
+import (
+	"bytes"
+	"chord-paper-be-workers/src/application"
+	"chord-paper-be-workers/src/application/admin"
+	"chord-paper-be-workers/src/application/integration_test/dummy"
+	"chord-paper-be-workers/src/application/jobs/save_stems_to_db"
+	"chord-paper-be-workers/src/application/jobs/split"
+	"chord-paper-be-workers/src/application/jobs/start"
+	"chord-paper-be-workers/src/application/pipeline"
+	"chord-paper-be-workers/src/application/resume"
+	"chord-paper-be-workers/src/application/tracks/entity"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Handler", func() {
+	const token = "s3cret"
+
+	var (
+		trackStore *dummy.TrackStore
+		rabbitMQ   *dummy.RabbitMQ
+		fileStore  *dummy.FileStore
+		stages     pipeline.Registry
+		artifacts  resume.Artifacts
+
+		request = func(method string, path string, body interface{}) *httptest.ResponseRecorder {
+			var reader io.Reader
+			if body != nil {
+				bodyBytes, err := json.Marshal(body)
+				Expect(err).NotTo(HaveOccurred())
+				reader = bytes.NewReader(bodyBytes)
+			}
+
+			req := httptest.NewRequest(method, path, reader)
+			req.Header.Set("Authorization", "Bearer "+token)
+
+			recorder := httptest.NewRecorder()
+			admin.NewHandler(trackStore, rabbitMQ, stages, artifacts, token).ServeHTTP(recorder, req)
+			return recorder
+		}
+
+		decode = func(recorder *httptest.ResponseRecorder) map[string]interface{} {
+			var body map[string]interface{}
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &body)).To(Succeed())
+			return body
+		}
+
+		erroredTrack = func(failedAt time.Time) entity.SplitStemTrack {
+			return entity.SplitStemTrack{
+				BaseTrack:         entity.BaseTrack{TrackType: entity.SplitFourStemsType},
+				OriginalURL:       "https://www.youtube.com/watch?v=abc",
+				JobStatus:         entity.ErrorStatus,
+				JobStatusMessage:  split.ErrorMessage,
+				JobStatusDebugLog: `{"error":"spleeter ran out of memory"}`,
+				JobProgress:       30,
+				JobHistory: []entity.JobHistoryEntry{{
+					Stage:      split.JobType,
+					Attempt:    3,
+					StartedAt:  failedAt.Add(-time.Minute),
+					FinishedAt: failedAt,
+					Outcome:    entity.JobFailed,
+				}},
+			}
+		}
+	)
+
+	BeforeEach(func() {
+		trackStore = dummy.NewDummyTrackStore()
+		rabbitMQ = dummy.NewRabbitMQ()
+		fileStore = dummy.NewDummyFileStore()
+		stages = application.Pipeline()
+		artifacts = resume.NewArtifacts(fileStore, "bucket", stages)
+
+		Expect(trackStore.SetTrack(context.Background(), "tracklist-1", "track-1", erroredTrack(time.Now()))).To(Succeed())
+	})
+
+	It("turns away requests without the token", func() {
+		req := httptest.NewRequest(http.MethodGet, "/admin/tracks/tracklist-1/track-1", nil)
+		req.Header.Set("Authorization", "Bearer wrong")
+
+		recorder := httptest.NewRecorder()
+		admin.NewHandler(trackStore, rabbitMQ, stages, artifacts, token).ServeHTTP(recorder, req)
+		Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("turns everyone away when no token is configured", func() {
+		req := httptest.NewRequest(http.MethodGet, "/admin/tracks/tracklist-1/track-1", nil)
+		req.Header.Set("Authorization", "Bearer ")
+
+		recorder := httptest.NewRecorder()
+		admin.NewHandler(trackStore, rabbitMQ, stages, artifacts, "").ServeHTTP(recorder, req)
+		Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	Describe("Looking up a track", func() {
+		It("returns its processing state", func() {
+			response := request(http.MethodGet, "/admin/tracks/tracklist-1/track-1", nil)
+			Expect(response.Code).To(Equal(http.StatusOK))
+
+			body := decode(response)
+			Expect(body["status"]).To(Equal(string(entity.ErrorStatus)))
+			Expect(body["status_message"]).To(Equal(split.ErrorMessage))
+			Expect(body["debug_log"]).To(Equal(map[string]interface{}{"error": "spleeter ran out of memory"}))
+			Expect(body["job_history"]).To(HaveLen(1))
+		})
+
+		It("is not found when the track doesn't exist", func() {
+			response := request(http.MethodGet, "/admin/tracks/tracklist-1/nope", nil)
+			Expect(response.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("Retrying a track", func() {
+		It("resets it to requested and enqueues a start job", func() {
+			response := request(http.MethodPost, "/admin/tracks/tracklist-1/track-1/retry", nil)
+			Expect(response.Code).To(Equal(http.StatusAccepted))
+
+			track, err := trackStore.GetTrack(context.Background(), "tracklist-1", "track-1")
+			Expect(err).NotTo(HaveOccurred())
+
+			splitStemTrack := track.(entity.SplitStemTrack)
+			Expect(splitStemTrack.JobStatus).To(Equal(entity.RequestedStatus))
+			Expect(splitStemTrack.JobStatusMessage).To(Equal(admin.RetryStatusMessage))
+			Expect(splitStemTrack.JobStatusDebugLog).To(BeEmpty())
+			Expect(splitStemTrack.JobProgress).To(Equal(0))
+			Expect(splitStemTrack.Outbox).To(BeEmpty())
+
+			Expect(rabbitMQ.MessageChannel).To(HaveLen(1))
+			job := <-rabbitMQ.MessageChannel
+			Expect(job.Type).To(Equal(start.JobType))
+			Expect(job.MessageId).To(Equal(decode(response)["message_id"]))
+
+			var params start.JobParams
+			Expect(json.Unmarshal(job.Body, &params)).To(Succeed())
+			Expect(params.TrackListID).To(Equal("tracklist-1"))
+			Expect(params.TrackID).To(Equal("track-1"))
+		})
+
+		It("leaves the job in the outbox when it can't be published", func() {
+			rabbitMQ.Unavailable = true
+
+			response := request(http.MethodPost, "/admin/tracks/tracklist-1/track-1/retry", nil)
+			Expect(response.Code).To(Equal(http.StatusAccepted))
+
+			track, err := trackStore.GetTrack(context.Background(), "tracklist-1", "track-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(track.(entity.SplitStemTrack).Outbox).To(HaveLen(1))
+		})
+
+		It("refuses tracks that haven't errored", func() {
+			track := erroredTrack(time.Now())
+			track.JobStatus = entity.ProcessingStatus
+			Expect(trackStore.SetTrack(context.Background(), "tracklist-1", "track-1", track)).To(Succeed())
+
+			response := request(http.MethodPost, "/admin/tracks/tracklist-1/track-1/retry", nil)
+			Expect(response.Code).To(Equal(http.StatusConflict))
+			Expect(rabbitMQ.MessageChannel).To(BeEmpty())
+		})
+	})
+
+	Describe("Resuming a track from a stage", func() {
+		It("enqueues the stage's job with the given params", func() {
+			response := request(http.MethodPost, "/admin/tracks/tracklist-1/track-1/resume", map[string]interface{}{
+				"stage":  split.JobType,
+				"params": map[string]interface{}{"saved_original_url": "https://storage.googleapis.com/bucket/original.mp3"},
+			})
+			Expect(response.Code).To(Equal(http.StatusAccepted))
+
+			track, err := trackStore.GetTrack(context.Background(), "tracklist-1", "track-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(track.(entity.SplitStemTrack).JobStatus).To(Equal(entity.ProcessingStatus))
+
+			Expect(rabbitMQ.MessageChannel).To(HaveLen(1))
+			job := <-rabbitMQ.MessageChannel
+			Expect(job.Type).To(Equal(split.JobType))
+
+			var params split.JobParams
+			Expect(json.Unmarshal(job.Body, &params)).To(Succeed())
+			Expect(params.TrackListID).To(Equal("tracklist-1"))
+			Expect(params.TrackID).To(Equal("track-1"))
+			Expect(params.SavedOriginalURL).To(Equal("https://storage.googleapis.com/bucket/original.mp3"))
+		})
+
+		Context("without params, from the stored artifacts", func() {
+			const originalURL = "https://storage.googleapis.com/bucket/tracklist-1/track-1/original/original.mp3"
+			const stemDirURL = "https://storage.googleapis.com/bucket/tracklist-1/track-1/4stems"
+
+			var saveStems = func() {
+				for _, stem := range []string{"vocals", "drums", "bass", "other"} {
+					fileStore.State[stemDirURL+"/"+stem+".mp3"] = []byte(stem)
+				}
+			}
+
+			var enqueuedJob = func(params interface{}) string {
+				Expect(rabbitMQ.MessageChannel).To(HaveLen(1))
+				job := <-rabbitMQ.MessageChannel
+				Expect(json.Unmarshal(job.Body, params)).To(Succeed())
+				return job.Type
+			}
+
+			It("splits the saved original again", func() {
+				fileStore.State[originalURL] = []byte("original")
+
+				response := request(http.MethodPost, "/admin/tracks/tracklist-1/track-1/resume", map[string]interface{}{
+					"stage": split.JobType,
+				})
+				Expect(response.Code).To(Equal(http.StatusAccepted))
+
+				var params split.JobParams
+				Expect(enqueuedJob(&params)).To(Equal(split.JobType))
+				Expect(params.TrackListID).To(Equal("tracklist-1"))
+				Expect(params.TrackID).To(Equal("track-1"))
+				Expect(params.SavedOriginalURL).To(Equal(originalURL))
+			})
+
+			It("saves the stored stems", func() {
+				saveStems()
+
+				response := request(http.MethodPost, "/admin/tracks/tracklist-1/track-1/resume", map[string]interface{}{
+					"stage": save_stems_to_db.JobType,
+				})
+				Expect(response.Code).To(Equal(http.StatusAccepted))
+
+				var params save_stems_to_db.JobParams
+				Expect(enqueuedJob(&params)).To(Equal(save_stems_to_db.JobType))
+				Expect(params.StemURLS).To(Equal(map[string]string{
+					"vocals": stemDirURL + "/vocals.mp3",
+					"drums":  stemDirURL + "/drums.mp3",
+					"bass":   stemDirURL + "/bass.mp3",
+					"other":  stemDirURL + "/other.mp3",
+				}))
+			})
+
+			It("picks up from the furthest stored artifacts without a stage", func() {
+				fileStore.State[originalURL] = []byte("original")
+				saveStems()
+
+				response := request(http.MethodPost, "/admin/tracks/tracklist-1/track-1/resume", map[string]interface{}{})
+				Expect(response.Code).To(Equal(http.StatusAccepted))
+				Expect(decode(response)).To(HaveKeyWithValue("job_type", save_stems_to_db.JobType))
+
+				var params save_stems_to_db.JobParams
+				Expect(enqueuedJob(&params)).To(Equal(save_stems_to_db.JobType))
+			})
+
+			It("starts over without a stage when nothing is stored", func() {
+				response := request(http.MethodPost, "/admin/tracks/tracklist-1/track-1/resume", map[string]interface{}{})
+				Expect(response.Code).To(Equal(http.StatusAccepted))
+
+				var params start.JobParams
+				Expect(enqueuedJob(&params)).To(Equal(start.JobType))
+				Expect(params.TrackID).To(Equal("track-1"))
+
+				track, err := trackStore.GetTrack(context.Background(), "tracklist-1", "track-1")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(track.(entity.SplitStemTrack).JobStatus).To(Equal(entity.RequestedStatus))
+			})
+
+			It("refuses when the original hasn't been saved", func() {
+				response := request(http.MethodPost, "/admin/tracks/tracklist-1/track-1/resume", map[string]interface{}{
+					"stage": split.JobType,
+				})
+				Expect(response.Code).To(Equal(http.StatusConflict))
+				Expect(rabbitMQ.MessageChannel).To(BeEmpty())
+			})
+
+			It("refuses when a stem is missing", func() {
+				saveStems()
+				delete(fileStore.State, stemDirURL+"/bass.mp3")
+
+				response := request(http.MethodPost, "/admin/tracks/tracklist-1/track-1/resume", map[string]interface{}{
+					"stage": save_stems_to_db.JobType,
+				})
+				Expect(response.Code).To(Equal(http.StatusConflict))
+				Expect(rabbitMQ.MessageChannel).To(BeEmpty())
+			})
+
+			It("fails without enqueueing anything while the file store is down", func() {
+				fileStore.Unavailable = true
+
+				response := request(http.MethodPost, "/admin/tracks/tracklist-1/track-1/resume", map[string]interface{}{
+					"stage": split.JobType,
+				})
+				Expect(response.Code).To(BeNumerically(">=", http.StatusInternalServerError))
+				Expect(rabbitMQ.MessageChannel).To(BeEmpty())
+			})
+
+			It("reopens a finished track as a split request", func() {
+				fileStore.State[originalURL] = []byte("original")
+				history := erroredTrack(time.Now()).JobHistory
+				Expect(trackStore.SetTrack(context.Background(), "tracklist-1", "track-1", entity.StemTrack{
+					BaseTrack:  entity.BaseTrack{TrackType: entity.FourStemsType},
+					StemURLs:   map[string]string{"vocals": stemDirURL + "/vocals.mp3"},
+					JobHistory: history,
+				})).To(Succeed())
+
+				response := request(http.MethodPost, "/admin/tracks/tracklist-1/track-1/resume", map[string]interface{}{
+					"stage": split.JobType,
+				})
+				Expect(response.Code).To(Equal(http.StatusAccepted))
+
+				track, err := trackStore.GetTrack(context.Background(), "tracklist-1", "track-1")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(track).To(BeAssignableToTypeOf(entity.SplitStemTrack{}))
+
+				splitStemTrack := track.(entity.SplitStemTrack)
+				Expect(splitStemTrack.TrackType).To(Equal(entity.SplitFourStemsType))
+				Expect(splitStemTrack.JobStatus).To(Equal(entity.ProcessingStatus))
+				Expect(splitStemTrack.JobHistory).To(Equal(history))
+
+				var params split.JobParams
+				Expect(enqueuedJob(&params)).To(Equal(split.JobType))
+			})
+
+			It("doesn't reopen a finished track for jobs with given params", func() {
+				Expect(trackStore.SetTrack(context.Background(), "tracklist-1", "track-1", entity.StemTrack{
+					BaseTrack: entity.BaseTrack{TrackType: entity.FourStemsType},
+				})).To(Succeed())
+
+				response := request(http.MethodPost, "/admin/tracks/tracklist-1/track-1/resume", map[string]interface{}{
+					"stage":  split.JobType,
+					"params": map[string]interface{}{"saved_original_url": originalURL},
+				})
+				Expect(response.Code).To(Equal(http.StatusConflict))
+			})
+		})
+
+		It("rejects unknown stages", func() {
+			response := request(http.MethodPost, "/admin/tracks/tracklist-1/track-1/resume", map[string]interface{}{
+				"stage": "bake_cake",
+			})
+			Expect(response.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Describe("Listing failures", func() {
+		BeforeEach(func() {
+			Expect(trackStore.SetTrack(context.Background(), "tracklist-2", "older", erroredTrack(time.Now().Add(-time.Hour)))).To(Succeed())
+
+			healthy := erroredTrack(time.Now())
+			healthy.JobStatus = entity.ProcessingStatus
+			Expect(trackStore.SetTrack(context.Background(), "tracklist-2", "healthy", healthy)).To(Succeed())
+		})
+
+		It("lists errored tracks, most recent first", func() {
+			response := request(http.MethodGet, "/admin/failures", nil)
+			Expect(response.Code).To(Equal(http.StatusOK))
+
+			failures := decode(response)["failures"].([]interface{})
+			Expect(failures).To(HaveLen(2))
+			Expect(failures[0].(map[string]interface{})["track_id"]).To(Equal("track-1"))
+			Expect(failures[0].(map[string]interface{})["stage"]).To(Equal(split.JobType))
+			Expect(failures[1].(map[string]interface{})["track_id"]).To(Equal("older"))
+		})
+
+		It("limits how many are listed", func() {
+			response := request(http.MethodGet, "/admin/failures?limit=1", nil)
+			Expect(response.Code).To(Equal(http.StatusOK))
+			Expect(decode(response)["failures"]).To(HaveLen(1))
+		})
+	})
+})
@@ -0,0 +1,152 @@
+package admin
+
+import (
+	"chord-paper-be-workers/src/application/tracks/entity"
+	"encoding/json"
+	"time"
+)
+
+// TrackResponse is how a track is shown to operators, through the admin API and the sender CLI
+type TrackResponse struct {
+	TrackType string `json:"track_type"`
+
+	// split stem tracks, while they're being processed
+	OriginalURL   string          `json:"original_url,omitempty"`
+	Status        string          `json:"status,omitempty"`
+	StatusMessage string          `json:"status_message,omitempty"`
+	Progress      int             `json:"progress,omitempty"`
+	DebugLog      json.RawMessage `json:"debug_log,omitempty"`
+	Outbox        []outboxEntry   `json:"outbox,omitempty"`
+	Lease         *leaseResponse  `json:"lease,omitempty"`
+
+	// stem tracks, once they're done
+	StemURLs map[string]string `json:"stem_urls,omitempty"`
+
+	// once the original has been transferred
+	SourceFormat *sourceFormatResponse `json:"source_format,omitempty"`
+
+	JobHistory []historyEntry `json:"job_history"`
+}
+
+type outboxEntry struct {
+	ID        string    `json:"id"`
+	JobType   string    `json:"job_type"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type leaseResponse struct {
+	JobType   string    `json:"job_type"`
+	MessageID string    `json:"message_id"`
+	Attempt   int       `json:"attempt"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type sourceFormatResponse struct {
+	Container  string `json:"container"`
+	Codec      string `json:"codec"`
+	Duration   string `json:"duration"`
+	SampleRate int    `json:"sample_rate"`
+	Channels   int    `json:"channels"`
+	FromVideo  bool   `json:"from_video"`
+}
+
+type historyEntry struct {
+	Stage        string            `json:"stage"`
+	Attempt      int               `json:"attempt"`
+	StartedAt    time.Time         `json:"started_at"`
+	FinishedAt   time.Time         `json:"finished_at"`
+	Duration     string            `json:"duration"`
+	Outcome      string            `json:"outcome"`
+	WorkerID     string            `json:"worker_id"`
+	ToolVersions map[string]string `json:"tool_versions,omitempty"`
+}
+
+func NewTrackResponse(track entity.Track) TrackResponse {
+	response := TrackResponse{
+		TrackType:  string(track.GetTrackType()),
+		JobHistory: []historyEntry{},
+	}
+
+	switch typedTrack := track.(type) {
+	case entity.SplitStemTrack:
+		response.OriginalURL = typedTrack.OriginalURL
+		response.Status = string(typedTrack.JobStatus)
+		response.StatusMessage = typedTrack.JobStatusMessage
+		response.Progress = typedTrack.JobProgress
+		response.DebugLog = debugLogJSON(typedTrack.JobStatusDebugLog)
+		response.JobHistory = newHistory(typedTrack.JobHistory)
+		response.SourceFormat = newSourceFormat(typedTrack.SourceFormat)
+
+		for _, message := range typedTrack.Outbox {
+			response.Outbox = append(response.Outbox, outboxEntry{
+				ID:        message.ID,
+				JobType:   message.JobType,
+				CreatedAt: message.CreatedAt,
+			})
+		}
+
+		if typedTrack.JobLease.IsHeld() {
+			response.Lease = &leaseResponse{
+				JobType:   typedTrack.JobLease.JobType,
+				MessageID: typedTrack.JobLease.MessageID,
+				Attempt:   typedTrack.JobLease.Attempt,
+				ExpiresAt: typedTrack.JobLease.ExpiresAt,
+			}
+		}
+
+	case entity.StemTrack:
+		response.StemURLs = typedTrack.StemURLs
+		response.JobHistory = newHistory(typedTrack.JobHistory)
+		response.SourceFormat = newSourceFormat(typedTrack.SourceFormat)
+	}
+
+	return response
+}
+
+func newHistory(history []entity.JobHistoryEntry) []historyEntry {
+	entries := []historyEntry{}
+	for _, entry := range history {
+		entries = append(entries, historyEntry{
+			Stage:        entry.Stage,
+			Attempt:      entry.Attempt,
+			StartedAt:    entry.StartedAt,
+			FinishedAt:   entry.FinishedAt,
+			Duration:     entry.Duration().Round(time.Millisecond).String(),
+			Outcome:      string(entry.Outcome),
+			WorkerID:     entry.WorkerID,
+			ToolVersions: entry.ToolVersions,
+		})
+	}
+
+	return entries
+}
+
+func newSourceFormat(format entity.SourceFormat) *sourceFormatResponse {
+	if !format.IsKnown() {
+		return nil
+	}
+
+	return &sourceFormatResponse{
+		Container:  format.Container,
+		Codec:      format.Codec,
+		Duration:   format.Duration.Round(time.Millisecond).String(),
+		SampleRate: format.SampleRate,
+		Channels:   format.Channels,
+		FromVideo:  format.FromVideo,
+	}
+}
+
+// debugLogJSON passes the structured debug log through as it is,
+// logs written before it was structured are passed as a JSON string
+func debugLogJSON(debugLog string) json.RawMessage {
+	if debugLog == "" {
+		return nil
+	}
+
+	if json.Valid([]byte(debugLog)) {
+		return json.RawMessage(debugLog)
+	}
+
+	quoted, _ := json.Marshal(debugLog)
+	return quoted
+}
@@ -1,8 +1,11 @@
 package application
 
 import (
+	"chord-paper-be-workers/src/application/admin"
 	filestore "chord-paper-be-workers/src/application/cloud_storage/store"
+	"chord-paper-be-workers/src/application/dedup"
 	"chord-paper-be-workers/src/application/executor"
+	"chord-paper-be-workers/src/application/health"
 	"chord-paper-be-workers/src/application/jobs/job_router"
 	"chord-paper-be-workers/src/application/jobs/save_stems_to_db"
 	"chord-paper-be-workers/src/application/jobs/split"
@@ -11,15 +14,32 @@ import (
 	"chord-paper-be-workers/src/application/jobs/start"
 	"chord-paper-be-workers/src/application/jobs/transfer"
 	"chord-paper-be-workers/src/application/jobs/transfer/download"
+	"chord-paper-be-workers/src/application/jobs/transfer/transcode"
+	"chord-paper-be-workers/src/application/lease"
+	"chord-paper-be-workers/src/application/metrics"
+	"chord-paper-be-workers/src/application/outbox"
+	"chord-paper-be-workers/src/application/pipeline"
 	"chord-paper-be-workers/src/application/publish"
+	"chord-paper-be-workers/src/application/rabbitmq"
+	"chord-paper-be-workers/src/application/resume"
+	"chord-paper-be-workers/src/application/retry"
+	"chord-paper-be-workers/src/application/tracing"
 	trackstore "chord-paper-be-workers/src/application/tracks/store"
 	"chord-paper-be-workers/src/application/worker"
 	"chord-paper-be-workers/src/lib/cerr"
 	"chord-paper-be-workers/src/lib/env"
+	"chord-paper-be-workers/src/lib/logging"
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/streadway/amqp"
+	"github.com/apex/log"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 func getEnvOrPanic(key string) string {
@@ -31,6 +51,15 @@ func getEnvOrPanic(key string) string {
 	return val
 }
 
+func getEnvOrDefault(key string, defaultVal string) string {
+	val := os.Getenv(key)
+	if val == "" {
+		return defaultVal
+	}
+
+	return val
+}
+
 func ensureOk(err error) {
 	if err != nil {
 		panic(err)
@@ -38,23 +67,100 @@ func ensureOk(err error) {
 }
 
 type App struct {
-	worker worker.QueueWorker
+	consumerConn *rabbitmq.Connection
+	producerConn *rabbitmq.Connection
+	worker       worker.QueueWorker
+	outboxRelay  outbox.Relay
+	// nil when the watchdog runs as its own deployment
+	watchdog *lease.Watchdog
+	// nil when disabled
+	httpServer *http.Server
 }
 
 func NewApp() App {
-	rabbitMQURL := rabbitURL()
-	consumerConn, err := amqp.Dial(rabbitMQURL)
-	ensureOk(err)
-	producerConn, err := amqp.Dial(rabbitMQURL)
-	ensureOk(err)
+	configureLogging()
+
+	rabbitMQURL := RabbitURL()
+	consumerConn := rabbitmq.NewConnection(rabbitMQURL)
+	producerConn := rabbitmq.NewConnection(rabbitMQURL)
+
+	topology := Topology()
+	publisher := publish.NewRoutingPublisher(producerConn, topology)
+	trackStore := trackstore.NewDynamoDBTrackStore(env.Get())
+
+	stages := workerStages()
+	queueWorker := newWorker(consumerConn, producerConn, topology, publisher, trackStore, stages)
+
+	// src/watchdog runs the watchdog on its own, this is only for running everything in one process, e.g. locally
+	var watchdog *lease.Watchdog
+	if getEnvOrDefault("WATCHDOG_ENABLED", "false") == "true" {
+		w := newWatchdog(trackStore, publisher)
+		watchdog = &w
+	}
+
+	var httpServer *http.Server
+	if getEnvOrDefault("HTTP_SERVER_ENABLED", "false") == "true" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		mux.Handle("/", health.NewHandler(readinessChecks(&queueWorker, trackStore, stages), queueWorker.ActiveJobs))
+
+		// the admin API is only served when there's a token to protect it with
+		if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+			registry := Pipeline()
+			artifacts := resume.NewArtifacts(newGoogleFileStore(), getEnvOrPanic("GOOGLE_CLOUD_STORAGE_BUCKET_NAME"), registry)
+			mux.Handle("/admin/", admin.NewHandler(trackStore, publisher, registry, artifacts, adminToken))
+		}
+
+		httpServer = &http.Server{
+			Addr:    getEnvOrDefault("HTTP_SERVER_ADDR", ":8080"),
+			Handler: mux,
+		}
+	}
 
 	return App{
-		worker: newWorker(consumerConn, producerConn),
+		consumerConn: consumerConn,
+		producerConn: producerConn,
+		worker:       queueWorker,
+		outboxRelay:  newOutboxRelay(trackStore, publisher),
+		watchdog:     watchdog,
+		httpServer:   httpServer,
 	}
 }
 
-func (a *App) Start() error {
-	err := a.worker.Start()
+// Start runs the worker until the stop context is done
+func (a *App) Start(stop context.Context) error {
+	stopTracing := startTracing()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := stopTracing(ctx); err != nil {
+			cerr.Log(cerr.Wrap(err).Error("Failed to flush traces"))
+		}
+	}()
+
+	// up before connecting, so that a worker stuck dialing shows as alive but not ready
+	if a.httpServer != nil {
+		go a.serveHTTP()
+		defer a.shutdownHTTP()
+	}
+
+	if err := a.consumerConn.Connect(stop); err != nil {
+		return cerr.Wrap(err).Error("Failed to connect the consumer")
+	}
+	defer a.consumerConn.Close()
+
+	if err := a.producerConn.Connect(stop); err != nil {
+		return cerr.Wrap(err).Error("Failed to connect the producer")
+	}
+	defer a.producerConn.Close()
+
+	go a.outboxRelay.Start(stop)
+	if a.watchdog != nil {
+		go a.watchdog.Start(stop)
+	}
+
+	err := a.worker.Start(stop)
 	if err != nil {
 		return cerr.Wrap(err).Error("Failed to start worker")
 	}
@@ -62,18 +168,345 @@ func (a *App) Start() error {
 	return nil
 }
 
-func newWorker(consumerConn *amqp.Connection, producerConn *amqp.Connection) worker.QueueWorker {
-	publisher := newPublisher(producerConn)
+// WatchdogApp only runs the stuck job watchdog, for running a single one
+// instead of one inside every worker
+type WatchdogApp struct {
+	producerConn *rabbitmq.Connection
+	watchdog     lease.Watchdog
+}
+
+func NewWatchdogApp() WatchdogApp {
+	configureLogging()
+
+	producerConn := rabbitmq.NewConnection(RabbitURL())
+	topology := Topology()
+	publisher := publish.NewRoutingPublisher(producerConn, topology)
 	trackStore := trackstore.NewDynamoDBTrackStore(env.Get())
-	queueWorker, err := worker.NewQueueWorkerFromConnection(
+
+	return WatchdogApp{
+		producerConn: producerConn,
+		watchdog:     newWatchdog(trackStore, publisher),
+	}
+}
+
+// Start runs the watchdog until the stop context is done
+func (w *WatchdogApp) Start(stop context.Context) error {
+	if err := w.producerConn.Connect(stop); err != nil {
+		return cerr.Wrap(err).Error("Failed to connect the producer")
+	}
+	defer w.producerConn.Close()
+
+	w.watchdog.Start(stop)
+	return nil
+}
+
+// configureLogging sets up the global logger, LOG_FORMAT "json" is for wherever the logs get collected
+func configureLogging() {
+	ensureOk(logging.Configure(getEnvOrDefault("LOG_FORMAT", "text"), getEnvOrDefault("LOG_LEVEL", "info")))
+}
+
+// startTracing picks the span exporter, "stdout" and "file" work without any collector around
+func startTracing() func(ctx context.Context) error {
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch exporterName := getEnvOrDefault("TRACING_EXPORTER", "none"); exporterName {
+	case "none":
+		return func(_ context.Context) error { return nil }
+	case "stdout":
+		exporter, err = tracing.NewStdoutExporter("")
+	case "file":
+		exporter, err = tracing.NewStdoutExporter(getEnvOrPanic("TRACING_FILE_PATH"))
+
+	default:
+		panic(fmt.Sprintf("Unrecognized tracing exporter %s", exporterName))
+	}
+
+	ensureOk(err)
+	return tracing.Start(exporter).Shutdown
+}
+
+func (a *App) serveHTTP() {
+	log.WithField("addr", a.httpServer.Addr).Info("Starting HTTP server")
+
+	err := a.httpServer.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		cerr.Log(cerr.Wrap(err).Error("HTTP server stopped"))
+	}
+}
+
+// shutdownHTTP runs once the worker is done, so /status keeps working while jobs drain
+func (a *App) shutdownHTTP() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := a.httpServer.Shutdown(ctx); err != nil {
+		cerr.Log(cerr.Wrap(err).Error("Failed to shut down HTTP server"))
+	}
+}
+
+// readinessChecks only checks the dependencies of the stages this deployment runs
+func readinessChecks(queueWorker *worker.QueueWorker, trackStore trackstore.DynamoDBTrackStore, stages []string) map[string]health.Check {
+	checks := map[string]health.Check{
+		"rabbitmq": func(_ context.Context) error {
+			if !queueWorker.IsConsuming() {
+				return cerr.Error("Not consuming from RabbitMQ")
+			}
+			return nil
+		},
+		"dynamodb": trackStore.Ping,
+	}
+
+	registry := Pipeline()
+	setups := stageSetups(trackStore)
+	for _, jobType := range stages {
+		stage, _ := registry.Stage(jobType)
+		for _, tool := range stage.Tools {
+			checks[tool] = health.BinaryCheck(getEnvOrPanic(tools[tool].binPathEnv))
+		}
+
+		if _, ok := checks["file_store"]; !ok && setups[jobType].usesFileStore {
+			fileStore := newGoogleFileStore()
+			bucketName := getEnvOrPanic("GOOGLE_CLOUD_STORAGE_BUCKET_NAME")
+			checks["file_store"] = func(ctx context.Context) error {
+				return fileStore.CheckBucket(ctx, bucketName)
+			}
+		}
+	}
+
+	return checks
+}
+
+// NewPipeline declares the stages in the order a track goes through them, each handing off to the one after it.
+// Only the stages with a handler, keyed by job type, are run
+func NewPipeline(handlers map[string]pipeline.Handler) pipeline.Registry {
+	stages := pipeline.Chain(
+		start.NewStage(),
+		transfer.NewStage(),
+		split.NewStage(),
+		save_stems_to_db.NewStage(),
+	)
+
+	for i, stage := range stages {
+		if handler, ok := handlers[stage.JobType]; ok {
+			stages[i] = stage.WithHandler(handler)
+		}
+	}
+
+	registry, err := pipeline.NewRegistry(stages...)
+	ensureOk(err)
+	return registry
+}
+
+// Pipeline is the track pipeline without any handlers, for telling its stages apart and making their job params
+func Pipeline() pipeline.Registry {
+	return NewPipeline(nil)
+}
+
+type stageSetup struct {
+	// only called in deployments that run the stage
+	newHandler func() pipeline.Handler
+	// whether the stage reads or writes the file store, for checking it's reachable
+	usesFileStore bool
+}
+
+// stageSetups set up the handler of each stage, only for the stages this deployment runs
+// so that e.g. a download deployment doesn't need any spleeter configuration
+func stageSetups(trackStore trackstore.DynamoDBTrackStore) map[string]stageSetup {
+	return map[string]stageSetup{
+		start.JobType: {
+			newHandler: func() pipeline.Handler { return start.StageHandler(newStartJobHandler(trackStore)) },
+		},
+		transfer.JobType: {
+			newHandler:    func() pipeline.Handler { return transfer.StageHandler(newDownloadJobHandler()) },
+			usesFileStore: true,
+		},
+		split.JobType: {
+			newHandler:    func() pipeline.Handler { return split.StageHandler(newSplitJobHandler()) },
+			usesFileStore: true,
+		},
+		save_stems_to_db.JobType: {
+			newHandler: func() pipeline.Handler { return save_stems_to_db.StageHandler(newSaveToDBJobHandler(trackStore)) },
+		},
+	}
+}
+
+type tool struct {
+	binPathEnv  string
+	versionFlag string
+}
+
+// tools are where to find the binaries of the tools the stages run, and how to ask them for their version
+var tools = map[string]tool{
+	metrics.ToolYoutubeDL: {binPathEnv: "YOUTUBEDL_BIN_PATH", versionFlag: "--version"},
+	metrics.ToolFFmpeg:    {binPathEnv: "FFMPEG_BIN_PATH", versionFlag: "-version"},
+	metrics.ToolFFprobe:   {binPathEnv: "FFPROBE_BIN_PATH", versionFlag: "-version"},
+	metrics.ToolSpleeter:  {binPathEnv: "SPLEETER_BIN_PATH", versionFlag: "--version"},
+}
+
+func newWorker(
+	consumerConn *rabbitmq.Connection,
+	producerConn *rabbitmq.Connection,
+	topology rabbitmq.Topology,
+	publisher publish.Publisher,
+	trackStore trackstore.DynamoDBTrackStore,
+	stages []string,
+) worker.QueueWorker {
+
+	queues := []worker.Queue{}
+	for _, stage := range stages {
+		queues = append(queues, worker.Queue{Name: topology.JobQueue(stage), JobType: stage})
+	}
+
+	log.WithField("stages", stages).Info("Configuring worker stages")
+
+	policies := retryPolicies()
+	return worker.NewQueueWorkerFromConnection(
 		consumerConn,
-		queueName(),
-		newJobRouter(trackStore, publisher))
+		queues,
+		newJobRouter(trackStore, publisher, policies, lease.NewKeeper(trackStore, jobLeaseDuration()), stages),
+		retry.NewRabbitMQRetrier(producerConn, topology, policies),
+		newDedupStore(),
+		workerConfig())
+}
+
+// newDedupStore shares the processed message IDs between all the workers in production,
+// they only need to outlive the redeliveries of a message
+func newDedupStore() dedup.Store {
+	ttl, err := time.ParseDuration(getEnvOrDefault("DEDUP_TTL", "168h"))
 	ensureOk(err)
-	return queueWorker
+
+	switch env.Get() {
+	case env.Production:
+		return dedup.NewDynamoDBStore(env.Production, ttl)
+	case env.Development:
+		return dedup.NewInMemoryStore(ttl)
+
+	default:
+		panic("Unrecognized environment")
+	}
+}
+
+// newOutboxRelay publishes the next jobs that a worker saved but never got to publish,
+// the grace period should comfortably cover a normal publish
+func newOutboxRelay(trackStore trackstore.DynamoDBTrackStore, publisher publish.Publisher) outbox.Relay {
+	interval, err := time.ParseDuration(getEnvOrDefault("OUTBOX_RELAY_INTERVAL", "1m"))
+	ensureOk(err)
+
+	gracePeriod, err := time.ParseDuration(getEnvOrDefault("OUTBOX_RELAY_GRACE_PERIOD", "2m"))
+	ensureOk(err)
+
+	return outbox.NewRelay(outbox.NewOutbox(trackStore, publisher), trackStore, interval, gracePeriod)
+}
+
+// jobLeaseDuration is how long a job can go without a heartbeat before it's considered dead
+func jobLeaseDuration() time.Duration {
+	duration, err := time.ParseDuration(getEnvOrDefault("JOB_LEASE_DURATION", "2m"))
+	ensureOk(err)
+	return duration
+}
+
+func newWatchdog(trackStore trackstore.DynamoDBTrackStore, publisher publish.Publisher) lease.Watchdog {
+	interval, err := time.ParseDuration(getEnvOrDefault("WATCHDOG_INTERVAL", "1m"))
+	ensureOk(err)
+
+	return lease.NewWatchdog(trackStore, publisher, retryPolicies(), jobLeaseDuration(), interval)
 }
 
-func rabbitURL() string {
+// workerStages picks the job types this deployment consumes, e.g. "split_track" for
+// a spleeter only deployment, or "all" to run the whole pipeline in one process
+func workerStages() []string {
+	allStages := Pipeline().JobTypes()
+
+	stagesVal := getEnvOrDefault("WORKER_STAGES", "all")
+	if stagesVal == "all" {
+		return allStages
+	}
+
+	stages := []string{}
+	for _, stage := range strings.Split(stagesVal, ",") {
+		stage = strings.TrimSpace(stage)
+		if stage == "" {
+			continue
+		}
+
+		if !containsStage(allStages, stage) {
+			panic(fmt.Sprintf("Unrecognized worker stage %s", stage))
+		}
+
+		stages = append(stages, stage)
+	}
+
+	if len(stages) == 0 {
+		panic("No worker stages configured")
+	}
+
+	return stages
+}
+
+func containsStage(stages []string, stage string) bool {
+	for _, s := range stages {
+		if s == stage {
+			return true
+		}
+	}
+
+	return false
+}
+
+func retryPolicies() retry.Policies {
+	return retry.Policies{
+		Default: retry.Policy{
+			MaxAttempts: 3,
+			Backoff:     []time.Duration{10 * time.Second, time.Minute},
+		},
+		Jobs: map[string]retry.Policy{
+			// spleeter failures are rarely transient and each attempt is expensive
+			split.JobType: {
+				MaxAttempts: 2,
+				Backoff:     []time.Duration{time.Minute},
+			},
+		},
+	}
+}
+
+func workerConfig() worker.Config {
+	config := worker.DefaultConfig()
+
+	concurrency, err := strconv.Atoi(getEnvOrDefault("WORKER_CONCURRENCY", "1"))
+	ensureOk(err)
+	config.Concurrency = concurrency
+
+	shutdownTimeout, err := time.ParseDuration(getEnvOrDefault("WORKER_SHUTDOWN_TIMEOUT", config.ShutdownTimeout.String()))
+	ensureOk(err)
+	config.ShutdownTimeout = shutdownTimeout
+
+	// the claim has to lapse before the lease does, or the watchdog's copy of a dead worker's job would be skipped
+	config.ClaimDuration = jobLeaseDuration() / 2
+
+	// e.g. "split_track=1,transfer_original=8"
+	jobConcurrency := getEnvOrDefault("WORKER_JOB_CONCURRENCY", "")
+	for _, entry := range strings.Split(jobConcurrency, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		jobType, limitStr, ok := strings.Cut(entry, "=")
+		if !ok {
+			panic(fmt.Sprintf("Malformed job concurrency entry %s", entry))
+		}
+
+		limit, err := strconv.Atoi(limitStr)
+		ensureOk(err)
+		config.JobConcurrency[strings.TrimSpace(jobType)] = limit
+	}
+
+	return config
+}
+
+// RabbitURL is the broker that the workers, the watchdog and the sender CLI all use
+func RabbitURL() string {
 	switch env.Get() {
 	case env.Production:
 		return getEnvOrPanic("RABBITMQ_URL")
@@ -85,7 +518,8 @@ func rabbitURL() string {
 	}
 }
 
-func queueName() string {
+// QueueName is the base name of the job queues, see rabbitmq.Topology
+func QueueName() string {
 	switch env.Get() {
 	case env.Production:
 		return getEnvOrPanic("RABBITMQ_QUEUE_NAME")
@@ -98,10 +532,9 @@ func queueName() string {
 
 }
 
-func newPublisher(conn *amqp.Connection) publish.RabbitMQPublisher {
-	publisher, err := publish.NewRabbitMQPublisher(conn, queueName())
-	ensureOk(err)
-	return publisher
+// Topology names the queues under QueueName, start jobs come in on it
+func Topology() rabbitmq.Topology {
+	return rabbitmq.NewTopology(QueueName(), start.JobType)
 }
 
 func newGoogleFileStore() filestore.GoogleFileStore {
@@ -112,14 +545,44 @@ func newGoogleFileStore() filestore.GoogleFileStore {
 	return fileStore
 }
 
-func newJobRouter(trackStore trackstore.DynamoDBTrackStore, publisher publish.Publisher) job_router.JobRouter {
+// newJobRouter only sets up the handlers for the configured stages,
+// so e.g. a download deployment doesn't need any spleeter configuration
+func newJobRouter(trackStore trackstore.DynamoDBTrackStore, publisher publish.Publisher, policies retry.Policies, leases lease.Keeper, stages []string) job_router.JobRouter {
+	setups := stageSetups(trackStore)
+
+	handlers := map[string]pipeline.Handler{}
+	for _, stage := range stages {
+		handlers[stage] = setups[stage].newHandler()
+	}
+
+	registry := NewPipeline(handlers)
+
 	return job_router.NewJobRouter(
 		trackStore,
 		publisher,
-		newStartJobHandler(trackStore),
-		newDownloadJobHandler(),
-		newSplitJobHandler(),
-		newSaveToDBJobHandler(trackStore))
+		registry,
+		policies,
+		leases,
+		toolVersions(registry, stages))
+}
+
+// toolVersions asks the tools the configured stages run for their versions, once on start up
+func toolVersions(registry pipeline.Registry, stages []string) map[string]string {
+	versions := map[string]string{}
+
+	for _, jobType := range stages {
+		stage, _ := registry.Stage(jobType)
+		for _, name := range stage.Tools {
+			if _, ok := versions[name]; ok {
+				continue
+			}
+
+			versions[name] = executor.VersionWithFlag(context.Background(), executor.BinaryFileExecutor{}, getEnvOrPanic(tools[name].binPathEnv), tools[name].versionFlag)
+		}
+	}
+
+	log.WithField("tool_versions", versions).Info("Detected tool versions")
+	return versions
 }
 
 func newStartJobHandler(trackStore trackstore.DynamoDBTrackStore) start.JobHandler {
@@ -133,18 +596,66 @@ func newDownloadJobHandler() transfer.JobHandler {
 	ensureOk(err)
 
 	youtubedler := download.NewYoutubeDLer(youtubeDLBinPath, executor.BinaryFileExecutor{})
-	genericdler := download.NewGenericDLer()
+	genericdler := download.NewGenericDLerWithConfig(genericDLConfig())
 
-	selectdler := download.NewSelectDLer(youtubedler, genericdler)
+	downloaders := map[download.Backend]download.Downloader{
+		download.BackendYoutubeDL: youtubedler,
+		download.BackendGeneric:   genericdler,
+	}
+
+	selectdler, err := download.NewSelectDLerWithRules(downloaders, downloadRules())
+	ensureOk(err)
 
 	trackStore := trackstore.NewDynamoDBTrackStore(env.Get())
 	bucketName := getEnvOrPanic("GOOGLE_CLOUD_STORAGE_BUCKET_NAME")
-	trackDownloader, err := transfer.NewTrackTransferrer(selectdler, trackStore, newGoogleFileStore(), bucketName, workingDir)
+	transcoder := transcode.NewTranscoder(getEnvOrPanic("FFMPEG_BIN_PATH"), getEnvOrPanic("FFPROBE_BIN_PATH"), executor.BinaryFileExecutor{})
+
+	trackDownloader, err := transfer.NewTrackTransferrer(selectdler, transcoder, trackStore, newGoogleFileStore(), bucketName, workingDir)
 	ensureOk(err)
 
 	return transfer.NewJobHandler(trackDownloader)
 }
 
+func genericDLConfig() download.GenericDLConfig {
+	config := download.DefaultGenericDLConfig()
+
+	connectTimeout, err := time.ParseDuration(getEnvOrDefault("GENERIC_DL_CONNECT_TIMEOUT", config.ConnectTimeout.String()))
+	ensureOk(err)
+	config.ConnectTimeout = connectTimeout
+
+	readTimeout, err := time.ParseDuration(getEnvOrDefault("GENERIC_DL_READ_TIMEOUT", config.ReadTimeout.String()))
+	ensureOk(err)
+	config.ReadTimeout = readTimeout
+
+	maxSize, err := strconv.ParseInt(getEnvOrDefault("GENERIC_DL_MAX_SIZE", strconv.FormatInt(config.MaxSize, 10)), 10, 64)
+	ensureOk(err)
+	config.MaxSize = maxSize
+
+	maxRedirects, err := strconv.Atoi(getEnvOrDefault("GENERIC_DL_MAX_REDIRECTS", strconv.Itoa(config.MaxRedirects)))
+	ensureOk(err)
+	config.MaxRedirects = maxRedirects
+
+	maxAttempts, err := strconv.Atoi(getEnvOrDefault("GENERIC_DL_MAX_ATTEMPTS", strconv.Itoa(config.Retries.MaxAttempts)))
+	ensureOk(err)
+	config.Retries.MaxAttempts = maxAttempts
+
+	return config
+}
+
+// downloadRules replace the default rules when they're configured,
+// e.g. "youtube.com=youtube-dl,~\.mp3$=generic"
+func downloadRules() []download.Rule {
+	rulesVal := getEnvOrDefault("DOWNLOAD_RULES", "")
+	if rulesVal == "" {
+		return download.DefaultRules
+	}
+
+	rules, err := download.ParseRules(rulesVal)
+	ensureOk(err)
+
+	return rules
+}
+
 func newSplitJobHandler() split.JobHandler {
 	workingDir := getEnvOrPanic("SPLEETER_WORKING_DIR_PATH")
 	spleeterBinPath := getEnvOrPanic("SPLEETER_BIN_PATH")
@@ -5,4 +5,5 @@ import "context"
 type FileStore interface {
 	GetFile(ctx context.Context, url string) ([]byte, error)
 	WriteFile(ctx context.Context, url string, fileContent []byte) error
+	FileExists(ctx context.Context, url string) (bool, error)
 }
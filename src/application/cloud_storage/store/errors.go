@@ -0,0 +1,40 @@
+package store
+
+import (
+	"chord-paper-be-workers/src/lib/cerr"
+	"errors"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// storageErrorCategory tells rate limiting and outages apart from requests that will never succeed
+func storageErrorCategory(err error) cerr.Category {
+	switch {
+	case errors.Is(err, storage.ErrObjectNotExist):
+		return cerr.Permanent
+	case errors.Is(err, storage.ErrBucketNotExist):
+		return cerr.Infrastructure
+	}
+
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		// most likely the connection, rather than anything the API had to say
+		return cerr.Transient
+	}
+
+	switch {
+	case apiErr.Code == http.StatusTooManyRequests,
+		apiErr.Code == http.StatusRequestTimeout,
+		apiErr.Code >= http.StatusInternalServerError:
+		return cerr.Transient
+	case apiErr.Code == http.StatusUnauthorized,
+		apiErr.Code == http.StatusForbidden:
+		return cerr.Infrastructure
+	case apiErr.Code == http.StatusNotFound:
+		return cerr.Permanent
+	default:
+		return cerr.Uncategorized
+	}
+}
@@ -2,12 +2,16 @@ package store
 
 import (
 	"chord-paper-be-workers/src/application/cloud_storage/entity"
+	"chord-paper-be-workers/src/application/metrics"
+	"chord-paper-be-workers/src/application/tracing"
 	"chord-paper-be-workers/src/lib/cerr"
 	"context"
+	"errors"
 	"io"
 	"strings"
 
 	"cloud.google.com/go/storage"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/api/option"
 )
 
@@ -31,34 +35,46 @@ func NewGoogleFileStore(jsonKey string) (GoogleFileStore, error) {
 	}, nil
 }
 
-func (g GoogleFileStore) GetFile(ctx context.Context, fileURL string) ([]byte, error) {
+func (g GoogleFileStore) GetFile(ctx context.Context, fileURL string) (_ []byte, err error) {
+	ctx, span := tracing.StartSpan(ctx, "file_store.read", attribute.String("file_url", fileURL))
+	defer func() { tracing.End(span, err) }()
+
 	errctx := cerr.Field("file_url", fileURL)
 	bucket, filePath, err := g.bucketAndPathFromURL(fileURL)
 	if err != nil {
-		return nil, errctx.Wrap(err).Error("Couldn't extract file path from URL")
+		return nil, errctx.Categorize(cerr.Permanent).Wrap(err).Error("Couldn't extract file path from URL")
 	}
 
 	objectHandle := g.objectHandle(bucket, filePath)
 	reader, err := objectHandle.NewReader(ctx)
 	if err != nil {
-		return nil, errctx.Wrap(err).Error("Failed to create reader for Google object handle")
+		return nil, errctx.Categorize(storageErrorCategory(err)).
+			Wrap(err).Error("Failed to create reader for Google object handle")
 	}
 
 	defer reader.Close()
 
 	contents, err := io.ReadAll(reader)
 	if err != nil {
-		return nil, errctx.Wrap(err).Error("Failed to read remote file")
+		return nil, errctx.Categorize(storageErrorCategory(err)).
+			Wrap(err).Error("Failed to read remote file")
 	}
 
+	metrics.StorageTransferred(metrics.DirectionRead, len(contents))
+
 	return contents, nil
 }
 
 func (g GoogleFileStore) WriteFile(ctx context.Context, fileURL string, fileContent []byte) (err error) {
+	ctx, span := tracing.StartSpan(ctx, "file_store.write",
+		attribute.String("file_url", fileURL),
+		attribute.Int("bytes", len(fileContent)))
+	defer func() { tracing.End(span, err) }()
+
 	errctx := cerr.Field("write_file_url", fileURL)
 	bucket, filePath, err := g.bucketAndPathFromURL(fileURL)
 	if err != nil {
-		return errctx.Wrap(err).Error("Couldn't extract file path from URL")
+		return errctx.Categorize(cerr.Permanent).Wrap(err).Error("Couldn't extract file path from URL")
 	}
 
 	objectHandle := g.objectHandle(bucket, filePath)
@@ -66,12 +82,50 @@ func (g GoogleFileStore) WriteFile(ctx context.Context, fileURL string, fileCont
 	defer func() {
 		closeErr := writer.Close()
 		if err == nil && closeErr != nil {
-			err = errctx.Wrap(closeErr).Error("Error occurred when closing the upload stream")
+			err = errctx.Categorize(storageErrorCategory(closeErr)).
+				Wrap(closeErr).Error("Error occurred when closing the upload stream")
 		}
 	}()
 
 	if _, err = writer.Write(fileContent); err != nil {
-		return errctx.Wrap(err).Error("Error occurred when uploading file")
+		return errctx.Categorize(storageErrorCategory(err)).
+			Wrap(err).Error("Error occurred when uploading file")
+	}
+
+	metrics.StorageTransferred(metrics.DirectionWritten, len(fileContent))
+
+	return nil
+}
+
+// FileExists only looks at the object's metadata, without downloading it
+func (g GoogleFileStore) FileExists(ctx context.Context, fileURL string) (_ bool, err error) {
+	ctx, span := tracing.StartSpan(ctx, "file_store.exists", attribute.String("file_url", fileURL))
+	defer func() { tracing.End(span, err) }()
+
+	errctx := cerr.Field("file_url", fileURL)
+	bucket, filePath, err := g.bucketAndPathFromURL(fileURL)
+	if err != nil {
+		return false, errctx.Categorize(cerr.Permanent).Wrap(err).Error("Couldn't extract file path from URL")
+	}
+
+	_, err = g.objectHandle(bucket, filePath).Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, errctx.Categorize(storageErrorCategory(err)).
+			Wrap(err).Error("Failed to get object attributes")
+	}
+
+	return true, nil
+}
+
+// CheckBucket makes sure the bucket exists and we're allowed to look at it
+func (g GoogleFileStore) CheckBucket(ctx context.Context, bucketName string) error {
+	if _, err := g.storageClient.Bucket(bucketName).Attrs(ctx); err != nil {
+		return cerr.Field("bucket_name", bucketName).
+			Wrap(err).Error("Failed to get bucket attributes")
 	}
 
 	return nil
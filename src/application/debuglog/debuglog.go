@@ -0,0 +1,255 @@
+package debuglog
+
+import (
+	"chord-paper-be-workers/src/application/tracks/entity"
+	"chord-paper-be-workers/src/application/tracks/store"
+	"chord-paper-be-workers/src/lib/cerr"
+	"chord-paper-be-workers/src/lib/ids"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// DynamoDB's item size limit, the whole tracklist is one item
+	itemSizeLimit = 400 * 1024
+	// the most a track takes up besides its debug log: a full job history of a few hundred bytes an entry,
+	// its outbox and lease, and the track's own fields
+	maxTrackSize = entity.MaxJobHistory*512 + 4*1024
+	// kept free for the tracklist's own attributes and whatever the estimate above misses
+	itemHeadroom = 40 * 1024
+)
+
+// MaxSize is each track's share of what's left of the item when every track in the tracklist
+// is as large as it gets, so that the debug logs can't push the tracklist over DynamoDB's limit
+const MaxSize = (itemSizeLimit-itemHeadroom)/store.MaxTrackIndex - maxTrackSize
+
+// fields longer than this are cut short before anything is dropped, e.g. the full output of a tool
+const maxStringLength = 2048
+
+const redacted = "[REDACTED]"
+
+// Entry is the debug log of a failed job, stored as JSON on the track
+type Entry struct {
+	JobType   string    `json:"job_type"`
+	MessageID string    `json:"message_id,omitempty"`
+	Attempt   int       `json:"attempt"`
+	WorkerID  string    `json:"worker_id"`
+	StartedAt time.Time `json:"started_at"`
+	FailedAt  time.Time `json:"failed_at"`
+	Category  string    `json:"category,omitempty"`
+	Error     string    `json:"error"`
+	// outermost first, the last link is the root cause
+	Chain     []Link   `json:"chain"`
+	Stack     []string `json:"stack,omitempty"`
+	Truncated bool     `json:"truncated,omitempty"`
+}
+
+// Link is one error in the chain, with the fields it was created with
+type Link struct {
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// Job is what's known about the job that failed
+type Job struct {
+	JobType   string
+	MessageID string
+	Attempt   int
+	StartedAt time.Time
+}
+
+// New describes the job's error, redacted and ready to be stored
+func New(job Job, jobErr error) Entry {
+	entry := Entry{
+		JobType:   job.JobType,
+		MessageID: job.MessageID,
+		Attempt:   job.Attempt,
+		WorkerID:  ids.WorkerID(),
+		StartedAt: job.StartedAt,
+		FailedAt:  time.Now().UTC(),
+		Category:  string(cerr.CategoryOf(jobErr)),
+		Error:     redactString(jobErr.Error()),
+		Chain:     chain(jobErr),
+		Stack:     cerr.StackTrace(jobErr).Lines(),
+	}
+
+	return entry
+}
+
+// Format renders the entry as JSON no larger than MaxSize, giving up detail until it fits
+func Format(entry Entry) string {
+	trimmers := []func(*Entry){
+		func(e *Entry) { e.Stack = nil },
+		func(e *Entry) { e.trimStrings() },
+		func(e *Entry) { e.trimChain() },
+	}
+
+	formatted := marshal(entry)
+	for _, trim := range trimmers {
+		if len(formatted) <= MaxSize {
+			return formatted
+		}
+
+		entry.Truncated = true
+		trim(&entry)
+		formatted = marshal(entry)
+	}
+
+	if len(formatted) <= MaxSize {
+		return formatted
+	}
+
+	// the chain is down to the outermost and root cause errors, and is still too large
+	entry.Chain = nil
+	entry.Error = truncate(entry.Error, MaxSize/2)
+	return marshal(entry)
+}
+
+// Describe is New and Format in one
+func Describe(job Job, jobErr error) string {
+	return Format(New(job, jobErr))
+}
+
+func chain(err error) []Link {
+	links := []Link{}
+
+	for err != nil {
+		link := Link{}
+
+		var fields map[string]interface{}
+		if contextual, ok := err.(cerr.ContextualError); ok {
+			link.Message = contextual.Msg
+			fields = contextual.Fields()
+		} else if errors.Unwrap(err) == nil {
+			link.Message = err.Error()
+		} else {
+			// wrappers like fmt.Errorf repeat their wrapped error in their message, which is in the next link
+			link.Message = strings.TrimSuffix(err.Error(), errors.Unwrap(err).Error())
+		}
+
+		link.Message = redactString(link.Message)
+		link.Fields = redactFields(fields)
+		links = append(links, link)
+
+		err = errors.Unwrap(err)
+	}
+
+	return links
+}
+
+func marshal(entry Entry) string {
+	bytes, err := json.Marshal(entry)
+	if err != nil {
+		// a field that doesn't marshal, fall back to what's certain to
+		entry.Chain = nil
+		bytes, _ = json.Marshal(entry)
+	}
+
+	return string(bytes)
+}
+
+func (e *Entry) trimStrings() {
+	e.Error = truncate(e.Error, maxStringLength)
+
+	for i, link := range e.Chain {
+		e.Chain[i].Message = truncate(link.Message, maxStringLength)
+
+		for k, v := range link.Fields {
+			if s, ok := v.(string); ok {
+				link.Fields[k] = truncate(s, maxStringLength)
+			}
+		}
+	}
+}
+
+// trimChain drops links from the middle of the chain until it fits,
+// the outermost and the root cause errors are the ones worth keeping
+func (e *Entry) trimChain() {
+	for len(e.Chain) > 2 && len(marshal(*e)) > MaxSize {
+		middle := len(e.Chain) / 2
+		e.Chain = append(e.Chain[:middle], e.Chain[middle+1:]...)
+	}
+}
+
+func truncate(s string, length int) string {
+	if len(s) <= length {
+		return s
+	}
+
+	return s[:length] + "...(truncated)"
+}
+
+// field names whose values are secret whatever they look like, e.g. api_key or x-amz-security-token.
+// Only whole credential names are matched, so that e.g. cache_key or object_key are kept
+var sensitiveKeyPattern = regexp.MustCompile(`(?i)^(?:.*[_-])?(?:api_?key|access_?key|secret_?key|private_?key|signing_?key|token|secret|password|passwd|credentials?|signature|authorization|cookie)$`)
+
+// query parameters that make a URL a credential, e.g. signed cloud storage URLs
+var sensitiveQueryPattern = regexp.MustCompile(`(?i)([?&](?:x-goog-signature|x-goog-credential|x-amz-signature|x-amz-credential|x-amz-security-token|signature|sig|token|access_token|key|api_key)=)[^&\s"']+`)
+
+func redactString(s string) string {
+	return sensitiveQueryPattern.ReplaceAllString(s, "${1}"+redacted)
+}
+
+func redactFields(fields map[string]interface{}) map[string]interface{} {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	redactedFields := map[string]interface{}{}
+	for k, v := range fields {
+		redactedFields[k] = redactValue(k, v)
+	}
+
+	return redactedFields
+}
+
+func redactValue(key string, value interface{}) interface{} {
+	if sensitiveKeyPattern.MatchString(key) {
+		return redacted
+	}
+
+	switch typed := value.(type) {
+	case string:
+		return redactString(typed)
+	case error:
+		return redactString(typed.Error())
+	case fmt.Stringer:
+		return redactString(typed.String())
+	}
+
+	// anything else, e.g. job params or maps of URLs, is taken apart as JSON so that what's nested gets redacted too
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return redactString(fmt.Sprintf("%+v", value))
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(bytes, &generic); err != nil {
+		return redactString(string(bytes))
+	}
+
+	return redactGeneric(generic)
+}
+
+func redactGeneric(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case string:
+		return redactString(typed)
+	case map[string]interface{}:
+		for k, v := range typed {
+			typed[k] = redactValue(k, v)
+		}
+		return typed
+	case []interface{}:
+		for i, v := range typed {
+			typed[i] = redactGeneric(v)
+		}
+		return typed
+	default:
+		return typed
+	}
+}
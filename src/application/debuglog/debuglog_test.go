@@ -0,0 +1,127 @@
+package debuglog_test
+
+import (
+	"chord-paper-be-workers/src/application/debuglog"
+	"chord-paper-be-workers/src/application/tracks/store"
+	"chord-paper-be-workers/src/lib/cerr"
+	"chord-paper-be-workers/src/lib/ids"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Debug log", func() {
+	var (
+		job     debuglog.Job
+		started time.Time
+	)
+
+	BeforeEach(func() {
+		started = time.Now().Add(-time.Minute).UTC()
+		job = debuglog.Job{
+			JobType:   "split_track",
+			MessageID: "message-id",
+			Attempt:   2,
+			StartedAt: started,
+		}
+	})
+
+	parse := func(formatted string) debuglog.Entry {
+		var entry debuglog.Entry
+		Expect(json.Unmarshal([]byte(formatted), &entry)).To(Succeed())
+		return entry
+	}
+
+	It("describes the job and every error in the chain", func() {
+		root := errors.New("connection reset")
+		jobErr := cerr.Field("tracklist_id", "tracklist-1").
+			Wrap(cerr.Categorize(cerr.Transient).Field("bucket", "chord-paper-tracks").Wrap(root).Error("Failed to upload")).
+			Error("Failed to handle split job")
+
+		entry := parse(debuglog.Describe(job, jobErr))
+
+		Expect(entry.JobType).To(Equal("split_track"))
+		Expect(entry.MessageID).To(Equal("message-id"))
+		Expect(entry.Attempt).To(Equal(2))
+		Expect(entry.WorkerID).To(Equal(ids.WorkerID()))
+		Expect(entry.StartedAt).To(BeTemporally("==", started))
+		Expect(entry.FailedAt).To(BeTemporally("~", time.Now(), time.Minute))
+		Expect(entry.Category).To(Equal("transient"))
+		Expect(entry.Error).To(Equal(jobErr.Error()))
+		Expect(entry.Stack).NotTo(BeEmpty())
+
+		Expect(entry.Chain).To(Equal([]debuglog.Link{
+			{Message: "Failed to handle split job", Fields: map[string]interface{}{"tracklist_id": "tracklist-1"}},
+			{Message: "Failed to upload", Fields: map[string]interface{}{"bucket": "chord-paper-tracks"}},
+			{Message: "connection reset"},
+		}))
+	})
+
+	It("redacts sensitive fields and signed URLs", func() {
+		signedURL := "https://storage.googleapis.com/bucket/track.mp3?X-Goog-Algorithm=GOOG4-RSA-SHA256&X-Goog-Signature=abcdef123"
+		jobErr := cerr.Field("api_key", "hunter2").
+			Field("source_url", signedURL).
+			Field("stem_urls", map[string]string{"vocals": signedURL}).
+			Error("Failed to fetch " + signedURL)
+
+		formatted := debuglog.Describe(job, jobErr)
+		Expect(formatted).NotTo(ContainSubstring("hunter2"))
+		Expect(formatted).NotTo(ContainSubstring("abcdef123"))
+
+		entry := parse(formatted)
+		Expect(entry.Chain[0].Fields).To(HaveKeyWithValue("api_key", "[REDACTED]"))
+		Expect(entry.Chain[0].Fields).To(HaveKeyWithValue("source_url", ContainSubstring("X-Goog-Algorithm=GOOG4-RSA-SHA256")))
+		Expect(entry.Chain[0].Fields).To(HaveKeyWithValue("source_url", ContainSubstring("X-Goog-Signature=[REDACTED]")))
+	})
+
+	It("only redacts fields named after credentials", func() {
+		jobErr := cerr.Field("access_token", "hunter2").
+			Field("X-Amz-Security-Token", "hunter3").
+			Field("client_secret", "hunter4").
+			Field("cache_key", "tracklist-1/track-1").
+			Field("object_key", "tracks/original.mp3").
+			Error("Failed to fetch")
+
+		entry := parse(debuglog.Describe(job, jobErr))
+		Expect(entry.Chain[0].Fields).To(Equal(map[string]interface{}{
+			"access_token":         "[REDACTED]",
+			"X-Amz-Security-Token": "[REDACTED]",
+			"client_secret":        "[REDACTED]",
+			"cache_key":            "tracklist-1/track-1",
+			"object_key":           "tracks/original.mp3",
+		}))
+	})
+
+	It("leaves room for every track in the tracklist to have a debug log as large as it gets", func() {
+		Expect(debuglog.MaxSize).To(BeNumerically(">=", 4*1024))
+		Expect(debuglog.MaxSize * store.MaxTrackIndex).To(BeNumerically("<", 400*1024/4))
+	})
+
+	It("caps its size, keeping the outermost and root cause errors", func() {
+		hugeOutput := strings.Repeat("downloading... ", 10000)
+
+		var jobErr error = cerr.Field("error_msg", hugeOutput).Error("youtube-dl failed")
+		for i := 0; i < 50; i++ {
+			jobErr = cerr.Field("layer", i).Field("detail", strings.Repeat("x", 1000)).Wrap(jobErr).Error("Failed further up")
+		}
+		jobErr = cerr.Wrap(jobErr).Error("Failed to handle transfer job")
+
+		formatted := debuglog.Describe(job, jobErr)
+		Expect(len(formatted)).To(BeNumerically("<=", debuglog.MaxSize))
+
+		entry := parse(formatted)
+		Expect(entry.Truncated).To(BeTrue())
+		Expect(entry.JobType).To(Equal("split_track"))
+		Expect(entry.Chain[0].Message).To(Equal("Failed to handle transfer job"))
+		Expect(entry.Chain[len(entry.Chain)-1].Message).To(Equal("youtube-dl failed"))
+	})
+
+	It("leaves small logs alone", func() {
+		entry := parse(debuglog.Describe(job, cerr.Error("small")))
+		Expect(entry.Truncated).To(BeFalse())
+	})
+})
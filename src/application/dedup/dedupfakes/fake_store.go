@@ -0,0 +1,277 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package dedupfakes
+
+import (
+	"chord-paper-be-workers/src/application/dedup"
+	"context"
+	"sync"
+	"time"
+)
+
+type FakeStore struct {
+	ClaimStub        func(context.Context, string, string, time.Duration) (bool, error)
+	claimMutex       sync.RWMutex
+	claimArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+		arg4 time.Duration
+	}
+	claimReturns struct {
+		result1 bool
+		result2 error
+	}
+	claimReturnsOnCall map[int]struct {
+		result1 bool
+		result2 error
+	}
+	MarkProcessedStub        func(context.Context, string) error
+	markProcessedMutex       sync.RWMutex
+	markProcessedArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+	}
+	markProcessedReturns struct {
+		result1 error
+	}
+	markProcessedReturnsOnCall map[int]struct {
+		result1 error
+	}
+	ReleaseStub        func(context.Context, string, string) error
+	releaseMutex       sync.RWMutex
+	releaseArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+	}
+	releaseReturns struct {
+		result1 error
+	}
+	releaseReturnsOnCall map[int]struct {
+		result1 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeStore) Claim(arg1 context.Context, arg2 string, arg3 string, arg4 time.Duration) (bool, error) {
+	fake.claimMutex.Lock()
+	ret, specificReturn := fake.claimReturnsOnCall[len(fake.claimArgsForCall)]
+	fake.claimArgsForCall = append(fake.claimArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+		arg4 time.Duration
+	}{arg1, arg2, arg3, arg4})
+	stub := fake.ClaimStub
+	fakeReturns := fake.claimReturns
+	fake.recordInvocation("Claim", []interface{}{arg1, arg2, arg3, arg4})
+	fake.claimMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3, arg4)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeStore) ClaimCallCount() int {
+	fake.claimMutex.RLock()
+	defer fake.claimMutex.RUnlock()
+	return len(fake.claimArgsForCall)
+}
+
+func (fake *FakeStore) ClaimCalls(stub func(context.Context, string, string, time.Duration) (bool, error)) {
+	fake.claimMutex.Lock()
+	defer fake.claimMutex.Unlock()
+	fake.ClaimStub = stub
+}
+
+func (fake *FakeStore) ClaimArgsForCall(i int) (context.Context, string, string, time.Duration) {
+	fake.claimMutex.RLock()
+	defer fake.claimMutex.RUnlock()
+	argsForCall := fake.claimArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4
+}
+
+func (fake *FakeStore) ClaimReturns(result1 bool, result2 error) {
+	fake.claimMutex.Lock()
+	defer fake.claimMutex.Unlock()
+	fake.ClaimStub = nil
+	fake.claimReturns = struct {
+		result1 bool
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) ClaimReturnsOnCall(i int, result1 bool, result2 error) {
+	fake.claimMutex.Lock()
+	defer fake.claimMutex.Unlock()
+	fake.ClaimStub = nil
+	if fake.claimReturnsOnCall == nil {
+		fake.claimReturnsOnCall = make(map[int]struct {
+			result1 bool
+			result2 error
+		})
+	}
+	fake.claimReturnsOnCall[i] = struct {
+		result1 bool
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) MarkProcessed(arg1 context.Context, arg2 string) error {
+	fake.markProcessedMutex.Lock()
+	ret, specificReturn := fake.markProcessedReturnsOnCall[len(fake.markProcessedArgsForCall)]
+	fake.markProcessedArgsForCall = append(fake.markProcessedArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.MarkProcessedStub
+	fakeReturns := fake.markProcessedReturns
+	fake.recordInvocation("MarkProcessed", []interface{}{arg1, arg2})
+	fake.markProcessedMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeStore) MarkProcessedCallCount() int {
+	fake.markProcessedMutex.RLock()
+	defer fake.markProcessedMutex.RUnlock()
+	return len(fake.markProcessedArgsForCall)
+}
+
+func (fake *FakeStore) MarkProcessedCalls(stub func(context.Context, string) error) {
+	fake.markProcessedMutex.Lock()
+	defer fake.markProcessedMutex.Unlock()
+	fake.MarkProcessedStub = stub
+}
+
+func (fake *FakeStore) MarkProcessedArgsForCall(i int) (context.Context, string) {
+	fake.markProcessedMutex.RLock()
+	defer fake.markProcessedMutex.RUnlock()
+	argsForCall := fake.markProcessedArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeStore) MarkProcessedReturns(result1 error) {
+	fake.markProcessedMutex.Lock()
+	defer fake.markProcessedMutex.Unlock()
+	fake.MarkProcessedStub = nil
+	fake.markProcessedReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) MarkProcessedReturnsOnCall(i int, result1 error) {
+	fake.markProcessedMutex.Lock()
+	defer fake.markProcessedMutex.Unlock()
+	fake.MarkProcessedStub = nil
+	if fake.markProcessedReturnsOnCall == nil {
+		fake.markProcessedReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.markProcessedReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) Release(arg1 context.Context, arg2 string, arg3 string) error {
+	fake.releaseMutex.Lock()
+	ret, specificReturn := fake.releaseReturnsOnCall[len(fake.releaseArgsForCall)]
+	fake.releaseArgsForCall = append(fake.releaseArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+	}{arg1, arg2, arg3})
+	stub := fake.ReleaseStub
+	fakeReturns := fake.releaseReturns
+	fake.recordInvocation("Release", []interface{}{arg1, arg2, arg3})
+	fake.releaseMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeStore) ReleaseCallCount() int {
+	fake.releaseMutex.RLock()
+	defer fake.releaseMutex.RUnlock()
+	return len(fake.releaseArgsForCall)
+}
+
+func (fake *FakeStore) ReleaseCalls(stub func(context.Context, string, string) error) {
+	fake.releaseMutex.Lock()
+	defer fake.releaseMutex.Unlock()
+	fake.ReleaseStub = stub
+}
+
+func (fake *FakeStore) ReleaseArgsForCall(i int) (context.Context, string, string) {
+	fake.releaseMutex.RLock()
+	defer fake.releaseMutex.RUnlock()
+	argsForCall := fake.releaseArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeStore) ReleaseReturns(result1 error) {
+	fake.releaseMutex.Lock()
+	defer fake.releaseMutex.Unlock()
+	fake.ReleaseStub = nil
+	fake.releaseReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) ReleaseReturnsOnCall(i int, result1 error) {
+	fake.releaseMutex.Lock()
+	defer fake.releaseMutex.Unlock()
+	fake.ReleaseStub = nil
+	if fake.releaseReturnsOnCall == nil {
+		fake.releaseReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.releaseReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.claimMutex.RLock()
+	defer fake.claimMutex.RUnlock()
+	fake.markProcessedMutex.RLock()
+	defer fake.markProcessedMutex.RUnlock()
+	fake.releaseMutex.RLock()
+	defer fake.releaseMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeStore) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ dedup.Store = new(FakeStore)
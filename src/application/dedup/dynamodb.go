@@ -0,0 +1,169 @@
+package dedup
+
+import (
+	"chord-paper-be-workers/src/lib/cerr"
+	"chord-paper-be-workers/src/lib/env"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	tableName = "ProcessedMessages"
+	idField   = "message_id"
+)
+
+const (
+	// expiresAtField is meant to be the table's TTL attribute, so old IDs get cleaned up by DynamoDB.
+	// DynamoDB only deletes expired items eventually, so the conditions check it too
+	expiresAtField = "expires_at"
+	// only set while the message is claimed, processed messages don't have one
+	claimIDField = "claim_id"
+
+	nowValueName     = ":now"
+	claimIDValueName = ":claimID"
+)
+
+var _ Store = DynamoDBStore{}
+
+func NewDynamoDBStore(environment env.Environment, ttl time.Duration) DynamoDBStore {
+	dbSession := session.Must(session.NewSession())
+
+	config := aws.NewConfig().WithCredentials(credentials.NewEnvCredentials())
+
+	switch environment {
+	case env.Production:
+		config = config.WithRegion("us-east-2")
+
+	case env.Development:
+		config = config.WithEndpoint("http://localhost:8000").
+			WithRegion("localhost")
+
+	default:
+		panic("Unrecognized environment")
+	}
+
+	return NewDynamoDBStoreWithClient(dynamodb.New(dbSession, config), ttl)
+}
+
+func NewDynamoDBStoreWithClient(dynamoDBClient dynamodbiface.DynamoDBAPI, ttl time.Duration) DynamoDBStore {
+	return DynamoDBStore{
+		dynamoDBClient: dynamoDBClient,
+		ttl:            ttl,
+	}
+}
+
+type DynamoDBStore struct {
+	dynamoDBClient dynamodbiface.DynamoDBAPI
+	ttl            time.Duration
+}
+
+// Claim puts the claim as long as there's no item for the message, or only an expired one or our own claim.
+// Processed messages have no claim ID, so they can't be claimed until they expire
+func (d DynamoDBStore) Claim(ctx context.Context, messageID string, claimID string, duration time.Duration) (bool, error) {
+	now := time.Now()
+
+	item := makeKey(messageID)
+	item[expiresAtField] = unixTime(now.Add(duration))
+	item[claimIDField] = stringValue(claimID)
+
+	conditionExpression := fmt.Sprintf("attribute_not_exists(%s) OR %s < %s OR %s = %s",
+		idField, expiresAtField, nowValueName, claimIDField, claimIDValueName)
+
+	_, err := d.dynamoDBClient.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		ConditionExpression: &conditionExpression,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			nowValueName:     unixTime(now),
+			claimIDValueName: stringValue(claimID),
+		},
+		Item:      item,
+		TableName: &tableName,
+	})
+
+	if conditionFailed(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, cerr.Field("message_id", messageID).
+			Wrap(err).Error("Failed to put message claim into DynamoDB")
+	}
+
+	return true, nil
+}
+
+func (d DynamoDBStore) MarkProcessed(ctx context.Context, messageID string) error {
+	item := makeKey(messageID)
+	item[expiresAtField] = unixTime(time.Now().Add(d.ttl))
+
+	_, err := d.dynamoDBClient.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		Item:      item,
+		TableName: &tableName,
+	})
+
+	if err != nil {
+		return cerr.Field("message_id", messageID).
+			Wrap(err).Error("Failed to put processed message into DynamoDB")
+	}
+
+	return nil
+}
+
+func (d DynamoDBStore) Release(ctx context.Context, messageID string, claimID string) error {
+	conditionExpression := fmt.Sprintf("%s = %s", claimIDField, claimIDValueName)
+
+	_, err := d.dynamoDBClient.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		ConditionExpression: &conditionExpression,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			claimIDValueName: stringValue(claimID),
+		},
+		Key:       makeKey(messageID),
+		TableName: &tableName,
+	})
+
+	// the message was processed or someone else claimed it since, either way it isn't ours to release
+	if conditionFailed(err) {
+		return nil
+	}
+
+	if err != nil {
+		return cerr.Field("message_id", messageID).
+			Wrap(err).Error("Failed to delete message claim from DynamoDB")
+	}
+
+	return nil
+}
+
+func conditionFailed(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+func unixTime(t time.Time) *dynamodb.AttributeValue {
+	attributeValue := dynamodb.AttributeValue{}
+	attributeValue.SetN(strconv.FormatInt(t.Unix(), 10))
+	return &attributeValue
+}
+
+func stringValue(s string) *dynamodb.AttributeValue {
+	attributeValue := dynamodb.AttributeValue{}
+	attributeValue.SetS(s)
+	return &attributeValue
+}
+
+func makeKey(messageID string) map[string]*dynamodb.AttributeValue {
+	attributeValue := dynamodb.AttributeValue{}
+	attributeValue.SetS(messageID)
+	return map[string]*dynamodb.AttributeValue{
+		idField: &attributeValue,
+	}
+}
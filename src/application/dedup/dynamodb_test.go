@@ -0,0 +1,196 @@
+package dedup_test
+
+import (
+	"chord-paper-be-workers/src/application/dedup"
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// table keeps the items put into it and checks the store's conditions the way DynamoDB would,
+// the rest of the DynamoDB API panics if the store ever calls it
+type table struct {
+	dynamodbiface.DynamoDBAPI
+	items    map[string]map[string]*dynamodb.AttributeValue
+	putInput *dynamodb.PutItemInput
+	err      error
+}
+
+func (t *table) PutItemWithContext(_ aws.Context, input *dynamodb.PutItemInput, _ ...request.Option) (*dynamodb.PutItemOutput, error) {
+	t.putInput = input
+	if t.err != nil {
+		return nil, t.err
+	}
+
+	id := *input.Item["message_id"].S
+	if input.ConditionExpression != nil && !t.claimable(id, input.ExpressionAttributeValues) {
+		return nil, conditionFailed()
+	}
+
+	t.items[id] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (t *table) DeleteItemWithContext(_ aws.Context, input *dynamodb.DeleteItemInput, _ ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+
+	id := *input.Key["message_id"].S
+	if !t.claimedBy(id, *input.ExpressionAttributeValues[":claimID"].S) {
+		return nil, conditionFailed()
+	}
+
+	delete(t.items, id)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+// claimable is the claim's condition: no item, an expired one or one with the same claim ID
+func (t *table) claimable(id string, values map[string]*dynamodb.AttributeValue) bool {
+	item, ok := t.items[id]
+	if !ok {
+		return true
+	}
+
+	if expiresAt, ok := item["expires_at"]; ok && unix(expiresAt) < unix(values[":now"]) {
+		return true
+	}
+
+	return t.claimedBy(id, *values[":claimID"].S)
+}
+
+func (t *table) claimedBy(id string, claimID string) bool {
+	claim, ok := t.items[id]["claim_id"]
+	return ok && *claim.S == claimID
+}
+
+func unix(value *dynamodb.AttributeValue) int64 {
+	seconds, err := strconv.ParseInt(*value.N, 10, 64)
+	Expect(err).NotTo(HaveOccurred())
+	return seconds
+}
+
+func conditionFailed() error {
+	return awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+}
+
+var _ = Describe("DynamoDBStore", func() {
+	var (
+		ctx   context.Context
+		db    *table
+		store dedup.DynamoDBStore
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		db = &table{items: map[string]map[string]*dynamodb.AttributeValue{}}
+		store = dedup.NewDynamoDBStoreWithClient(db, time.Hour)
+	})
+
+	expiresAt := func() time.Time {
+		return time.Unix(unix(db.items["message-id"]["expires_at"]), 0)
+	}
+
+	claim := func(claimID string) bool {
+		claimed, err := store.Claim(ctx, "message-id", claimID, time.Minute)
+		Expect(err).NotTo(HaveOccurred())
+		return claimed
+	}
+
+	It("claims messages that were never processed", func() {
+		Expect(claim("claim-id")).To(BeTrue())
+		Expect(*db.putInput.TableName).To(Equal("ProcessedMessages"))
+	})
+
+	It("claims messages for the duration", func() {
+		Expect(claim("claim-id")).To(BeTrue())
+		Expect(expiresAt()).To(BeTemporally("~", time.Now().Add(time.Minute), 2*time.Second))
+	})
+
+	It("doesn't claim messages that another handler claimed", func() {
+		Expect(claim("claim-id")).To(BeTrue())
+		Expect(claim("other-claim-id")).To(BeFalse())
+
+		claimed, err := store.Claim(ctx, "other-message-id", "other-claim-id", time.Minute)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(claimed).To(BeTrue())
+	})
+
+	It("renews the claim for the handler that holds it", func() {
+		Expect(claim("claim-id")).To(BeTrue())
+		Expect(claim("claim-id")).To(BeTrue())
+	})
+
+	It("claims messages whose claim lapsed but wasn't deleted yet", func() {
+		_, err := store.Claim(ctx, "message-id", "claim-id", -time.Minute)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(claim("other-claim-id")).To(BeTrue())
+	})
+
+	It("doesn't claim messages once they're marked processed", func() {
+		Expect(claim("claim-id")).To(BeTrue())
+		Expect(store.MarkProcessed(ctx, "message-id")).To(Succeed())
+
+		Expect(claim("claim-id")).To(BeFalse())
+		Expect(claim("other-claim-id")).To(BeFalse())
+	})
+
+	It("marks messages to expire after the TTL", func() {
+		Expect(store.MarkProcessed(ctx, "message-id")).To(Succeed())
+		Expect(expiresAt()).To(BeTemporally("~", time.Now().Add(time.Hour), 2*time.Second))
+	})
+
+	It("claims messages that were processed so long ago they expired", func() {
+		Expect(store.MarkProcessed(ctx, "message-id")).To(Succeed())
+		db.items["message-id"]["expires_at"] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)),
+		}
+
+		Expect(claim("claim-id")).To(BeTrue())
+	})
+
+	It("lets the next handler claim a released message", func() {
+		Expect(claim("claim-id")).To(BeTrue())
+		Expect(store.Release(ctx, "message-id", "claim-id")).To(Succeed())
+
+		Expect(claim("other-claim-id")).To(BeTrue())
+	})
+
+	It("only releases the claim with the claim ID", func() {
+		Expect(claim("claim-id")).To(BeTrue())
+		Expect(store.Release(ctx, "message-id", "other-claim-id")).To(Succeed())
+
+		Expect(claim("other-claim-id")).To(BeFalse())
+	})
+
+	It("doesn't release messages that were processed", func() {
+		Expect(store.MarkProcessed(ctx, "message-id")).To(Succeed())
+		Expect(store.Release(ctx, "message-id", "claim-id")).To(Succeed())
+
+		Expect(claim("claim-id")).To(BeFalse())
+	})
+
+	It("fails when DynamoDB does", func() {
+		db.err = errors.New("throttled")
+
+		_, err := store.Claim(ctx, "message-id", "claim-id", time.Minute)
+		Expect(err).To(MatchError(ContainSubstring("throttled")))
+
+		err = store.MarkProcessed(ctx, "message-id")
+		Expect(err).To(MatchError(ContainSubstring("throttled")))
+
+		err = store.Release(ctx, "message-id", "claim-id")
+		Expect(err).To(MatchError(ContainSubstring("throttled")))
+	})
+})
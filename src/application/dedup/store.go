@@ -0,0 +1,93 @@
+package dedup
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -generate
+
+//counterfeiter:generate . Store
+
+// Store remembers the messages that are being or have been processed, so that redeliveries
+// and copies of a message are skipped. Claiming is atomic, only one handler gets a message's claim
+type Store interface {
+	// Claim takes the message for the handler with the claim ID, unless it was processed already
+	// or another handler holds an unexpired claim on it. Claiming again with the same claim ID renews it,
+	// a claim that isn't renewed lapses after the duration, so that a dead worker's message can run again
+	Claim(ctx context.Context, messageID string, claimID string, duration time.Duration) (bool, error)
+	// MarkProcessed replaces the claim, the message is skipped until the store's TTL is up
+	MarkProcessed(ctx context.Context, messageID string) error
+	// Release gives up the claim on a message that wasn't processed, so that its next attempt can claim it.
+	// Only the claim with the claim ID is released
+	Release(ctx context.Context, messageID string, claimID string) error
+}
+
+var _ Store = &InMemoryStore{}
+
+// NewInMemoryStore only deduplicates within the one process,
+// which covers redeliveries to the same worker and tests
+func NewInMemoryStore(ttl time.Duration) *InMemoryStore {
+	return &InMemoryStore{
+		ttl:      ttl,
+		messages: map[string]entry{},
+	}
+}
+
+type InMemoryStore struct {
+	ttl      time.Duration
+	mutex    sync.Mutex
+	messages map[string]entry
+}
+
+type entry struct {
+	expiresAt time.Time
+	// empty once the message is processed
+	claimID string
+}
+
+func (i *InMemoryStore) Claim(_ context.Context, messageID string, claimID string, duration time.Duration) (bool, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	now := time.Now()
+	i.removeExpired(now)
+
+	if existing, ok := i.messages[messageID]; ok && existing.claimID != claimID {
+		return false, nil
+	}
+
+	i.messages[messageID] = entry{expiresAt: now.Add(duration), claimID: claimID}
+	return true, nil
+}
+
+func (i *InMemoryStore) MarkProcessed(_ context.Context, messageID string) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	now := time.Now()
+	i.removeExpired(now)
+
+	i.messages[messageID] = entry{expiresAt: now.Add(i.ttl)}
+	return nil
+}
+
+func (i *InMemoryStore) Release(_ context.Context, messageID string, claimID string) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if existing, ok := i.messages[messageID]; ok && existing.claimID == claimID {
+		delete(i.messages, messageID)
+	}
+
+	return nil
+}
+
+func (i *InMemoryStore) removeExpired(now time.Time) {
+	for id, existing := range i.messages {
+		if !now.Before(existing.expiresAt) {
+			delete(i.messages, id)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package dedup_test
+
+import (
+	"chord-paper-be-workers/src/application/dedup"
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("InMemoryStore", func() {
+	var (
+		ctx   context.Context
+		store *dedup.InMemoryStore
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		store = dedup.NewInMemoryStore(time.Hour)
+	})
+
+	claim := func(claimID string, duration time.Duration) bool {
+		claimed, err := store.Claim(ctx, "message-id", claimID, duration)
+		Expect(err).NotTo(HaveOccurred())
+		return claimed
+	}
+
+	It("only lets one handler claim a message", func() {
+		Expect(claim("claim-id", time.Minute)).To(BeTrue())
+		Expect(claim("other-claim-id", time.Minute)).To(BeFalse())
+		Expect(claim("claim-id", time.Minute)).To(BeTrue())
+	})
+
+	It("lets another handler claim a message once the claim lapses", func() {
+		Expect(claim("claim-id", time.Millisecond)).To(BeTrue())
+		time.Sleep(5 * time.Millisecond)
+
+		Expect(claim("other-claim-id", time.Minute)).To(BeTrue())
+	})
+
+	It("doesn't let anyone claim a processed message", func() {
+		Expect(claim("claim-id", time.Minute)).To(BeTrue())
+		Expect(store.MarkProcessed(ctx, "message-id")).To(Succeed())
+
+		Expect(claim("claim-id", time.Minute)).To(BeFalse())
+		Expect(claim("other-claim-id", time.Minute)).To(BeFalse())
+	})
+
+	It("only releases the claim with the claim ID", func() {
+		Expect(claim("claim-id", time.Minute)).To(BeTrue())
+
+		Expect(store.Release(ctx, "message-id", "other-claim-id")).To(Succeed())
+		Expect(claim("other-claim-id", time.Minute)).To(BeFalse())
+
+		Expect(store.Release(ctx, "message-id", "claim-id")).To(Succeed())
+		Expect(claim("other-claim-id", time.Minute)).To(BeTrue())
+	})
+})
@@ -1,11 +1,14 @@
 package executor
 
-import "os/exec"
+import (
+	"context"
+	"os/exec"
+)
 
 var _ Executor = BinaryFileExecutor{}
 
 type Executor interface {
-	Command(name string, arg ...string) Command
+	Command(ctx context.Context, name string, arg ...string) Command
 }
 
 type Command interface {
@@ -16,9 +19,9 @@ type Command interface {
 // the only reason this is here is to create an interface for testing
 type BinaryFileExecutor struct{}
 
-func (b BinaryFileExecutor) Command(name string, arg ...string) Command {
+func (b BinaryFileExecutor) Command(ctx context.Context, name string, arg ...string) Command {
 	return &BinaryFileCommand{
-		cmd: exec.Command(name, arg...),
+		cmd: exec.CommandContext(ctx, name, arg...),
 	}
 
 }
@@ -0,0 +1,34 @@
+package executor
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+const UnknownVersion = "unknown"
+
+const versionTimeout = 10 * time.Second
+
+// Version asks a tool for its version, for the record rather than for anything to depend on
+func Version(ctx context.Context, executor Executor, binPath string) string {
+	return VersionWithFlag(ctx, executor, binPath, "--version")
+}
+
+// VersionWithFlag is for tools that don't take --version, e.g. ffmpeg's -version
+func VersionWithFlag(ctx context.Context, executor Executor, binPath string, flag string) string {
+	ctx, cancel := context.WithTimeout(ctx, versionTimeout)
+	defer cancel()
+
+	output, err := executor.Command(ctx, binPath, flag).CombinedOutput()
+	if err != nil {
+		return UnknownVersion
+	}
+
+	firstLine := strings.TrimSpace(strings.SplitN(string(output), "\n", 2)[0])
+	if firstLine == "" {
+		return UnknownVersion
+	}
+
+	return firstLine
+}
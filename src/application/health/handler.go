@@ -0,0 +1,154 @@
+package health
+
+import (
+	"chord-paper-be-workers/src/application/worker"
+	"chord-paper-be-workers/src/lib/cerr"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+const checkTimeout = 5 * time.Second
+
+// Check reports whether one of the worker's dependencies is usable
+type Check func(ctx context.Context) error
+
+// ActiveJobs lists what the worker is busy with
+type ActiveJobs func() []worker.JobStatus
+
+// NewHandler serves /healthz for liveness, /readyz for readiness based on the checks,
+// and /status with the jobs currently in flight
+func NewHandler(checks map[string]Check, activeJobs ActiveJobs) http.Handler {
+	h := handler{
+		checks:     checks,
+		activeJobs: activeJobs,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", h.healthz)
+	mux.HandleFunc("/readyz", h.readyz)
+	mux.HandleFunc("/status", h.status)
+	return mux
+}
+
+type handler struct {
+	checks     map[string]Check
+	activeJobs ActiveJobs
+}
+
+type readyResponse struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+type jobResponse struct {
+	JobType     string    `json:"job_type"`
+	MessageID   string    `json:"message_id,omitempty"`
+	TrackListID string    `json:"tracklist_id,omitempty"`
+	TrackID     string    `json:"track_id,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	Elapsed     string    `json:"elapsed"`
+}
+
+type statusResponse struct {
+	Jobs []jobResponse `json:"jobs"`
+}
+
+// healthz only says the process is up and serving, anything deeper belongs in readyz
+func (h handler) healthz(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
+
+func (h handler) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	response := readyResponse{
+		Ready:  true,
+		Checks: map[string]string{},
+	}
+
+	// the checks are network calls, run them side by side so one slow one doesn't time the probe out
+	mutex := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for name, check := range h.checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+
+			err := check(ctx)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if err != nil {
+				log.WithField("check", name).WithError(err).Warn("Readiness check failed")
+				response.Ready = false
+				response.Checks[name] = err.Error()
+				return
+			}
+
+			response.Checks[name] = "ok"
+		}(name, check)
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	if !response.Ready {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, response)
+}
+
+func (h handler) status(w http.ResponseWriter, _ *http.Request) {
+	now := time.Now()
+
+	response := statusResponse{
+		Jobs: []jobResponse{},
+	}
+
+	for _, job := range h.activeJobs() {
+		response.Jobs = append(response.Jobs, jobResponse{
+			JobType:     job.JobType,
+			MessageID:   job.MessageID,
+			TrackListID: job.TrackListID,
+			TrackID:     job.TrackID,
+			StartedAt:   job.StartedAt,
+			Elapsed:     now.Sub(job.StartedAt).Round(time.Second).String(),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		cerr.Log(cerr.Wrap(err).Error("Failed to write response"))
+	}
+}
+
+// BinaryCheck makes sure a binary we shell out to is there and executable
+func BinaryCheck(path string) Check {
+	return func(_ context.Context) error {
+		info, err := os.Stat(path)
+		if err != nil {
+			return cerr.Field("path", path).Wrap(err).Error("Binary not found")
+		}
+
+		if info.IsDir() || info.Mode()&0111 == 0 {
+			return cerr.Field("path", path).Error("Binary is not executable")
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,116 @@
+package health_test
+
+import (
+	"chord-paper-be-workers/src/application/health"
+	"chord-paper-be-workers/src/application/jobs/split"
+	"chord-paper-be-workers/src/application/worker"
+	"chord-paper-be-workers/src/lib/cerr"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Handler", func() {
+	var (
+		checks     map[string]health.Check
+		activeJobs []worker.JobStatus
+
+		get = func(path string) *httptest.ResponseRecorder {
+			handler := health.NewHandler(checks, func() []worker.JobStatus {
+				return activeJobs
+			})
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+			return recorder
+		}
+
+		passing = func(_ context.Context) error {
+			return nil
+		}
+	)
+
+	BeforeEach(func() {
+		checks = map[string]health.Check{
+			"rabbitmq": passing,
+			"dynamodb": passing,
+		}
+		activeJobs = []worker.JobStatus{}
+	})
+
+	Describe("/healthz", func() {
+		It("is ok even when dependencies are down", func() {
+			checks["dynamodb"] = func(_ context.Context) error {
+				return cerr.Error("down")
+			}
+
+			Expect(get("/healthz").Code).To(Equal(http.StatusOK))
+		})
+	})
+
+	Describe("/readyz", func() {
+		It("is ready when every check passes", func() {
+			response := get("/readyz")
+			Expect(response.Code).To(Equal(http.StatusOK))
+
+			var body map[string]interface{}
+			Expect(json.Unmarshal(response.Body.Bytes(), &body)).To(Succeed())
+			Expect(body["ready"]).To(BeTrue())
+		})
+
+		It("is unavailable and names the failing check", func() {
+			checks["dynamodb"] = func(_ context.Context) error {
+				return cerr.Error("down")
+			}
+
+			response := get("/readyz")
+			Expect(response.Code).To(Equal(http.StatusServiceUnavailable))
+
+			var body struct {
+				Ready  bool              `json:"ready"`
+				Checks map[string]string `json:"checks"`
+			}
+			Expect(json.Unmarshal(response.Body.Bytes(), &body)).To(Succeed())
+			Expect(body.Ready).To(BeFalse())
+			Expect(body.Checks["rabbitmq"]).To(Equal("ok"))
+			Expect(body.Checks["dynamodb"]).To(ContainSubstring("down"))
+		})
+	})
+
+	Describe("/status", func() {
+		It("lists the jobs in flight", func() {
+			activeJobs = []worker.JobStatus{
+				{
+					JobType:     split.JobType,
+					TrackListID: "tracklist-id",
+					TrackID:     "track-id",
+					StartedAt:   time.Now().Add(-time.Minute),
+				},
+			}
+
+			response := get("/status")
+			Expect(response.Code).To(Equal(http.StatusOK))
+
+			var body struct {
+				Jobs []map[string]interface{} `json:"jobs"`
+			}
+			Expect(json.Unmarshal(response.Body.Bytes(), &body)).To(Succeed())
+			Expect(body.Jobs).To(HaveLen(1))
+			Expect(body.Jobs[0]["job_type"]).To(Equal(split.JobType))
+			Expect(body.Jobs[0]["track_id"]).To(Equal("track-id"))
+			Expect(body.Jobs[0]["elapsed"]).To(Equal("1m0s"))
+		})
+	})
+
+	Describe("BinaryCheck", func() {
+		It("fails for a missing binary", func() {
+			err := health.BinaryCheck("/does/not/exist")(context.Background())
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,104 @@
+package dummy
+
+import (
+	"chord-paper-be-workers/src/application/executor"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var _ executor.Executor = &FFmpegExecutor{}
+
+// MP3Probe is ffprobe's output for a plain MP3
+const MP3Probe = `{
+	"streams": [{"codec_name": "mp3", "codec_type": "audio", "sample_rate": "44100", "channels": 2, "disposition": {"attached_pic": 0}}],
+	"format": {"format_name": "mp3", "duration": "215.250000"}
+}`
+
+// MP4VideoProbe is ffprobe's output for a music video
+const MP4VideoProbe = `{
+	"streams": [
+		{"codec_name": "h264", "codec_type": "video", "disposition": {"attached_pic": 0}},
+		{"codec_name": "aac", "codec_type": "audio", "sample_rate": "48000", "channels": 2, "disposition": {"attached_pic": 0}}
+	],
+	"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "183.500000"}
+}`
+
+func NewDummyFFmpegExecutor() *FFmpegExecutor {
+	return &FFmpegExecutor{
+		ProbeOutput: MP3Probe,
+	}
+}
+
+// FFmpegExecutor probes every file as ProbeOutput, and converts files by copying them
+type FFmpegExecutor struct {
+	Unavailable bool
+	ProbeOutput string
+	// the tool and arguments of every command run, in order
+	Commands [][]string
+}
+
+type FFmpegCommand struct {
+	Unavailable bool
+	ProbeOutput string
+	Tool        string
+	Args        []string
+}
+
+func (f *FFmpegExecutor) Command(_ context.Context, name string, arg ...string) executor.Command {
+	tool := filepath.Base(name)
+	f.Commands = append(f.Commands, append([]string{tool}, arg...))
+
+	return FFmpegCommand{
+		Unavailable: f.Unavailable,
+		ProbeOutput: f.ProbeOutput,
+		Tool:        tool,
+		Args:        arg,
+	}
+}
+
+// Ran reports whether a command of the tool was run
+func (f *FFmpegExecutor) Ran(tool string) bool {
+	for _, command := range f.Commands {
+		if command[0] == tool {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f FFmpegCommand) SetDir(_ string) {}
+
+func (f FFmpegCommand) CombinedOutput() ([]byte, error) {
+	if f.Unavailable {
+		return nil, NetworkFailure
+	}
+
+	switch {
+	case strings.HasPrefix(f.Tool, "ffprobe"):
+		return []byte(f.ProbeOutput), nil
+
+	case strings.HasPrefix(f.Tool, "ffmpeg"):
+		sourcePath, err := getOptionValue(f.Args, "-i")
+		if err != nil {
+			return nil, err
+		}
+
+		contents, err := os.ReadFile(sourcePath)
+		if err != nil {
+			return nil, err
+		}
+
+		outPath := f.Args[len(f.Args)-1]
+		if err := os.WriteFile(outPath, contents, os.ModePerm); err != nil {
+			return nil, err
+		}
+
+		return nil, nil
+
+	default:
+		return nil, UnexpectedInput
+	}
+}
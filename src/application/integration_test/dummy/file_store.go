@@ -48,3 +48,15 @@ func (t *FileStore) WriteFile(_ context.Context, url string, fileContent []byte)
 
 	return nil
 }
+
+func (t *FileStore) FileExists(_ context.Context, url string) (bool, error) {
+	if t.Unavailable {
+		return false, NetworkFailure
+	}
+
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	_, ok := t.State[url]
+
+	return ok, nil
+}
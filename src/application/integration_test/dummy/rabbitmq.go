@@ -2,50 +2,110 @@ package dummy
 
 import (
 	"chord-paper-be-workers/src/application/publish"
+	"chord-paper-be-workers/src/application/retry"
 	"chord-paper-be-workers/src/application/worker"
+	"context"
+	"sync"
 
 	"github.com/streadway/amqp"
 )
 
 var _ publish.Publisher = &RabbitMQ{}
 var _ worker.MessageChannel = &RabbitMQ{}
+var _ retry.Retrier = &RabbitMQ{}
 var _ amqp.Acknowledger = RabbitMQAcknowledger{}
 
+// RabbitMQ is used by the worker's goroutines, so what it records is only read through its getters
 type RabbitMQ struct {
-	AckCounter     int
-	NackCounter    int
+	RetryPolicies  retry.Policies
 	Unavailable    bool
 	MessageChannel chan amqp.Delivery
+
+	mutex sync.Mutex
+	// queues that had messages published straight to them, see PublishTo.
+	// Consuming any other queue gets MessageChannel, where everything published by job type goes
+	queues       map[string]chan amqp.Delivery
+	ackCount     int
+	nackCount    int
+	requeueCount int
+	retryCount   int
+	deadLetters  []amqp.Publishing
+	cancelled    bool
+
+	// the channel's prefetch count, and the one for the consumers started next, 0 is unlimited
+	prefetchCount    int
+	consumerPrefetch int
+	// deliveries handed out across all consumers that weren't acked or nacked yet
+	unacked int
+	// signalled whenever a delivery is settled or a consumer stops
+	settled *sync.Cond
+	// closed to stop a consumer, by consumer tag
+	consumers map[string]chan struct{}
 }
 
 type RabbitMQAcknowledger struct {
 	ack  func()
-	nack func()
+	nack func(requeue bool)
 }
 
 func NewRabbitMQ() *RabbitMQ {
-	return &RabbitMQ{
+	r := &RabbitMQ{
 		Unavailable:    false,
+		RetryPolicies:  retry.NoRetries(),
 		MessageChannel: make(chan amqp.Delivery, 100),
+		queues:         map[string]chan amqp.Delivery{},
+		consumers:      map[string]chan struct{}{},
 	}
+
+	r.settled = sync.NewCond(&r.mutex)
+	return r
 }
 
-func (r *RabbitMQ) Publish(msg amqp.Publishing) error {
+func (r *RabbitMQ) Publish(_ context.Context, msg amqp.Publishing) error {
 	if r.Unavailable {
 		return NetworkFailure
 	}
 
+	r.MessageChannel <- r.delivery(msg)
+	return nil
+}
+
+// PublishTo puts a message on a named queue, which is only delivered to whoever consumes that queue.
+// Messages have to be published before the queue is consumed
+func (r *RabbitMQ) PublishTo(queueName string, msg amqp.Publishing) {
+	r.mutex.Lock()
+	queue, ok := r.queues[queueName]
+	if !ok {
+		queue = make(chan amqp.Delivery, 100)
+		r.queues[queueName] = queue
+	}
+	r.mutex.Unlock()
+
+	queue <- r.delivery(msg)
+}
+
+func (r *RabbitMQ) delivery(msg amqp.Publishing) amqp.Delivery {
 	acknowledger := RabbitMQAcknowledger{
 		ack: func() {
-			r.AckCounter++
+			r.mutex.Lock()
+			defer r.mutex.Unlock()
+			r.ackCount++
 		},
-		nack: func() {
-			r.NackCounter++
+		nack: func(requeue bool) {
+			r.mutex.Lock()
+			defer r.mutex.Unlock()
+			if requeue {
+				r.requeueCount++
+			} else {
+				r.nackCount++
+			}
 		},
 	}
 
-	r.MessageChannel <- amqp.Delivery{
+	return amqp.Delivery{
 		Acknowledger:    acknowledger,
+		Headers:         msg.Headers,
+		MessageId:       msg.MessageId,
 		ContentType:     msg.ContentType,
 		ContentEncoding: msg.ContentEncoding,
 		DeliveryMode:    msg.DeliveryMode,
@@ -53,30 +113,246 @@ func (r *RabbitMQ) Publish(msg amqp.Publishing) error {
 		Type:            msg.Type,
 		Body:            msg.Body,
 	}
+}
+
+// Retry skips the delay and puts the next attempt straight back on the queue
+func (r *RabbitMQ) Retry(ctx context.Context, message amqp.Delivery, jobErr error) error {
+	if r.Unavailable {
+		return NetworkFailure
+	}
+
+	if r.RetryPolicies.IsFinalAttempt(message) {
+		deadLetter := retry.ToPublishing(message)
+		deadLetter.Headers[retry.ErrorHeader] = retry.ErrorHeaderValue(jobErr)
+
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.deadLetters = append(r.deadLetters, deadLetter)
+		return nil
+	}
+
+	r.mutex.Lock()
+	r.retryCount++
+	r.mutex.Unlock()
+
+	return r.Publish(ctx, retry.NextAttempt(message))
+}
+
+func (r *RabbitMQ) DeadLetterCount() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.deadLetters)
+}
+
+func (r *RabbitMQ) DeadLetters() []amqp.Publishing {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]amqp.Publishing{}, r.deadLetters...)
+}
+
+func (r *RabbitMQ) AckCount() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.ackCount
+}
+
+func (r *RabbitMQ) NackCount() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.nackCount
+}
+
+func (r *RabbitMQ) RequeueCount() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.requeueCount
+}
+
+func (r *RabbitMQ) RetryCount() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.retryCount
+}
+
+func (r *RabbitMQ) PrefetchCount() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.prefetchCount
+}
+
+func (r *RabbitMQ) Cancelled() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.cancelled
+}
+
+// Qos works like RabbitMQ's, a global prefetch count is shared by the consumers on the channel,
+// otherwise it applies to each consumer that's started after it
+func (r *RabbitMQ) Qos(prefetchCount int, _ int, global bool) error {
+	if r.Unavailable {
+		return NetworkFailure
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if global {
+		r.prefetchCount = prefetchCount
+	} else {
+		r.consumerPrefetch = prefetchCount
+	}
+
 	return nil
 }
 
-func (r *RabbitMQ) Consume(_ string, _ string, _ bool, _ bool, _ bool, _ bool, _ amqp.Table) (<-chan amqp.Delivery, error) {
+// Consume hands out the queue's messages for as long as they fit in the prefetch counts
+func (r *RabbitMQ) Consume(queueName string, consumerTag string, _ bool, _ bool, _ bool, _ bool, _ amqp.Table) (<-chan amqp.Delivery, error) {
 	if r.Unavailable {
 		return nil, NetworkFailure
 	}
 
-	return r.MessageChannel, nil
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	source := r.MessageChannel
+	if queue, ok := r.queues[queueName]; ok {
+		source = queue
+	}
+
+	stopped := make(chan struct{})
+	r.consumers[consumerTag] = stopped
+
+	deliveries := make(chan amqp.Delivery)
+	go r.deliver(source, deliveries, stopped, r.consumerPrefetch)
+
+	return deliveries, nil
+}
+
+func (r *RabbitMQ) deliver(source chan amqp.Delivery, deliveries chan<- amqp.Delivery, stopped <-chan struct{}, prefetchCount int) {
+	defer close(deliveries)
+
+	// guarded by the mutex, like the channel's count
+	unacked := 0
+	hasRoom := func() bool {
+		return (r.prefetchCount == 0 || r.unacked < r.prefetchCount) &&
+			(prefetchCount == 0 || unacked < prefetchCount)
+	}
+
+	for {
+		var message amqp.Delivery
+		select {
+		case message = <-source:
+		case <-stopped:
+			return
+		}
+
+		// held on to until there's room for it, like the broker does for a consumer at its prefetch count
+		r.mutex.Lock()
+		for !isClosed(stopped) && !hasRoom() {
+			r.settled.Wait()
+		}
+
+		if isClosed(stopped) {
+			r.mutex.Unlock()
+			source <- message
+			return
+		}
+
+		r.unacked++
+		unacked++
+		r.mutex.Unlock()
+
+		once := sync.Once{}
+		settle := func() {
+			once.Do(func() {
+				r.mutex.Lock()
+				defer r.mutex.Unlock()
+				r.unacked--
+				unacked--
+				r.settled.Broadcast()
+			})
+		}
+
+		message.Acknowledger = settlingAcknowledger{Acknowledger: message.Acknowledger, settle: settle}
+
+		select {
+		case deliveries <- message:
+		case <-stopped:
+			// never got to the consumer, so it goes back on its queue
+			settle()
+			source <- message
+			return
+		}
+	}
 }
 
+func isClosed(stopped <-chan struct{}) bool {
+	select {
+	case <-stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *RabbitMQ) Cancel(consumerTag string, _ bool) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.cancelled = true
+	r.stopConsumer(consumerTag)
+	return nil
+}
+
+// Close stops all the consumers, the broker's queues and counts stay around
 func (r *RabbitMQ) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for consumerTag := range r.consumers {
+		r.stopConsumer(consumerTag)
+	}
+
 	return nil
 }
 
+func (r *RabbitMQ) stopConsumer(consumerTag string) {
+	if stopped, ok := r.consumers[consumerTag]; ok {
+		close(stopped)
+		delete(r.consumers, consumerTag)
+		r.settled.Broadcast()
+	}
+}
+
+// settlingAcknowledger gives a delivery's room in the prefetch counts back once it's acked or nacked
+type settlingAcknowledger struct {
+	amqp.Acknowledger
+	settle func()
+}
+
+func (s settlingAcknowledger) Ack(tag uint64, multiple bool) error {
+	s.settle()
+	return s.Acknowledger.Ack(tag, multiple)
+}
+
+func (s settlingAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	s.settle()
+	return s.Acknowledger.Nack(tag, multiple, requeue)
+}
+
+func (s settlingAcknowledger) Reject(tag uint64, requeue bool) error {
+	s.settle()
+	return s.Acknowledger.Reject(tag, requeue)
+}
+
 func (r RabbitMQAcknowledger) Ack(tag uint64, multiple bool) error {
 	r.ack()
 	return nil
 }
 func (r RabbitMQAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
-	r.nack()
+	r.nack(requeue)
 	return nil
 }
 func (r RabbitMQAcknowledger) Reject(tag uint64, requeue bool) error {
-	r.nack()
+	r.nack(requeue)
 	return nil
 }
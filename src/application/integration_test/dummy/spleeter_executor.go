@@ -2,6 +2,7 @@ package dummy
 
 import (
 	"chord-paper-be-workers/src/application/executor"
+	"context"
 	"os"
 	"path/filepath"
 )
@@ -16,16 +17,22 @@ func NewDummySpleeterExecutor() *SpleeterExecutor {
 
 type SpleeterExecutor struct {
 	Unavailable bool
+	// the command runs until it is cancelled, like a very long split would
+	Hang bool
 }
 
 type SpleeterCommand struct {
+	ctx         context.Context
 	Unavailable bool
+	Hang        bool
 	Args        []string
 }
 
-func (y SpleeterExecutor) Command(_ string, arg ...string) executor.Command {
+func (y SpleeterExecutor) Command(ctx context.Context, _ string, arg ...string) executor.Command {
 	return SpleeterCommand{
+		ctx:         ctx,
 		Unavailable: y.Unavailable,
+		Hang:        y.Hang,
 		Args:        arg,
 	}
 }
@@ -65,6 +72,11 @@ func (s SpleeterCommand) CombinedOutput() ([]byte, error) {
 		return nil, NetworkFailure
 	}
 
+	if s.Hang {
+		<-s.ctx.Done()
+		return nil, s.ctx.Err()
+	}
+
 	contents, err := os.ReadFile(sourcePath)
 	if err != nil {
 		return nil, err
@@ -30,14 +30,15 @@ func (t *TrackStore) GetTrack(_ context.Context, tracklistID string, trackID str
 	t.mutex.RLock()
 	defer t.mutex.RUnlock()
 
+	// categorized like the DynamoDB store's, a missing track stays missing
 	trackMap, ok := t.State[tracklistID]
 	if !ok {
-		return entity.BaseTrack{}, NotFound
+		return entity.BaseTrack{}, cerr.Categorize(cerr.Permanent).Wrap(NotFound).Error("Tracklist not found")
 	}
 
 	track, ok := trackMap[trackID]
 	if !ok {
-		return entity.BaseTrack{}, NotFound
+		return entity.BaseTrack{}, cerr.Categorize(cerr.Permanent).Wrap(NotFound).Error("Track not found")
 	}
 
 	return track, nil
@@ -82,3 +83,86 @@ func (t *TrackStore) UpdateTrack(ctx context.Context, trackListID string, trackI
 
 	return nil
 }
+
+func (t *TrackStore) ScanTracks(_ context.Context, visitor entity.TrackVisitor) error {
+	if t.Unavailable {
+		return NetworkFailure
+	}
+
+	// visit a snapshot, so that the visitor is free to update the tracks it sees
+	type trackEntry struct {
+		tracklistID string
+		trackID     string
+		track       entity.Track
+	}
+
+	t.mutex.RLock()
+	entries := []trackEntry{}
+	for tracklistID, trackMap := range t.State {
+		for trackID, track := range trackMap {
+			entries = append(entries, trackEntry{tracklistID, trackID, track})
+		}
+	}
+	t.mutex.RUnlock()
+
+	for _, entry := range entries {
+		if err := visitor(entry.tracklistID, entry.trackID, entry.track); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *TrackStore) SetJobLease(_ context.Context, tracklistID string, trackID string, lease entity.JobLease) error {
+	if t.Unavailable {
+		return NetworkFailure
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	track, ok := t.State[tracklistID][trackID]
+	if !ok {
+		return NotFound
+	}
+
+	splitStemTrack, ok := track.(entity.SplitStemTrack)
+	if !ok {
+		if lease.IsHeld() {
+			return cerr.Error("Only split stem tracks can hold a job lease")
+		}
+
+		return nil
+	}
+
+	splitStemTrack.JobLease = lease
+	t.State[tracklistID][trackID] = splitStemTrack
+
+	return nil
+}
+
+func (t *TrackStore) ClaimJobLease(_ context.Context, tracklistID string, trackID string, expired entity.JobLease, claimed entity.JobLease) error {
+	if t.Unavailable {
+		return NetworkFailure
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	track, ok := t.State[tracklistID][trackID]
+	if !ok {
+		return NotFound
+	}
+
+	// categorized like the DynamoDB store's failed condition
+	splitStemTrack, ok := track.(entity.SplitStemTrack)
+	if !ok || !splitStemTrack.JobLease.ExpiresAt.Equal(expired.ExpiresAt) {
+		return cerr.Categorize(cerr.Permanent).Error("Job lease was not found as it was when it expired")
+	}
+
+	splitStemTrack.JobLease = claimed
+	t.State[tracklistID][trackID] = splitStemTrack
+
+	return nil
+}
@@ -2,6 +2,7 @@ package dummy
 
 import (
 	"chord-paper-be-workers/src/application/executor"
+	"context"
 	"os"
 )
 
@@ -31,7 +32,7 @@ func (y *YoutubeDLExecutor) AddURL(url string, content []byte) {
 	y.URLContent[url] = append([]byte{}, content...)
 }
 
-func (y YoutubeDLExecutor) Command(_ string, arg ...string) executor.Command {
+func (y YoutubeDLExecutor) Command(_ context.Context, _ string, arg ...string) executor.Command {
 	return YoutubeDLCommand{
 		Unavailable: y.Unavailable,
 		Args:        arg,
@@ -2,6 +2,8 @@ package integration_test_test
 
 import (
 	"bytes"
+	"chord-paper-be-workers/src/application"
+	"chord-paper-be-workers/src/application/dedup"
 	"chord-paper-be-workers/src/application/integration_test/dummy"
 	"chord-paper-be-workers/src/application/jobs/job_message"
 	"chord-paper-be-workers/src/application/jobs/job_router"
@@ -12,10 +14,16 @@ import (
 	"chord-paper-be-workers/src/application/jobs/start"
 	"chord-paper-be-workers/src/application/jobs/transfer"
 	"chord-paper-be-workers/src/application/jobs/transfer/download"
+	"chord-paper-be-workers/src/application/jobs/transfer/transcode"
+	"chord-paper-be-workers/src/application/lease"
+	"chord-paper-be-workers/src/application/pipeline"
+	"chord-paper-be-workers/src/application/rabbitmq"
+	"chord-paper-be-workers/src/application/retry"
 	"chord-paper-be-workers/src/application/tracks/entity"
 	"chord-paper-be-workers/src/application/worker"
 	"context"
 	"encoding/json"
+	"time"
 
 	"github.com/streadway/amqp"
 
@@ -38,8 +46,17 @@ var _ = Describe("IntegrationTest", func() {
 		youtubeDLExecutor *dummy.YoutubeDLExecutor
 		spleeterExecutor  *dummy.SpleeterExecutor
 
-		queueWorker worker.QueueWorker
-		run         func()
+		router         job_router.JobRouter
+		processed      *dedup.InMemoryStore
+		workerConfig   worker.Config
+		startMessageID string
+		topology       rabbitmq.Topology
+		workerQueues   []worker.Queue
+		// the queue the start job is put on, by job type when empty
+		startQueue string
+		stopWorker context.CancelFunc
+		workerDone chan struct{}
+		run        func()
 	)
 
 	BeforeEach(func() {
@@ -86,7 +103,9 @@ var _ = Describe("IntegrationTest", func() {
 			genericdler := download.NewGenericDLer()
 			selectdler := download.NewSelectDLer(youtubedler, genericdler)
 
-			trackDownloader, err := transfer.NewTrackTransferrer(selectdler, trackStore, fileStore, bucketName, workingDir)
+			transcoder := transcode.NewTranscoder("/whatever/ffmpeg", "/whatever/ffprobe", dummy.NewDummyFFmpegExecutor())
+
+			trackDownloader, err := transfer.NewTrackTransferrer(selectdler, transcoder, trackStore, fileStore, bucketName, workingDir)
 			Expect(err).NotTo(HaveOccurred())
 
 			transferHandler = transfer.NewJobHandler(trackDownloader)
@@ -107,25 +126,32 @@ var _ = Describe("IntegrationTest", func() {
 			saveHandler = save_stems_to_db.NewJobHandler(trackStore)
 		})
 
-		By("Instantiating the worker", func() {
-			router := job_router.NewJobRouter(
+		By("Instantiating the router", func() {
+			registry := application.NewPipeline(map[string]pipeline.Handler{
+				start.JobType:            start.StageHandler(startHandler),
+				transfer.JobType:         transfer.StageHandler(transferHandler),
+				split.JobType:            split.StageHandler(splitHandler),
+				save_stems_to_db.JobType: save_stems_to_db.StageHandler(saveHandler),
+			})
+
+			router = job_router.NewJobRouter(
 				trackStore,
 				rabbitMQ,
-				startHandler,
-				transferHandler,
-				splitHandler,
-				saveHandler,
+				registry,
+				rabbitMQ.RetryPolicies,
+				lease.NewKeeper(trackStore, time.Minute),
+				nil,
 			)
-			queueWorker = worker.NewQueueWorker(rabbitMQ, "test-queue", router)
+			processed = dedup.NewInMemoryStore(time.Hour)
+			startMessageID = ""
+			workerConfig = worker.DefaultConfig()
+			workerQueues = []worker.Queue{{Name: "test-queue"}}
+			startQueue = ""
+			topology = rabbitmq.NewTopology("tracks", start.JobType)
 		})
 
 		By("Setting up the run routine", func() {
 			run = func() {
-				go func() {
-					err := queueWorker.Start()
-					Expect(err).NotTo(HaveOccurred())
-				}()
-
 				startJobParams := start.JobParams{
 					TrackIdentifier: job_message.TrackIdentifier{
 						TrackListID: tracklistID,
@@ -137,30 +163,53 @@ var _ = Describe("IntegrationTest", func() {
 				Expect(err).NotTo(HaveOccurred())
 
 				message := amqp.Publishing{
-					Type: start.JobType,
-					Body: jsonBytes,
+					MessageId: startMessageID,
+					Type:      start.JobType,
+					Body:      jsonBytes,
 				}
-				err = rabbitMQ.Publish(message)
-				Expect(err).NotTo(HaveOccurred())
+
+				// published before the worker starts, so that it's waiting on its queue when it's consumed
+				if startQueue == "" {
+					err = rabbitMQ.Publish(context.Background(), message)
+					Expect(err).NotTo(HaveOccurred())
+				} else {
+					rabbitMQ.PublishTo(startQueue, message)
+				}
+
+				queueWorker := worker.NewQueueWorker(rabbitMQ, workerQueues, router, rabbitMQ, processed, workerConfig)
+
+				var stop context.Context
+				stop, stopWorker = context.WithCancel(context.Background())
+				workerDone = make(chan struct{})
+
+				go func() {
+					defer GinkgoRecover()
+					defer close(workerDone)
+					err := queueWorker.Start(stop)
+					Expect(err).NotTo(HaveOccurred())
+				}()
 			}
 		})
 	})
 
+	AfterEach(func() {
+		if stopWorker != nil {
+			stopWorker()
+			Eventually(workerDone).Should(BeClosed())
+		}
+	})
+
 	Describe("All jobs run successfully", func() {
 		It("gets 4 acks", func() {
 			run()
 
-			Eventually(func() int {
-				return rabbitMQ.AckCounter
-			}).Should(Equal(4))
+			Eventually(rabbitMQ.AckCount).Should(Equal(4))
 		})
 
 		It("gets no nacks", func() {
 			run()
 
-			Consistently(func() int {
-				return rabbitMQ.NackCounter
-			}).Should(Equal(0))
+			Consistently(rabbitMQ.NackCount).Should(Equal(0))
 		})
 
 		It("uploads the data and converts the track", func() {
@@ -200,6 +249,51 @@ var _ = Describe("IntegrationTest", func() {
 				return true
 			}).Should(BeTrue())
 		})
+
+		It("keeps the history of every stage on the converted track", func() {
+			run()
+
+			Eventually(func() []string {
+				track, err := trackStore.GetTrack(context.Background(), tracklistID, trackID)
+				if err != nil {
+					return nil
+				}
+
+				stemTrack, ok := track.(entity.StemTrack)
+				if !ok {
+					return nil
+				}
+
+				stages := []string{}
+				for _, entry := range stemTrack.JobHistory {
+					if entry.Outcome == entity.JobSucceeded {
+						stages = append(stages, entry.Stage)
+					}
+				}
+
+				return stages
+			}).Should(Equal([]string{start.JobType, transfer.JobType, split.JobType, save_stems_to_db.JobType}))
+		})
+	})
+
+	Describe("Consuming the queue of every stage", func() {
+		BeforeEach(func() {
+			workerQueues = []worker.Queue{}
+			for _, jobType := range []string{start.JobType, transfer.JobType, split.JobType, save_stems_to_db.JobType} {
+				workerQueues = append(workerQueues, worker.Queue{Name: topology.JobQueue(jobType), JobType: jobType})
+			}
+		})
+
+		It("processes a track requested on the base queue", func() {
+			startQueue = topology.BaseQueueName
+			run()
+
+			Eventually(rabbitMQ.AckCount).Should(Equal(4))
+
+			track, err := trackStore.GetTrack(context.Background(), tracklistID, trackID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(track).To(BeAssignableToTypeOf(entity.StemTrack{}))
+		})
 	})
 
 	Describe("File storage is down", func() {
@@ -207,20 +301,19 @@ var _ = Describe("IntegrationTest", func() {
 			fileStore.Unavailable = true
 		})
 
-		It("gets 1 ack for the start job", func() {
+		It("acks the start job and the failed transfer/download job", func() {
 			run()
 
-			Eventually(func() int {
-				return rabbitMQ.AckCounter
-			}).Should(Equal(1))
+			Eventually(rabbitMQ.AckCount).Should(Equal(2))
+
+			Consistently(rabbitMQ.NackCount).Should(Equal(0))
 		})
 
-		It("gets 1 nack for the transfer/download job failing", func() {
+		It("dead letters the transfer/download job", func() {
 			run()
 
-			Eventually(func() int {
-				return rabbitMQ.NackCounter
-			}).Should(Equal(1))
+			Eventually(rabbitMQ.DeadLetterCount).Should(Equal(1))
+			Expect(rabbitMQ.DeadLetters()[0].Type).To(Equal(transfer.JobType))
 		})
 
 		It("reports the error status", func() {
@@ -249,4 +342,116 @@ var _ = Describe("IntegrationTest", func() {
 			}).Should(BeTrue())
 		})
 	})
+
+	Describe("File storage is down with retries configured", func() {
+		BeforeEach(func() {
+			fileStore.Unavailable = true
+			rabbitMQ.RetryPolicies = retry.Policies{
+				Default: retry.Policy{MaxAttempts: 3},
+			}
+		})
+
+		It("retries the transfer/download job until it runs out of attempts", func() {
+			run()
+
+			Eventually(rabbitMQ.DeadLetterCount).Should(Equal(1))
+			Expect(rabbitMQ.RetryCount()).To(Equal(2))
+			Expect(rabbitMQ.DeadLetters()[0].Headers[retry.AttemptHeader]).To(Equal(int32(3)))
+		})
+
+		It("reports the error status after the final attempt", func() {
+			run()
+
+			Eventually(rabbitMQ.DeadLetterCount).Should(Equal(1))
+
+			track, err := trackStore.GetTrack(context.Background(), tracklistID, trackID)
+			Expect(err).NotTo(HaveOccurred())
+
+			stemTrack, ok := track.(entity.SplitStemTrack)
+			Expect(ok).To(BeTrue())
+			Expect(stemTrack.JobStatus).To(Equal(entity.ErrorStatus))
+		})
+	})
+
+	Describe("Running with concurrency", func() {
+		BeforeEach(func() {
+			workerConfig.Concurrency = 4
+			workerConfig.JobConcurrency = map[string]int{
+				split.JobType: 1,
+			}
+		})
+
+		It("sets the prefetch count to the concurrency", func() {
+			run()
+
+			Eventually(rabbitMQ.PrefetchCount).Should(Equal(4))
+		})
+
+		It("gets 4 acks", func() {
+			run()
+
+			Eventually(rabbitMQ.AckCount).Should(Equal(4))
+		})
+	})
+
+	Describe("Redelivering a message that was already processed", func() {
+		BeforeEach(func() {
+			startMessageID = "start-message-id"
+			err := processed.MarkProcessed(context.Background(), startMessageID)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("acks it without running the job again", func() {
+			run()
+
+			Eventually(rabbitMQ.AckCount).Should(Equal(1))
+
+			Consistently(rabbitMQ.AckCount).Should(Equal(1))
+
+			track, err := trackStore.GetTrack(context.Background(), tracklistID, trackID)
+			Expect(err).NotTo(HaveOccurred())
+
+			stemTrack, ok := track.(entity.SplitStemTrack)
+			Expect(ok).To(BeTrue())
+			Expect(stemTrack.JobStatus).To(Equal(entity.RequestedStatus))
+		})
+	})
+
+	Describe("Shutting down while a job is in flight", func() {
+		BeforeEach(func() {
+			spleeterExecutor.Hang = true
+			workerConfig.ShutdownTimeout = 100 * time.Millisecond
+		})
+
+		JustBeforeEach(func() {
+			run()
+
+			// start and transfer go through, split is now stuck
+			Eventually(rabbitMQ.AckCount).Should(Equal(2))
+
+			stopWorker()
+		})
+
+		It("stops consuming", func() {
+			Eventually(rabbitMQ.Cancelled).Should(BeTrue())
+		})
+
+		It("cancels the job and requeues the message", func() {
+			Eventually(rabbitMQ.RequeueCount).Should(Equal(1))
+
+			Expect(rabbitMQ.NackCount()).To(BeZero())
+			Eventually(workerDone).Should(BeClosed())
+		})
+
+		It("doesn't mark the track as errored", func() {
+			Eventually(workerDone).Should(BeClosed())
+
+			track, err := trackStore.GetTrack(context.Background(), tracklistID, trackID)
+			Expect(err).NotTo(HaveOccurred())
+
+			stemTrack, ok := track.(entity.SplitStemTrack)
+			Expect(ok).To(BeTrue())
+			Expect(stemTrack.JobStatus).To(Equal(entity.ProcessingStatus))
+		})
+	})
 })
@@ -0,0 +1,38 @@
+package job_message
+
+import (
+	"chord-paper-be-workers/src/application/tracing"
+	"chord-paper-be-workers/src/lib/cerr"
+	"chord-paper-be-workers/src/lib/ids"
+	"context"
+	"encoding/json"
+
+	"github.com/streadway/amqp"
+)
+
+// NewPublishing turns job params into a message for the job type's queue
+func NewPublishing(ctx context.Context, jobType string, messageID string, params interface{}) (amqp.Publishing, error) {
+	jsonBytes, err := json.Marshal(params)
+	if err != nil {
+		return amqp.Publishing{}, cerr.Wrap(err).Error("Failed to marshal job params")
+	}
+
+	// the job's span continues the trace of whatever enqueued it
+	headers := amqp.Table{}
+	tracing.Inject(ctx, headers)
+
+	// the ID stays with the message through redeliveries, retries and the outbox,
+	// which is how workers recognize a job they've already done
+	return amqp.Publishing{
+		Headers:   headers,
+		MessageId: messageID,
+		Type:      jobType,
+		Body:      jsonBytes,
+	}, nil
+}
+
+// NextJobID identifies the job a message hands off to. It's the same every time the message is handled,
+// so if a stage runs again after a crash, the job it hands off to again is skipped as a duplicate
+func NextJobID(messageID string, nextJobType string) string {
+	return ids.Derive(messageID, nextJobType)
+}
@@ -1,6 +1,7 @@
 package job_router_test
 
 import (
+	"chord-paper-be-workers/src/application/debuglog"
 	"chord-paper-be-workers/src/application/integration_test/dummy"
 	"chord-paper-be-workers/src/application/jobs/job_message"
 	"chord-paper-be-workers/src/application/jobs/job_router"
@@ -13,14 +14,25 @@ import (
 	"chord-paper-be-workers/src/application/jobs/start/startfakes"
 	"chord-paper-be-workers/src/application/jobs/transfer"
 	"chord-paper-be-workers/src/application/jobs/transfer/transferfakes"
+	"chord-paper-be-workers/src/application/lease"
+	"chord-paper-be-workers/src/application/pipeline"
+	"chord-paper-be-workers/src/application/retry"
+	"chord-paper-be-workers/src/application/tracing"
 	"chord-paper-be-workers/src/application/tracks/entity"
 	"chord-paper-be-workers/src/lib/cerr"
+	"chord-paper-be-workers/src/lib/ids"
+	"chord-paper-be-workers/src/lib/logging"
 	"context"
 	"encoding/json"
+	"time"
 
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
 	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 
 	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
 
 	. "github.com/onsi/ginkgo"
 )
@@ -35,15 +47,45 @@ var _ = Describe("JobRouter", func() {
 		splitHandler     *splitfakes.FakeSplitJobHandler
 		saveStemsHandler *save_stems_to_dbfakes.FakeSaveStemsJobHandler
 
-		trackStore *dummy.TrackStore
-		rabbitMQ   *dummy.RabbitMQ
+		trackStore    *dummy.TrackStore
+		rabbitMQ      *dummy.RabbitMQ
+		retryPolicies retry.Policies
 
 		jobRouter job_router.JobRouter
 
 		message     amqp.Delivery
 		messageJson []byte
 
+		toolVersions = map[string]string{"spleeter": "Spleeter Version: 2.3.0", "youtube-dl": "2021.12.17"}
+
+		newJobRouter = func(stages ...pipeline.Stage) job_router.JobRouter {
+			registry, err := pipeline.NewRegistry(stages...)
+			Expect(err).NotTo(HaveOccurred())
+
+			return job_router.NewJobRouter(trackStore, rabbitMQ, registry, retryPolicies, lease.NewKeeper(trackStore, time.Minute), toolVersions)
+		}
+
+		allStages = func() []pipeline.Stage {
+			return pipeline.Chain(
+				start.NewStage().WithHandler(start.StageHandler(startHandler)),
+				transfer.NewStage().WithHandler(transfer.StageHandler(transferHandler)),
+				split.NewStage().WithHandler(split.StageHandler(splitHandler)),
+				save_stems_to_db.NewStage().WithHandler(save_stems_to_db.StageHandler(saveStemsHandler)),
+			)
+		}
+
 		// reusable tests
+		lastHistoryEntry = func() entity.JobHistoryEntry {
+			track, err := trackStore.GetTrack(context.Background(), tracklistID, trackID)
+			Expect(err).NotTo(HaveOccurred())
+
+			stemTrack, ok := track.(entity.SplitStemTrack)
+			Expect(ok).To(BeTrue())
+			Expect(stemTrack.JobHistory).NotTo(BeEmpty())
+
+			return stemTrack.JobHistory[len(stemTrack.JobHistory)-1]
+		}
+
 		WhenJobFails = func(failureSetup func()) {
 			Describe("When job fails", func() {
 				BeforeEach(failureSetup)
@@ -51,7 +93,7 @@ var _ = Describe("JobRouter", func() {
 				It("updates the track to error status", func() {
 					Expect(message).NotTo(BeZero())
 
-					_ = jobRouter.HandleMessage(message)
+					_ = jobRouter.HandleMessage(context.Background(), message)
 
 					track, err := trackStore.GetTrack(context.Background(), tracklistID, trackID)
 					Expect(err).NotTo(HaveOccurred())
@@ -62,8 +104,30 @@ var _ = Describe("JobRouter", func() {
 					Expect(stemTrack.JobStatus).To(Equal(entity.ErrorStatus))
 				})
 
+				It("records a structured debug log", func() {
+					_ = jobRouter.HandleMessage(context.Background(), message)
+
+					track, err := trackStore.GetTrack(context.Background(), tracklistID, trackID)
+					Expect(err).NotTo(HaveOccurred())
+
+					var debugLog debuglog.Entry
+					err = json.Unmarshal([]byte(track.(entity.SplitStemTrack).JobStatusDebugLog), &debugLog)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(debugLog.JobType).To(Equal(message.Type))
+					Expect(debugLog.Attempt).To(Equal(1))
+					Expect(debugLog.Chain).NotTo(BeEmpty())
+					Expect(debugLog.Chain[len(debugLog.Chain)-1].Message).To(Equal("i failed"))
+				})
+
+				It("records the failed attempt in the job history", func() {
+					_ = jobRouter.HandleMessage(context.Background(), message)
+					Expect(lastHistoryEntry().Stage).To(Equal(message.Type))
+					Expect(lastHistoryEntry().Outcome).To(Equal(entity.JobFailed))
+				})
+
 				It("returns an error", func() {
-					err := jobRouter.HandleMessage(message)
+					err := jobRouter.HandleMessage(context.Background(), message)
 					Expect(err).To(HaveOccurred())
 				})
 
@@ -75,7 +139,7 @@ var _ = Describe("JobRouter", func() {
 
 		ItUpdatesProgress = func() {
 			It("updates the progress", func() {
-				_ = jobRouter.HandleMessage(message)
+				_ = jobRouter.HandleMessage(context.Background(), message)
 
 				track, err := trackStore.GetTrack(context.Background(), tracklistID, trackID)
 				Expect(err).NotTo(HaveOccurred())
@@ -85,6 +149,16 @@ var _ = Describe("JobRouter", func() {
 
 				Expect(stemTrack.JobProgress).To(BeNumerically(">", 0))
 			})
+
+			It("records the attempt in the job history", func() {
+				_ = jobRouter.HandleMessage(context.Background(), message)
+				Expect(lastHistoryEntry()).To(MatchFields(IgnoreExtras, Fields{
+					"Stage":    Equal(message.Type),
+					"Attempt":  Equal(1),
+					"Outcome":  Equal(entity.JobSucceeded),
+					"WorkerID": Equal(ids.WorkerID()),
+				}))
+			})
 		}
 	)
 
@@ -108,8 +182,9 @@ var _ = Describe("JobRouter", func() {
 
 			trackStore = dummy.NewDummyTrackStore()
 			rabbitMQ = dummy.NewRabbitMQ()
+			retryPolicies = retry.NoRetries()
 
-			jobRouter = job_router.NewJobRouter(trackStore, rabbitMQ, startHandler, transferHandler, splitHandler, saveStemsHandler)
+			jobRouter = newJobRouter(allStages()...)
 		})
 
 		By("Setting up the track store", func() {
@@ -147,16 +222,17 @@ var _ = Describe("JobRouter", func() {
 			})
 
 			It("doesn't return an error", func() {
-				err := jobRouter.HandleMessage(message)
+				err := jobRouter.HandleMessage(context.Background(), message)
 				Expect(err).NotTo(HaveOccurred())
 			})
 
 			It("publishes the next job", func() {
-				_ = jobRouter.HandleMessage(message)
+				_ = jobRouter.HandleMessage(context.Background(), message)
 				Expect(rabbitMQ.MessageChannel).To(HaveLen(1))
 
 				nextJob := <-rabbitMQ.MessageChannel
 				Expect(nextJob.Type).To(Equal(transfer.JobType))
+				Expect(nextJob.MessageId).NotTo(BeEmpty())
 
 				var transferJob transfer.JobParams
 				err := json.Unmarshal(nextJob.Body, &transferJob)
@@ -165,7 +241,143 @@ var _ = Describe("JobRouter", func() {
 				Expect(transferJob.TrackID).To(Equal(trackID))
 			})
 
+			It("gives the next job the same ID every time the message is handled", func() {
+				message.MessageId = "start-message-id"
+
+				Expect(jobRouter.HandleMessage(context.Background(), message)).To(Succeed())
+				Expect(jobRouter.HandleMessage(context.Background(), message)).To(Succeed())
+				Expect(rabbitMQ.MessageChannel).To(HaveLen(2))
+
+				firstJob := <-rabbitMQ.MessageChannel
+				secondJob := <-rabbitMQ.MessageChannel
+				Expect(firstJob.MessageId).To(Equal(job_message.NextJobID("start-message-id", transfer.JobType)))
+				Expect(secondJob.MessageId).To(Equal(firstJob.MessageId))
+			})
+
+			It("hands off to new jobs every time a message without an ID is handled, so that a resubmitted track isn't skipped", func() {
+				Expect(jobRouter.HandleMessage(context.Background(), message)).To(Succeed())
+				Expect(jobRouter.HandleMessage(context.Background(), message)).To(Succeed())
+				Expect(rabbitMQ.MessageChannel).To(HaveLen(2))
+
+				firstJob := <-rabbitMQ.MessageChannel
+				secondJob := <-rabbitMQ.MessageChannel
+				Expect(firstJob.MessageId).NotTo(BeEmpty())
+				Expect(secondJob.MessageId).NotTo(Equal(firstJob.MessageId))
+			})
+
+			It("keeps the ID it gives a message without one on the lease, so that a rescued job hands off to the same job", func() {
+				var leaseWhileRunning entity.JobLease
+				startHandler.HandleStartJobStub = func(ctx context.Context, body []byte) (start.JobParams, error) {
+					track, err := trackStore.GetTrack(ctx, tracklistID, trackID)
+					Expect(err).NotTo(HaveOccurred())
+					leaseWhileRunning = track.(entity.SplitStemTrack).JobLease
+
+					return start.JobParams{
+						TrackIdentifier: job_message.TrackIdentifier{
+							TrackListID: tracklistID,
+							TrackID:     trackID,
+						},
+					}, nil
+				}
+
+				Expect(jobRouter.HandleMessage(context.Background(), message)).To(Succeed())
+
+				nextJob := <-rabbitMQ.MessageChannel
+				Expect(leaseWhileRunning.MessageID).NotTo(BeEmpty())
+				Expect(nextJob.MessageId).To(Equal(job_message.NextJobID(leaseWhileRunning.MessageID, transfer.JobType)))
+			})
+
 			ItUpdatesProgress()
+
+			It("holds the job lease while the job runs", func() {
+				var leaseWhileRunning entity.JobLease
+				startHandler.HandleStartJobStub = func(ctx context.Context, body []byte) (start.JobParams, error) {
+					track, err := trackStore.GetTrack(ctx, tracklistID, trackID)
+					Expect(err).NotTo(HaveOccurred())
+					leaseWhileRunning = track.(entity.SplitStemTrack).JobLease
+
+					return start.JobParams{
+						TrackIdentifier: job_message.TrackIdentifier{
+							TrackListID: tracklistID,
+							TrackID:     trackID,
+						},
+					}, nil
+				}
+
+				err := jobRouter.HandleMessage(context.Background(), message)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(leaseWhileRunning.IsHeld()).To(BeTrue())
+				Expect(leaseWhileRunning.JobType).To(Equal(start.JobType))
+			})
+
+			It("releases the job lease when handing off to the next job", func() {
+				_ = jobRouter.HandleMessage(context.Background(), message)
+
+				track, err := trackStore.GetTrack(context.Background(), tracklistID, trackID)
+				Expect(err).NotTo(HaveOccurred())
+
+				stemTrack, ok := track.(entity.SplitStemTrack)
+				Expect(ok).To(BeTrue())
+
+				Expect(stemTrack.JobLease.IsHeld()).To(BeFalse())
+			})
+
+			It("carries the trace on to the next job", func() {
+				exporter := tracetest.NewInMemoryExporter()
+				provider := tracing.Start(exporter)
+				defer provider.Shutdown(context.Background())
+
+				_ = jobRouter.HandleMessage(context.Background(), message)
+				Expect(provider.ForceFlush(context.Background())).To(Succeed())
+
+				nextJob := <-rabbitMQ.MessageChannel
+				Expect(nextJob.Headers).To(HaveKey("traceparent"))
+
+				spans := exporter.GetSpans()
+				Expect(spans).NotTo(BeEmpty())
+				jobSpan := spans[len(spans)-1]
+				Expect(jobSpan.Name).To(Equal("job " + start.JobType))
+
+				_, nextSpan := tracing.StartJobSpan(context.Background(), nextJob)
+				Expect(nextSpan.SpanContext().TraceID()).To(Equal(jobSpan.SpanContext.TraceID()))
+			})
+
+			It("clears the outbox once the next job is published", func() {
+				_ = jobRouter.HandleMessage(context.Background(), message)
+
+				track, err := trackStore.GetTrack(context.Background(), tracklistID, trackID)
+				Expect(err).NotTo(HaveOccurred())
+
+				stemTrack, ok := track.(entity.SplitStemTrack)
+				Expect(ok).To(BeTrue())
+
+				Expect(stemTrack.Outbox).To(BeEmpty())
+			})
+
+			Describe("When the next job can't be published", func() {
+				BeforeEach(func() {
+					rabbitMQ.Unavailable = true
+				})
+
+				It("doesn't return an error", func() {
+					err := jobRouter.HandleMessage(context.Background(), message)
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				It("leaves the next job in the outbox", func() {
+					_ = jobRouter.HandleMessage(context.Background(), message)
+
+					track, err := trackStore.GetTrack(context.Background(), tracklistID, trackID)
+					Expect(err).NotTo(HaveOccurred())
+
+					stemTrack, ok := track.(entity.SplitStemTrack)
+					Expect(ok).To(BeTrue())
+
+					Expect(stemTrack.Outbox).To(HaveLen(1))
+					Expect(stemTrack.Outbox[0].JobType).To(Equal(transfer.JobType))
+				})
+			})
 		})
 
 		WhenJobFails(func() {
@@ -195,12 +407,12 @@ var _ = Describe("JobRouter", func() {
 			})
 
 			It("doesn't return an error", func() {
-				err := jobRouter.HandleMessage(message)
+				err := jobRouter.HandleMessage(context.Background(), message)
 				Expect(err).NotTo(HaveOccurred())
 			})
 
 			It("publishes the next job", func() {
-				_ = jobRouter.HandleMessage(message)
+				_ = jobRouter.HandleMessage(context.Background(), message)
 				Expect(rabbitMQ.MessageChannel).To(HaveLen(1))
 
 				nextJob := <-rabbitMQ.MessageChannel
@@ -215,11 +427,145 @@ var _ = Describe("JobRouter", func() {
 			})
 
 			ItUpdatesProgress()
+
+			It("hands the job a logger identifying the track", func() {
+				handler := memory.New()
+				ctx := logging.WithLogger(context.Background(), &log.Logger{Handler: handler, Level: log.InfoLevel})
+
+				message.DeliveryTag = 7
+				message.MessageId = "message-id"
+				_ = jobRouter.HandleMessage(ctx, message)
+
+				Expect(transferHandler.HandleTransferJobCallCount()).To(Equal(1))
+				jobCtx, _ := transferHandler.HandleTransferJobArgsForCall(0)
+				logging.FromContext(jobCtx).Info("from the job")
+
+				Expect(handler.Entries).NotTo(BeEmpty())
+				entry := handler.Entries[len(handler.Entries)-1]
+				Expect(entry.Message).To(Equal("from the job"))
+				Expect(entry.Fields).To(HaveKeyWithValue("tracklist_id", tracklistID))
+				Expect(entry.Fields).To(HaveKeyWithValue("track_id", trackID))
+				Expect(entry.Fields).To(HaveKeyWithValue("job_type", transfer.JobType))
+				Expect(entry.Fields).To(HaveKeyWithValue("message_id", "message-id"))
+				Expect(entry.Fields).To(HaveKeyWithValue("delivery_tag", uint64(7)))
+				Expect(entry.Fields).To(HaveKeyWithValue("attempt", 1))
+			})
 		})
 
 		WhenJobFails(func() {
 			transferHandler.HandleTransferJobReturns(transfer.JobParams{}, "", cerr.Error("i failed"))
 		})
+
+		Describe("When job fails with attempts left", func() {
+			BeforeEach(func() {
+				transferHandler.HandleTransferJobReturns(transfer.JobParams{}, "", cerr.Error("i failed"))
+
+				retryPolicies = retry.Policies{
+					Default: retry.Policy{MaxAttempts: 3},
+				}
+				jobRouter = newJobRouter(allStages()...)
+
+				message.Headers = amqp.Table{retry.AttemptHeader: int32(2)}
+			})
+
+			It("returns an error", func() {
+				err := jobRouter.HandleMessage(context.Background(), message)
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("doesn't update the track to error status", func() {
+				_ = jobRouter.HandleMessage(context.Background(), message)
+
+				track, err := trackStore.GetTrack(context.Background(), tracklistID, trackID)
+				Expect(err).NotTo(HaveOccurred())
+
+				stemTrack, ok := track.(entity.SplitStemTrack)
+				Expect(ok).To(BeTrue())
+
+				Expect(stemTrack.JobStatus).To(Equal(entity.RequestedStatus))
+			})
+
+			It("records that the attempt will be retried, with the versions of the stage's tools", func() {
+				_ = jobRouter.HandleMessage(context.Background(), message)
+
+				entry := lastHistoryEntry()
+				Expect(entry.Outcome).To(Equal(entity.JobRetried))
+				Expect(entry.Attempt).To(Equal(2))
+				Expect(entry.ToolVersions).To(Equal(map[string]string{"youtube-dl": "2021.12.17"}))
+				Expect(entry.FinishedAt).NotTo(BeTemporally("<", entry.StartedAt))
+			})
+
+			Describe("On the final attempt", func() {
+				BeforeEach(func() {
+					message.Headers = amqp.Table{retry.AttemptHeader: int32(3)}
+				})
+
+				It("updates the track to error status", func() {
+					_ = jobRouter.HandleMessage(context.Background(), message)
+
+					track, err := trackStore.GetTrack(context.Background(), tracklistID, trackID)
+					Expect(err).NotTo(HaveOccurred())
+
+					stemTrack, ok := track.(entity.SplitStemTrack)
+					Expect(ok).To(BeTrue())
+
+					Expect(stemTrack.JobStatus).To(Equal(entity.ErrorStatus))
+				})
+
+				It("tells the user it's on us when the failure was transient", func() {
+					transferHandler.HandleTransferJobReturns(transfer.JobParams{}, "",
+						cerr.Categorize(cerr.Transient).Error("throttled"))
+
+					_ = jobRouter.HandleMessage(context.Background(), message)
+
+					track, err := trackStore.GetTrack(context.Background(), tracklistID, trackID)
+					Expect(err).NotTo(HaveOccurred())
+
+					stemTrack, ok := track.(entity.SplitStemTrack)
+					Expect(ok).To(BeTrue())
+
+					Expect(stemTrack.JobStatus).To(Equal(entity.ErrorStatus))
+					Expect(stemTrack.JobStatusMessage).To(Equal(job_router.UnavailableErrorMessage))
+				})
+			})
+
+			Describe("When the failure won't go away with another attempt", func() {
+				BeforeEach(func() {
+					transferHandler.HandleTransferJobReturns(transfer.JobParams{}, "",
+						cerr.Categorize(cerr.UserInput).Error("video unavailable"))
+				})
+
+				It("updates the track to error status without waiting for the final attempt", func() {
+					err := jobRouter.HandleMessage(context.Background(), message)
+					Expect(cerr.CategoryOf(err)).To(Equal(cerr.UserInput))
+
+					track, err := trackStore.GetTrack(context.Background(), tracklistID, trackID)
+					Expect(err).NotTo(HaveOccurred())
+
+					stemTrack, ok := track.(entity.SplitStemTrack)
+					Expect(ok).To(BeTrue())
+
+					Expect(stemTrack.JobStatus).To(Equal(entity.ErrorStatus))
+					Expect(stemTrack.JobStatusMessage).To(Equal(transfer.ErrorMessage))
+				})
+
+				It("tells the user what went wrong when the job explains it", func() {
+					explanation := "That link is to a web page, not a song"
+					transferHandler.HandleTransferJobReturns(transfer.JobParams{}, "",
+						cerr.Categorize(cerr.UserInput).Explain(explanation).Error("not audio"))
+
+					_ = jobRouter.HandleMessage(context.Background(), message)
+
+					track, err := trackStore.GetTrack(context.Background(), tracklistID, trackID)
+					Expect(err).NotTo(HaveOccurred())
+
+					stemTrack, ok := track.(entity.SplitStemTrack)
+					Expect(ok).To(BeTrue())
+
+					Expect(stemTrack.JobStatusMessage).To(Equal(explanation))
+				})
+			})
+		})
 	})
 
 	Describe("Split job", func() {
@@ -251,12 +597,12 @@ var _ = Describe("JobRouter", func() {
 			})
 
 			It("doesn't return an error", func() {
-				err := jobRouter.HandleMessage(message)
+				err := jobRouter.HandleMessage(context.Background(), message)
 				Expect(err).NotTo(HaveOccurred())
 			})
 
 			It("publishes the next job", func() {
-				_ = jobRouter.HandleMessage(message)
+				_ = jobRouter.HandleMessage(context.Background(), message)
 				Expect(rabbitMQ.MessageChannel).To(HaveLen(1))
 
 				nextJob := <-rabbitMQ.MessageChannel
@@ -276,6 +622,129 @@ var _ = Describe("JobRouter", func() {
 		WhenJobFails(func() {
 			splitHandler.HandleSplitJobReturns(split.JobParams{}, nil, cerr.Error("i failed"))
 		})
+
+		Describe("When job is interrupted", func() {
+			var ctx context.Context
+
+			BeforeEach(func() {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithCancel(context.Background())
+				cancel()
+
+				splitHandler.HandleSplitJobReturns(split.JobParams{}, nil, context.Canceled)
+			})
+
+			It("returns an error", func() {
+				err := jobRouter.HandleMessage(ctx, message)
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("doesn't update the track to error status", func() {
+				_ = jobRouter.HandleMessage(ctx, message)
+
+				track, err := trackStore.GetTrack(context.Background(), tracklistID, trackID)
+				Expect(err).NotTo(HaveOccurred())
+
+				stemTrack, ok := track.(entity.SplitStemTrack)
+				Expect(ok).To(BeTrue())
+
+				Expect(stemTrack.JobStatus).To(Equal(entity.RequestedStatus))
+			})
+		})
+	})
+
+	Describe("Job type without a configured handler", func() {
+		BeforeEach(func() {
+			jobRouter = newJobRouter(pipeline.Chain(
+				start.NewStage().WithHandler(start.StageHandler(startHandler)),
+				transfer.NewStage().WithHandler(transfer.StageHandler(transferHandler)),
+				split.NewStage(),
+				save_stems_to_db.NewStage().WithHandler(save_stems_to_db.StageHandler(saveStemsHandler)),
+			)...)
+			message = amqp.Delivery{
+				Type: split.JobType,
+				Body: messageJson,
+			}
+		})
+
+		It("returns an error", func() {
+			err := jobRouter.HandleMessage(context.Background(), message)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("doesn't publish any new jobs", func() {
+			_ = jobRouter.HandleMessage(context.Background(), message)
+			Expect(rabbitMQ.MessageChannel).To(BeEmpty())
+		})
+	})
+
+	Describe("A registered stage", func() {
+		const analyzeJobType = "analyze_track"
+
+		var analyzeErr error
+
+		BeforeEach(func() {
+			analyzeErr = nil
+
+			analyzeStage := pipeline.Stage{
+				JobType:       analyzeJobType,
+				StatusMessage: "Analyzing the track",
+				ErrorMessage:  "Failed to analyze the track",
+				Weight:        90,
+				Next:          []string{save_stems_to_db.JobType},
+				Handler: func(_ context.Context, body []byte) (pipeline.Output, error) {
+					if analyzeErr != nil {
+						return nil, analyzeErr
+					}
+
+					var params job_message.TrackIdentifier
+					Expect(json.Unmarshal(body, &params)).To(Succeed())
+
+					return pipeline.NewOutput(split.Output{
+						TrackIdentifier: params,
+						StemURLs:        map[string]string{"vocals": "vocals.mp3"},
+					})
+				},
+			}
+
+			jobRouter = newJobRouter(analyzeStage, save_stems_to_db.NewStage().WithHandler(save_stems_to_db.StageHandler(saveStemsHandler)))
+			message = amqp.Delivery{
+				Type: analyzeJobType,
+				Body: messageJson,
+			}
+		})
+
+		It("hands off to the stage it declares next", func() {
+			Expect(jobRouter.HandleMessage(context.Background(), message)).To(Succeed())
+
+			Expect(rabbitMQ.MessageChannel).To(HaveLen(1))
+			job := <-rabbitMQ.MessageChannel
+			Expect(job.Type).To(Equal(save_stems_to_db.JobType))
+
+			var params save_stems_to_db.JobParams
+			Expect(json.Unmarshal(job.Body, &params)).To(Succeed())
+			Expect(params.TrackListID).To(Equal(tracklistID))
+			Expect(params.StemURLS).To(HaveKey("vocals"))
+		})
+
+		It("shows the next stage's status and its share of the progress", func() {
+			Expect(jobRouter.HandleMessage(context.Background(), message)).To(Succeed())
+
+			track, err := trackStore.GetTrack(context.Background(), tracklistID, trackID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(track.(entity.SplitStemTrack).JobStatusMessage).To(Equal("Saving processed stems into database"))
+			Expect(track.(entity.SplitStemTrack).JobProgress).To(Equal(90))
+		})
+
+		It("tells the user the stage's error message when it fails", func() {
+			analyzeErr = cerr.Error("i failed")
+			Expect(jobRouter.HandleMessage(context.Background(), message)).NotTo(Succeed())
+
+			track, err := trackStore.GetTrack(context.Background(), tracklistID, trackID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(track.(entity.SplitStemTrack).JobStatus).To(Equal(entity.ErrorStatus))
+			Expect(track.(entity.SplitStemTrack).JobStatusMessage).To(Equal("Failed to analyze the track"))
+		})
 	})
 
 	Describe("Save stem tracks job", func() {
@@ -292,12 +761,12 @@ var _ = Describe("JobRouter", func() {
 			})
 
 			It("doesn't return an error", func() {
-				err := jobRouter.HandleMessage(message)
+				err := jobRouter.HandleMessage(context.Background(), message)
 				Expect(err).NotTo(HaveOccurred())
 			})
 
 			It("doesn't publishes the next job", func() {
-				_ = jobRouter.HandleMessage(message)
+				_ = jobRouter.HandleMessage(context.Background(), message)
 				Expect(rabbitMQ.MessageChannel).To(BeEmpty())
 			})
 
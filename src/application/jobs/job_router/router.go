@@ -1,162 +1,313 @@
 package job_router
 
 import (
+	"chord-paper-be-workers/src/application/debuglog"
 	"chord-paper-be-workers/src/application/jobs/job_message"
-	"chord-paper-be-workers/src/application/jobs/save_stems_to_db"
-	"chord-paper-be-workers/src/application/jobs/split"
-	"chord-paper-be-workers/src/application/jobs/start"
-	"chord-paper-be-workers/src/application/jobs/transfer"
+	"chord-paper-be-workers/src/application/lease"
+	"chord-paper-be-workers/src/application/metrics"
+	"chord-paper-be-workers/src/application/outbox"
+	"chord-paper-be-workers/src/application/pipeline"
 	"chord-paper-be-workers/src/application/publish"
+	"chord-paper-be-workers/src/application/retry"
+	"chord-paper-be-workers/src/application/tracing"
 	"chord-paper-be-workers/src/application/tracks/entity"
 	"chord-paper-be-workers/src/lib/cerr"
+	"chord-paper-be-workers/src/lib/ids"
+	"chord-paper-be-workers/src/lib/logging"
 	"context"
 	"encoding/json"
-	"fmt"
+	"time"
 
+	"github.com/apex/log"
 	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+const UnavailableErrorMessage = "We're having trouble on our end at the moment, please try again later"
+
+// DefaultErrorMessage is for stages that don't have an error message of their own
+const DefaultErrorMessage = "Failed to process the track"
+
+// NewJobRouter runs the job of every stage in the registry that has a handler,
+// handing its track on to the stages that follow it
 func NewJobRouter(
 	trackStore entity.TrackStore,
 	publisher publish.Publisher,
-	startHandler start.StartJobHandler,
-	transferHandler transfer.TransferJobHandler,
-	splitHandler split.SplitJobHandler,
-	saveStemsHandler save_stems_to_db.SaveStemsJobHandler,
+	stages pipeline.Registry,
+	retryPolicies retry.Policies,
+	leases lease.Keeper,
+	toolVersions map[string]string,
 ) JobRouter {
 	return JobRouter{
-		trackStore:       trackStore,
-		publisher:        publisher,
-		startHandler:     startHandler,
-		transferHandler:  transferHandler,
-		splitHandler:     splitHandler,
-		saveStemsHandler: saveStemsHandler,
+		retryPolicies: retryPolicies,
+		leases:        leases,
+		toolVersions:  toolVersions,
+		trackStore:    trackStore,
+		outbox:        outbox.NewOutbox(trackStore, publisher),
+		stages:        stages,
 	}
 }
 
 type JobRouter struct {
-	publisher  publish.Publisher
-	trackStore entity.TrackStore
-
-	startHandler     start.StartJobHandler
-	transferHandler  transfer.TransferJobHandler
-	splitHandler     split.SplitJobHandler
-	saveStemsHandler save_stems_to_db.SaveStemsJobHandler
+	outbox        outbox.Outbox
+	trackStore    entity.TrackStore
+	retryPolicies retry.Policies
+	leases        lease.Keeper
+	// keyed by tool name, recorded in the job history of the stages that run them
+	toolVersions map[string]string
+
+	stages pipeline.Registry
 }
 
-func (j JobRouter) HandleMessage(message amqp.Delivery) error {
-	err := j.handleMessageWithoutErrorHandling(message)
+func (j JobRouter) HandleMessage(ctx context.Context, message amqp.Delivery) (err error) {
+	jobType := j.jobTypeLabel(message.Type)
+	started := time.Now()
+	metrics.JobHandled(jobType)
+
+	// messages published without an ID, like the API's start jobs, get a fresh one when they're handled.
+	// The lease and the next jobs carry it on, so that a rescued job still hands off to the same jobs
+	if message.MessageId == "" {
+		message.MessageId = ids.New()
+	}
+
+	ctx, span := tracing.StartJobSpan(ctx, message,
+		attribute.String("job_type", jobType),
+		attribute.String("message_id", message.MessageId),
+		attribute.Int("attempt", retry.Attempt(message)))
+	defer func() { tracing.End(span, err) }()
+
+	ctx = WithJobLogger(ctx, message)
+
+	// heartbeats stop before anything else is written to the track, so that they can't
+	// bring back the lease that handing off or failing the job releases
+	heartbeat := j.leases.Acquire(ctx, message)
+
+	err = j.handleMessageWithoutErrorHandling(ctx, message, heartbeat, started)
+	heartbeat.Stop()
+
 	if err != nil {
-		j.handleError(message, err)
+		// the job was interrupted rather than failed, it'll be retried
+		// so the track shouldn't be marked as errored
+		if ctx.Err() != nil {
+			metrics.JobFinished(jobType, metrics.OutcomeInterrupted, started)
+			j.recordAttempt(ctx, message, j.historyEntry(message, started, entity.JobInterrupted))
+			return cerr.Wrap(err).Error("Job was interrupted")
+		}
+
+		metrics.JobFinished(jobType, metrics.OutcomeFailed, started)
+
+		// the job will be attempted again, the user only hears about it once we've given up.
+		// failures that another attempt wouldn't fix are reported straight away
+		if j.retryPolicies.ShouldRetry(message, err) {
+			j.recordAttempt(ctx, message, j.historyEntry(message, started, entity.JobRetried))
+			return cerr.Field("attempt", retry.Attempt(message)).
+				Wrap(err).Error("Job failed, will be retried")
+		}
+
+		j.handleError(ctx, message, err, started)
 		return err
 	}
 
+	metrics.JobFinished(jobType, metrics.OutcomeSucceeded, started)
 	return nil
 }
 
-func (j JobRouter) handleMessageWithoutErrorHandling(message amqp.Delivery) error {
-	var nextJobMsg amqp.Publishing
-	var nextJobMessage string
-	var nextJobProgress int
-	wasLastJob := false
+// WithJobLogger attaches a logger identifying the job and its track to the context,
+// so that the lines of jobs running side by side can be told apart
+func WithJobLogger(ctx context.Context, message amqp.Delivery) context.Context {
+	fields := log.Fields{
+		"job_type":     message.Type,
+		"message_id":   message.MessageId,
+		"delivery_tag": message.DeliveryTag,
+		"attempt":      retry.Attempt(message),
+	}
 
-	switch message.Type {
-	case start.JobType:
-		startJobParams, err := j.startHandler.HandleStartJob(message.Body)
-		if err != nil {
-			return cerr.Field("message_body", string(message.Body)).Wrap(err).Error("Failed to handle start job")
-		}
+	if trackParams, err := trackIdentifier(message); err == nil {
+		fields["tracklist_id"] = trackParams.TrackListID
+		fields["track_id"] = trackParams.TrackID
+	}
 
-		nextJobMessage = "Retrieving the original track from provided URL"
-		nextJobProgress = 10
-		nextJobMsg, err = createTransferJobMessage(startJobParams.TrackListID, startJobParams.TrackID)
-		if err != nil {
-			return cerr.Field("tracklist_id", startJobParams.TrackListID).
-				Field("track_id", startJobParams.TrackID).
-				Wrap(err).
-				Error("Failed to create transfer job message")
-		}
+	return logging.WithFields(ctx, fields)
+}
 
-	case transfer.JobType:
-		transferJobParams, savedOriginalURL, err := j.transferHandler.HandleTransferJob(message.Body)
-		if err != nil {
-			return cerr.Field("message_body", string(message.Body)).Wrap(err).Error("Failed to handle transfer job")
-		}
+// jobTypeLabel keeps whatever junk ends up on the queue from becoming a metric label
+func (j JobRouter) jobTypeLabel(jobType string) string {
+	if _, ok := j.stages.Stage(jobType); ok {
+		return jobType
+	}
 
-		nextJobMessage = "Splitting the track into stems"
-		nextJobProgress = 30
-		nextJobMsg, err = createSplitJobMessage(transferJobParams.TrackListID, transferJobParams.TrackID, savedOriginalURL)
-		if err != nil {
-			return cerr.Field("tracklist_id", transferJobParams.TrackListID).
-				Field("track_id", transferJobParams.TrackID).
-				Field("saved_original_url", savedOriginalURL).
-				Wrap(err).
-				Error("Failed to create split job message")
-		}
+	return "unknown"
+}
 
-	case split.JobType:
-		splitJobParams, stemURLs, err := j.splitHandler.HandleSplitJob(message.Body)
-		if err != nil {
-			return cerr.Field("message_body", string(message.Body)).Wrap(err).Error("Failed to handle split job")
-		}
+func (j JobRouter) historyEntry(message amqp.Delivery, started time.Time, outcome entity.JobOutcome) entity.JobHistoryEntry {
+	stage, _ := j.stages.Stage(message.Type)
 
-		nextJobMessage = "Saving processed stems into database"
-		nextJobProgress = 90
-		nextJobMsg, err = createSaveStemsToDBJobMessage(splitJobParams.TrackListID, splitJobParams.TrackID, stemURLs)
-		if err != nil {
-			return cerr.Field("tracklist_id", splitJobParams.TrackListID).
-				Field("track_id", splitJobParams.TrackID).
-				Field("stem_urls", stemURLs).
-				Wrap(err).
-				Error("Failed to create save stems to DB job message")
+	toolVersions := map[string]string{}
+	for _, tool := range stage.Tools {
+		if version, ok := j.toolVersions[tool]; ok {
+			toolVersions[tool] = version
 		}
+	}
 
-	case save_stems_to_db.JobType:
-		err := j.saveStemsHandler.HandleSaveStemsToDBJob(message.Body)
-		if err != nil {
-			return cerr.Field("message_body", string(message.Body)).Wrap(err).Error("Failed to handle save stems to DB job")
+	return entity.JobHistoryEntry{
+		Stage:        message.Type,
+		Attempt:      retry.Attempt(message),
+		StartedAt:    started.UTC(),
+		FinishedAt:   time.Now().UTC(),
+		Outcome:      outcome,
+		WorkerID:     ids.WorkerID(),
+		ToolVersions: toolVersions,
+	}
+}
+
+// recordAttempt adds the attempt to the track's history and releases its lease, for the attempts
+// that don't write to the track otherwise. It's best effort, a lease that sticks around
+// only makes the watchdog enqueue the job again
+func (j JobRouter) recordAttempt(ctx context.Context, message amqp.Delivery, entry entity.JobHistoryEntry) {
+	trackParams, err := trackIdentifier(message)
+	if err != nil {
+		return
+	}
+
+	updater := func(track entity.Track) (entity.Track, error) {
+		switch typedTrack := track.(type) {
+		case entity.SplitStemTrack:
+			typedTrack.JobHistory = entity.AppendJobHistory(typedTrack.JobHistory, entry)
+			typedTrack.JobLease = entity.JobLease{}
+			return typedTrack, nil
+
+		// the last stage turns the track into its stems
+		case entity.StemTrack:
+			typedTrack.JobHistory = entity.AppendJobHistory(typedTrack.JobHistory, entry)
+			return typedTrack, nil
+
+		default:
+			return entity.BaseTrack{}, cerr.Categorize(cerr.Permanent).Error("Track from DB has no job history")
 		}
+	}
+
+	// the job context might be cancelled already, so it only lends its logger here
+	err = j.trackStore.UpdateTrack(context.Background(), trackParams.TrackListID, trackParams.TrackID, updater)
+	if err == nil {
+		return
+	}
+
+	cerr.LogContext(ctx, cerr.Field("outcome", entry.Outcome).Wrap(err).Error("Failed to record job history"))
 
-		wasLastJob = true
+	if err := j.leases.Release(context.Background(), trackParams.TrackListID, trackParams.TrackID); err != nil {
+		cerr.LogContext(ctx, err)
+	}
+}
 
-	default:
+func (j JobRouter) handleMessageWithoutErrorHandling(ctx context.Context, message amqp.Delivery, heartbeat *lease.Heartbeat, started time.Time) error {
+	stage, ok := j.stages.Stage(message.Type)
+	if !ok {
 		return cerr.Field("job_type", message.Type).Error("Unrecognized amqp job type")
 	}
 
-	if !wasLastJob {
-		if err := j.updateProgress(message, nextJobMessage, nextJobProgress); err != nil {
-			return cerr.Wrap(err).Error("Failed to publish next job message")
+	// deployments only running some of the stages leave the others without a handler
+	if stage.Handler == nil {
+		return cerr.Field("job_type", message.Type).Error("Router is not configured to handle this job type")
+	}
+
+	output, err := stage.Handler(ctx, message.Body)
+	if err != nil {
+		return cerr.Field("job_type", message.Type).
+			Field("message_body", string(message.Body)).
+			Wrap(err).Error("Failed to handle job")
+	}
+
+	heartbeat.Stop()
+
+	if stage.IsLast() {
+		j.recordAttempt(ctx, message, j.historyEntry(message, started, entity.JobSucceeded))
+		return nil
+	}
+
+	trackParams, err := trackIdentifier(message)
+	if err != nil {
+		return cerr.Wrap(err).Error("Failed to get track from job message")
+	}
+
+	nextJobs, err := j.createNextJobs(ctx, message.MessageId, stage, output)
+	if err != nil {
+		return cerr.Field("tracklist_id", trackParams.TrackListID).
+			Field("track_id", trackParams.TrackID).
+			Wrap(err).Error("Failed to create next job messages")
+	}
+
+	// the stages after this one all follow the same stages, so they show the same progress
+	nextStage, _ := j.stages.Stage(stage.Next[0])
+
+	// the next jobs are saved along with the progress, so that they can't get lost
+	// if we go down before publishing them
+	history := j.historyEntry(message, started, entity.JobSucceeded)
+	err = j.updateProgress(ctx, trackParams, nextStage.StatusMessage, j.stages.Progress(nextStage.JobType), nextJobs, history)
+	if err != nil {
+		return cerr.Wrap(err).Error("Failed to hand off next job")
+	}
+
+	// this job is done either way, the relay publishes the next jobs if we can't right now
+	if err := j.outbox.Flush(ctx, trackParams.TrackListID, trackParams.TrackID); err != nil {
+		cerr.LogContext(ctx, cerr.Field("next_job_types", stage.Next).
+			Wrap(err).Error("Failed to publish next job, leaving it to the outbox relay"))
+	}
+
+	return nil
+}
+
+// createNextJobs makes a job for each of the stages after this one, in the order they're declared,
+// each taking its params from this stage's output
+func (j JobRouter) createNextJobs(ctx context.Context, parentID string, stage pipeline.Stage, output pipeline.Output) ([]entity.OutboxMessage, error) {
+	nextJobs := []entity.OutboxMessage{}
+	for _, nextJobType := range stage.Next {
+		errctx := cerr.Field("next_job_type", nextJobType)
+
+		// the registry only lets stages with params follow another
+		nextStage, _ := j.stages.Stage(nextJobType)
+		params, err := nextStage.ParamsFrom(output)
+		if err != nil {
+			return nil, errctx.Wrap(err).Error("Failed to make job params")
 		}
 
-		if err := j.publisher.Publish(nextJobMsg); err != nil {
-			return cerr.Field("next_job", nextJobMsg).
-				Wrap(err).Error("Failed to publish next job message")
+		publishing, err := job_message.NewPublishing(ctx, nextJobType, job_message.NextJobID(parentID, nextJobType), params)
+		if err != nil {
+			return nil, errctx.Field("params", params).Wrap(err).Error("Failed to create job message")
 		}
+
+		nextJobs = append(nextJobs, outbox.NewMessage(publishing))
 	}
 
-	return nil
+	return nextJobs, nil
 }
 
-func (j JobRouter) updateProgress(message amqp.Delivery, statusMessage string, progress int) error {
+func trackIdentifier(message amqp.Delivery) (job_message.TrackIdentifier, error) {
 	var trackParams job_message.TrackIdentifier
 	err := json.Unmarshal(message.Body, &trackParams)
 	if err != nil {
-		return cerr.Wrap(err).Error("Failed to unmarshal job message")
+		return job_message.TrackIdentifier{}, cerr.Categorize(cerr.Permanent).Wrap(err).Error("Failed to unmarshal job message")
 	}
 
+	return trackParams, nil
+}
+
+func (j JobRouter) updateProgress(ctx context.Context, trackParams job_message.TrackIdentifier, statusMessage string, progress int, nextJobs []entity.OutboxMessage, history entity.JobHistoryEntry) error {
 	updater := func(track entity.Track) (entity.Track, error) {
 		splitStemTrack, ok := track.(entity.SplitStemTrack)
 		if !ok {
-			return entity.BaseTrack{}, cerr.Error("Track from DB is not a split stem track")
+			return entity.BaseTrack{}, cerr.Categorize(cerr.Permanent).Error("Track from DB is not a split stem track")
 		}
 
 		splitStemTrack.JobStatusMessage = statusMessage
 		splitStemTrack.JobProgress = progress
+		splitStemTrack.Outbox = append(splitStemTrack.Outbox, nextJobs...)
+		splitStemTrack.JobLease = entity.JobLease{}
+		splitStemTrack.JobHistory = entity.AppendJobHistory(splitStemTrack.JobHistory, history)
 
 		return splitStemTrack, nil
 	}
 
-	err = j.trackStore.UpdateTrack(context.Background(), trackParams.TrackListID, trackParams.TrackID, updater)
+	err := j.trackStore.UpdateTrack(ctx, trackParams.TrackListID, trackParams.TrackID, updater)
 	if err != nil {
 		return cerr.Wrap(err).Error("Failed to update track")
 	}
@@ -164,92 +315,59 @@ func (j JobRouter) updateProgress(message amqp.Delivery, statusMessage string, p
 	return nil
 }
 
-func (j JobRouter) getErrorMessage(jobType string) string {
-	switch jobType {
-	case start.JobType:
-		return start.ErrorMessage
-	case transfer.JobType:
-		return transfer.ErrorMessage
-	case split.JobType:
-		return split.ErrorMessage
-	case save_stems_to_db.JobType:
-		return save_stems_to_db.ErrorMessage
-	default:
-		panic(fmt.Sprintf("Unhandled message type in error handling, type: %s", jobType))
+// getErrorMessage is what the user sees, failures that weren't down to them or their track
+// don't get blamed on the job they happened in, and the rest are explained if the job knows how
+func (j JobRouter) getErrorMessage(jobType string, jobError error) string {
+	switch cerr.CategoryOf(jobError) {
+	case cerr.Transient, cerr.Infrastructure:
+		return UnavailableErrorMessage
+	}
+
+	if explanation := cerr.ExplanationOf(jobError); explanation != "" {
+		return explanation
 	}
+
+	stage, ok := j.stages.Stage(jobType)
+	if !ok || stage.ErrorMessage == "" {
+		return DefaultErrorMessage
+	}
+
+	return stage.ErrorMessage
 }
 
-func (j JobRouter) handleError(message amqp.Delivery, jobError error) error {
+func (j JobRouter) handleError(ctx context.Context, message amqp.Delivery, jobError error, started time.Time) error {
 	var trackParams job_message.TrackIdentifier
 	err := json.Unmarshal(message.Body, &trackParams)
 	if err != nil {
 		return cerr.Wrap(err).Error("Failed to report error to track DB")
 	}
 
+	debugLog := debuglog.Describe(debuglog.Job{
+		JobType:   message.Type,
+		MessageID: message.MessageId,
+		Attempt:   retry.Attempt(message),
+		StartedAt: started,
+	}, jobError)
+
 	updater := func(track entity.Track) (entity.Track, error) {
 		splitStemTrack, ok := track.(entity.SplitStemTrack)
 		if !ok {
-			return entity.BaseTrack{}, cerr.Error("Track from DB is not a split stem track")
+			return entity.BaseTrack{}, cerr.Categorize(cerr.Permanent).Error("Track from DB is not a split stem track")
 		}
 
 		splitStemTrack.JobStatus = entity.ErrorStatus
-		splitStemTrack.JobStatusMessage = j.getErrorMessage(message.Type)
-		splitStemTrack.JobStatusDebugLog = jobError.Error()
+		splitStemTrack.JobStatusMessage = j.getErrorMessage(message.Type, jobError)
+		splitStemTrack.JobStatusDebugLog = debugLog
+		splitStemTrack.JobLease = entity.JobLease{}
+		splitStemTrack.JobHistory = entity.AppendJobHistory(splitStemTrack.JobHistory, j.historyEntry(message, started, entity.JobFailed))
 
 		return splitStemTrack, nil
 	}
 
-	err = j.trackStore.UpdateTrack(context.Background(), trackParams.TrackListID, trackParams.TrackID, updater)
+	err = j.trackStore.UpdateTrack(ctx, trackParams.TrackListID, trackParams.TrackID, updater)
 	if err != nil {
 		return cerr.Wrap(err).Error("Failed to update track")
 	}
 
 	return nil
 }
-
-func createTransferJobMessage(tracklistID string, trackID string) (amqp.Publishing, error) {
-	job := transfer.JobParams{
-		job_message.TrackIdentifier{
-			TrackListID: tracklistID,
-			TrackID:     trackID,
-		},
-	}
-
-	return createJobMessage(transfer.JobType, job)
-}
-
-func createSplitJobMessage(tracklistID string, trackID string, savedOriginalURL string) (amqp.Publishing, error) {
-	job := split.JobParams{
-		TrackIdentifier: job_message.TrackIdentifier{
-			TrackListID: tracklistID,
-			TrackID:     trackID,
-		},
-		SavedOriginalURL: savedOriginalURL,
-	}
-
-	return createJobMessage(split.JobType, job)
-}
-
-func createSaveStemsToDBJobMessage(tracklistID string, trackID string, stemURLs map[string]string) (amqp.Publishing, error) {
-	job := save_stems_to_db.JobParams{
-		TrackIdentifier: job_message.TrackIdentifier{
-			TrackListID: tracklistID,
-			TrackID:     trackID,
-		},
-		StemURLS: stemURLs,
-	}
-
-	return createJobMessage(save_stems_to_db.JobType, job)
-}
-
-func createJobMessage(jobType string, message interface{}) (amqp.Publishing, error) {
-	jsonBytes, err := json.Marshal(message)
-	if err != nil {
-		return amqp.Publishing{}, cerr.Wrap(err).Error("Failed to marshal job params")
-	}
-
-	return amqp.Publishing{
-		Type: jobType,
-		Body: jsonBytes,
-	}, nil
-}
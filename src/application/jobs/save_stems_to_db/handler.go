@@ -26,7 +26,7 @@ type JobParams struct {
 
 //counterfeiter:generate . SaveStemsJobHandler
 type SaveStemsJobHandler interface {
-	HandleSaveStemsToDBJob(message []byte) error
+	HandleSaveStemsToDBJob(ctx context.Context, message []byte) error
 }
 
 func NewJobHandler(trackStore entity.TrackStore) JobHandler {
@@ -39,10 +39,10 @@ type JobHandler struct {
 	trackStore entity.TrackStore
 }
 
-func (s JobHandler) HandleSaveStemsToDBJob(message []byte) error {
+func (s JobHandler) HandleSaveStemsToDBJob(ctx context.Context, message []byte) error {
 	params, err := unmarshalMessage(message)
 	if err != nil {
-		return cerr.Wrap(err).Error("Failed to unmarshal message JSON")
+		return cerr.Categorize(cerr.Permanent).Wrap(err).Error("Failed to unmarshal message JSON")
 	}
 
 	errctx := cerr.Field("job_params", params)
@@ -63,13 +63,15 @@ func (s JobHandler) HandleSaveStemsToDBJob(message []byte) error {
 			BaseTrack: entity.BaseTrack{
 				TrackType: newTrackType,
 			},
-			StemURLs: params.StemURLS,
+			StemURLs:     params.StemURLS,
+			SourceFormat: splitStemTrack.SourceFormat,
+			JobHistory:   splitStemTrack.JobHistory,
 		}
 
 		return newTrack, nil
 	}
 
-	err = s.trackStore.UpdateTrack(context.Background(), params.TrackListID, params.TrackID, updater)
+	err = s.trackStore.UpdateTrack(ctx, params.TrackListID, params.TrackID, updater)
 	if err != nil {
 		return errctx.Wrap(err).Error("Failed to update track")
 	}
@@ -81,17 +83,17 @@ func unmarshalMessage(message []byte) (JobParams, error) {
 	params := JobParams{}
 	err := json.Unmarshal(message, &params)
 	if err != nil {
-		return JobParams{}, cerr.Wrap(err).Error("Failed to unmarshal message JSON")
+		return JobParams{}, cerr.Categorize(cerr.Permanent).Wrap(err).Error("Failed to unmarshal message JSON")
 	}
 
 	errctx := cerr.Field("job_params", params)
 
 	if params.TrackListID == "" {
-		return JobParams{}, errctx.Error("Missing tracklist ID")
+		return JobParams{}, errctx.Categorize(cerr.Permanent).Error("Missing tracklist ID")
 	}
 
 	if params.TrackID == "" {
-		return JobParams{}, errctx.Error("Missing track ID")
+		return JobParams{}, errctx.Categorize(cerr.Permanent).Error("Missing track ID")
 	}
 
 	if len(params.StemURLS) == 0 {
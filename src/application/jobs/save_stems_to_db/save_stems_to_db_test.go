@@ -84,12 +84,12 @@ var _ = Describe("JobHandler", func() {
 
 				Describe("Store saves successfully", func() {
 					It("does not error", func() {
-						err := handler.HandleSaveStemsToDBJob(messageBytes)
+						err := handler.HandleSaveStemsToDBJob(context.Background(), messageBytes)
 						Expect(err).NotTo(HaveOccurred())
 					})
 
 					It("updates the track store", func() {
-						_ = handler.HandleSaveStemsToDBJob(messageBytes)
+						_ = handler.HandleSaveStemsToDBJob(context.Background(), messageBytes)
 						track, err := dummyTrackStore.GetTrack(context.Background(), tracklistID, trackID)
 						Expect(err).NotTo(HaveOccurred())
 						stemTrack, ok := track.(entity.StemTrack)
@@ -105,7 +105,7 @@ var _ = Describe("JobHandler", func() {
 					})
 
 					It("also returns a failure", func() {
-						err := handler.HandleSaveStemsToDBJob(messageBytes)
+						err := handler.HandleSaveStemsToDBJob(context.Background(), messageBytes)
 						Expect(err).To(HaveOccurred())
 					})
 				})
@@ -135,12 +135,12 @@ var _ = Describe("JobHandler", func() {
 
 				Describe("Store saves successfully", func() {
 					It("does not error", func() {
-						err := handler.HandleSaveStemsToDBJob(messageBytes)
+						err := handler.HandleSaveStemsToDBJob(context.Background(), messageBytes)
 						Expect(err).NotTo(HaveOccurred())
 					})
 
 					It("updates the track store", func() {
-						_ = handler.HandleSaveStemsToDBJob(messageBytes)
+						_ = handler.HandleSaveStemsToDBJob(context.Background(), messageBytes)
 						track, err := dummyTrackStore.GetTrack(context.Background(), tracklistID, trackID)
 						Expect(err).NotTo(HaveOccurred())
 						stemTrack, ok := track.(entity.StemTrack)
@@ -156,7 +156,7 @@ var _ = Describe("JobHandler", func() {
 					})
 
 					It("also returns a failure", func() {
-						err := handler.HandleSaveStemsToDBJob(messageBytes)
+						err := handler.HandleSaveStemsToDBJob(context.Background(), messageBytes)
 						Expect(err).To(HaveOccurred())
 					})
 				})
@@ -187,12 +187,12 @@ var _ = Describe("JobHandler", func() {
 
 				Describe("Store saves successfully", func() {
 					It("does not error", func() {
-						err := handler.HandleSaveStemsToDBJob(messageBytes)
+						err := handler.HandleSaveStemsToDBJob(context.Background(), messageBytes)
 						Expect(err).NotTo(HaveOccurred())
 					})
 
 					It("updates the track store", func() {
-						_ = handler.HandleSaveStemsToDBJob(messageBytes)
+						_ = handler.HandleSaveStemsToDBJob(context.Background(), messageBytes)
 						track, err := dummyTrackStore.GetTrack(context.Background(), tracklistID, trackID)
 						Expect(err).NotTo(HaveOccurred())
 						stemTrack, ok := track.(entity.StemTrack)
@@ -208,7 +208,7 @@ var _ = Describe("JobHandler", func() {
 					})
 
 					It("also returns a failure", func() {
-						err := handler.HandleSaveStemsToDBJob(messageBytes)
+						err := handler.HandleSaveStemsToDBJob(context.Background(), messageBytes)
 						Expect(err).To(HaveOccurred())
 					})
 				})
@@ -233,7 +233,7 @@ var _ = Describe("JobHandler", func() {
 			})
 
 			It("returns error", func() {
-				err := handler.HandleSaveStemsToDBJob(messageBytes)
+				err := handler.HandleSaveStemsToDBJob(context.Background(), messageBytes)
 				Expect(err).To(HaveOccurred())
 			})
 		})
@@ -3,14 +3,16 @@ package save_stems_to_dbfakes
 
 import (
 	"chord-paper-be-workers/src/application/jobs/save_stems_to_db"
+	"context"
 	"sync"
 )
 
 type FakeSaveStemsJobHandler struct {
-	HandleSaveStemsToDBJobStub        func([]byte) error
+	HandleSaveStemsToDBJobStub        func(context.Context, []byte) error
 	handleSaveStemsToDBJobMutex       sync.RWMutex
 	handleSaveStemsToDBJobArgsForCall []struct {
-		arg1 []byte
+		arg1 context.Context
+		arg2 []byte
 	}
 	handleSaveStemsToDBJobReturns struct {
 		result1 error
@@ -22,23 +24,24 @@ type FakeSaveStemsJobHandler struct {
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeSaveStemsJobHandler) HandleSaveStemsToDBJob(arg1 []byte) error {
-	var arg1Copy []byte
-	if arg1 != nil {
-		arg1Copy = make([]byte, len(arg1))
-		copy(arg1Copy, arg1)
+func (fake *FakeSaveStemsJobHandler) HandleSaveStemsToDBJob(arg1 context.Context, arg2 []byte) error {
+	var arg2Copy []byte
+	if arg2 != nil {
+		arg2Copy = make([]byte, len(arg2))
+		copy(arg2Copy, arg2)
 	}
 	fake.handleSaveStemsToDBJobMutex.Lock()
 	ret, specificReturn := fake.handleSaveStemsToDBJobReturnsOnCall[len(fake.handleSaveStemsToDBJobArgsForCall)]
 	fake.handleSaveStemsToDBJobArgsForCall = append(fake.handleSaveStemsToDBJobArgsForCall, struct {
-		arg1 []byte
-	}{arg1Copy})
+		arg1 context.Context
+		arg2 []byte
+	}{arg1, arg2Copy})
 	stub := fake.HandleSaveStemsToDBJobStub
 	fakeReturns := fake.handleSaveStemsToDBJobReturns
-	fake.recordInvocation("HandleSaveStemsToDBJob", []interface{}{arg1Copy})
+	fake.recordInvocation("HandleSaveStemsToDBJob", []interface{}{arg1, arg2Copy})
 	fake.handleSaveStemsToDBJobMutex.Unlock()
 	if stub != nil {
-		return stub(arg1)
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1
@@ -52,17 +55,17 @@ func (fake *FakeSaveStemsJobHandler) HandleSaveStemsToDBJobCallCount() int {
 	return len(fake.handleSaveStemsToDBJobArgsForCall)
 }
 
-func (fake *FakeSaveStemsJobHandler) HandleSaveStemsToDBJobCalls(stub func([]byte) error) {
+func (fake *FakeSaveStemsJobHandler) HandleSaveStemsToDBJobCalls(stub func(context.Context, []byte) error) {
 	fake.handleSaveStemsToDBJobMutex.Lock()
 	defer fake.handleSaveStemsToDBJobMutex.Unlock()
 	fake.HandleSaveStemsToDBJobStub = stub
 }
 
-func (fake *FakeSaveStemsJobHandler) HandleSaveStemsToDBJobArgsForCall(i int) []byte {
+func (fake *FakeSaveStemsJobHandler) HandleSaveStemsToDBJobArgsForCall(i int) (context.Context, []byte) {
 	fake.handleSaveStemsToDBJobMutex.RLock()
 	defer fake.handleSaveStemsToDBJobMutex.RUnlock()
 	argsForCall := fake.handleSaveStemsToDBJobArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakeSaveStemsJobHandler) HandleSaveStemsToDBJobReturns(result1 error) {
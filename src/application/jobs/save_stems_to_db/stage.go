@@ -0,0 +1,24 @@
+package save_stems_to_db
+
+import (
+	"chord-paper-be-workers/src/application/pipeline"
+	"context"
+)
+
+// NewStage turns the track into its stems
+func NewStage() pipeline.Stage {
+	return pipeline.Stage{
+		JobType:       JobType,
+		StatusMessage: "Saving processed stems into database",
+		ErrorMessage:  ErrorMessage,
+		Weight:        10,
+		Params:        func() interface{} { return &JobParams{} },
+	}
+}
+
+// StageHandler runs the stage's jobs on the handler, it's the last stage so it has no output
+func StageHandler(handler SaveStemsJobHandler) pipeline.Handler {
+	return func(ctx context.Context, message []byte) (pipeline.Output, error) {
+		return nil, handler.HandleSaveStemsToDBJob(ctx, message)
+	}
+}
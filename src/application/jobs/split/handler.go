@@ -20,7 +20,7 @@ type JobParams struct {
 
 //counterfeiter:generate . SplitJobHandler
 type SplitJobHandler interface {
-	HandleSplitJob(message []byte) (JobParams, splitter.StemFilePaths, error)
+	HandleSplitJob(ctx context.Context, message []byte) (JobParams, splitter.StemFilePaths, error)
 }
 
 func NewJobHandler(splitter splitter.TrackSplitter) JobHandler {
@@ -33,16 +33,16 @@ type JobHandler struct {
 	splitter splitter.TrackSplitter
 }
 
-func (s JobHandler) HandleSplitJob(message []byte) (JobParams, splitter.StemFilePaths, error) {
+func (s JobHandler) HandleSplitJob(ctx context.Context, message []byte) (JobParams, splitter.StemFilePaths, error) {
 	params := JobParams{}
 	err := json.Unmarshal(message, &params)
 	if err != nil {
-		return JobParams{}, nil, cerr.Wrap(err).Error("Failed to unmarshal message JSON")
+		return JobParams{}, nil, cerr.Categorize(cerr.Permanent).Wrap(err).Error("Failed to unmarshal message JSON")
 	}
 
 	errctx := cerr.Field("job_params", params)
 
-	stemURLs, err := s.splitter.SplitTrack(context.Background(), params.TrackListID, params.TrackID, params.SavedOriginalURL)
+	stemURLs, err := s.splitter.SplitTrack(ctx, params.TrackListID, params.TrackID, params.SavedOriginalURL)
 	if err != nil {
 		return JobParams{}, nil, errctx.Wrap(err).Error("Failed to split the track")
 	}
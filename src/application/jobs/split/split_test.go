@@ -141,7 +141,7 @@ var _ = Describe("Split handler", func() {
 			})
 
 			JustBeforeEach(func() {
-				returnedJobParams, returnedStemUrls, err = handler.HandleSplitJob(message)
+				returnedJobParams, returnedStemUrls, err = handler.HandleSplitJob(context.Background(), message)
 			})
 
 			Describe("2stems", func() {
@@ -247,7 +247,7 @@ var _ = Describe("Split handler", func() {
 			})
 
 			It("returns an error", func() {
-				_, _, err := handler.HandleSplitJob(message)
+				_, _, err := handler.HandleSplitJob(context.Background(), message)
 				Expect(err).To(HaveOccurred())
 			})
 		})
@@ -258,7 +258,7 @@ var _ = Describe("Split handler", func() {
 			})
 
 			It("returns an error", func() {
-				_, _, err := handler.HandleSplitJob(message)
+				_, _, err := handler.HandleSplitJob(context.Background(), message)
 				Expect(err).To(HaveOccurred())
 			})
 		})
@@ -281,7 +281,7 @@ var _ = Describe("Split handler", func() {
 		})
 
 		It("failaroo", func() {
-			_, _, err := handler.HandleSplitJob(message)
+			_, _, err := handler.HandleSplitJob(context.Background(), message)
 			Expect(err).To(HaveOccurred())
 		})
 	})
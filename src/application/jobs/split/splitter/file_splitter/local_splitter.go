@@ -3,15 +3,20 @@ package file_splitter
 import (
 	"chord-paper-be-workers/src/application/executor"
 	"chord-paper-be-workers/src/application/jobs/split/splitter"
+	"chord-paper-be-workers/src/application/metrics"
+	"chord-paper-be-workers/src/application/tracing"
 	"chord-paper-be-workers/src/lib/cerr"
+	"chord-paper-be-workers/src/lib/logging"
 	"chord-paper-be-workers/src/lib/working_dir"
 	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/apex/log"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var _ splitter.FileSplitter = LocalFileSplitter{}
@@ -58,16 +63,16 @@ func (l LocalFileSplitter) SplitFile(ctx context.Context, originalTrackFilePath
 		return nil, cerr.Wrap(ctx.Err()).Error("Context cancelled before splitting could happen")
 	}
 
-	if err := l.runSpleeter(absOriginalTrackFilePath, absStemsOutputDir, splitType); err != nil {
+	if err := l.runSpleeter(ctx, absOriginalTrackFilePath, absStemsOutputDir, splitType); err != nil {
 		return nil, cerr.Field("output_dir", absStemsOutputDir).
 			Wrap(err).Error("Failed to execute spleeter")
 	}
 
-	return collectStemFilePaths(absStemsOutputDir)
+	return collectStemFilePaths(ctx, absStemsOutputDir)
 }
 
-func (l LocalFileSplitter) runSpleeter(sourcePath string, destPath string, splitType splitter.SplitType) error {
-	logger := log.WithFields(log.Fields{
+func (l LocalFileSplitter) runSpleeter(ctx context.Context, sourcePath string, destPath string, splitType splitter.SplitType) error {
+	logger := logging.FromContext(ctx).WithFields(log.Fields{
 		"sourcePath": sourcePath,
 		"destPath":   destPath,
 		"splitType":  splitType,
@@ -85,10 +90,14 @@ func (l LocalFileSplitter) runSpleeter(sourcePath string, destPath string, split
 
 	errctx := cerr.Field("spleeter_bin_path", l.spleeterBinPath).Field("spleeter_args", args)
 
-	cmd := l.executor.Command(l.spleeterBinPath, args...)
+	ctx, span := tracing.StartSpan(ctx, "spleeter", attribute.String("split_type", string(splitType)))
+	cmd := l.executor.Command(ctx, l.spleeterBinPath, args...)
 	cmd.SetDir(l.workingDir.Root())
 
+	started := time.Now()
 	output, err := cmd.CombinedOutput()
+	metrics.ToolFinished(metrics.ToolSpleeter, err, started)
+	tracing.End(span, err)
 	if err != nil {
 		return errctx.Field("spleeter_output", string(output)).
 			Wrap(err).
@@ -101,8 +110,8 @@ func (l LocalFileSplitter) runSpleeter(sourcePath string, destPath string, split
 	return nil
 }
 
-func collectStemFilePaths(dir string) (splitter.StemFilePaths, error) {
-	logger := log.WithFields(log.Fields{
+func collectStemFilePaths(ctx context.Context, dir string) (splitter.StemFilePaths, error) {
+	logger := logging.FromContext(ctx).WithFields(log.Fields{
 		"dir": dir,
 	})
 
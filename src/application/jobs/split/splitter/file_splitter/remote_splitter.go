@@ -4,11 +4,13 @@ import (
 	cloudstorage "chord-paper-be-workers/src/application/cloud_storage/entity"
 	"chord-paper-be-workers/src/application/jobs/split/splitter"
 	"chord-paper-be-workers/src/lib/cerr"
+	"chord-paper-be-workers/src/lib/logging"
 	"chord-paper-be-workers/src/lib/working_dir"
 	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 
 	"github.com/apex/log"
@@ -36,7 +38,7 @@ type RemoteFileSplitter struct {
 }
 
 func (r RemoteFileSplitter) SplitFile(ctx context.Context, remoteSourcePath string, remoteDestPath string, splitType splitter.SplitType) (splitter.StemFilePaths, error) {
-	logger := log.WithFields(log.Fields{
+	logger := logging.FromContext(ctx).WithFields(log.Fields{
 		"remoteSourcePath": remoteSourcePath,
 		"remoteDestPath":   remoteDestPath,
 		"splitType":        splitType,
@@ -49,7 +51,7 @@ func (r RemoteFileSplitter) SplitFile(ctx context.Context, remoteSourcePath stri
 	}
 
 	logger.Info("Creating temp directory to store the original track")
-	originalTrackDir, removeOriginalTrackDir, err := r.createTempDir("original")
+	originalTrackDir, removeOriginalTrackDir, err := r.createTempDir(ctx, "original")
 	if err != nil {
 		return nil, cerr.Wrap(err).Error("Failed to create directory to save original track")
 	}
@@ -57,13 +59,14 @@ func (r RemoteFileSplitter) SplitFile(ctx context.Context, remoteSourcePath stri
 	defer removeOriginalTrackDir()
 
 	logger.Info("Writing original track into temp directory")
-	originalTrackFilePath := filepath.Join(originalTrackDir, "original.mp3")
+	// named after the format it was saved in, rather than assumed to be an MP3
+	originalTrackFilePath := filepath.Join(originalTrackDir, "original"+path.Ext(remoteSourcePath))
 	if err := os.WriteFile(originalTrackFilePath, fileContents, os.ModePerm); err != nil {
 		return nil, cerr.Wrap(err).Error("Failed to write file temporarily to disk")
 	}
 
 	logger.Info("Creating temp directory to store the split stem track")
-	stemTrackDir, removeStemTrackDir, err := r.createTempDir("stems")
+	stemTrackDir, removeStemTrackDir, err := r.createTempDir(ctx, "stems")
 	if err != nil {
 		return nil, cerr.Wrap(err).Error("Failed to create directory to save stem tracks")
 	}
@@ -85,7 +88,7 @@ func (r RemoteFileSplitter) SplitFile(ctx context.Context, remoteSourcePath stri
 	return remoteFilePaths, nil
 }
 
-func (r RemoteFileSplitter) createTempDir(prefix string) (string, func(), error) {
+func (r RemoteFileSplitter) createTempDir(ctx context.Context, prefix string) (string, func(), error) {
 	tempDir, err := ioutil.TempDir(r.workingDir.TempDir(), fmt.Sprintf("%s-*", prefix))
 	if err != nil {
 		return "", nil, cerr.Wrap(err).Error("Failed to create a temporary directory")
@@ -94,7 +97,7 @@ func (r RemoteFileSplitter) createTempDir(prefix string) (string, func(), error)
 	removeTempDirFn := func() {
 		err := os.RemoveAll(tempDir)
 		if err != nil {
-			log.WithField("tempDir", tempDir).Error("Failed to remove temp dir")
+			logging.FromContext(ctx).WithField("tempDir", tempDir).Error("Failed to remove temp dir")
 		}
 	}
 
@@ -102,7 +105,7 @@ func (r RemoteFileSplitter) createTempDir(prefix string) (string, func(), error)
 }
 
 func (r RemoteFileSplitter) uploadStem(ctx context.Context, done chan error, sourceStemFilePath string, destStemFilePath string) {
-	logger := log.WithFields(log.Fields{
+	logger := logging.FromContext(ctx).WithFields(log.Fields{
 		"sourceStemFilePath": sourceStemFilePath,
 		"destStemFilePath":   destStemFilePath,
 	})
@@ -131,19 +134,20 @@ func (r RemoteFileSplitter) uploadStems(ctx context.Context, remoteStemDir strin
 	uploadResultChannels := []chan error{}
 	remoteFilePaths := splitter.StemFilePaths{}
 
-	log.Info("Spinning off upload threads")
+	logger := logging.FromContext(ctx)
+	logger.Info("Spinning off upload threads")
 
 	for stemKey, localStemFilePath := range localStemFilePaths {
 		resultChannel := make(chan error)
 		uploadResultChannels = append(uploadResultChannels, resultChannel)
 
-		remoteDestFilePath := fmt.Sprintf("%s/%s.mp3", remoteStemDir, stemKey)
+		remoteDestFilePath := splitter.StemURL(remoteStemDir, stemKey)
 		remoteFilePaths[stemKey] = remoteDestFilePath
 
 		go r.uploadStem(ctx, resultChannel, localStemFilePath, remoteDestFilePath)
 	}
 
-	log.Info("Waiting for upload threads to finish")
+	logger.Info("Waiting for upload threads to finish")
 	for _, resultChannel := range uploadResultChannels {
 		err := <-resultChannel
 		if err != nil {
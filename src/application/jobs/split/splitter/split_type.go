@@ -14,6 +14,13 @@ const (
 	SplitFiveStemsType SplitType = "5stems"
 )
 
+// StemNames are the stems spleeter writes for each split type, named after its instruments
+var StemNames = map[SplitType][]string{
+	SplitTwoStemsType:  {"vocals", "accompaniment"},
+	SplitFourStemsType: {"vocals", "drums", "bass", "other"},
+	SplitFiveStemsType: {"vocals", "drums", "bass", "piano", "other"},
+}
+
 func ConvertToSplitType(trackType entity.TrackType) (SplitType, error) {
 	switch trackType {
 	case entity.SplitTwoStemsType:
@@ -62,10 +62,19 @@ func (t TrackSplitter) SplitTrack(ctx context.Context, tracklistID string, track
 }
 
 func (t TrackSplitter) generatePath(tracklistID string, trackID string, splitType SplitType) (string, error) {
+	return StemDirURL(t.bucketName, tracklistID, trackID, splitType)
+}
+
+// StemDirURL is where the split stage saves a track's stems
+func StemDirURL(bucketName string, tracklistID string, trackID string, splitType SplitType) (string, error) {
 	splitDir, ok := splitDirNames[splitType]
 	if !ok {
 		return "", cerr.Error("Invalid split type provided")
 	}
 
-	return fmt.Sprintf("%s/%s/%s/%s/%s", store.GOOGLE_STORAGE_HOST, t.bucketName, tracklistID, trackID, splitDir), nil
+	return fmt.Sprintf("%s/%s/%s/%s/%s", store.GOOGLE_STORAGE_HOST, bucketName, tracklistID, trackID, splitDir), nil
+}
+
+func StemURL(stemDirURL string, stemName string) string {
+	return fmt.Sprintf("%s/%s.mp3", stemDirURL, stemName)
 }
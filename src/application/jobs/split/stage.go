@@ -0,0 +1,42 @@
+package split
+
+import (
+	"chord-paper-be-workers/src/application/jobs/job_message"
+	"chord-paper-be-workers/src/application/metrics"
+	"chord-paper-be-workers/src/application/pipeline"
+	"context"
+)
+
+// Output is the track's saved stems
+type Output struct {
+	job_message.TrackIdentifier
+	// keyed by stem
+	StemURLs map[string]string `json:"stem_urls"`
+}
+
+// NewStage splits the saved original into stems
+func NewStage() pipeline.Stage {
+	return pipeline.Stage{
+		JobType:       JobType,
+		StatusMessage: "Splitting the track into stems",
+		ErrorMessage:  ErrorMessage,
+		Weight:        60,
+		Tools:         []string{metrics.ToolSpleeter},
+		Params:        func() interface{} { return &JobParams{} },
+	}
+}
+
+// StageHandler runs the stage's jobs on the handler
+func StageHandler(handler SplitJobHandler) pipeline.Handler {
+	return func(ctx context.Context, message []byte) (pipeline.Output, error) {
+		params, stemURLs, err := handler.HandleSplitJob(ctx, message)
+		if err != nil {
+			return nil, err
+		}
+
+		return pipeline.NewOutput(Output{
+			TrackIdentifier: params.TrackIdentifier,
+			StemURLs:        stemURLs,
+		})
+	}
+}
@@ -15,7 +15,7 @@ const ErrorMessage string = "Failed to start processing audio splitting"
 
 //counterfeiter:generate . StartJobHandler
 type StartJobHandler interface {
-	HandleStartJob(message []byte) (JobParams, error)
+	HandleStartJob(ctx context.Context, message []byte) (JobParams, error)
 }
 
 type JobParams struct {
@@ -32,10 +32,10 @@ type JobHandler struct {
 	trackStore entity.TrackStore
 }
 
-func (d JobHandler) HandleStartJob(message []byte) (JobParams, error) {
+func (d JobHandler) HandleStartJob(ctx context.Context, message []byte) (JobParams, error) {
 	params, err := unmarshalMessage(message)
 	if err != nil {
-		return JobParams{}, cerr.Wrap(err).Error("Failed to unmarshal message JSON")
+		return JobParams{}, cerr.Categorize(cerr.Permanent).Wrap(err).Error("Failed to unmarshal message JSON")
 	}
 
 	errCtx := cerr.Field("tracklist_id", params.TrackListID).
@@ -44,11 +44,11 @@ func (d JobHandler) HandleStartJob(message []byte) (JobParams, error) {
 	updater := func(track entity.Track) (entity.Track, error) {
 		splitStemTrack, ok := track.(entity.SplitStemTrack)
 		if !ok {
-			return entity.BaseTrack{}, errCtx.Error("Track from DB is not a split stem track")
+			return entity.BaseTrack{}, errCtx.Categorize(cerr.Permanent).Error("Track from DB is not a split stem track")
 		}
 
 		if splitStemTrack.JobStatus != entity.RequestedStatus {
-			return entity.BaseTrack{}, errCtx.Error("Track is not in requested status, abort processing to be safe")
+			return entity.BaseTrack{}, errCtx.Categorize(cerr.Permanent).Error("Track is not in requested status, abort processing to be safe")
 		}
 
 		splitStemTrack.JobStatus = entity.ProcessingStatus
@@ -56,7 +56,7 @@ func (d JobHandler) HandleStartJob(message []byte) (JobParams, error) {
 		return splitStemTrack, nil
 	}
 
-	err = d.trackStore.UpdateTrack(context.Background(), params.TrackListID, params.TrackID, updater)
+	err = d.trackStore.UpdateTrack(ctx, params.TrackListID, params.TrackID, updater)
 	if err != nil {
 		return JobParams{}, errCtx.Wrap(err).Error("Failed to set the track status")
 	}
@@ -68,17 +68,17 @@ func unmarshalMessage(message []byte) (JobParams, error) {
 	params := JobParams{}
 	err := json.Unmarshal(message, &params)
 	if err != nil {
-		return JobParams{}, cerr.Wrap(err).Error("Failed to unmarshal message JSON")
+		return JobParams{}, cerr.Categorize(cerr.Permanent).Wrap(err).Error("Failed to unmarshal message JSON")
 	}
 
 	errctx := cerr.Field("job_params", params)
 
 	if params.TrackListID == "" {
-		return JobParams{}, errctx.Wrap(err).Error("Missing tracklist ID")
+		return JobParams{}, errctx.Categorize(cerr.Permanent).Wrap(err).Error("Missing tracklist ID")
 	}
 
 	if params.TrackID == "" {
-		return JobParams{}, errctx.Wrap(err).Error("Missing track ID")
+		return JobParams{}, errctx.Categorize(cerr.Permanent).Wrap(err).Error("Missing track ID")
 	}
 
 	return params, nil
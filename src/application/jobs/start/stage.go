@@ -0,0 +1,29 @@
+package start
+
+import (
+	"chord-paper-be-workers/src/application/pipeline"
+	"context"
+)
+
+// NewStage picks up a requested track, it's where the pipeline starts
+func NewStage() pipeline.Stage {
+	return pipeline.Stage{
+		JobType:       JobType,
+		StatusMessage: "Starting to process the track",
+		ErrorMessage:  ErrorMessage,
+		Weight:        10,
+		Params:        func() interface{} { return &JobParams{} },
+	}
+}
+
+// StageHandler runs the stage's jobs on the handler, its output is the track it picked up
+func StageHandler(handler StartJobHandler) pipeline.Handler {
+	return func(ctx context.Context, message []byte) (pipeline.Output, error) {
+		params, err := handler.HandleStartJob(ctx, message)
+		if err != nil {
+			return nil, err
+		}
+
+		return pipeline.NewOutput(params.TrackIdentifier)
+	}
+}
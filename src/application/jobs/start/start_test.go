@@ -72,7 +72,7 @@ var _ = Describe("Start", func() {
 			var jobParams start.JobParams
 
 			BeforeEach(func() {
-				jobParams, err = handler.HandleStartJob(message)
+				jobParams, err = handler.HandleStartJob(context.Background(), message)
 			})
 
 			It("doesn't return an error", func() {
@@ -101,7 +101,7 @@ var _ = Describe("Start", func() {
 			})
 
 			It("returns an error", func() {
-				_, err := handler.HandleStartJob(message)
+				_, err := handler.HandleStartJob(context.Background(), message)
 				Expect(err).To(HaveOccurred())
 			})
 		})
@@ -121,7 +121,7 @@ var _ = Describe("Start", func() {
 		})
 
 		It("returns error", func() {
-			_, err := handler.HandleStartJob(message)
+			_, err := handler.HandleStartJob(context.Background(), message)
 			Expect(err).To(HaveOccurred())
 		})
 	})
@@ -3,14 +3,16 @@ package startfakes
 
 import (
 	"chord-paper-be-workers/src/application/jobs/start"
+	"context"
 	"sync"
 )
 
 type FakeStartJobHandler struct {
-	HandleStartJobStub        func([]byte) (start.JobParams, error)
+	HandleStartJobStub        func(context.Context, []byte) (start.JobParams, error)
 	handleStartJobMutex       sync.RWMutex
 	handleStartJobArgsForCall []struct {
-		arg1 []byte
+		arg1 context.Context
+		arg2 []byte
 	}
 	handleStartJobReturns struct {
 		result1 start.JobParams
@@ -24,23 +26,24 @@ type FakeStartJobHandler struct {
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeStartJobHandler) HandleStartJob(arg1 []byte) (start.JobParams, error) {
-	var arg1Copy []byte
-	if arg1 != nil {
-		arg1Copy = make([]byte, len(arg1))
-		copy(arg1Copy, arg1)
+func (fake *FakeStartJobHandler) HandleStartJob(arg1 context.Context, arg2 []byte) (start.JobParams, error) {
+	var arg2Copy []byte
+	if arg2 != nil {
+		arg2Copy = make([]byte, len(arg2))
+		copy(arg2Copy, arg2)
 	}
 	fake.handleStartJobMutex.Lock()
 	ret, specificReturn := fake.handleStartJobReturnsOnCall[len(fake.handleStartJobArgsForCall)]
 	fake.handleStartJobArgsForCall = append(fake.handleStartJobArgsForCall, struct {
-		arg1 []byte
-	}{arg1Copy})
+		arg1 context.Context
+		arg2 []byte
+	}{arg1, arg2Copy})
 	stub := fake.HandleStartJobStub
 	fakeReturns := fake.handleStartJobReturns
-	fake.recordInvocation("HandleStartJob", []interface{}{arg1Copy})
+	fake.recordInvocation("HandleStartJob", []interface{}{arg1, arg2Copy})
 	fake.handleStartJobMutex.Unlock()
 	if stub != nil {
-		return stub(arg1)
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
@@ -54,17 +57,17 @@ func (fake *FakeStartJobHandler) HandleStartJobCallCount() int {
 	return len(fake.handleStartJobArgsForCall)
 }
 
-func (fake *FakeStartJobHandler) HandleStartJobCalls(stub func([]byte) (start.JobParams, error)) {
+func (fake *FakeStartJobHandler) HandleStartJobCalls(stub func(context.Context, []byte) (start.JobParams, error)) {
 	fake.handleStartJobMutex.Lock()
 	defer fake.handleStartJobMutex.Unlock()
 	fake.HandleStartJobStub = stub
 }
 
-func (fake *FakeStartJobHandler) HandleStartJobArgsForCall(i int) []byte {
+func (fake *FakeStartJobHandler) HandleStartJobArgsForCall(i int) (context.Context, []byte) {
 	fake.handleStartJobMutex.RLock()
 	defer fake.handleStartJobMutex.RUnlock()
 	argsForCall := fake.handleStartJobArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakeStartJobHandler) HandleStartJobReturns(result1 start.JobParams, result2 error) {
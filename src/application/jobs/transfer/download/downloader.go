@@ -1,5 +1,7 @@
 package download
 
+import "context"
+
 type Downloader interface {
-	Download(sourceURL string, outFilePath string) error
+	Download(ctx context.Context, sourceURL string, outFilePath string) error
 }
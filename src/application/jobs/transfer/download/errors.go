@@ -0,0 +1,59 @@
+package download
+
+import (
+	"chord-paper-be-workers/src/lib/cerr"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// youtube-dl output that means the URL won't ever work, rather than that something broke along the way
+var youtubeDLUserInputErrors = []string{
+	"Unsupported URL",
+	"is not a valid URL",
+	"Video unavailable",
+	"Private video",
+	"This video is not available",
+	"This video has been removed",
+	"Sign in to confirm your age",
+}
+
+func youtubeDLErrorCategory(err error, output string) cerr.Category {
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+		return cerr.Infrastructure
+	}
+
+	for _, message := range youtubeDLUserInputErrors {
+		if strings.Contains(output, message) {
+			return cerr.UserInput
+		}
+	}
+
+	return cerr.Uncategorized
+}
+
+// requestErrorCategory blames hosts that don't exist on the URL we were given, and anything else on the network
+func requestErrorCategory(err error) cerr.Category {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return cerr.UserInput
+	}
+
+	return cerr.Transient
+}
+
+func statusCodeCategory(statusCode int) cerr.Category {
+	switch {
+	case statusCode == http.StatusRequestTimeout,
+		statusCode == http.StatusTooManyRequests,
+		statusCode >= http.StatusInternalServerError:
+		return cerr.Transient
+	case statusCode >= http.StatusBadRequest:
+		return cerr.UserInput
+	default:
+		return cerr.Uncategorized
+	}
+}
@@ -1,44 +1,303 @@
 package download
 
 import (
-	"chord-paper-be-workers/src/application/executor"
+	"bytes"
 	"chord-paper-be-workers/src/lib/cerr"
+	"chord-paper-be-workers/src/lib/logging"
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
-
-	"github.com/apex/log"
+	"sync/atomic"
+	"time"
 )
 
 var _ Downloader = GenericDLer{}
 
+var errTooManyRedirects = errors.New("too many redirects")
+
+const notMediaExplanation = "The link doesn't lead to an audio or video file, it may be to a web page that plays one"
+
+type GenericDLConfig struct {
+	// how long connecting, including the TLS handshake, can take
+	ConnectTimeout time.Duration
+	// how long the source can go without sending anything, both before it responds and while it sends the file
+	ReadTimeout time.Duration
+	// the largest file in bytes that's downloaded
+	MaxSize      int64
+	MaxRedirects int
+	// how many times to try the source within one job, each attempt picking up
+	// where the last one left off when the source takes range requests
+	Retries Backoff
+	// how often to log how much has been downloaded
+	ProgressInterval time.Duration
+}
+
+// Backoff is how many times a download is tried within one job, and how long it waits after each failed attempt
+type Backoff struct {
+	MaxAttempts int
+	// the last delay is used for any attempts past the delays given
+	Delays []time.Duration
+}
+
+func (b Backoff) delay(failedAttempt int) time.Duration {
+	if len(b.Delays) == 0 {
+		return 0
+	}
+
+	index := failedAttempt - 1
+	if index < 0 {
+		index = 0
+	}
+
+	if index >= len(b.Delays) {
+		index = len(b.Delays) - 1
+	}
+
+	return b.Delays[index]
+}
+
+func DefaultGenericDLConfig() GenericDLConfig {
+	return GenericDLConfig{
+		ConnectTimeout: 10 * time.Second,
+		ReadTimeout:    30 * time.Second,
+		MaxSize:        512 << 20,
+		MaxRedirects:   5,
+		Retries: Backoff{
+			MaxAttempts: 4,
+			Delays:      []time.Duration{time.Second, 5 * time.Second, 15 * time.Second},
+		},
+		ProgressInterval: 10 * time.Second,
+	}
+}
+
 func NewGenericDLer() GenericDLer {
-	return GenericDLer{}
+	return NewGenericDLerWithConfig(DefaultGenericDLConfig())
+}
+
+func NewGenericDLerWithConfig(config GenericDLConfig) GenericDLer {
+	dialer := &net.Dialer{
+		Timeout:   config.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = config.ConnectTimeout
+	transport.ResponseHeaderTimeout = config.ReadTimeout
+
+	return GenericDLer{
+		config: config,
+		client: &http.Client{
+			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) > config.MaxRedirects {
+					return errTooManyRedirects
+				}
+
+				return nil
+			},
+		},
+	}
 }
 
+// GenericDLer downloads links straight to audio or video files,
+// and refuses anything that turns out to be something else
 type GenericDLer struct {
-	commandExecutor executor.Executor
+	config GenericDLConfig
+	client *http.Client
+}
+
+func (y GenericDLer) Download(ctx context.Context, sourceURL string, outFilePath string) (err error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("Running generic-dl")
+
+	out, err := os.Create(outFilePath)
+	if err != nil {
+		return cerr.Categorize(cerr.Infrastructure).Wrap(err).Error("Failed to create temp file")
+	}
+	defer func() {
+		_ = out.Close()
+		if err != nil {
+			_ = os.Remove(outFilePath)
+		}
+	}()
+
+	partial := newPartialDownload(out, logger, y.config.ProgressInterval)
+
+	for attempt := 1; ; attempt++ {
+		err = y.attempt(ctx, sourceURL, partial)
+		if err == nil {
+			logger.WithField("size", partial.written).Info("Downloaded file from provided source")
+			return nil
+		}
+
+		errctx := cerr.Field("attempt", attempt).Field("downloaded", partial.written).Field("total", partial.total)
+
+		if !cerr.IsRetryable(err) || attempt >= y.config.Retries.MaxAttempts {
+			return errctx.Wrap(err).Error("Failed to download file from provided source")
+		}
+
+		delay := y.config.Retries.delay(attempt)
+		logger.WithError(err).WithField("attempt", attempt).WithField("downloaded", partial.written).
+			WithField("delay", delay.String()).Warn("Download attempt failed, trying again")
+
+		select {
+		case <-ctx.Done():
+			return errctx.Wrap(ctx.Err()).Error("Stopped retrying download")
+		case <-time.After(delay):
+		}
+	}
 }
 
-func (y GenericDLer) Download(sourceURL string, outFilePath string) error {
-	log.Info("Running generic-dl")
+// attempt saves as much of the file as it can, carrying on from what's already been saved if the source allows it
+func (y GenericDLer) attempt(ctx context.Context, sourceURL string, partial *partialDownload) error {
+	// cancelled when the source stalls, which ends the request wherever it's up to
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	resp, err := http.Get(sourceURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL, nil)
 	if err != nil {
-		return cerr.Wrap(err).Error("Failed to fetch file from provided source")
+		return cerr.Categorize(cerr.UserInput).Wrap(err).Error("Failed to create request for provided source")
+	}
+
+	rangeHeaders := partial.rangeHeaders()
+	for key, values := range rangeHeaders {
+		req.Header[key] = values
+	}
+
+	resp, err := y.client.Do(req)
+	if errors.Is(err, errTooManyRedirects) {
+		return cerr.Field("max_redirects", y.config.MaxRedirects).Categorize(cerr.UserInput).
+			Explain("The link redirects too many times to follow").
+			Wrap(err).Error("Provided source redirected too many times")
+	}
+	if err != nil {
+		return cerr.Categorize(requestErrorCategory(err)).Wrap(err).Error("Failed to fetch file from provided source")
 	}
 	defer resp.Body.Close()
 
-	out, err := os.Create(outFilePath)
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return cerr.Categorize(statusCodeCategory(resp.StatusCode)).
+			Field("status_code", resp.StatusCode).
+			Explain(fmt.Sprintf("The link couldn't be downloaded, the site responded with %s", resp.Status)).
+			Error("Provided source responded with an error")
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if !isMediaContentType(contentType) {
+		return cerr.Field("content_type", contentType).Categorize(cerr.UserInput).
+			Explain(notMediaExplanation).
+			Error("Provided source isn't an audio or video file")
+	}
+
+	// a source that doesn't take range requests, or whose file has changed, sends all of it again
+	if resp.StatusCode == http.StatusPartialContent && len(rangeHeaders) > 0 {
+		err = partial.resume(resp)
+	} else {
+		err = partial.restart(resp)
+	}
 	if err != nil {
-		return cerr.Wrap(err).Error("Failed to create temp file")
+		return err
 	}
-	defer out.Close()
 
-	// Write the body to file
-	if _, err = io.Copy(out, resp.Body); err != nil {
-		return cerr.Wrap(err).Error("Failed to write song contents out to file")
+	if partial.total > y.config.MaxSize {
+		return y.tooLargeError(partial.total)
+	}
+
+	var stalled int32
+	stallTimer := time.AfterFunc(y.config.ReadTimeout, func() {
+		atomic.StoreInt32(&stalled, 1)
+		cancel()
+	})
+	defer stallTimer.Stop()
+
+	body := idleTimeoutReader{
+		reader:  resp.Body,
+		timer:   stallTimer,
+		timeout: y.config.ReadTimeout,
+	}
+
+	err = y.save(ctx, body, partial)
+	if err != nil && atomic.LoadInt32(&stalled) == 1 {
+		return cerr.Field("read_timeout", y.config.ReadTimeout).Categorize(cerr.Transient).
+			Wrap(err).Error("Provided source stopped sending the file")
+	}
+
+	return err
+}
+
+// save checks that the file starts like an audio or video file before writing any of it out,
+// and that all of it arrived once the source is done sending it
+func (y GenericDLer) save(ctx context.Context, body io.Reader, partial *partialDownload) error {
+	if partial.written == 0 {
+		header := make([]byte, sniffLength)
+		headerLength, err := io.ReadFull(body, header)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return cerr.Categorize(cerr.Transient).Wrap(err).Error("Failed to read song contents from provided source")
+		}
+		header = header[:headerLength]
+
+		format, ok := sniffMedia(header)
+		if !ok {
+			return cerr.Field("header_length", headerLength).Categorize(cerr.UserInput).
+				Explain(notMediaExplanation).
+				Error("Provided source doesn't start like an audio or video file")
+		}
+
+		logging.FromContext(ctx).WithField("format", format).Info("Recognized source format")
+
+		body = io.MultiReader(bytes.NewReader(header), body)
+	}
+
+	// one byte past the limit shows that the file is too large, without reading the rest of it
+	contents := io.LimitReader(body, y.config.MaxSize+1-partial.written)
+
+	if _, err := io.Copy(partial, contents); err != nil {
+		return cerr.Field("written", partial.written).Categorize(cerr.Transient).
+			Wrap(err).Error("Failed to write song contents out to file")
+	}
+
+	if partial.written > y.config.MaxSize {
+		return y.tooLargeError(partial.written)
+	}
+
+	if partial.total >= 0 && partial.written != partial.total {
+		errctx := cerr.Field("written", partial.written).Field("total", partial.total)
+
+		// there's no telling which part of what was saved is wrong
+		if partial.written > partial.total {
+			partial.resumable = false
+		}
+
+		return errctx.Categorize(cerr.Transient).Error("Downloaded length doesn't match what the source said")
 	}
 
 	return nil
 }
+
+func (y GenericDLer) tooLargeError(size int64) error {
+	return cerr.Field("size", size).Field("max_size", y.config.MaxSize).Categorize(cerr.UserInput).
+		Explain(fmt.Sprintf("The file is too large, it can be at most %d MB", y.config.MaxSize>>20)).
+		Error("Provided source is too large")
+}
+
+// idleTimeoutReader pushes the timer back whenever data arrives, so that it only fires
+// when the source has gone quiet rather than when a large file takes a while
+type idleTimeoutReader struct {
+	reader  io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (r idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+
+	return n, err
+}
@@ -0,0 +1,353 @@
+package download_test
+
+import (
+	"chord-paper-be-workers/src/application/jobs/transfer/download"
+	"chord-paper-be-workers/src/lib/cerr"
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GenericDLer", func() {
+	var (
+		mp3Contents []byte
+
+		handler http.HandlerFunc
+		server  *httptest.Server
+
+		config      download.GenericDLConfig
+		outFilePath string
+	)
+
+	BeforeEach(func() {
+		mp3Contents = append([]byte("ID3\x03\x00"), make([]byte, 2048)...)
+
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "audio/mpeg")
+			_, _ = w.Write(mp3Contents)
+		}
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler(w, r)
+		}))
+
+		config = download.DefaultGenericDLConfig()
+		config.ReadTimeout = time.Second
+		config.Retries = download.Backoff{MaxAttempts: 1}
+
+		tempDir, err := os.MkdirTemp("", "genericdl")
+		Expect(err).NotTo(HaveOccurred())
+		outFilePath = filepath.Join(tempDir, "original.mp3")
+	})
+
+	AfterEach(func() {
+		server.Close()
+		_ = os.RemoveAll(filepath.Dir(outFilePath))
+	})
+
+	fetch := func(path string) error {
+		genericdler := download.NewGenericDLerWithConfig(config)
+		return genericdler.Download(context.Background(), server.URL+path, outFilePath)
+	}
+
+	ItRejects := func(category cerr.Category) {
+		It("fails with an explained "+string(category)+" error and leaves no file behind", func() {
+			err := fetch("/song.mp3")
+			Expect(err).To(HaveOccurred())
+			Expect(cerr.CategoryOf(err)).To(Equal(category))
+			Expect(cerr.ExplanationOf(err)).NotTo(BeEmpty())
+
+			_, err = os.Stat(outFilePath)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	}
+
+	It("saves an audio file", func() {
+		Expect(fetch("/song.mp3")).To(Succeed())
+
+		contents, err := os.ReadFile(outFilePath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(contents).To(Equal(mp3Contents))
+	})
+
+	It("saves a file the server doesn't know the type of, once its magic bytes show it's audio", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write(append([]byte("RIFF\x24\x08\x00\x00WAVEfmt "), make([]byte, 64)...))
+		}
+
+		Expect(fetch("/song.wav")).To(Succeed())
+	})
+
+	It("follows a few redirects", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			hops, _ := strconv.Atoi(r.URL.Query().Get("hops"))
+			if hops < 3 {
+				http.Redirect(w, r, fmt.Sprintf("/song.mp3?hops=%d", hops+1), http.StatusFound)
+				return
+			}
+
+			_, _ = w.Write(mp3Contents)
+		}
+
+		Expect(fetch("/song.mp3")).To(Succeed())
+	})
+
+	Describe("Source responds with an error", func() {
+		BeforeEach(func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				http.NotFound(w, r)
+			}
+		})
+
+		ItRejects(cerr.UserInput)
+	})
+
+	Describe("Source is a web page", func() {
+		BeforeEach(func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				_, _ = w.Write([]byte("<html><body>Listen here!</body></html>"))
+			}
+		})
+
+		ItRejects(cerr.UserInput)
+	})
+
+	Describe("Source claims to be audio but isn't", func() {
+		BeforeEach(func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "audio/mpeg")
+				_, _ = w.Write([]byte("<html><body>Listen here!</body></html>"))
+			}
+		})
+
+		ItRejects(cerr.UserInput)
+	})
+
+	Describe("Source is empty", func() {
+		BeforeEach(func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "audio/mpeg")
+			}
+		})
+
+		ItRejects(cerr.UserInput)
+	})
+
+	Describe("Source says it's too large", func() {
+		BeforeEach(func() {
+			config.MaxSize = 1024
+		})
+
+		ItRejects(cerr.UserInput)
+	})
+
+	Describe("Source turns out to be too large without saying how large it is", func() {
+		BeforeEach(func() {
+			config.MaxSize = 1024
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "audio/mpeg")
+				for start := 0; start < len(mp3Contents); start += 256 {
+					end := start + 256
+					if end > len(mp3Contents) {
+						end = len(mp3Contents)
+					}
+
+					_, _ = w.Write(mp3Contents[start:end])
+					w.(http.Flusher).Flush()
+				}
+			}
+		})
+
+		ItRejects(cerr.UserInput)
+	})
+
+	Describe("Source redirects too many times", func() {
+		BeforeEach(func() {
+			config.MaxRedirects = 2
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "/song.mp3", http.StatusFound)
+			}
+		})
+
+		ItRejects(cerr.UserInput)
+	})
+
+	Describe("Source stops sending the file", func() {
+		BeforeEach(func() {
+			config.ReadTimeout = 100 * time.Millisecond
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "audio/mpeg")
+				w.Header().Set("Content-Length", strconv.Itoa(len(mp3Contents)))
+				_, _ = w.Write(mp3Contents[:1024])
+				w.(http.Flusher).Flush()
+
+				select {
+				case <-r.Context().Done():
+				case <-time.After(5 * time.Second):
+				}
+			}
+		})
+
+		It("gives up with a transient error once it's gone quiet for the read timeout", func() {
+			started := time.Now()
+
+			err := fetch("/song.mp3")
+			Expect(err).To(HaveOccurred())
+			Expect(cerr.CategoryOf(err)).To(Equal(cerr.Transient))
+			Expect(time.Since(started)).To(BeNumerically("<", 2*time.Second))
+		})
+	})
+
+	Describe("Source drops the connection", func() {
+		var (
+			contents    []byte
+			disconnects int
+			ranges      []string
+			takesRanges bool
+			// what the source says the file's size is when it sends part of it
+			announcedTotal int
+		)
+
+		BeforeEach(func() {
+			contents = append([]byte("ID3\x03\x00"), make([]byte, 64<<10)...)
+			_, _ = rand.New(rand.NewSource(1)).Read(contents[5:])
+
+			disconnects = 2
+			ranges = []string{}
+			takesRanges = true
+			announcedTotal = len(contents)
+
+			config.Retries = download.Backoff{MaxAttempts: 4, Delays: []time.Duration{10 * time.Millisecond}}
+			config.ProgressInterval = 0
+
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				start := 0
+				w.Header().Set("Content-Type", "audio/mpeg")
+				w.Header().Set("ETag", `"v1"`)
+
+				if takesRanges {
+					w.Header().Set("Accept-Ranges", "bytes")
+
+					if r.Header.Get("Range") != "" {
+						ranges = append(ranges, r.Header.Get("Range"))
+						_, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(r.Header.Get("If-Range")).To(Equal(`"v1"`))
+
+						w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(contents)-1, announcedTotal))
+					}
+				}
+
+				remaining := contents[start:]
+				w.Header().Set("Content-Length", strconv.Itoa(len(remaining)))
+				if start > 0 {
+					w.WriteHeader(http.StatusPartialContent)
+				}
+
+				if disconnects == 0 {
+					_, _ = w.Write(remaining)
+					return
+				}
+				disconnects--
+
+				// send a third of what's left, then cut the connection off halfway through the body
+				_, _ = w.Write(remaining[:len(remaining)/3])
+				w.(http.Flusher).Flush()
+
+				conn, _, err := w.(http.Hijacker).Hijack()
+				Expect(err).NotTo(HaveOccurred())
+				_ = conn.Close()
+			}
+		})
+
+		It("picks up where it left off", func() {
+			Expect(fetch("/song.mp3")).To(Succeed())
+
+			saved, err := os.ReadFile(outFilePath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(saved).To(Equal(contents))
+
+			firstCut := len(contents) / 3
+			secondCut := firstCut + (len(contents)-firstCut)/3
+			Expect(ranges).To(Equal([]string{
+				fmt.Sprintf("bytes=%d-", firstCut),
+				fmt.Sprintf("bytes=%d-", secondCut),
+			}))
+		})
+
+		Describe("Source doesn't take range requests", func() {
+			BeforeEach(func() {
+				takesRanges = false
+			})
+
+			It("starts over each time", func() {
+				Expect(fetch("/song.mp3")).To(Succeed())
+
+				saved, err := os.ReadFile(outFilePath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(saved).To(Equal(contents))
+				Expect(ranges).To(BeEmpty())
+			})
+		})
+
+		Describe("Source keeps dropping the connection", func() {
+			BeforeEach(func() {
+				disconnects = 10
+			})
+
+			It("gives up after the last attempt with a transient error and leaves no file behind", func() {
+				err := fetch("/song.mp3")
+				Expect(err).To(HaveOccurred())
+				Expect(cerr.CategoryOf(err)).To(Equal(cerr.Transient))
+				Expect(ranges).To(HaveLen(3))
+
+				_, err = os.Stat(outFilePath)
+				Expect(os.IsNotExist(err)).To(BeTrue())
+			})
+		})
+
+		Describe("Source sends less than it said the file was", func() {
+			BeforeEach(func() {
+				disconnects = 1
+				announcedTotal = len(contents) + 100
+			})
+
+			It("doesn't accept the file", func() {
+				err := fetch("/song.mp3")
+				Expect(err).To(HaveOccurred())
+				Expect(cerr.CategoryOf(err)).To(Equal(cerr.Transient))
+
+				_, err = os.Stat(outFilePath)
+				Expect(os.IsNotExist(err)).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("Source takes too long to respond", func() {
+		BeforeEach(func() {
+			config.ReadTimeout = 100 * time.Millisecond
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(5 * time.Second):
+				}
+			}
+		})
+
+		It("gives up with a transient error", func() {
+			err := fetch("/song.mp3")
+			Expect(err).To(HaveOccurred())
+			Expect(cerr.CategoryOf(err)).To(Equal(cerr.Transient))
+		})
+	})
+})
@@ -0,0 +1,91 @@
+package download
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+)
+
+// sniffLength is how much of the start of a file is needed to recognize it
+const sniffLength = 512
+
+// mediaSignature recognizes a container or codec from the bytes it starts with
+type mediaSignature struct {
+	format string
+	offset int
+	magic  []byte
+	// the magic bytes that matter, all of them if nil
+	mask []byte
+}
+
+var mediaSignatures = []mediaSignature{
+	{format: "mp3", magic: []byte("ID3")},
+	// MPEG audio frame sync, which covers ADTS AAC too
+	{format: "mpeg", magic: []byte{0xFF, 0xE0}, mask: []byte{0xFF, 0xE0}},
+	{format: "wav", magic: []byte("RIFF\x00\x00\x00\x00WAVE"), mask: []byte("\xFF\xFF\xFF\xFF\x00\x00\x00\x00\xFF\xFF\xFF\xFF")},
+	{format: "aiff", magic: []byte("FORM\x00\x00\x00\x00AIF"), mask: []byte("\xFF\xFF\xFF\xFF\x00\x00\x00\x00\xFF\xFF\xFF")},
+	{format: "flac", magic: []byte("fLaC")},
+	{format: "ogg", magic: []byte("OggS")},
+	// MP4, M4A and QuickTime
+	{format: "mp4", offset: 4, magic: []byte("ftyp")},
+	// WebM and Matroska
+	{format: "webm", magic: []byte{0x1A, 0x45, 0xDF, 0xA3}},
+	// WMA and WMV
+	{format: "asf", magic: []byte{0x30, 0x26, 0xB2, 0x75, 0x8E, 0x66, 0xCF, 0x11}},
+	{format: "caf", magic: []byte("caff")},
+	{format: "amr", magic: []byte("#!AMR")},
+}
+
+// sniffMedia returns the format of the audio or video file that the header is the start of
+func sniffMedia(header []byte) (string, bool) {
+	for _, signature := range mediaSignatures {
+		if signature.matches(header) {
+			return signature.format, true
+		}
+	}
+
+	return "", false
+}
+
+func (s mediaSignature) matches(header []byte) bool {
+	if len(header) < s.offset+len(s.magic) {
+		return false
+	}
+
+	start := header[s.offset : s.offset+len(s.magic)]
+	if s.mask == nil {
+		return bytes.Equal(start, s.magic)
+	}
+
+	for i := range s.magic {
+		if start[i]&s.mask[i] != s.magic[i]&s.mask[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isMediaContentType lets through audio and video, and the types servers fall back on
+// when they don't know what a file is, which the file's magic bytes then have to settle
+func isMediaContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	if strings.HasPrefix(mediaType, "audio/") || strings.HasPrefix(mediaType, "video/") {
+		return true
+	}
+
+	switch mediaType {
+	case "application/octet-stream", "binary/octet-stream", "application/ogg", "application/x-ogg":
+		return true
+	default:
+		return false
+	}
+}
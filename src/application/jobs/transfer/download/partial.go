@@ -0,0 +1,158 @@
+package download
+
+import (
+	"chord-paper-be-workers/src/lib/cerr"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// partialDownload is what's been saved of the file so far, carried across attempts
+// so that a dropped connection doesn't mean starting over
+type partialDownload struct {
+	out     *os.File
+	written int64
+	// -1 until the source says how large the file is
+	total int64
+	// whether the source takes range requests, and what identifies the version of the file
+	// that was started on, so that the rest isn't taken from a different one
+	resumable bool
+	validator string
+
+	logger           log.Interface
+	progressInterval time.Duration
+	reported         time.Time
+}
+
+func newPartialDownload(out *os.File, logger log.Interface, progressInterval time.Duration) *partialDownload {
+	return &partialDownload{
+		out:              out,
+		total:            -1,
+		logger:           logger,
+		progressInterval: progressInterval,
+		reported:         time.Now(),
+	}
+}
+
+// rangeHeaders ask for the rest of the file, none when there's nothing to pick up from
+func (p *partialDownload) rangeHeaders() http.Header {
+	headers := http.Header{}
+	if p.written == 0 || !p.resumable {
+		return headers
+	}
+
+	headers.Set("Range", fmt.Sprintf("bytes=%d-", p.written))
+	if p.validator != "" {
+		headers.Set("If-Range", p.validator)
+	}
+
+	return headers
+}
+
+// resume takes the rest of the file from a partial content response
+func (p *partialDownload) resume(resp *http.Response) error {
+	start, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil || start != p.written {
+		// whatever was sent can't be lined up with what's saved, so the next attempt starts over
+		p.resumable = false
+		return cerr.Field("content_range", resp.Header.Get("Content-Range")).Field("written", p.written).
+			Categorize(cerr.Transient).Wrap(err).Error("Provided source resumed from the wrong place")
+	}
+
+	if total >= 0 {
+		p.total = total
+	}
+
+	return nil
+}
+
+// restart takes the whole file from a response, throwing away anything saved before
+func (p *partialDownload) restart(resp *http.Response) error {
+	if p.written > 0 {
+		p.logger.WithField("written", p.written).Info("Provided source can't resume, starting the download over")
+	}
+
+	if err := p.out.Truncate(0); err != nil {
+		return cerr.Categorize(cerr.Infrastructure).Wrap(err).Error("Failed to empty temp file")
+	}
+
+	if _, err := p.out.Seek(0, io.SeekStart); err != nil {
+		return cerr.Categorize(cerr.Infrastructure).Wrap(err).Error("Failed to rewind temp file")
+	}
+
+	p.written = 0
+	p.total = resp.ContentLength
+	p.resumable = resp.Header.Get("Accept-Ranges") == "bytes"
+
+	// a weak ETag can't be used to pick up a byte range
+	p.validator = resp.Header.Get("Last-Modified")
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		p.validator = etag
+	}
+
+	return nil
+}
+
+func (p *partialDownload) Write(b []byte) (int, error) {
+	n, err := p.out.Write(b)
+	p.written += int64(n)
+
+	if time.Since(p.reported) >= p.progressInterval {
+		p.reportProgress()
+	}
+
+	return n, err
+}
+
+func (p *partialDownload) reportProgress() {
+	p.reported = time.Now()
+
+	logger := p.logger.WithField("downloaded", p.written)
+	if p.total > 0 {
+		logger = logger.WithField("total", p.total).WithField("percent", p.written*100/p.total)
+	}
+
+	logger.Info("Downloading from provided source")
+}
+
+// parseContentRange reads e.g. "bytes 100-999/1000", the total is -1 when the source doesn't know it
+func parseContentRange(contentRange string) (start int64, total int64, err error) {
+	errctx := cerr.Field("content_range", contentRange)
+
+	if !strings.HasPrefix(contentRange, "bytes ") {
+		return 0, 0, errctx.Error("Content range isn't in bytes")
+	}
+	byteRange := strings.TrimPrefix(contentRange, "bytes ")
+
+	span, size, ok := strings.Cut(byteRange, "/")
+	if !ok {
+		return 0, 0, errctx.Error("Content range has no size")
+	}
+
+	first, _, ok := strings.Cut(span, "-")
+	if !ok {
+		return 0, 0, errctx.Error("Content range has no span")
+	}
+
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, errctx.Wrap(err).Error("Content range has an invalid start")
+	}
+
+	if size == "*" {
+		return start, -1, nil
+	}
+
+	total, err = strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return 0, 0, errctx.Wrap(err).Error("Content range has an invalid size")
+	}
+
+	return start, total, nil
+}
@@ -0,0 +1,116 @@
+package download
+
+import (
+	"chord-paper-be-workers/src/lib/cerr"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Backend names the downloader that a rule sends sources to
+type Backend string
+
+const (
+	BackendYoutubeDL Backend = "youtube-dl"
+	// BackendGeneric fetches the URL as it is, so it only suits links straight to a file
+	BackendGeneric Backend = "generic"
+)
+
+var backends = []Backend{BackendYoutubeDL, BackendGeneric}
+
+// Rule sends the sources it matches to a backend. A rule matches either
+// on the host, which covers its subdomains too, or on a pattern
+type Rule struct {
+	Host string
+	// matched against the host and path, e.g. cdn.example.com/songs/song.mp3, leaving out the query
+	Pattern *regexp.Regexp
+	Backend Backend
+}
+
+// DefaultRules send the sites youtube-dl knows how to pull audio from to it,
+// and links straight to audio or video files to the generic downloader
+var DefaultRules = []Rule{
+	{Host: "youtube.com", Backend: BackendYoutubeDL},
+	{Host: "youtu.be", Backend: BackendYoutubeDL},
+	{Host: "soundcloud.com", Backend: BackendYoutubeDL},
+	{Host: "bandcamp.com", Backend: BackendYoutubeDL},
+	{Host: "vimeo.com", Backend: BackendYoutubeDL},
+	{Pattern: regexp.MustCompile(`(?i)\.(mp3|wav|flac|ogg|oga|opus|m4a|aac|aiff|mp4|m4v|mov|webm)$`), Backend: BackendGeneric},
+}
+
+func (r Rule) Matches(sourceURL *url.URL) bool {
+	host := normalizeHost(sourceURL)
+
+	if r.Pattern != nil {
+		return r.Pattern.MatchString(host + sourceURL.EscapedPath())
+	}
+
+	ruleHost := strings.ToLower(r.Host)
+	return host == ruleHost || strings.HasSuffix(host, "."+ruleHost)
+}
+
+func normalizeHost(sourceURL *url.URL) string {
+	return strings.TrimSuffix(strings.ToLower(sourceURL.Hostname()), ".")
+}
+
+// ParseRules reads rules written as matcher=backend and separated by commas, e.g.
+// "youtu.be=youtube-dl,~\.mp3$=generic". A matcher starting with ~ is a pattern, anything else is a host
+func ParseRules(rulesVal string) ([]Rule, error) {
+	rules := []Rule{}
+
+	for _, entry := range strings.Split(rulesVal, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		errctx := cerr.Field("rule", entry)
+
+		// backends never have an = in them, patterns might
+		separator := strings.LastIndex(entry, "=")
+		if separator == -1 {
+			return nil, errctx.Error("Download rule has no backend")
+		}
+
+		matcher := strings.TrimSpace(entry[:separator])
+		backend := Backend(strings.TrimSpace(entry[separator+1:]))
+
+		if !isBackend(backend) {
+			return nil, errctx.Field("backends", backends).Error("Download rule has an unknown backend")
+		}
+
+		rule := Rule{Backend: backend}
+		if pattern := strings.TrimPrefix(matcher, "~"); pattern != matcher {
+			compiled, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, errctx.Wrap(err).Error("Download rule has an invalid pattern")
+			}
+
+			rule.Pattern = compiled
+		} else {
+			rule.Host = matcher
+		}
+
+		if rule.Pattern == nil && rule.Host == "" {
+			return nil, errctx.Error("Download rule has nothing to match on")
+		}
+
+		rules = append(rules, rule)
+	}
+
+	if len(rules) == 0 {
+		return nil, cerr.Error("No download rules configured")
+	}
+
+	return rules, nil
+}
+
+func isBackend(backend Backend) bool {
+	for _, b := range backends {
+		if b == backend {
+			return true
+		}
+	}
+
+	return false
+}
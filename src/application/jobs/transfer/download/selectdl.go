@@ -2,34 +2,64 @@ package download
 
 import (
 	"chord-paper-be-workers/src/lib/cerr"
+	"context"
 	"net/url"
-	"strings"
 )
 
 var _ Downloader = SelectDLer{}
 
 func NewSelectDLer(youtubedler YoutubeDLer, genericdler GenericDLer) SelectDLer {
 	return SelectDLer{
-		genericdler: genericdler,
-		youtubedler: youtubedler,
+		downloaders: map[Backend]Downloader{
+			BackendYoutubeDL: youtubedler,
+			BackendGeneric:   genericdler,
+		},
+		rules: DefaultRules,
 	}
 }
 
+// NewSelectDLerWithRules checks the rules in order and downloads with the backend of the first one that matches
+func NewSelectDLerWithRules(downloaders map[Backend]Downloader, rules []Rule) (SelectDLer, error) {
+	for _, rule := range rules {
+		if _, ok := downloaders[rule.Backend]; !ok {
+			return SelectDLer{}, cerr.Field("backend", rule.Backend).Error("No downloader for the download rule's backend")
+		}
+	}
+
+	return SelectDLer{
+		downloaders: downloaders,
+		rules:       rules,
+	}, nil
+}
+
 type SelectDLer struct {
-	genericdler GenericDLer
-	youtubedler YoutubeDLer
+	downloaders map[Backend]Downloader
+	rules       []Rule
 }
 
-func (s SelectDLer) Download(sourceURL string, outFilePath string) error {
+func (s SelectDLer) Download(ctx context.Context, sourceURL string, outFilePath string) error {
 	url, err := url.Parse(sourceURL)
 
 	if err != nil {
-		return cerr.Wrap(err).Error("Failed to parse source URL")
+		return cerr.Categorize(cerr.UserInput).Wrap(err).Error("Failed to parse source URL")
+	}
+
+	errctx := cerr.Field("source_url", sourceURL).Field("host", url.Host)
+
+	if url.Scheme != "http" && url.Scheme != "https" {
+		return errctx.Field("scheme", url.Scheme).Categorize(cerr.UserInput).
+			Explain("The link has to start with http:// or https://").
+			Error("Source URL isn't a web link")
 	}
 
-	if strings.HasSuffix(url.Host, "youtube.com") {
-		return s.youtubedler.Download(sourceURL, outFilePath)
+	for _, rule := range s.rules {
+		if rule.Matches(url) {
+			return s.downloaders[rule.Backend].Download(ctx, sourceURL, outFilePath)
+		}
 	}
 
-	return s.genericdler.Download(sourceURL, outFilePath)
+	// anything else would be fetched as a web page and saved as if it were the track
+	return errctx.Categorize(cerr.UserInput).
+		Explain("Links from this site aren't supported, try a YouTube, SoundCloud, Bandcamp or Vimeo link, or a link straight to an audio file").
+		Error("Source isn't supported")
 }
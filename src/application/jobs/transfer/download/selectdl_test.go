@@ -0,0 +1,157 @@
+package download_test
+
+import (
+	"chord-paper-be-workers/src/application/jobs/transfer/download"
+	"chord-paper-be-workers/src/lib/cerr"
+	"context"
+	"regexp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// recordingDLer remembers the sources it was asked to download
+type recordingDLer struct {
+	sourceURLs *[]string
+}
+
+func (r recordingDLer) Download(ctx context.Context, sourceURL string, outFilePath string) error {
+	*r.sourceURLs = append(*r.sourceURLs, sourceURL)
+	return nil
+}
+
+var _ = Describe("SelectDLer", func() {
+	var (
+		youtubeDLed []string
+		genericDLed []string
+
+		downloaders map[download.Backend]download.Downloader
+		rules       []download.Rule
+
+		selectdler download.SelectDLer
+	)
+
+	BeforeEach(func() {
+		youtubeDLed = []string{}
+		genericDLed = []string{}
+
+		downloaders = map[download.Backend]download.Downloader{
+			download.BackendYoutubeDL: recordingDLer{sourceURLs: &youtubeDLed},
+			download.BackendGeneric:   recordingDLer{sourceURLs: &genericDLed},
+		}
+		rules = download.DefaultRules
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		selectdler, err = download.NewSelectDLerWithRules(downloaders, rules)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	ItDownloadsWithYoutubeDL := func(sourceURL string) {
+		It("downloads "+sourceURL+" with youtube-dl", func() {
+			err := selectdler.Download(context.Background(), sourceURL, "out.mp3")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(youtubeDLed).To(Equal([]string{sourceURL}))
+			Expect(genericDLed).To(BeEmpty())
+		})
+	}
+
+	ItDownloadsWithGenericDL := func(sourceURL string) {
+		It("downloads "+sourceURL+" with the generic downloader", func() {
+			err := selectdler.Download(context.Background(), sourceURL, "out.mp3")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(genericDLed).To(Equal([]string{sourceURL}))
+			Expect(youtubeDLed).To(BeEmpty())
+		})
+	}
+
+	ItRejects := func(sourceURL string) {
+		It("rejects "+sourceURL+" without downloading it", func() {
+			err := selectdler.Download(context.Background(), sourceURL, "out.mp3")
+			Expect(err).To(HaveOccurred())
+			Expect(cerr.CategoryOf(err)).To(Equal(cerr.UserInput))
+			Expect(cerr.IsRetryable(err)).To(BeFalse())
+
+			Expect(youtubeDLed).To(BeEmpty())
+			Expect(genericDLed).To(BeEmpty())
+		})
+	}
+
+	Describe("Default rules", func() {
+		ItDownloadsWithYoutubeDL("https://www.youtube.com/watch?v=dQw4w9WgXcQ")
+		ItDownloadsWithYoutubeDL("https://music.youtube.com/watch?v=dQw4w9WgXcQ")
+		ItDownloadsWithYoutubeDL("https://youtu.be/dQw4w9WgXcQ")
+		ItDownloadsWithYoutubeDL("https://YouTube.com/watch?v=dQw4w9WgXcQ")
+		ItDownloadsWithYoutubeDL("https://soundcloud.com/artist/song")
+		ItDownloadsWithYoutubeDL("https://artist.bandcamp.com/track/song")
+		ItDownloadsWithYoutubeDL("https://vimeo.com/123456")
+
+		ItDownloadsWithGenericDL("https://cdn.example.com/songs/song.mp3")
+		ItDownloadsWithGenericDL("https://cdn.example.com/songs/song.WAV?signature=abc")
+		ItDownloadsWithGenericDL("http://example.com:8080/videos/song.mp4")
+
+		ItRejects("https://example.com/songs/song")
+		ItRejects("https://example.com/song.mp3.html")
+		ItRejects("https://notyoutube.com/watch?v=dQw4w9WgXcQ")
+		ItRejects("ftp://example.com/song.mp3")
+		ItRejects("song.mp3")
+	})
+
+	Describe("Custom rules", func() {
+		BeforeEach(func() {
+			rules = []download.Rule{
+				{Host: "media.example.com", Backend: download.BackendGeneric},
+				{Pattern: regexp.MustCompile(`^videos\.example\.com/watch/`), Backend: download.BackendYoutubeDL},
+			}
+		})
+
+		ItDownloadsWithGenericDL("https://media.example.com/stream/123")
+		ItDownloadsWithYoutubeDL("https://videos.example.com/watch/123")
+
+		ItRejects("https://www.youtube.com/watch?v=dQw4w9WgXcQ")
+		ItRejects("https://videos.example.com/embed/123")
+	})
+
+	It("refuses rules for backends it has no downloader for", func() {
+		delete(downloaders, download.BackendGeneric)
+
+		_, err := download.NewSelectDLerWithRules(downloaders, download.DefaultRules)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("ParseRules", func() {
+	It("reads hosts and patterns in order", func() {
+		rules, err := download.ParseRules(`youtu.be=youtube-dl, ~\.(mp3|wav)$=generic ,`)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(rules).To(HaveLen(2))
+		Expect(rules[0].Host).To(Equal("youtu.be"))
+		Expect(rules[0].Backend).To(Equal(download.BackendYoutubeDL))
+		Expect(rules[1].Pattern.String()).To(Equal(`\.(mp3|wav)$`))
+		Expect(rules[1].Backend).To(Equal(download.BackendGeneric))
+	})
+
+	It("keeps an = in a pattern", func() {
+		rules, err := download.ParseRules(`~id=\d+=youtube-dl`)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(rules[0].Pattern.String()).To(Equal(`id=\d+`))
+	})
+
+	ItRejects := func(description string, rulesVal string) {
+		It("rejects "+description, func() {
+			_, err := download.ParseRules(rulesVal)
+			Expect(err).To(HaveOccurred())
+		})
+	}
+
+	ItRejects("a rule without a backend", "youtube.com")
+	ItRejects("an unknown backend", "youtube.com=wget")
+	ItRejects("an invalid pattern", "~(mp3=generic")
+	ItRejects("a rule without a matcher", "=generic")
+	ItRejects("no rules at all", " , ")
+})
@@ -2,10 +2,12 @@ package download
 
 import (
 	"chord-paper-be-workers/src/application/executor"
+	"chord-paper-be-workers/src/application/metrics"
 	"chord-paper-be-workers/src/lib/cerr"
+	"chord-paper-be-workers/src/lib/logging"
+	"context"
 	"fmt"
-
-	"github.com/apex/log"
+	"time"
 )
 
 var _ Downloader = YoutubeDLer{}
@@ -22,13 +24,16 @@ type YoutubeDLer struct {
 	commandExecutor  executor.Executor
 }
 
-func (y YoutubeDLer) Download(sourceURL string, outFilePath string) error {
-	log.Info("Running youtube-dl")
+func (y YoutubeDLer) Download(ctx context.Context, sourceURL string, outFilePath string) error {
+	logging.FromContext(ctx).Info("Running youtube-dl")
 
-	cmd := y.commandExecutor.Command(y.youtubedlBinPath, "-o", outFilePath, "-x", "--audio-format", "mp3", "--audio-quality", "0", sourceURL)
+	cmd := y.commandExecutor.Command(ctx, y.youtubedlBinPath, "-o", outFilePath, "-x", "--audio-format", "mp3", "--audio-quality", "0", sourceURL)
+	started := time.Now()
 	output, err := cmd.CombinedOutput()
+	metrics.ToolFinished(metrics.ToolYoutubeDL, err, started)
 	if err != nil {
-		return cerr.Field("error_msg", string(output)).
+		return cerr.Categorize(youtubeDLErrorCategory(err, string(output))).
+			Field("error_msg", string(output)).
 			Wrap(err).
 			Error(fmt.Sprintf("Failed to run youtube-dl: %s", string(output)))
 	}
@@ -6,11 +6,14 @@ import (
 	"chord-paper-be-workers/src/application/jobs/job_message"
 	"chord-paper-be-workers/src/application/jobs/transfer"
 	"chord-paper-be-workers/src/application/tracks/entity"
+	"chord-paper-be-workers/src/lib/cerr"
 	"context"
 	"fmt"
 
 	"chord-paper-be-workers/src/application/jobs/transfer/download"
+	"chord-paper-be-workers/src/application/jobs/transfer/transcode"
 	"encoding/json"
+	"time"
 
 	. "github.com/onsi/gomega"
 
@@ -25,6 +28,7 @@ var _ = Describe("Download Job Handler", func() {
 		dummyTrackStore *dummy.TrackStore
 		dummyFileStore  *dummy.FileStore
 		dummyExecutor   *dummy.YoutubeDLExecutor
+		ffmpegExecutor  *dummy.FFmpegExecutor
 
 		handler transfer.JobHandler
 
@@ -50,6 +54,7 @@ var _ = Describe("Download Job Handler", func() {
 			dummyTrackStore = dummy.NewDummyTrackStore()
 			dummyFileStore = dummy.NewDummyFileStore()
 			dummyExecutor = dummy.NewDummyYoutubeDLExecutor()
+			ffmpegExecutor = dummy.NewDummyFFmpegExecutor()
 		})
 
 		By("Setting up the dummy track store data", func() {
@@ -71,7 +76,9 @@ var _ = Describe("Download Job Handler", func() {
 			genericDownloader := download.NewGenericDLer()
 			selectDownloader := download.NewSelectDLer(youtubeDownloader, genericDownloader)
 
-			trackDownloader, err := transfer.NewTrackTransferrer(selectDownloader, dummyTrackStore, dummyFileStore, bucketName, workingDir)
+			transcoder := transcode.NewTranscoder("/bin/ffmpeg", "/bin/ffprobe", ffmpegExecutor)
+
+			trackDownloader, err := transfer.NewTrackTransferrer(selectDownloader, transcoder, dummyTrackStore, dummyFileStore, bucketName, workingDir)
 			Expect(err).NotTo(HaveOccurred())
 
 			handler = transfer.NewJobHandler(trackDownloader)
@@ -100,7 +107,7 @@ var _ = Describe("Download Job Handler", func() {
 			var savedOriginalURL string
 
 			BeforeEach(func() {
-				jobParams, savedOriginalURL, err = handler.HandleTransferJob(message)
+				jobParams, savedOriginalURL, err = handler.HandleTransferJob(context.Background(), message)
 				expectedSavedURL = fmt.Sprintf("%s/%s/%s/%s/original/original.mp3", store.GOOGLE_STORAGE_HOST, bucketName, tracklistID, trackID)
 			})
 
@@ -119,6 +126,67 @@ var _ = Describe("Download Job Handler", func() {
 				Expect(jobParams.TrackListID).To(Equal(job.TrackListID))
 				Expect(jobParams.TrackID).To(Equal(job.TrackID))
 			})
+
+			It("records the source format on the track", func() {
+				track, err := dummyTrackStore.GetTrack(context.Background(), tracklistID, trackID)
+				Expect(err).NotTo(HaveOccurred())
+
+				splitStemTrack, ok := track.(entity.SplitStemTrack)
+				Expect(ok).To(BeTrue())
+				Expect(splitStemTrack.SourceFormat).To(Equal(entity.SourceFormat{
+					Container:  "mp3",
+					Codec:      "mp3",
+					Duration:   215250 * time.Millisecond,
+					SampleRate: 44100,
+					Channels:   2,
+				}))
+			})
+
+			It("saves an MP3 as it is", func() {
+				Expect(ffmpegExecutor.Ran("ffprobe")).To(BeTrue())
+				Expect(ffmpegExecutor.Ran("ffmpeg")).To(BeFalse())
+			})
+		})
+
+		Describe("Source is a video", func() {
+			BeforeEach(func() {
+				ffmpegExecutor.ProbeOutput = dummy.MP4VideoProbe
+			})
+
+			It("saves the audio converted from the video", func() {
+				_, savedOriginalURL, err := handler.HandleTransferJob(context.Background(), message)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ffmpegExecutor.Ran("ffmpeg")).To(BeTrue())
+
+				contents, err := dummyFileStore.GetFile(context.Background(), savedOriginalURL)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(contents).To(Equal(originalTrackData))
+
+				track, err := dummyTrackStore.GetTrack(context.Background(), tracklistID, trackID)
+				Expect(err).NotTo(HaveOccurred())
+
+				splitStemTrack, ok := track.(entity.SplitStemTrack)
+				Expect(ok).To(BeTrue())
+				Expect(splitStemTrack.SourceFormat.Codec).To(Equal("aac"))
+				Expect(splitStemTrack.SourceFormat.FromVideo).To(BeTrue())
+			})
+		})
+
+		Describe("Source has no audio", func() {
+			BeforeEach(func() {
+				ffmpegExecutor.ProbeOutput = `{"streams": [{"codec_name": "h264", "codec_type": "video"}], "format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2"}}`
+			})
+
+			It("returns an explained error without saving anything", func() {
+				_, _, err := handler.HandleTransferJob(context.Background(), message)
+				Expect(err).To(HaveOccurred())
+				Expect(cerr.CategoryOf(err)).To(Equal(cerr.UserInput))
+				Expect(cerr.ExplanationOf(err)).NotTo(BeEmpty())
+
+				exists, err := dummyFileStore.FileExists(context.Background(), transfer.OriginalURL(bucketName, tracklistID, trackID))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(exists).To(BeFalse())
+			})
 		})
 
 		Describe("Can't reach track store", func() {
@@ -127,7 +195,7 @@ var _ = Describe("Download Job Handler", func() {
 			})
 
 			It("returns an error", func() {
-				_, _, err := handler.HandleTransferJob(message)
+				_, _, err := handler.HandleTransferJob(context.Background(), message)
 				Expect(err).To(HaveOccurred())
 			})
 		})
@@ -147,7 +215,7 @@ var _ = Describe("Download Job Handler", func() {
 		})
 
 		It("returns error", func() {
-			_, _, err := handler.HandleTransferJob(message)
+			_, _, err := handler.HandleTransferJob(context.Background(), message)
 			Expect(err).To(HaveOccurred())
 		})
 	})
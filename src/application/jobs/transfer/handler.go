@@ -3,6 +3,7 @@ package transfer
 import (
 	"chord-paper-be-workers/src/application/jobs/job_message"
 	"chord-paper-be-workers/src/lib/cerr"
+	"context"
 	"encoding/json"
 )
 
@@ -17,7 +18,7 @@ type JobParams struct {
 
 //counterfeiter:generate . TransferJobHandler
 type TransferJobHandler interface {
-	HandleTransferJob(message []byte) (JobParams, string, error)
+	HandleTransferJob(ctx context.Context, message []byte) (JobParams, string, error)
 }
 
 func NewJobHandler(downloader TrackTransferrer) JobHandler {
@@ -30,15 +31,15 @@ type JobHandler struct {
 	trackDownloader TrackTransferrer
 }
 
-func (d JobHandler) HandleTransferJob(message []byte) (JobParams, string, error) {
+func (d JobHandler) HandleTransferJob(ctx context.Context, message []byte) (JobParams, string, error) {
 	params, err := unmarshalMessage(message)
 	if err != nil {
-		return JobParams{}, "", cerr.Wrap(err).Error("Failed to unmarshal message JSON")
+		return JobParams{}, "", cerr.Categorize(cerr.Permanent).Wrap(err).Error("Failed to unmarshal message JSON")
 	}
 
 	errctx := cerr.Field("params", params)
 
-	savedOriginalURL, err := d.trackDownloader.Download(params.TrackListID, params.TrackID)
+	savedOriginalURL, err := d.trackDownloader.Download(ctx, params.TrackListID, params.TrackID)
 	if err != nil {
 		return JobParams{}, "", errctx.Wrap(err).Error("Failed to download track")
 	}
@@ -50,17 +51,17 @@ func unmarshalMessage(message []byte) (JobParams, error) {
 	params := JobParams{}
 	err := json.Unmarshal(message, &params)
 	if err != nil {
-		return JobParams{}, cerr.Wrap(err).Error("Failed to unmarshal message JSON")
+		return JobParams{}, cerr.Categorize(cerr.Permanent).Wrap(err).Error("Failed to unmarshal message JSON")
 	}
 
 	errctx := cerr.Field("job_params", params)
 
 	if params.TrackListID == "" {
-		return JobParams{}, errctx.Wrap(err).Error("Missing tracklist ID")
+		return JobParams{}, errctx.Categorize(cerr.Permanent).Wrap(err).Error("Missing tracklist ID")
 	}
 
 	if params.TrackID == "" {
-		return JobParams{}, errctx.Wrap(err).Error("Missing track ID")
+		return JobParams{}, errctx.Categorize(cerr.Permanent).Wrap(err).Error("Missing track ID")
 	}
 
 	return params, nil
@@ -0,0 +1,41 @@
+package transfer
+
+import (
+	"chord-paper-be-workers/src/application/jobs/job_message"
+	"chord-paper-be-workers/src/application/metrics"
+	"chord-paper-be-workers/src/application/pipeline"
+	"context"
+)
+
+// Output is the track's saved original
+type Output struct {
+	job_message.TrackIdentifier
+	SavedOriginalURL string `json:"saved_original_url"`
+}
+
+// NewStage saves the track's original
+func NewStage() pipeline.Stage {
+	return pipeline.Stage{
+		JobType:       JobType,
+		StatusMessage: "Retrieving the original track from provided URL",
+		ErrorMessage:  ErrorMessage,
+		Weight:        20,
+		Tools:         []string{metrics.ToolYoutubeDL, metrics.ToolFFmpeg, metrics.ToolFFprobe},
+		Params:        func() interface{} { return &JobParams{} },
+	}
+}
+
+// StageHandler runs the stage's jobs on the handler
+func StageHandler(handler TransferJobHandler) pipeline.Handler {
+	return func(ctx context.Context, message []byte) (pipeline.Output, error) {
+		params, savedOriginalURL, err := handler.HandleTransferJob(ctx, message)
+		if err != nil {
+			return nil, err
+		}
+
+		return pipeline.NewOutput(Output{
+			TrackIdentifier:  params.TrackIdentifier,
+			SavedOriginalURL: savedOriginalURL,
+		})
+	}
+}
@@ -4,20 +4,23 @@ import (
 	cloudstorage "chord-paper-be-workers/src/application/cloud_storage/entity"
 	"chord-paper-be-workers/src/application/cloud_storage/store"
 	"chord-paper-be-workers/src/application/jobs/transfer/download"
+	"chord-paper-be-workers/src/application/jobs/transfer/transcode"
+	"chord-paper-be-workers/src/application/tracing"
 	"chord-paper-be-workers/src/application/tracks/entity"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 
-	"github.com/apex/log"
+	"go.opentelemetry.io/otel/attribute"
 
 	"chord-paper-be-workers/src/lib/cerr"
+	"chord-paper-be-workers/src/lib/logging"
 	"chord-paper-be-workers/src/lib/working_dir"
 	"context"
 	"fmt"
 )
 
-func NewTrackTransferrer(downloader download.SelectDLer, trackStore entity.TrackStore, fileStore cloudstorage.FileStore, bucketName string, workingDirStr string) (TrackTransferrer, error) {
+func NewTrackTransferrer(downloader download.SelectDLer, transcoder transcode.Transcoder, trackStore entity.TrackStore, fileStore cloudstorage.FileStore, bucketName string, workingDirStr string) (TrackTransferrer, error) {
 	workingDir, err := working_dir.NewWorkingDir(workingDirStr)
 	if err != nil {
 		return TrackTransferrer{}, cerr.Field("working_dir_str", workingDirStr).Wrap(err).Error("Failed to create working dir")
@@ -27,6 +30,7 @@ func NewTrackTransferrer(downloader download.SelectDLer, trackStore entity.Track
 		fileStore:  fileStore,
 		trackStore: trackStore,
 		downloader: downloader,
+		transcoder: transcoder,
 		bucketName: bucketName,
 		workingDir: workingDir,
 	}, nil
@@ -36,13 +40,14 @@ type TrackTransferrer struct {
 	fileStore  cloudstorage.FileStore
 	trackStore entity.TrackStore
 	downloader download.SelectDLer
+	transcoder transcode.Transcoder
 	bucketName string
 	workingDir working_dir.WorkingDir
 }
 
-func (t TrackTransferrer) Download(tracklistID string, trackID string) (string, error) {
+func (t TrackTransferrer) Download(ctx context.Context, tracklistID string, trackID string) (string, error) {
 	errctx := cerr.Field("tracklist_id", tracklistID).Field("track_id", trackID)
-	track, err := t.trackStore.GetTrack(context.Background(), tracklistID, trackID)
+	track, err := t.trackStore.GetTrack(ctx, tracklistID, trackID)
 	if err != nil {
 		return "", errctx.Wrap(err).Error("Failed to GetTrack")
 	}
@@ -52,42 +57,77 @@ func (t TrackTransferrer) Download(tracklistID string, trackID string) (string,
 		return "", errctx.Wrap(err).Error("Unexpected - track is not a split request")
 	}
 
-	tempFilePath, cleanUpTempDir, err := t.makeTempOutFilePath()
+	tempDir, cleanUpTempDir, err := t.makeTempDir(ctx)
 	if err != nil {
-		return "", errctx.Wrap(err).Error("Failed to make a temp file path")
+		return "", errctx.Wrap(err).Error("Failed to make a temp dir")
 	}
 
 	defer cleanUpTempDir()
 
-	err = t.downloader.Download(splitStemTrack.OriginalURL, tempFilePath)
+	// youtube-dl only keeps the name it's given when it ends in the format it converts to,
+	// whatever the generic downloader saves there is probed rather than trusted
+	sourceFilePath := filepath.Join(tempDir, "source.mp3")
+	originalFilePath := filepath.Join(tempDir, "original.mp3")
+
+	downloadCtx, span := tracing.StartSpan(ctx, "download", attribute.String("original_url", splitStemTrack.OriginalURL))
+	err = t.downloader.Download(downloadCtx, splitStemTrack.OriginalURL, sourceFilePath)
+	tracing.End(span, err)
 	if err != nil {
 		return "", errctx.Field("original_url", splitStemTrack.OriginalURL).
 			Wrap(err).Error("Failed to download track to cloud")
 	}
 
-	log.Info("Reading output file to memory")
-	fileContent, err := os.ReadFile(tempFilePath)
+	transcodeCtx, span := tracing.StartSpan(ctx, "transcode")
+	sourceFormat, err := t.transcoder.Canonicalize(transcodeCtx, sourceFilePath, originalFilePath)
+	tracing.End(span, err)
+	if err != nil {
+		return "", errctx.Wrap(err).Error("Failed to convert downloaded track")
+	}
+
+	logger := logging.FromContext(ctx)
+
+	logger.Info("Reading output file to memory")
+	fileContent, err := os.ReadFile(originalFilePath)
 	if err != nil {
-		return "", errctx.Wrap(err).Error("Failed to read outputed youtubedl mp3")
+		return "", errctx.Wrap(err).Error("Failed to read converted original")
 	}
 
 	destinationURL := t.generatePath(tracklistID, trackID)
 
-	log.Info("Writing file to remote file store")
-	err = t.fileStore.WriteFile(context.Background(), destinationURL, fileContent)
+	logger.Info("Writing file to remote file store")
+	err = t.fileStore.WriteFile(ctx, destinationURL, fileContent)
 	if err != nil {
 		return "", errctx.Wrap(err).Error("Failed to write file to the cloud")
 	}
 
+	logger.Info("Recording the source format on the track")
+	err = t.trackStore.UpdateTrack(ctx, tracklistID, trackID, func(track entity.Track) (entity.Track, error) {
+		splitStemTrack, ok := track.(entity.SplitStemTrack)
+		if !ok {
+			return nil, errctx.Categorize(cerr.Permanent).Error("Unexpected - track is not a split request")
+		}
+
+		splitStemTrack.SourceFormat = sourceFormat
+		return splitStemTrack, nil
+	})
+	if err != nil {
+		return "", errctx.Wrap(err).Error("Failed to record the source format")
+	}
+
 	return destinationURL, nil
 }
 
 func (t TrackTransferrer) generatePath(tracklistID string, trackID string) string {
-	return fmt.Sprintf("%s/%s/%s/%s/original/original.mp3", store.GOOGLE_STORAGE_HOST, t.bucketName, tracklistID, trackID)
+	return OriginalURL(t.bucketName, tracklistID, trackID)
 }
 
-func (t TrackTransferrer) makeTempOutFilePath() (string, func(), error) {
-	log.Info("Creating temp dir to store downloaded source file temporarily")
+// OriginalURL is where the transfer stage saves a track's source audio
+func OriginalURL(bucketName string, tracklistID string, trackID string) string {
+	return fmt.Sprintf("%s/%s/%s/%s/original/original.mp3", store.GOOGLE_STORAGE_HOST, bucketName, tracklistID, trackID)
+}
+
+func (t TrackTransferrer) makeTempDir(ctx context.Context) (string, func(), error) {
+	logging.FromContext(ctx).Info("Creating temp dir to store downloaded source file temporarily")
 	tempDir, err := ioutil.TempDir(t.workingDir.TempDir(), "transfer-*")
 	if err != nil {
 		return "", nil, cerr.Field("temp_dir", t.workingDir.TempDir()).
@@ -100,7 +140,5 @@ func (t TrackTransferrer) makeTempOutFilePath() (string, func(), error) {
 			Wrap(err).Error("Failed to turn temp dir into absolute format")
 	}
 
-	outputPath := filepath.Join(tempDir, "original.mp3")
-
-	return outputPath, func() { os.RemoveAll(tempDir) }, nil
+	return tempDir, func() { os.RemoveAll(tempDir) }, nil
 }
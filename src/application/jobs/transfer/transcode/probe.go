@@ -0,0 +1,90 @@
+package transcode
+
+import (
+	"bytes"
+	"chord-paper-be-workers/src/lib/cerr"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// probeOutput is the part of ffprobe's JSON output that's used
+type probeOutput struct {
+	Streams []probeStream `json:"streams"`
+	Format  probeFormat   `json:"format"`
+}
+
+type probeStream struct {
+	CodecName   string `json:"codec_name"`
+	CodecType   string `json:"codec_type"`
+	SampleRate  string `json:"sample_rate"`
+	Channels    int    `json:"channels"`
+	Disposition struct {
+		// cover art embedded in an audio file shows up as a video stream
+		AttachedPic int `json:"attached_pic"`
+	} `json:"disposition"`
+}
+
+type probeFormat struct {
+	FormatName string `json:"format_name"`
+	Duration   string `json:"duration"`
+}
+
+// Probe is what's in a media file
+type Probe struct {
+	// ffprobe's name for the container, which lists every format it could be, e.g. "mov,mp4,m4a,3gp,3g2,mj2"
+	Container  string
+	AudioCodec string
+	Duration   time.Duration
+	SampleRate int
+	Channels   int
+	HasVideo   bool
+}
+
+func parseProbe(output []byte) (Probe, error) {
+	// anything ffprobe logs ends up around the JSON, since the executor combines stdout and stderr
+	start := bytes.IndexByte(output, '{')
+	end := bytes.LastIndexByte(output, '}')
+	if start == -1 || end < start {
+		return Probe{}, cerr.Field("output", string(output)).Error("ffprobe output has no JSON in it")
+	}
+
+	parsed := probeOutput{}
+	if err := json.Unmarshal(output[start:end+1], &parsed); err != nil {
+		return Probe{}, cerr.Field("output", string(output)).Wrap(err).Error("Failed to parse ffprobe output")
+	}
+
+	probe := Probe{
+		Container: parsed.Format.FormatName,
+	}
+
+	if seconds, err := strconv.ParseFloat(parsed.Format.Duration, 64); err == nil {
+		probe.Duration = time.Duration(seconds * float64(time.Second))
+	}
+
+	foundAudio := false
+	for _, stream := range parsed.Streams {
+		switch stream.CodecType {
+		case "audio":
+			if foundAudio {
+				continue
+			}
+
+			foundAudio = true
+			probe.AudioCodec = stream.CodecName
+			probe.Channels = stream.Channels
+			probe.SampleRate, _ = strconv.Atoi(stream.SampleRate)
+
+		case "video":
+			if stream.Disposition.AttachedPic == 0 {
+				probe.HasVideo = true
+			}
+		}
+	}
+
+	return probe, nil
+}
+
+func (p Probe) HasAudio() bool {
+	return p.AudioCodec != ""
+}
@@ -0,0 +1,13 @@
+package transcode_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestTranscode(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Transcode Suite")
+}
@@ -0,0 +1,150 @@
+package transcode
+
+import (
+	"chord-paper-be-workers/src/application/executor"
+	"chord-paper-be-workers/src/application/metrics"
+	"chord-paper-be-workers/src/application/tracks/entity"
+	"chord-paper-be-workers/src/lib/cerr"
+	"chord-paper-be-workers/src/lib/logging"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// CanonicalSampleRate and CanonicalChannels are what spleeter works in, so converting to them up front costs nothing
+const (
+	CanonicalSampleRate = 44100
+	CanonicalChannels   = 2
+)
+
+func NewTranscoder(ffmpegBinPath string, ffprobeBinPath string, commandExecutor executor.Executor) Transcoder {
+	return Transcoder{
+		ffmpegBinPath:   ffmpegBinPath,
+		ffprobeBinPath:  ffprobeBinPath,
+		commandExecutor: commandExecutor,
+	}
+}
+
+// Transcoder turns whatever was downloaded into an MP3, which is what originals are saved as
+// and what the rest of the pipeline expects
+type Transcoder struct {
+	ffmpegBinPath   string
+	ffprobeBinPath  string
+	commandExecutor executor.Executor
+}
+
+func (t Transcoder) Probe(ctx context.Context, filePath string) (Probe, error) {
+	cmd := t.commandExecutor.Command(ctx, t.ffprobeBinPath, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", filePath)
+	started := time.Now()
+	output, err := cmd.CombinedOutput()
+	metrics.ToolFinished(metrics.ToolFFprobe, err, started)
+	if err != nil {
+		return Probe{}, cerr.Categorize(toolErrorCategory(ctx, err)).
+			Explain("The file couldn't be read as audio or video").
+			Field("error_msg", string(output)).
+			Wrap(err).Error(fmt.Sprintf("Failed to run ffprobe: %s", string(output)))
+	}
+
+	probe, err := parseProbe(output)
+	if err != nil {
+		return Probe{}, cerr.Categorize(cerr.Permanent).Wrap(err).Error("Failed to understand ffprobe output")
+	}
+
+	return probe, nil
+}
+
+// Canonicalize saves the audio of the source file as an MP3 at the out path, converting it only if it isn't
+// one at the canonical sample rate and channels already, and returns what the source was.
+//
+// Lossless sources (FLAC, WAV) are encoded to MP3 too, on purpose: the original is saved and played back as
+// original.mp3, the split and every later stage read that one file, and spleeter's models only separate
+// frequencies well below what V0 (-q:a 0) keeps, so a lossless original would cost several times the storage
+// and transfer for stems that sound the same
+func (t Transcoder) Canonicalize(ctx context.Context, sourcePath string, outPath string) (entity.SourceFormat, error) {
+	probe, err := t.Probe(ctx, sourcePath)
+	if err != nil {
+		return entity.SourceFormat{}, err
+	}
+
+	sourceFormat := entity.SourceFormat{
+		Container:  probe.Container,
+		Codec:      probe.AudioCodec,
+		Duration:   probe.Duration,
+		SampleRate: probe.SampleRate,
+		Channels:   probe.Channels,
+		FromVideo:  probe.HasVideo,
+	}
+
+	logger := logging.FromContext(ctx).WithFields(log.Fields{
+		"container":   sourceFormat.Container,
+		"codec":       sourceFormat.Codec,
+		"duration":    sourceFormat.Duration.String(),
+		"sample_rate": sourceFormat.SampleRate,
+		"channels":    sourceFormat.Channels,
+		"from_video":  sourceFormat.FromVideo,
+	})
+
+	if !probe.HasAudio() {
+		return entity.SourceFormat{}, cerr.Field("container", probe.Container).Categorize(cerr.UserInput).
+			Explain("The file has no audio in it").
+			Error("Source has no audio stream")
+	}
+
+	// converting an MP3 that's already canonical again would only lose quality
+	if probe.Container == "mp3" && probe.AudioCodec == "mp3" && !probe.HasVideo &&
+		probe.SampleRate == CanonicalSampleRate && probe.Channels == CanonicalChannels {
+		logger.Info("Source is already an MP3")
+
+		if err := os.Rename(sourcePath, outPath); err != nil {
+			return entity.SourceFormat{}, cerr.Categorize(cerr.Infrastructure).Wrap(err).Error("Failed to move source file")
+		}
+
+		return sourceFormat, nil
+	}
+
+	logger.Info("Converting source to MP3")
+
+	cmd := t.commandExecutor.Command(ctx, t.ffmpegBinPath,
+		"-nostdin", "-y", "-v", "error",
+		"-i", sourcePath,
+		// only the first audio stream, which leaves out any video
+		"-map", "0:a:0",
+		"-codec:a", "libmp3lame", "-q:a", "0",
+		"-ar", fmt.Sprint(CanonicalSampleRate), "-ac", fmt.Sprint(CanonicalChannels),
+		outPath)
+	started := time.Now()
+	output, err := cmd.CombinedOutput()
+	metrics.ToolFinished(metrics.ToolFFmpeg, err, started)
+	if err != nil {
+		return entity.SourceFormat{}, cerr.Categorize(toolErrorCategory(ctx, err)).
+			Explain("The audio in the file couldn't be converted for splitting").
+			Field("error_msg", string(output)).
+			Wrap(err).Error(fmt.Sprintf("Failed to run ffmpeg: %s", string(output)))
+	}
+
+	return sourceFormat, nil
+}
+
+// toolErrorCategory blames a binary that can't be run on us,
+// and a binary that ran and gave up on the file it was given, unless it was stopped
+func toolErrorCategory(ctx context.Context, err error) cerr.Category {
+	if ctx.Err() != nil {
+		return cerr.Uncategorized
+	}
+
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+		return cerr.Infrastructure
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return cerr.UserInput
+	}
+
+	return cerr.Uncategorized
+}
@@ -0,0 +1,184 @@
+package transcode_test
+
+import (
+	"chord-paper-be-workers/src/application/integration_test/dummy"
+	"chord-paper-be-workers/src/application/jobs/transfer/transcode"
+	"chord-paper-be-workers/src/lib/cerr"
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Transcoder", func() {
+	var (
+		ffmpegExecutor *dummy.FFmpegExecutor
+		transcoder     transcode.Transcoder
+
+		sourceContents []byte
+		sourcePath     string
+		outPath        string
+	)
+
+	BeforeEach(func() {
+		ffmpegExecutor = dummy.NewDummyFFmpegExecutor()
+		transcoder = transcode.NewTranscoder("/usr/bin/ffmpeg", "/usr/bin/ffprobe", ffmpegExecutor)
+
+		tempDir, err := os.MkdirTemp("", "transcode")
+		Expect(err).NotTo(HaveOccurred())
+
+		sourceContents = []byte("cool_jamz")
+		sourcePath = filepath.Join(tempDir, "source.mp3")
+		outPath = filepath.Join(tempDir, "original.mp3")
+		Expect(os.WriteFile(sourcePath, sourceContents, os.ModePerm)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		_ = os.RemoveAll(filepath.Dir(sourcePath))
+	})
+
+	Describe("Probe", func() {
+		It("reads the container and first audio stream", func() {
+			probe, err := transcoder.Probe(context.Background(), sourcePath)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(probe).To(Equal(transcode.Probe{
+				Container:  "mp3",
+				AudioCodec: "mp3",
+				Duration:   215250 * time.Millisecond,
+				SampleRate: 44100,
+				Channels:   2,
+			}))
+			Expect(ffmpegExecutor.Commands).To(Equal([][]string{
+				{"ffprobe", "-v", "error", "-print_format", "json", "-show_format", "-show_streams", sourcePath},
+			}))
+		})
+
+		It("doesn't count cover art as video", func() {
+			ffmpegExecutor.ProbeOutput = `{
+				"streams": [
+					{"codec_name": "alac", "codec_type": "audio", "sample_rate": "96000", "channels": 1},
+					{"codec_name": "mjpeg", "codec_type": "video", "disposition": {"attached_pic": 1}}
+				],
+				"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "N/A"}
+			}`
+
+			probe, err := transcoder.Probe(context.Background(), sourcePath)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(probe.HasVideo).To(BeFalse())
+			Expect(probe.AudioCodec).To(Equal("alac"))
+			Expect(probe.SampleRate).To(Equal(96000))
+			Expect(probe.Channels).To(Equal(1))
+			Expect(probe.Duration).To(BeZero())
+		})
+
+		It("finds the JSON among anything ffprobe logged", func() {
+			ffmpegExecutor.ProbeOutput = "[mp3 @ 0x55] Estimating duration from bitrate\n" + dummy.MP3Probe
+
+			probe, err := transcoder.Probe(context.Background(), sourcePath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(probe.AudioCodec).To(Equal("mp3"))
+		})
+
+		It("fails permanently on output it can't understand", func() {
+			ffmpegExecutor.ProbeOutput = "Segmentation fault"
+
+			_, err := transcoder.Probe(context.Background(), sourcePath)
+			Expect(err).To(HaveOccurred())
+			Expect(cerr.CategoryOf(err)).To(Equal(cerr.Permanent))
+		})
+
+		It("leaves failures to run ffprobe to be retried", func() {
+			ffmpegExecutor.Unavailable = true
+
+			_, err := transcoder.Probe(context.Background(), sourcePath)
+			Expect(err).To(HaveOccurred())
+			Expect(cerr.IsRetryable(err)).To(BeTrue())
+		})
+	})
+
+	Describe("Canonicalize", func() {
+		It("keeps an MP3 as it is", func() {
+			format, err := transcoder.Canonicalize(context.Background(), sourcePath, outPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(format.Container).To(Equal("mp3"))
+			Expect(format.FromVideo).To(BeFalse())
+			Expect(ffmpegExecutor.Ran("ffmpeg")).To(BeFalse())
+
+			contents, err := os.ReadFile(outPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(contents).To(Equal(sourceContents))
+		})
+
+		It("converts an MP3 that isn't at the canonical sample rate and channels", func() {
+			ffmpegExecutor.ProbeOutput = `{
+				"streams": [{"codec_name": "mp3", "codec_type": "audio", "sample_rate": "22050", "channels": 1}],
+				"format": {"format_name": "mp3", "duration": "10.000000"}
+			}`
+
+			format, err := transcoder.Canonicalize(context.Background(), sourcePath, outPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(format.SampleRate).To(Equal(22050))
+			Expect(format.Channels).To(Equal(1))
+			Expect(ffmpegExecutor.Commands).To(ContainElement(ContainElements("-ar", "44100", "-ac", "2", outPath)))
+		})
+
+		It("takes the audio out of a video and converts it to MP3", func() {
+			ffmpegExecutor.ProbeOutput = dummy.MP4VideoProbe
+
+			format, err := transcoder.Canonicalize(context.Background(), sourcePath, outPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(format.Container).To(Equal("mov,mp4,m4a,3gp,3g2,mj2"))
+			Expect(format.Codec).To(Equal("aac"))
+			Expect(format.SampleRate).To(Equal(48000))
+			Expect(format.Duration).To(Equal(183500 * time.Millisecond))
+			Expect(format.FromVideo).To(BeTrue())
+
+			Expect(ffmpegExecutor.Commands).To(ContainElement([]string{
+				"ffmpeg", "-nostdin", "-y", "-v", "error",
+				"-i", sourcePath,
+				"-map", "0:a:0",
+				"-codec:a", "libmp3lame", "-q:a", "0",
+				"-ar", "44100", "-ac", "2",
+				outPath,
+			}))
+
+			_, err = os.Stat(outPath)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("converts audio that isn't an MP3", func() {
+			ffmpegExecutor.ProbeOutput = `{
+				"streams": [{"codec_name": "pcm_s16le", "codec_type": "audio", "sample_rate": "44100", "channels": 2}],
+				"format": {"format_name": "wav", "duration": "10.000000"}
+			}`
+
+			format, err := transcoder.Canonicalize(context.Background(), sourcePath, outPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(format.Container).To(Equal("wav"))
+			Expect(format.Codec).To(Equal("pcm_s16le"))
+			Expect(ffmpegExecutor.Ran("ffmpeg")).To(BeTrue())
+		})
+
+		It("rejects a file without any audio", func() {
+			ffmpegExecutor.ProbeOutput = `{
+				"streams": [{"codec_name": "h264", "codec_type": "video"}],
+				"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "10.000000"}
+			}`
+
+			_, err := transcoder.Canonicalize(context.Background(), sourcePath, outPath)
+			Expect(err).To(HaveOccurred())
+			Expect(cerr.CategoryOf(err)).To(Equal(cerr.UserInput))
+			Expect(cerr.ExplanationOf(err)).NotTo(BeEmpty())
+			Expect(ffmpegExecutor.Ran("ffmpeg")).To(BeFalse())
+		})
+	})
+})
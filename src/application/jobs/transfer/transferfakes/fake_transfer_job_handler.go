@@ -3,14 +3,16 @@ package transferfakes
 
 import (
 	"chord-paper-be-workers/src/application/jobs/transfer"
+	"context"
 	"sync"
 )
 
 type FakeTransferJobHandler struct {
-	HandleTransferJobStub        func([]byte) (transfer.JobParams, string, error)
+	HandleTransferJobStub        func(context.Context, []byte) (transfer.JobParams, string, error)
 	handleTransferJobMutex       sync.RWMutex
 	handleTransferJobArgsForCall []struct {
-		arg1 []byte
+		arg1 context.Context
+		arg2 []byte
 	}
 	handleTransferJobReturns struct {
 		result1 transfer.JobParams
@@ -26,23 +28,24 @@ type FakeTransferJobHandler struct {
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeTransferJobHandler) HandleTransferJob(arg1 []byte) (transfer.JobParams, string, error) {
-	var arg1Copy []byte
-	if arg1 != nil {
-		arg1Copy = make([]byte, len(arg1))
-		copy(arg1Copy, arg1)
+func (fake *FakeTransferJobHandler) HandleTransferJob(arg1 context.Context, arg2 []byte) (transfer.JobParams, string, error) {
+	var arg2Copy []byte
+	if arg2 != nil {
+		arg2Copy = make([]byte, len(arg2))
+		copy(arg2Copy, arg2)
 	}
 	fake.handleTransferJobMutex.Lock()
 	ret, specificReturn := fake.handleTransferJobReturnsOnCall[len(fake.handleTransferJobArgsForCall)]
 	fake.handleTransferJobArgsForCall = append(fake.handleTransferJobArgsForCall, struct {
-		arg1 []byte
-	}{arg1Copy})
+		arg1 context.Context
+		arg2 []byte
+	}{arg1, arg2Copy})
 	stub := fake.HandleTransferJobStub
 	fakeReturns := fake.handleTransferJobReturns
-	fake.recordInvocation("HandleTransferJob", []interface{}{arg1Copy})
+	fake.recordInvocation("HandleTransferJob", []interface{}{arg1, arg2Copy})
 	fake.handleTransferJobMutex.Unlock()
 	if stub != nil {
-		return stub(arg1)
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2, ret.result3
@@ -56,17 +59,17 @@ func (fake *FakeTransferJobHandler) HandleTransferJobCallCount() int {
 	return len(fake.handleTransferJobArgsForCall)
 }
 
-func (fake *FakeTransferJobHandler) HandleTransferJobCalls(stub func([]byte) (transfer.JobParams, string, error)) {
+func (fake *FakeTransferJobHandler) HandleTransferJobCalls(stub func(context.Context, []byte) (transfer.JobParams, string, error)) {
 	fake.handleTransferJobMutex.Lock()
 	defer fake.handleTransferJobMutex.Unlock()
 	fake.HandleTransferJobStub = stub
 }
 
-func (fake *FakeTransferJobHandler) HandleTransferJobArgsForCall(i int) []byte {
+func (fake *FakeTransferJobHandler) HandleTransferJobArgsForCall(i int) (context.Context, []byte) {
 	fake.handleTransferJobMutex.RLock()
 	defer fake.handleTransferJobMutex.RUnlock()
 	argsForCall := fake.handleTransferJobArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakeTransferJobHandler) HandleTransferJobReturns(result1 transfer.JobParams, result2 string, result3 error) {
@@ -0,0 +1,116 @@
+package lease
+
+import (
+	"chord-paper-be-workers/src/application/jobs/job_message"
+	"chord-paper-be-workers/src/application/retry"
+	"chord-paper-be-workers/src/application/tracks/entity"
+	"chord-paper-be-workers/src/lib/cerr"
+	"chord-paper-be-workers/src/lib/logging"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/streadway/amqp"
+)
+
+func NewKeeper(trackStore entity.TrackStore, duration time.Duration) Keeper {
+	return Keeper{
+		trackStore: trackStore,
+		duration:   duration,
+	}
+}
+
+// Keeper records which job is working on a track, renewing the lease well before
+// it runs out so that only a dead worker leaves an expired lease behind
+type Keeper struct {
+	trackStore entity.TrackStore
+	duration   time.Duration
+}
+
+// Acquire takes the lease on the job's track and keeps renewing it until the heartbeat is stopped.
+// Failing to take the lease doesn't stop the job, the track just can't be rescued by the watchdog
+func (k Keeper) Acquire(ctx context.Context, message amqp.Delivery) *Heartbeat {
+	heartbeat := &Heartbeat{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	var trackParams job_message.TrackIdentifier
+	if err := json.Unmarshal(message.Body, &trackParams); err != nil {
+		close(heartbeat.done)
+		return heartbeat
+	}
+
+	logger := logging.FromContext(ctx).WithFields(log.Fields{
+		"tracklist_id": trackParams.TrackListID,
+		"track_id":     trackParams.TrackID,
+		"job_type":     message.Type,
+	})
+
+	lease := entity.JobLease{
+		JobType:   message.Type,
+		MessageID: message.MessageId,
+		Body:      message.Body,
+		Attempt:   retry.Attempt(message),
+	}
+
+	renew := func() error {
+		lease.ExpiresAt = time.Now().Add(k.duration)
+		return k.trackStore.SetJobLease(ctx, trackParams.TrackListID, trackParams.TrackID, lease)
+	}
+
+	if err := renew(); err != nil {
+		cerr.LogContext(ctx, cerr.Field("job_type", message.Type).Wrap(err).Error("Failed to acquire job lease"))
+	}
+
+	go func() {
+		defer close(heartbeat.done)
+
+		ticker := time.NewTicker(k.duration / 4)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-heartbeat.stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := renew(); err != nil {
+					logger.WithError(err).Warn("Failed to renew job lease")
+				}
+			}
+		}
+	}()
+
+	return heartbeat
+}
+
+// Release gives up the lease, for jobs that end without writing the track themselves
+func (k Keeper) Release(ctx context.Context, tracklistID string, trackID string) error {
+	err := k.trackStore.SetJobLease(ctx, tracklistID, trackID, entity.JobLease{})
+	if err != nil {
+		return cerr.Field("tracklist_id", tracklistID).
+			Field("track_id", trackID).
+			Wrap(err).Error("Failed to release job lease")
+	}
+
+	return nil
+}
+
+type Heartbeat struct {
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// Stop waits for any renewal in progress, so that nothing renews the lease after it is released
+func (h *Heartbeat) Stop() {
+	h.stopOnce.Do(func() {
+		close(h.stop)
+	})
+
+	<-h.done
+}
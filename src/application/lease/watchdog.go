@@ -0,0 +1,165 @@
+package lease
+
+import (
+	"chord-paper-be-workers/src/application/debuglog"
+	"chord-paper-be-workers/src/application/publish"
+	"chord-paper-be-workers/src/application/retry"
+	"chord-paper-be-workers/src/application/tracks/entity"
+	"chord-paper-be-workers/src/lib/cerr"
+	"chord-paper-be-workers/src/lib/logging"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/streadway/amqp"
+)
+
+const StuckErrorMessage = "Processing stopped unexpectedly, please try again"
+
+func NewWatchdog(trackStore entity.TrackStore, publisher publish.Publisher, policies retry.Policies, leaseDuration time.Duration, interval time.Duration) Watchdog {
+	return Watchdog{
+		trackStore:    trackStore,
+		publisher:     publisher,
+		policies:      policies,
+		leaseDuration: leaseDuration,
+		interval:      interval,
+	}
+}
+
+// Watchdog looks for tracks whose job stopped heartbeating, e.g. because its worker was OOM killed.
+// Jobs with attempts left are enqueued again, otherwise the track is marked as errored
+type Watchdog struct {
+	trackStore    entity.TrackStore
+	publisher     publish.Publisher
+	policies      retry.Policies
+	leaseDuration time.Duration
+	interval      time.Duration
+}
+
+// Start checks for expired leases every interval until the stop context is done
+func (w Watchdog) Start(stop context.Context) {
+	log.WithField("interval", w.interval).Info("Starting stuck job watchdog")
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop.Done():
+			return
+		case <-ticker.C:
+			if err := w.CheckExpired(stop); err != nil {
+				cerr.Log(cerr.Wrap(err).Error("Failed to check for stuck jobs"))
+			}
+		}
+	}
+}
+
+func (w Watchdog) CheckExpired(ctx context.Context) error {
+	now := time.Now()
+
+	visitor := func(tracklistID string, trackID string, track entity.Track) error {
+		splitStemTrack, ok := track.(entity.SplitStemTrack)
+		if !ok || !splitStemTrack.JobLease.IsExpired(now) {
+			return nil
+		}
+
+		trackCtx := logging.WithFields(ctx, log.Fields{
+			"tracklist_id": tracklistID,
+			"track_id":     trackID,
+		})
+
+		// one stuck track shouldn't hold up the others
+		if err := w.rescue(trackCtx, tracklistID, trackID, splitStemTrack.JobLease); err != nil {
+			cerr.LogContext(trackCtx, err)
+		}
+
+		return nil
+	}
+
+	if err := w.trackStore.ScanTracks(ctx, visitor); err != nil {
+		return cerr.Wrap(err).Error("Failed to scan tracks for expired leases")
+	}
+
+	return nil
+}
+
+func (w Watchdog) rescue(ctx context.Context, tracklistID string, trackID string, expired entity.JobLease) error {
+	errctx := cerr.Field("tracklist_id", tracklistID).
+		Field("track_id", trackID).
+		Field("job_type", expired.JobType).
+		Field("attempt", expired.Attempt)
+
+	logger := logging.FromContext(ctx).WithFields(log.Fields{
+		"job_type": expired.JobType,
+		"attempt":  expired.Attempt,
+	})
+
+	finalAttempt := expired.Attempt >= w.policies.For(expired.JobType).MaxAttempts
+
+	// held on behalf of the enqueued job until it picks the lease up itself,
+	// if publishing fails below this expires again and we get another go.
+	// On the final attempt it's only held until the track is marked as errored
+	claimed := expired
+	claimed.ExpiresAt = time.Now().Add(w.leaseDuration)
+	if !finalAttempt {
+		claimed.Attempt++
+	}
+
+	// conditional, so that when two watchdogs find the same expired lease only one of them rescues the job,
+	// this also fails if the job came back to life in the meantime
+	if err := w.trackStore.ClaimJobLease(ctx, tracklistID, trackID, expired, claimed); err != nil {
+		return errctx.Wrap(err).Error("Failed to claim expired job lease")
+	}
+
+	if finalAttempt {
+		if err := w.markStuck(ctx, tracklistID, trackID, expired); err != nil {
+			return errctx.Wrap(err).Error("Failed to mark track with expired job lease as errored")
+		}
+
+		logger.Warn("Job lease expired on its final attempt, marked track as errored")
+		return nil
+	}
+
+	// the original message ID is kept, if the job was only slow and does finish,
+	// this copy is skipped as a duplicate
+	err := w.publisher.Publish(ctx, amqp.Publishing{
+		Headers: amqp.Table{
+			retry.AttemptHeader: int32(claimed.Attempt),
+		},
+		MessageId: expired.MessageID,
+		Type:      expired.JobType,
+		Body:      expired.Body,
+	})
+
+	if err != nil {
+		return errctx.Wrap(err).Error("Failed to enqueue the stuck job again")
+	}
+
+	logger.Warn("Job lease expired, enqueued the job again")
+	return nil
+}
+
+// markStuck errors the track whose job ran out of attempts, only once its lease is claimed so nobody else does the same
+func (w Watchdog) markStuck(ctx context.Context, tracklistID string, trackID string, expired entity.JobLease) error {
+	updater := func(track entity.Track) (entity.Track, error) {
+		splitStemTrack, ok := track.(entity.SplitStemTrack)
+		if !ok {
+			return entity.BaseTrack{}, cerr.Error("Track from DB is not a split stem track")
+		}
+
+		splitStemTrack.JobStatus = entity.ErrorStatus
+		splitStemTrack.JobStatusMessage = StuckErrorMessage
+		splitStemTrack.JobStatusDebugLog = debuglog.Describe(debuglog.Job{
+			JobType:   expired.JobType,
+			MessageID: expired.MessageID,
+			Attempt:   expired.Attempt,
+		}, cerr.Field("lease_expired_at", expired.ExpiresAt.Format(time.RFC3339)).
+			Error(fmt.Sprintf("The %s job stopped heartbeating, its worker most likely died", expired.JobType)))
+		splitStemTrack.JobLease = entity.JobLease{}
+		return splitStemTrack, nil
+	}
+
+	return w.trackStore.UpdateTrack(ctx, tracklistID, trackID, updater)
+}
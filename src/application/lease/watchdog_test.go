@@ -0,0 +1,198 @@
+package lease_test
+
+import (
+	"chord-paper-be-workers/src/application/integration_test/dummy"
+	"chord-paper-be-workers/src/application/jobs/split"
+	"chord-paper-be-workers/src/application/lease"
+	"chord-paper-be-workers/src/application/retry"
+	"chord-paper-be-workers/src/application/tracks/entity"
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// staleReads keeps handing out the track as it was when it was made, like to watchdogs that all read it
+// before any of them rescued its job. Updates write over whatever is stored, the DynamoDB store's reads
+// and writes aren't atomic either
+type staleReads struct {
+	*dummy.TrackStore
+	tracklistID string
+	trackID     string
+	track       entity.Track
+}
+
+func (s staleReads) ScanTracks(_ context.Context, visitor entity.TrackVisitor) error {
+	return visitor(s.tracklistID, s.trackID, s.track)
+}
+
+func (s staleReads) UpdateTrack(ctx context.Context, tracklistID string, trackID string, updater entity.TrackUpdater) error {
+	updatedTrack, err := updater(s.track)
+	if err != nil {
+		return err
+	}
+
+	return s.SetTrack(ctx, tracklistID, trackID, updatedTrack)
+}
+
+var _ = Describe("Watchdog", func() {
+	var (
+		tracklistID string
+		trackID     string
+
+		trackStore *dummy.TrackStore
+		rabbitMQ   *dummy.RabbitMQ
+		policies   retry.Policies
+		jobLease   entity.JobLease
+
+		watchdog lease.Watchdog
+
+		getTrack = func() entity.SplitStemTrack {
+			track, err := trackStore.GetTrack(context.Background(), tracklistID, trackID)
+			Expect(err).NotTo(HaveOccurred())
+
+			splitStemTrack, ok := track.(entity.SplitStemTrack)
+			Expect(ok).To(BeTrue())
+
+			return splitStemTrack
+		}
+	)
+
+	BeforeEach(func() {
+		tracklistID = "tracklist-id"
+		trackID = "track-id"
+
+		trackStore = dummy.NewDummyTrackStore()
+		rabbitMQ = dummy.NewRabbitMQ()
+		policies = retry.Policies{
+			Default: retry.Policy{MaxAttempts: 2},
+		}
+
+		jobLease = entity.JobLease{
+			JobType:   split.JobType,
+			MessageID: "split-message-id",
+			Body:      []byte("{}"),
+			Attempt:   1,
+			ExpiresAt: time.Now().Add(-time.Minute),
+		}
+	})
+
+	JustBeforeEach(func() {
+		track := entity.SplitStemTrack{
+			BaseTrack: entity.BaseTrack{
+				TrackType: entity.SplitFourStemsType,
+			},
+			JobStatus: entity.ProcessingStatus,
+			JobLease:  jobLease,
+		}
+
+		err := trackStore.SetTrack(context.Background(), tracklistID, trackID, track)
+		Expect(err).NotTo(HaveOccurred())
+
+		watchdog = lease.NewWatchdog(trackStore, rabbitMQ, policies, time.Minute, time.Minute)
+	})
+
+	Describe("When the lease is still live", func() {
+		BeforeEach(func() {
+			jobLease.ExpiresAt = time.Now().Add(time.Minute)
+		})
+
+		It("leaves the track alone", func() {
+			err := watchdog.CheckExpired(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(rabbitMQ.MessageChannel).To(BeEmpty())
+			Expect(getTrack().JobStatus).To(Equal(entity.ProcessingStatus))
+		})
+	})
+
+	Describe("When the lease expired with attempts left", func() {
+		It("enqueues the job again as its next attempt", func() {
+			err := watchdog.CheckExpired(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(rabbitMQ.MessageChannel).To(HaveLen(1))
+			job := <-rabbitMQ.MessageChannel
+			Expect(job.Type).To(Equal(split.JobType))
+			Expect(job.MessageId).To(Equal("split-message-id"))
+			Expect(retry.Attempt(job)).To(Equal(2))
+		})
+
+		It("only enqueues the job once when another watchdog found it too", func() {
+			stale := staleReads{TrackStore: trackStore, tracklistID: tracklistID, trackID: trackID, track: getTrack()}
+			otherWatchdog := lease.NewWatchdog(stale, rabbitMQ, policies, time.Minute, time.Minute)
+			watchdog = lease.NewWatchdog(stale, rabbitMQ, policies, time.Minute, time.Minute)
+
+			Expect(watchdog.CheckExpired(context.Background())).To(Succeed())
+			Expect(otherWatchdog.CheckExpired(context.Background())).To(Succeed())
+
+			Expect(rabbitMQ.MessageChannel).To(HaveLen(1))
+			Expect(getTrack().JobLease.Attempt).To(Equal(2))
+		})
+
+		It("leaves the job alone when it heartbeats before the lease is claimed", func() {
+			stale := staleReads{TrackStore: trackStore, tracklistID: tracklistID, trackID: trackID, track: getTrack()}
+			watchdog = lease.NewWatchdog(stale, rabbitMQ, policies, time.Minute, time.Minute)
+
+			heartbeat := jobLease
+			heartbeat.ExpiresAt = time.Now().Add(time.Minute)
+			Expect(trackStore.SetJobLease(context.Background(), tracklistID, trackID, heartbeat)).To(Succeed())
+
+			Expect(watchdog.CheckExpired(context.Background())).To(Succeed())
+
+			Expect(rabbitMQ.MessageChannel).To(BeEmpty())
+			Expect(getTrack().JobLease.Attempt).To(Equal(1))
+		})
+
+		It("holds the lease for the enqueued job", func() {
+			err := watchdog.CheckExpired(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+
+			track := getTrack()
+			Expect(track.JobLease.IsExpired(time.Now())).To(BeFalse())
+			Expect(track.JobLease.Attempt).To(Equal(2))
+			Expect(track.JobStatus).To(Equal(entity.ProcessingStatus))
+		})
+	})
+
+	Describe("When the lease expired on the final attempt", func() {
+		BeforeEach(func() {
+			jobLease.Attempt = 2
+		})
+
+		It("marks the track as errored", func() {
+			err := watchdog.CheckExpired(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+
+			track := getTrack()
+			Expect(track.JobStatus).To(Equal(entity.ErrorStatus))
+			Expect(track.JobStatusMessage).To(Equal(lease.StuckErrorMessage))
+			Expect(track.JobStatusDebugLog).To(ContainSubstring(split.JobType))
+			Expect(track.JobLease.IsHeld()).To(BeFalse())
+		})
+
+		It("only marks the track as errored once when another watchdog found it too", func() {
+			stale := staleReads{TrackStore: trackStore, tracklistID: tracklistID, trackID: trackID, track: getTrack()}
+			watchdog = lease.NewWatchdog(stale, rabbitMQ, policies, time.Minute, time.Minute)
+			Expect(watchdog.CheckExpired(context.Background())).To(Succeed())
+
+			// the track is picked up again after being marked, e.g. by an admin retry
+			retried := getTrack()
+			retried.JobStatus = entity.ProcessingStatus
+			Expect(trackStore.SetTrack(context.Background(), tracklistID, trackID, retried)).To(Succeed())
+
+			otherWatchdog := lease.NewWatchdog(stale, rabbitMQ, policies, time.Minute, time.Minute)
+			Expect(otherWatchdog.CheckExpired(context.Background())).To(Succeed())
+
+			Expect(getTrack().JobStatus).To(Equal(entity.ProcessingStatus))
+		})
+
+		It("doesn't enqueue the job again", func() {
+			err := watchdog.CheckExpired(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(rabbitMQ.MessageChannel).To(BeEmpty())
+		})
+	})
+})
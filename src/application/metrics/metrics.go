@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// label values are kept to small fixed sets, never IDs or URLs
+const (
+	OutcomeSucceeded   = "succeeded"
+	OutcomeFailed      = "failed"
+	OutcomeInterrupted = "interrupted"
+
+	ToolSpleeter  = "spleeter"
+	ToolYoutubeDL = "youtube-dl"
+	ToolFFmpeg    = "ffmpeg"
+	ToolFFprobe   = "ffprobe"
+
+	DirectionRead    = "read"
+	DirectionWritten = "written"
+)
+
+const namespace = "chord_paper_workers"
+
+// spleeter runs take minutes, so the buckets go well past the prometheus defaults
+var longBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200}
+
+var (
+	jobsHandled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_handled_total",
+		Help:      "Jobs picked up by the router.",
+	}, []string{"job_type"})
+
+	jobsSucceeded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_succeeded_total",
+		Help:      "Jobs that finished successfully.",
+	}, []string{"job_type"})
+
+	jobsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_failed_total",
+		Help:      "Jobs that failed or were interrupted.",
+	}, []string{"job_type", "outcome"})
+
+	jobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_duration_seconds",
+		Help:      "How long jobs take, by outcome.",
+		Buckets:   longBuckets,
+	}, []string{"job_type", "outcome"})
+
+	toolDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tool_duration_seconds",
+		Help:      "How long the external tools we shell out to take, by outcome.",
+		Buckets:   longBuckets,
+	}, []string{"tool", "outcome"})
+
+	storageBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "file_store_bytes_total",
+		Help:      "Bytes read from and written to the file store.",
+	}, []string{"direction"})
+
+	trackStoreDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "track_store_call_duration_seconds",
+		Help:      "Latency of track store calls.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+)
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+func JobHandled(jobType string) {
+	jobsHandled.WithLabelValues(jobType).Inc()
+}
+
+// JobFinished records the job's outcome and how long it took since it started
+func JobFinished(jobType string, outcome string, started time.Time) {
+	if outcome == OutcomeSucceeded {
+		jobsSucceeded.WithLabelValues(jobType).Inc()
+	} else {
+		jobsFailed.WithLabelValues(jobType, outcome).Inc()
+	}
+
+	jobDuration.WithLabelValues(jobType, outcome).Observe(time.Since(started).Seconds())
+}
+
+func ToolFinished(tool string, err error, started time.Time) {
+	toolDuration.WithLabelValues(tool, outcomeOf(err)).Observe(time.Since(started).Seconds())
+}
+
+func StorageTransferred(direction string, bytes int) {
+	storageBytes.WithLabelValues(direction).Add(float64(bytes))
+}
+
+// TrackStoreCall is meant to be deferred at the top of the call, e.g.
+// defer metrics.TrackStoreCall("get_track", time.Now())
+func TrackStoreCall(operation string, started time.Time) {
+	trackStoreDuration.WithLabelValues(operation).Observe(time.Since(started).Seconds())
+}
+
+func outcomeOf(err error) string {
+	if err != nil {
+		return OutcomeFailed
+	}
+
+	return OutcomeSucceeded
+}
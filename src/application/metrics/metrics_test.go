@@ -0,0 +1,51 @@
+package metrics_test
+
+import (
+	"chord-paper-be-workers/src/application/metrics"
+	"chord-paper-be-workers/src/lib/cerr"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+	scrape := func() string {
+		recorder := httptest.NewRecorder()
+		metrics.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		return recorder.Body.String()
+	}
+
+	It("exposes job outcomes by job type", func() {
+		metrics.JobHandled("split_track")
+		metrics.JobFinished("split_track", metrics.OutcomeSucceeded, time.Now())
+		metrics.JobFinished("split_track", metrics.OutcomeFailed, time.Now())
+
+		body := scrape()
+		Expect(body).To(ContainSubstring(`chord_paper_workers_jobs_handled_total{job_type="split_track"} 1`))
+		Expect(body).To(ContainSubstring(`chord_paper_workers_jobs_succeeded_total{job_type="split_track"} 1`))
+		Expect(body).To(ContainSubstring(`chord_paper_workers_jobs_failed_total{job_type="split_track",outcome="failed"} 1`))
+		Expect(body).To(ContainSubstring(`chord_paper_workers_job_duration_seconds_count{job_type="split_track",outcome="succeeded"} 1`))
+	})
+
+	It("exposes tool runs by outcome", func() {
+		metrics.ToolFinished(metrics.ToolSpleeter, cerr.Error("spleeter fell over"), time.Now())
+
+		Expect(scrape()).To(ContainSubstring(`chord_paper_workers_tool_duration_seconds_count{outcome="failed",tool="spleeter"} 1`))
+	})
+
+	It("exposes file store bytes", func() {
+		metrics.StorageTransferred(metrics.DirectionWritten, 512)
+
+		Expect(scrape()).To(ContainSubstring(`chord_paper_workers_file_store_bytes_total{direction="written"} 512`))
+	})
+
+	It("exposes track store latency by operation", func() {
+		metrics.TrackStoreCall("get_track", time.Now())
+
+		Expect(scrape()).To(ContainSubstring(`chord_paper_workers_track_store_call_duration_seconds_count{operation="get_track"} 1`))
+	})
+})
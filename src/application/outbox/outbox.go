@@ -0,0 +1,211 @@
+package outbox
+
+import (
+	"chord-paper-be-workers/src/application/publish"
+	"chord-paper-be-workers/src/application/tracks/entity"
+	"chord-paper-be-workers/src/lib/cerr"
+	"chord-paper-be-workers/src/lib/ids"
+	"context"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/streadway/amqp"
+)
+
+// NewMessage turns a next job into an outbox entry, to be saved on the track
+// in the same update that moves the track's progress along.
+// The entry keeps the message's ID, so relayed copies can be deduplicated
+func NewMessage(msg amqp.Publishing) entity.OutboxMessage {
+	id := msg.MessageId
+	if id == "" {
+		id = ids.New()
+	}
+
+	// only string headers survive, which covers the trace context
+	headers := map[string]string{}
+	for key, value := range msg.Headers {
+		if stringValue, ok := value.(string); ok {
+			headers[key] = stringValue
+		}
+	}
+
+	return entity.OutboxMessage{
+		ID:        id,
+		JobType:   msg.Type,
+		Headers:   headers,
+		Body:      msg.Body,
+		CreatedAt: time.Now(),
+	}
+}
+
+func toPublishing(message entity.OutboxMessage) amqp.Publishing {
+	headers := amqp.Table{}
+	for key, value := range message.Headers {
+		headers[key] = value
+	}
+
+	return amqp.Publishing{
+		Headers:   headers,
+		MessageId: message.ID,
+		Type:      message.JobType,
+		Body:      message.Body,
+	}
+}
+
+func NewOutbox(trackStore entity.TrackStore, publisher publish.Publisher) Outbox {
+	return Outbox{
+		trackStore: trackStore,
+		publisher:  publisher,
+	}
+}
+
+// Outbox publishes the next jobs that were saved on a track, and removes them once they are published.
+// A message can be published more than once, e.g. when removing it fails, so jobs have to cope with duplicates
+type Outbox struct {
+	trackStore entity.TrackStore
+	publisher  publish.Publisher
+}
+
+// Flush publishes every message in the track's outbox
+func (o Outbox) Flush(ctx context.Context, tracklistID string, trackID string) error {
+	return o.flush(ctx, tracklistID, trackID, time.Now())
+}
+
+// flush only publishes the messages created before the cutoff
+func (o Outbox) flush(ctx context.Context, tracklistID string, trackID string, cutoff time.Time) error {
+	errctx := cerr.Field("tracklist_id", tracklistID).Field("track_id", trackID)
+
+	track, err := o.trackStore.GetTrack(ctx, tracklistID, trackID)
+	if err != nil {
+		return errctx.Wrap(err).Error("Failed to get track")
+	}
+
+	splitStemTrack, ok := track.(entity.SplitStemTrack)
+	if !ok {
+		// the track has finished processing, there's nothing left to hand off
+		return nil
+	}
+
+	published := map[string]bool{}
+	var publishErr error
+	for _, message := range splitStemTrack.Outbox {
+		if message.CreatedAt.After(cutoff) {
+			continue
+		}
+
+		if publishErr = o.publisher.Publish(ctx, toPublishing(message)); publishErr != nil {
+			publishErr = errctx.Field("outbox_message_id", message.ID).
+				Wrap(publishErr).Error("Failed to publish outbox message")
+			break
+		}
+
+		published[message.ID] = true
+	}
+
+	if len(published) > 0 {
+		if err := o.remove(ctx, tracklistID, trackID, published); err != nil {
+			return errctx.Wrap(err).Error("Failed to remove published messages from the outbox")
+		}
+	}
+
+	return publishErr
+}
+
+func (o Outbox) remove(ctx context.Context, tracklistID string, trackID string, messageIDs map[string]bool) error {
+	updater := func(track entity.Track) (entity.Track, error) {
+		splitStemTrack, ok := track.(entity.SplitStemTrack)
+		if !ok {
+			return track, nil
+		}
+
+		remaining := []entity.OutboxMessage{}
+		for _, message := range splitStemTrack.Outbox {
+			if !messageIDs[message.ID] {
+				remaining = append(remaining, message)
+			}
+		}
+
+		splitStemTrack.Outbox = remaining
+		return splitStemTrack, nil
+	}
+
+	return o.trackStore.UpdateTrack(ctx, tracklistID, trackID, updater)
+}
+
+func NewRelay(outbox Outbox, trackStore entity.TrackStore, interval time.Duration, gracePeriod time.Duration) Relay {
+	return Relay{
+		outbox:      outbox,
+		trackStore:  trackStore,
+		interval:    interval,
+		gracePeriod: gracePeriod,
+	}
+}
+
+// Relay picks up the outbox messages that were never published,
+// e.g. because the worker died right after updating the track
+type Relay struct {
+	outbox     Outbox
+	trackStore entity.TrackStore
+	interval   time.Duration
+	// messages younger than this are left alone, the job that wrote them is most likely still publishing them
+	gracePeriod time.Duration
+}
+
+// Start relays pending messages every interval until the stop context is done
+func (r Relay) Start(stop context.Context) {
+	log.WithField("interval", r.interval).Info("Starting outbox relay")
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop.Done():
+			return
+		case <-ticker.C:
+			if err := r.RelayPending(stop); err != nil {
+				cerr.Log(cerr.Wrap(err).Error("Failed to relay pending outbox messages"))
+			}
+		}
+	}
+}
+
+// RelayPending publishes every outbox message older than the grace period
+func (r Relay) RelayPending(ctx context.Context) error {
+	cutoff := time.Now().Add(-r.gracePeriod)
+
+	visitor := func(tracklistID string, trackID string, track entity.Track) error {
+		splitStemTrack, ok := track.(entity.SplitStemTrack)
+		if !ok || !hasMessagesBefore(splitStemTrack.Outbox, cutoff) {
+			return nil
+		}
+
+		log.WithFields(log.Fields{
+			"tracklist_id": tracklistID,
+			"track_id":     trackID,
+		}).Warn("Relaying outbox messages that were not published")
+
+		// one stuck track shouldn't hold up the others
+		if err := r.outbox.flush(ctx, tracklistID, trackID, cutoff); err != nil {
+			cerr.Log(err)
+		}
+
+		return nil
+	}
+
+	if err := r.trackStore.ScanTracks(ctx, visitor); err != nil {
+		return cerr.Wrap(err).Error("Failed to scan tracks for outbox messages")
+	}
+
+	return nil
+}
+
+func hasMessagesBefore(outbox []entity.OutboxMessage, cutoff time.Time) bool {
+	for _, message := range outbox {
+		if !message.CreatedAt.After(cutoff) {
+			return true
+		}
+	}
+
+	return false
+}
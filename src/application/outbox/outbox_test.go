@@ -0,0 +1,105 @@
+package outbox_test
+
+import (
+	"chord-paper-be-workers/src/application/integration_test/dummy"
+	"chord-paper-be-workers/src/application/jobs/split"
+	"chord-paper-be-workers/src/application/outbox"
+	"chord-paper-be-workers/src/application/tracks/entity"
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Relay", func() {
+	var (
+		tracklistID string
+		trackID     string
+
+		trackStore *dummy.TrackStore
+		rabbitMQ   *dummy.RabbitMQ
+
+		relay outbox.Relay
+
+		getOutbox = func() []entity.OutboxMessage {
+			track, err := trackStore.GetTrack(context.Background(), tracklistID, trackID)
+			Expect(err).NotTo(HaveOccurred())
+
+			splitStemTrack, ok := track.(entity.SplitStemTrack)
+			Expect(ok).To(BeTrue())
+
+			return splitStemTrack.Outbox
+		}
+	)
+
+	BeforeEach(func() {
+		tracklistID = "tracklist-id"
+		trackID = "track-id"
+
+		trackStore = dummy.NewDummyTrackStore()
+		rabbitMQ = dummy.NewRabbitMQ()
+
+		relay = outbox.NewRelay(outbox.NewOutbox(trackStore, rabbitMQ), trackStore, time.Minute, time.Minute)
+	})
+
+	Describe("With a message left over from a crashed job", func() {
+		BeforeEach(func() {
+			track := entity.SplitStemTrack{
+				BaseTrack: entity.BaseTrack{
+					TrackType: entity.SplitFourStemsType,
+				},
+				JobStatus: entity.ProcessingStatus,
+				Outbox: []entity.OutboxMessage{
+					{
+						ID:        "stale",
+						JobType:   split.JobType,
+						Body:      []byte("{}"),
+						CreatedAt: time.Now().Add(-time.Hour),
+					},
+					{
+						ID:        "fresh",
+						JobType:   split.JobType,
+						Body:      []byte("{}"),
+						CreatedAt: time.Now(),
+					},
+				},
+			}
+
+			err := trackStore.SetTrack(context.Background(), tracklistID, trackID, track)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("publishes the stale message", func() {
+			err := relay.RelayPending(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(rabbitMQ.MessageChannel).To(HaveLen(1))
+			published := <-rabbitMQ.MessageChannel
+			Expect(published.Type).To(Equal(split.JobType))
+			Expect(published.MessageId).To(Equal("stale"))
+		})
+
+		It("leaves the message that is still being published alone", func() {
+			err := relay.RelayPending(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+
+			remaining := getOutbox()
+			Expect(remaining).To(HaveLen(1))
+			Expect(remaining[0].ID).To(Equal("fresh"))
+		})
+
+		Describe("When publishing fails", func() {
+			BeforeEach(func() {
+				rabbitMQ.Unavailable = true
+			})
+
+			It("keeps the message for the next run", func() {
+				err := relay.RelayPending(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(getOutbox()).To(HaveLen(2))
+			})
+		})
+	})
+})
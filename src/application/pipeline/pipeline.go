@@ -0,0 +1,236 @@
+package pipeline
+
+import (
+	"bytes"
+	"chord-paper-be-workers/src/lib/cerr"
+	"context"
+	"encoding/json"
+)
+
+// Handler runs a stage's job from its message body, and returns what it produced
+type Handler func(ctx context.Context, message []byte) (Output, error)
+
+// Output is what a stage's job produced, as JSON. The pipeline hands it on without looking into it,
+// the stages after it decode their job params from it
+type Output json.RawMessage
+
+// NewOutput encodes what a stage's job produced, for the stages after it
+func NewOutput(output interface{}) (Output, error) {
+	encoded, err := json.Marshal(output)
+	if err != nil {
+		return nil, cerr.Wrap(err).Error("Failed to encode stage output")
+	}
+
+	return encoded, nil
+}
+
+// Params makes a stage's empty job params, for its params to be decoded into
+type Params func() interface{}
+
+// Stage is one step of processing a track, run by the job of its job type
+type Stage struct {
+	JobType string
+	// what the user sees while the stage's job is waiting or running
+	StatusMessage string
+	// what the user sees when the stage fails, for failures that are down to them or their track
+	ErrorMessage string
+	// the stage's share of the work, the progress shown moves on by it once the stage is done
+	Weight int
+	// the stages that are handed off to when this one is done, none for the last stage
+	Next []string
+	// nil for a stage that can't be given params, neither by the stage before it nor by hand
+	Params Params
+	// the tools the stage runs, whose versions go into the job history
+	Tools []string
+	// nil in deployments that don't run the stage
+	Handler Handler
+}
+
+// WithHandler is the stage for a deployment that runs it
+func (s Stage) WithHandler(handler Handler) Stage {
+	s.Handler = handler
+	return s
+}
+
+// ParamsFrom decodes the stage's job params from the output of the stage before it,
+// fields that only the stage before it knows about are left out
+func (s Stage) ParamsFrom(previous Output) (interface{}, error) {
+	errctx := cerr.Field("job_type", s.JobType)
+
+	if s.Params == nil {
+		return nil, errctx.Categorize(cerr.Permanent).Error("Stage doesn't take params")
+	}
+
+	params := s.Params()
+	if err := json.Unmarshal(previous, params); err != nil {
+		return nil, errctx.Categorize(cerr.Permanent).Wrap(err).
+			Error("Failed to decode job params from the output of the stage before it")
+	}
+
+	return params, nil
+}
+
+// ParseParams decodes job params given by hand, and rejects the fields the stage doesn't know about
+func (s Stage) ParseParams(rawParams []byte) (interface{}, error) {
+	errctx := cerr.Field("job_type", s.JobType)
+
+	if s.Params == nil {
+		return nil, errctx.Error("Stage doesn't take params")
+	}
+
+	params := s.Params()
+	decoder := json.NewDecoder(bytes.NewReader(rawParams))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(params); err != nil {
+		return nil, errctx.Wrap(err).Error("Params don't match the stage's job")
+	}
+
+	return params, nil
+}
+
+func (s Stage) IsLast() bool {
+	return len(s.Next) == 0
+}
+
+// Chain hands each stage off to the one after it, in the order given
+func Chain(stages ...Stage) []Stage {
+	chained := make([]Stage, len(stages))
+	for i, stage := range stages {
+		if i+1 < len(stages) {
+			stage.Next = []string{stages[i+1].JobType}
+		}
+
+		chained[i] = stage
+	}
+
+	return chained
+}
+
+// Registry holds the stages of the pipeline. Every stage follows at most one other,
+// so that there's only one way to get to it and its progress is always the same
+type Registry struct {
+	// in the order they were registered
+	jobTypes []string
+	stages   map[string]Stage
+	progress map[string]int
+	// the stage each stage follows
+	previous map[string]string
+}
+
+func NewRegistry(stages ...Stage) (Registry, error) {
+	registry := Registry{
+		jobTypes: []string{},
+		stages:   map[string]Stage{},
+		progress: map[string]int{},
+	}
+
+	for _, stage := range stages {
+		errctx := cerr.Field("job_type", stage.JobType)
+
+		if stage.JobType == "" {
+			return Registry{}, cerr.Error("Stage has no job type")
+		}
+
+		if _, ok := registry.stages[stage.JobType]; ok {
+			return Registry{}, errctx.Error("Stage is registered twice")
+		}
+
+		if stage.Weight < 0 {
+			return Registry{}, errctx.Field("weight", stage.Weight).Error("Stage weight can't be negative")
+		}
+
+		registry.jobTypes = append(registry.jobTypes, stage.JobType)
+		registry.stages[stage.JobType] = stage
+	}
+
+	previous := map[string]string{}
+	for _, jobType := range registry.jobTypes {
+		for _, next := range registry.stages[jobType].Next {
+			errctx := cerr.Field("job_type", jobType).Field("next", next)
+
+			if _, ok := registry.stages[next]; !ok {
+				return Registry{}, errctx.Error("Next stage isn't registered")
+			}
+
+			if registry.stages[next].Params == nil {
+				return Registry{}, errctx.Error("Next stage can't take the output of the stage before it")
+			}
+
+			if other, ok := previous[next]; ok {
+				return Registry{}, errctx.Field("other_job_type", other).Error("Stage follows more than one stage")
+			}
+
+			previous[next] = jobType
+		}
+	}
+
+	if err := registry.calculateProgress(previous); err != nil {
+		return Registry{}, err
+	}
+
+	registry.previous = previous
+
+	return registry, nil
+}
+
+// calculateProgress gives each stage the share of the work done by the stages before it,
+// out of the work along the longest way through the pipeline
+func (r *Registry) calculateProgress(previous map[string]string) error {
+	workBefore := map[string]int{}
+	totalWork := 0
+
+	for _, jobType := range r.jobTypes {
+		work := 0
+		visited := map[string]bool{jobType: true}
+
+		for current, ok := previous[jobType]; ok; current, ok = previous[current] {
+			if visited[current] {
+				return cerr.Field("job_type", jobType).Error("Stages go round in a loop")
+			}
+
+			visited[current] = true
+			work += r.stages[current].Weight
+		}
+
+		workBefore[jobType] = work
+
+		if done := work + r.stages[jobType].Weight; done > totalWork {
+			totalWork = done
+		}
+	}
+
+	if totalWork == 0 {
+		return cerr.Error("Stages have no weight between them")
+	}
+
+	for jobType, work := range workBefore {
+		r.progress[jobType] = work * 100 / totalWork
+	}
+
+	return nil
+}
+
+func (r Registry) Stage(jobType string) (Stage, bool) {
+	stage, ok := r.stages[jobType]
+	return stage, ok
+}
+
+// Previous is the stage that hands off to the stage, if it follows one
+func (r Registry) Previous(jobType string) (Stage, bool) {
+	previous, ok := r.previous[jobType]
+	if !ok {
+		return Stage{}, false
+	}
+
+	return r.stages[previous], true
+}
+
+// JobTypes are the job types of all the stages, in the order they were registered
+func (r Registry) JobTypes() []string {
+	return append([]string{}, r.jobTypes...)
+}
+
+// Progress is the percentage shown once the stage's job has been handed off to
+func (r Registry) Progress(jobType string) int {
+	return r.progress[jobType]
+}
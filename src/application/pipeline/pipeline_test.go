@@ -0,0 +1,187 @@
+package pipeline_test
+
+import (
+	"chord-paper-be-workers/src/application/jobs/job_message"
+	"chord-paper-be-workers/src/application/jobs/save_stems_to_db"
+	"chord-paper-be-workers/src/application/jobs/split"
+	"chord-paper-be-workers/src/application/jobs/start"
+	"chord-paper-be-workers/src/application/jobs/transfer"
+	"chord-paper-be-workers/src/application/pipeline"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registry", func() {
+	// the params of test stages that follow another
+	var params = func() interface{} {
+		return &job_message.TrackIdentifier{}
+	}
+
+	It("gives each stage of the track pipeline the progress of the stages before it", func() {
+		registry, err := pipeline.NewRegistry(pipeline.Chain(
+			start.NewStage(),
+			transfer.NewStage(),
+			split.NewStage(),
+			save_stems_to_db.NewStage(),
+		)...)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(registry.JobTypes()).To(Equal([]string{start.JobType, transfer.JobType, split.JobType, save_stems_to_db.JobType}))
+		Expect(registry.Progress(start.JobType)).To(Equal(0))
+		Expect(registry.Progress(transfer.JobType)).To(Equal(10))
+		Expect(registry.Progress(split.JobType)).To(Equal(30))
+		Expect(registry.Progress(save_stems_to_db.JobType)).To(Equal(90))
+	})
+
+	It("measures progress along the longest way through the pipeline", func() {
+		registry, err := pipeline.NewRegistry(
+			pipeline.Stage{JobType: "a", Weight: 10, Next: []string{"b", "c"}},
+			pipeline.Stage{JobType: "b", Weight: 10, Params: params},
+			pipeline.Stage{JobType: "c", Weight: 30, Params: params},
+		)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(registry.Progress("b")).To(Equal(25))
+		Expect(registry.Progress("c")).To(Equal(25))
+	})
+
+	It("knows which stage is last", func() {
+		registry, err := pipeline.NewRegistry(
+			pipeline.Stage{JobType: "a", Weight: 1, Next: []string{"b"}},
+			pipeline.Stage{JobType: "b", Weight: 1, Params: params},
+		)
+		Expect(err).NotTo(HaveOccurred())
+
+		a, ok := registry.Stage("a")
+		Expect(ok).To(BeTrue())
+		Expect(a.IsLast()).To(BeFalse())
+
+		b, ok := registry.Stage("b")
+		Expect(ok).To(BeTrue())
+		Expect(b.IsLast()).To(BeTrue())
+
+		_, ok = registry.Stage("c")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("knows which stage each stage follows", func() {
+		registry, err := pipeline.NewRegistry(
+			pipeline.Stage{JobType: "a", Weight: 1, Next: []string{"b"}},
+			pipeline.Stage{JobType: "b", Weight: 1, Params: params},
+		)
+		Expect(err).NotTo(HaveOccurred())
+
+		previous, ok := registry.Previous("b")
+		Expect(ok).To(BeTrue())
+		Expect(previous.JobType).To(Equal("a"))
+
+		_, ok = registry.Previous("a")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("chains stages in the order they're given", func() {
+		stages := pipeline.Chain(
+			pipeline.Stage{JobType: "a", Weight: 1},
+			pipeline.Stage{JobType: "b", Weight: 1, Params: params},
+			pipeline.Stage{JobType: "c", Weight: 1, Params: params},
+		)
+
+		Expect(stages[0].Next).To(Equal([]string{"b"}))
+		Expect(stages[1].Next).To(Equal([]string{"c"}))
+		Expect(stages[2].IsLast()).To(BeTrue())
+	})
+
+	Describe("Rejecting pipelines that don't add up", func() {
+		var ItRejects = func(description string, stages ...pipeline.Stage) {
+			It("rejects "+description, func() {
+				_, err := pipeline.NewRegistry(stages...)
+				Expect(err).To(HaveOccurred())
+			})
+		}
+
+		ItRejects("a stage without a job type",
+			pipeline.Stage{Weight: 1})
+
+		ItRejects("a stage registered twice",
+			pipeline.Stage{JobType: "a", Weight: 1},
+			pipeline.Stage{JobType: "a", Weight: 1})
+
+		ItRejects("a negative weight",
+			pipeline.Stage{JobType: "a", Weight: -1})
+
+		ItRejects("a next stage that isn't registered",
+			pipeline.Stage{JobType: "a", Weight: 1, Next: []string{"b"}})
+
+		ItRejects("a next stage that can't take the output of the stage before it",
+			pipeline.Stage{JobType: "a", Weight: 1, Next: []string{"b"}},
+			pipeline.Stage{JobType: "b", Weight: 1})
+
+		ItRejects("a stage following two others",
+			pipeline.Stage{JobType: "a", Weight: 1, Next: []string{"c"}},
+			pipeline.Stage{JobType: "b", Weight: 1, Next: []string{"c"}},
+			pipeline.Stage{JobType: "c", Weight: 1, Params: params})
+
+		ItRejects("a loop",
+			pipeline.Stage{JobType: "a", Weight: 1, Next: []string{"b"}, Params: params},
+			pipeline.Stage{JobType: "b", Weight: 1, Next: []string{"a"}, Params: params})
+
+		ItRejects("stages without any weight",
+			pipeline.Stage{JobType: "a"})
+	})
+})
+
+var _ = Describe("Stage", func() {
+	var stage = split.NewStage()
+
+	Describe("Taking the output of the stage before it", func() {
+		It("decodes its params from it", func() {
+			output, err := pipeline.NewOutput(transfer.Output{
+				TrackIdentifier:  job_message.TrackIdentifier{TrackListID: "tracklist-id", TrackID: "track-id"},
+				SavedOriginalURL: "https://storage/original.mp3",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			params, err := stage.ParamsFrom(output)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(params).To(Equal(&split.JobParams{
+				TrackIdentifier:  job_message.TrackIdentifier{TrackListID: "tracklist-id", TrackID: "track-id"},
+				SavedOriginalURL: "https://storage/original.mp3",
+			}))
+		})
+
+		It("leaves out what only the stage before it knows about", func() {
+			params, err := stage.ParamsFrom(pipeline.Output(`{"track_id":"track-id","source_format":"webm"}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(params).To(Equal(&split.JobParams{
+				TrackIdentifier: job_message.TrackIdentifier{TrackID: "track-id"},
+			}))
+		})
+
+		It("fails for output that isn't JSON", func() {
+			_, err := stage.ParamsFrom(pipeline.Output("not json"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Taking params given by hand", func() {
+		It("decodes them", func() {
+			params, err := stage.ParseParams([]byte(`{"tracklist_id":"tracklist-id","track_id":"track-id","saved_original_url":"https://storage/original.mp3"}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(params).To(Equal(&split.JobParams{
+				TrackIdentifier:  job_message.TrackIdentifier{TrackListID: "tracklist-id", TrackID: "track-id"},
+				SavedOriginalURL: "https://storage/original.mp3",
+			}))
+		})
+
+		It("rejects fields the stage doesn't know about", func() {
+			_, err := stage.ParseParams([]byte(`{"track_id":"track-id","stem_urls":{}}`))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects params for a stage that doesn't take any", func() {
+			_, err := pipeline.Stage{JobType: "a"}.ParseParams([]byte(`{}`))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
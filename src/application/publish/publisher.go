@@ -1,39 +1,228 @@
 package publish
 
 import (
+	"chord-paper-be-workers/src/application/rabbitmq"
 	"chord-paper-be-workers/src/lib/cerr"
+	"chord-paper-be-workers/src/lib/logging"
+	"context"
+	"errors"
+	"sync"
+	"time"
 
 	"github.com/streadway/amqp"
 )
 
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -generate
 
-var _ Publisher = RabbitMQPublisher{}
+const defaultConfirmTimeout = 10 * time.Second
+
+var _ Publisher = &RabbitMQPublisher{}
 
 //counterfeiter:generate . Publisher
 type Publisher interface {
-	Publish(msg amqp.Publishing) error
+	Publish(ctx context.Context, msg amqp.Publishing) error
 }
 
-func NewRabbitMQPublisher(conn *amqp.Connection, queueName string) (RabbitMQPublisher, error) {
-	channel, err := conn.Channel()
-	if err != nil {
-		return RabbitMQPublisher{}, cerr.Wrap(err).Error("Failed to create rabbit channel")
+//counterfeiter:generate . Channel
+
+// Channel is the part of an *amqp.Channel that publishing uses
+type Channel interface {
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	NotifyReturn(c chan amqp.Return) chan amqp.Return
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Close() error
+}
+
+// ChannelOpener hands out a channel to publish on,
+// it is called again whenever the previous channel dies
+type ChannelOpener func(ctx context.Context) (Channel, error)
+
+func NewRabbitMQPublisher(conn *rabbitmq.Connection, queueName string) *RabbitMQPublisher {
+	return NewRabbitMQPublisherWithQueueArgs(conn, queueName, nil)
+}
+
+// NewRabbitMQPublisherWithQueueArgs publishes to a queue that needs extra arguments
+// when declared, e.g. a TTL and dead letter target for delayed retries
+func NewRabbitMQPublisherWithQueueArgs(conn *rabbitmq.Connection, queueName string, queueArgs amqp.Table) *RabbitMQPublisher {
+	openChannel := func(ctx context.Context) (Channel, error) {
+		channel, err := conn.Channel(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return channel, nil
 	}
 
-	return RabbitMQPublisher{
-		channel:   channel,
-		queueName: queueName,
-	}, nil
+	return NewRabbitMQPublisherWithOpener(openChannel, queueName, queueArgs)
+}
+
+// NewRabbitMQPublisherWithOpener publishes on the channels the opener hands out
+func NewRabbitMQPublisherWithOpener(openChannel ChannelOpener, queueName string, queueArgs amqp.Table) *RabbitMQPublisher {
+	return &RabbitMQPublisher{
+		openChannel:    openChannel,
+		queueName:      queueName,
+		queueArgs:      queueArgs,
+		confirmTimeout: defaultConfirmTimeout,
+	}
 }
 
+// RabbitMQPublisher puts its channel in confirm mode, Publish only succeeds once the broker
+// has taken responsibility for the message. Unroutable messages come back as errors
 type RabbitMQPublisher struct {
-	channel   *amqp.Channel
-	queueName string
+	openChannel    ChannelOpener
+	queueName      string
+	queueArgs      amqp.Table
+	confirmTimeout time.Duration
+
+	// held for the whole publish, so that confirmations line up with what was published
+	mutex   sync.Mutex
+	channel *confirmChannel
+}
+
+type confirmChannel struct {
+	channel  Channel
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
 }
 
-func (r RabbitMQPublisher) Publish(msg amqp.Publishing) error {
+func (r *RabbitMQPublisher) Publish(ctx context.Context, msg amqp.Publishing) error {
 	msg.ContentType = "application/json"
 	msg.DeliveryMode = amqp.Persistent
-	return r.channel.Publish("", r.queueName, true, false, msg)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	err := r.publishAndConfirm(ctx, msg)
+	if !errors.Is(err, amqp.ErrClosed) {
+		return err
+	}
+
+	// the channel went away underneath us, most likely because the connection dropped.
+	// grab a fresh one, which waits for the reconnection, and try once more
+	logging.FromContext(ctx).Warn("Publishing channel closed, retrying on a new channel")
+	r.channel = nil
+
+	return r.publishAndConfirm(ctx, msg)
+}
+
+func (r *RabbitMQPublisher) publishAndConfirm(ctx context.Context, msg amqp.Publishing) error {
+	errctx := cerr.Field("queue_name", r.queueName)
+
+	channel, err := r.getChannel(ctx)
+	if err != nil {
+		return errctx.Wrap(err).Error("Failed to get a channel to publish on")
+	}
+
+	if err := channel.channel.Publish("", r.queueName, true, false, msg); err != nil {
+		return errctx.Wrap(err).Error("Failed to publish message")
+	}
+
+	if err := r.waitForConfirm(ctx, channel); err != nil {
+		return errctx.Wrap(err).Error("Message was not accepted by the broker")
+	}
+
+	return nil
+}
+
+func (r *RabbitMQPublisher) waitForConfirm(ctx context.Context, channel *confirmChannel) error {
+	timeout := time.NewTimer(r.confirmTimeout)
+	defer timeout.Stop()
+
+	// an unroutable message is returned first, and then still confirmed
+	var returned *amqp.Return
+
+	for {
+		select {
+		case ret, ok := <-channel.returns:
+			if !ok {
+				return cerr.Wrap(amqp.ErrClosed).Error("Channel closed before the publish was confirmed")
+			}
+
+			returned = &ret
+
+		case confirmation, ok := <-channel.confirms:
+			if !ok {
+				return cerr.Wrap(amqp.ErrClosed).Error("Channel closed before the publish was confirmed")
+			}
+
+			// the return is sent before the confirmation, but by now both can be waiting
+			// and select doesn't pick between them in order
+			if returned == nil {
+				select {
+				case ret, ok := <-channel.returns:
+					if ok {
+						returned = &ret
+					}
+				default:
+				}
+			}
+
+			if returned != nil {
+				return cerr.Field("reply_code", returned.ReplyCode).
+					Field("reply_text", returned.ReplyText).
+					Error("Message was returned as unroutable")
+			}
+
+			if !confirmation.Ack {
+				return cerr.Field("delivery_tag", confirmation.DeliveryTag).
+					Error("Broker nacked the message")
+			}
+
+			return nil
+
+		case <-timeout.C:
+			// a late confirmation would be mistaken for the next publish's, start over on a new channel
+			r.closeChannel(channel)
+			return cerr.Field("confirm_timeout", r.confirmTimeout).
+				Error("Timed out waiting for the broker to confirm the publish")
+
+		case <-ctx.Done():
+			r.closeChannel(channel)
+			return cerr.Wrap(ctx.Err()).Error("Gave up waiting for the broker to confirm the publish")
+		}
+	}
+}
+
+// getChannel expects the mutex to be held
+func (r *RabbitMQPublisher) getChannel(ctx context.Context) (*confirmChannel, error) {
+	if r.channel != nil {
+		return r.channel, nil
+	}
+
+	channel, err := r.openChannel(ctx)
+	if err != nil {
+		return nil, cerr.Wrap(err).Error("Failed to create rabbit channel")
+	}
+
+	// declared every time a channel is opened, in case the broker came back without it
+	_, err = channel.QueueDeclare(r.queueName, true, false, false, false, r.queueArgs)
+	if err != nil {
+		_ = channel.Close()
+		return nil, cerr.Field("queue_name", r.queueName).
+			Wrap(err).Error("Failed to declare queue")
+	}
+
+	if err := channel.Confirm(false); err != nil {
+		_ = channel.Close()
+		return nil, cerr.Wrap(err).Error("Failed to put channel into confirm mode")
+	}
+
+	r.channel = &confirmChannel{
+		channel:  channel,
+		confirms: channel.NotifyPublish(make(chan amqp.Confirmation, 1)),
+		returns:  channel.NotifyReturn(make(chan amqp.Return, 1)),
+	}
+
+	return r.channel, nil
+}
+
+// closeChannel expects the mutex to be held
+func (r *RabbitMQPublisher) closeChannel(channel *confirmChannel) {
+	if r.channel == channel {
+		r.channel = nil
+	}
+
+	_ = channel.channel.Close()
 }
@@ -0,0 +1,246 @@
+package publish_test
+
+import (
+	"chord-paper-be-workers/src/application/publish"
+	"chord-paper-be-workers/src/application/publish/publishfakes"
+	"chord-paper-be-workers/src/lib/cerr"
+	"context"
+
+	"github.com/streadway/amqp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RabbitMQPublisher", func() {
+	type broker struct {
+		// what the broker does with each publish
+		publishErr error
+		nack       bool
+		unroutable bool
+		// closes the channel's confirmations instead of confirming, like a channel that died mid publish
+		dropConfirms bool
+		// never confirms
+		silent bool
+	}
+
+	var (
+		queueArgs amqp.Table
+		message   amqp.Publishing
+
+		// what each channel that's opened does, the last one is used once they run out
+		brokers  []broker
+		channels []*publishfakes.FakeChannel
+
+		publisher *publish.RabbitMQPublisher
+
+		newChannel = func(b broker) *publishfakes.FakeChannel {
+			channel := &publishfakes.FakeChannel{}
+
+			var confirms chan amqp.Confirmation
+			var returns chan amqp.Return
+			channel.NotifyPublishStub = func(c chan amqp.Confirmation) chan amqp.Confirmation {
+				confirms = c
+				return c
+			}
+			channel.NotifyReturnStub = func(c chan amqp.Return) chan amqp.Return {
+				returns = c
+				return c
+			}
+
+			channel.PublishStub = func(_ string, _ string, _ bool, _ bool, _ amqp.Publishing) error {
+				switch {
+				case b.publishErr != nil:
+					return b.publishErr
+				case b.dropConfirms:
+					close(confirms)
+				case b.silent:
+				default:
+					if b.unroutable {
+						returns <- amqp.Return{ReplyCode: 312, ReplyText: "NO_ROUTE"}
+					}
+					confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: !b.nack}
+				}
+
+				return nil
+			}
+
+			return channel
+		}
+	)
+
+	BeforeEach(func() {
+		queueArgs = amqp.Table{"x-message-ttl": int64(1000)}
+		message = amqp.Publishing{
+			MessageId: "message-id",
+			Type:      "split_track",
+			Body:      []byte("{}"),
+		}
+
+		brokers = []broker{{}}
+		channels = []*publishfakes.FakeChannel{}
+	})
+
+	JustBeforeEach(func() {
+		openChannel := func(_ context.Context) (publish.Channel, error) {
+			b := brokers[len(brokers)-1]
+			if len(channels) < len(brokers) {
+				b = brokers[len(channels)]
+			}
+
+			channel := newChannel(b)
+			channels = append(channels, channel)
+			return channel, nil
+		}
+
+		publisher = publish.NewRabbitMQPublisherWithOpener(openChannel, "tracks.split_track", queueArgs)
+	})
+
+	It("declares the queue and publishes the message to it persistently, in confirm mode", func() {
+		Expect(publisher.Publish(context.Background(), message)).To(Succeed())
+
+		Expect(channels).To(HaveLen(1))
+		channel := channels[0]
+
+		Expect(channel.QueueDeclareCallCount()).To(Equal(1))
+		queueName, durable, _, _, _, args := channel.QueueDeclareArgsForCall(0)
+		Expect(queueName).To(Equal("tracks.split_track"))
+		Expect(durable).To(BeTrue())
+		Expect(args).To(Equal(queueArgs))
+
+		Expect(channel.ConfirmCallCount()).To(Equal(1))
+
+		Expect(channel.PublishCallCount()).To(Equal(1))
+		exchange, key, mandatory, _, published := channel.PublishArgsForCall(0)
+		Expect(exchange).To(BeEmpty())
+		Expect(key).To(Equal("tracks.split_track"))
+		Expect(mandatory).To(BeTrue())
+		Expect(published.MessageId).To(Equal("message-id"))
+		Expect(published.DeliveryMode).To(Equal(amqp.Persistent))
+		Expect(published.ContentType).To(Equal("application/json"))
+	})
+
+	It("keeps publishing on the same channel", func() {
+		Expect(publisher.Publish(context.Background(), message)).To(Succeed())
+		Expect(publisher.Publish(context.Background(), message)).To(Succeed())
+
+		Expect(channels).To(HaveLen(1))
+		Expect(channels[0].PublishCallCount()).To(Equal(2))
+	})
+
+	Describe("When the broker nacks the message", func() {
+		BeforeEach(func() {
+			brokers = []broker{{nack: true}}
+		})
+
+		It("returns an error", func() {
+			Expect(publisher.Publish(context.Background(), message)).NotTo(Succeed())
+		})
+	})
+
+	Describe("When the message can't be routed to a queue", func() {
+		BeforeEach(func() {
+			brokers = []broker{{unroutable: true}}
+		})
+
+		It("returns an error even though the broker confirms it", func() {
+			// the return and the confirmation are both waiting by the time they're read,
+			// so this would only fail some of the time if their order weren't kept
+			for i := 0; i < 20; i++ {
+				err := publisher.Publish(context.Background(), message)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("unroutable"))
+			}
+		})
+	})
+
+	Describe("When the channel is closed underneath the publish", func() {
+		BeforeEach(func() {
+			brokers = []broker{{publishErr: amqp.ErrClosed}, {}}
+		})
+
+		It("publishes again once on a new channel", func() {
+			Expect(publisher.Publish(context.Background(), message)).To(Succeed())
+
+			Expect(channels).To(HaveLen(2))
+			Expect(channels[0].PublishCallCount()).To(Equal(1))
+			Expect(channels[1].PublishCallCount()).To(Equal(1))
+		})
+
+		It("carries on with the new channel", func() {
+			Expect(publisher.Publish(context.Background(), message)).To(Succeed())
+			Expect(publisher.Publish(context.Background(), message)).To(Succeed())
+
+			Expect(channels).To(HaveLen(2))
+			Expect(channels[1].PublishCallCount()).To(Equal(2))
+		})
+	})
+
+	Describe("When the channel closes before the publish is confirmed", func() {
+		BeforeEach(func() {
+			brokers = []broker{{dropConfirms: true}, {}}
+		})
+
+		It("publishes again once on a new channel", func() {
+			Expect(publisher.Publish(context.Background(), message)).To(Succeed())
+			Expect(channels).To(HaveLen(2))
+		})
+	})
+
+	Describe("When the new channel is closed too", func() {
+		BeforeEach(func() {
+			brokers = []broker{{publishErr: amqp.ErrClosed}}
+		})
+
+		It("gives up after the second channel", func() {
+			Expect(publisher.Publish(context.Background(), message)).NotTo(Succeed())
+			Expect(channels).To(HaveLen(2))
+		})
+	})
+
+	Describe("When publishing fails for another reason", func() {
+		BeforeEach(func() {
+			brokers = []broker{{publishErr: cerr.Error("frame too large")}}
+		})
+
+		It("doesn't try again", func() {
+			Expect(publisher.Publish(context.Background(), message)).NotTo(Succeed())
+			Expect(channels).To(HaveLen(1))
+		})
+	})
+
+	Describe("When the broker never confirms", func() {
+		BeforeEach(func() {
+			brokers = []broker{{silent: true}, {}}
+		})
+
+		It("gives up once the context is done, and starts over on a new channel", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			Expect(publisher.Publish(ctx, message)).NotTo(Succeed())
+			Expect(channels[0].CloseCallCount()).To(Equal(1))
+
+			Expect(publisher.Publish(context.Background(), message)).To(Succeed())
+			Expect(channels).To(HaveLen(2))
+		})
+	})
+
+	Describe("When the queue can't be declared", func() {
+		JustBeforeEach(func() {
+			channel := &publishfakes.FakeChannel{}
+			channel.QueueDeclareReturns(amqp.Queue{}, cerr.Error("precondition failed"))
+
+			publisher = publish.NewRabbitMQPublisherWithOpener(func(_ context.Context) (publish.Channel, error) {
+				channels = append(channels, channel)
+				return channel, nil
+			}, "tracks.split_track", queueArgs)
+		})
+
+		It("returns an error and closes the channel", func() {
+			Expect(publisher.Publish(context.Background(), message)).NotTo(Succeed())
+			Expect(channels[0].CloseCallCount()).To(Equal(1))
+			Expect(channels[0].PublishCallCount()).To(BeZero())
+		})
+	})
+})
@@ -0,0 +1,496 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package publishfakes
+
+import (
+	"chord-paper-be-workers/src/application/publish"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+type FakeChannel struct {
+	CloseStub        func() error
+	closeMutex       sync.RWMutex
+	closeArgsForCall []struct {
+	}
+	closeReturns struct {
+		result1 error
+	}
+	closeReturnsOnCall map[int]struct {
+		result1 error
+	}
+	ConfirmStub        func(bool) error
+	confirmMutex       sync.RWMutex
+	confirmArgsForCall []struct {
+		arg1 bool
+	}
+	confirmReturns struct {
+		result1 error
+	}
+	confirmReturnsOnCall map[int]struct {
+		result1 error
+	}
+	NotifyPublishStub        func(chan amqp.Confirmation) chan amqp.Confirmation
+	notifyPublishMutex       sync.RWMutex
+	notifyPublishArgsForCall []struct {
+		arg1 chan amqp.Confirmation
+	}
+	notifyPublishReturns struct {
+		result1 chan amqp.Confirmation
+	}
+	notifyPublishReturnsOnCall map[int]struct {
+		result1 chan amqp.Confirmation
+	}
+	NotifyReturnStub        func(chan amqp.Return) chan amqp.Return
+	notifyReturnMutex       sync.RWMutex
+	notifyReturnArgsForCall []struct {
+		arg1 chan amqp.Return
+	}
+	notifyReturnReturns struct {
+		result1 chan amqp.Return
+	}
+	notifyReturnReturnsOnCall map[int]struct {
+		result1 chan amqp.Return
+	}
+	PublishStub        func(string, string, bool, bool, amqp.Publishing) error
+	publishMutex       sync.RWMutex
+	publishArgsForCall []struct {
+		arg1 string
+		arg2 string
+		arg3 bool
+		arg4 bool
+		arg5 amqp.Publishing
+	}
+	publishReturns struct {
+		result1 error
+	}
+	publishReturnsOnCall map[int]struct {
+		result1 error
+	}
+	QueueDeclareStub        func(string, bool, bool, bool, bool, amqp.Table) (amqp.Queue, error)
+	queueDeclareMutex       sync.RWMutex
+	queueDeclareArgsForCall []struct {
+		arg1 string
+		arg2 bool
+		arg3 bool
+		arg4 bool
+		arg5 bool
+		arg6 amqp.Table
+	}
+	queueDeclareReturns struct {
+		result1 amqp.Queue
+		result2 error
+	}
+	queueDeclareReturnsOnCall map[int]struct {
+		result1 amqp.Queue
+		result2 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeChannel) Close() error {
+	fake.closeMutex.Lock()
+	ret, specificReturn := fake.closeReturnsOnCall[len(fake.closeArgsForCall)]
+	fake.closeArgsForCall = append(fake.closeArgsForCall, struct {
+	}{})
+	stub := fake.CloseStub
+	fakeReturns := fake.closeReturns
+	fake.recordInvocation("Close", []interface{}{})
+	fake.closeMutex.Unlock()
+	if stub != nil {
+		return stub()
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeChannel) CloseCallCount() int {
+	fake.closeMutex.RLock()
+	defer fake.closeMutex.RUnlock()
+	return len(fake.closeArgsForCall)
+}
+
+func (fake *FakeChannel) CloseCalls(stub func() error) {
+	fake.closeMutex.Lock()
+	defer fake.closeMutex.Unlock()
+	fake.CloseStub = stub
+}
+
+func (fake *FakeChannel) CloseReturns(result1 error) {
+	fake.closeMutex.Lock()
+	defer fake.closeMutex.Unlock()
+	fake.CloseStub = nil
+	fake.closeReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeChannel) CloseReturnsOnCall(i int, result1 error) {
+	fake.closeMutex.Lock()
+	defer fake.closeMutex.Unlock()
+	fake.CloseStub = nil
+	if fake.closeReturnsOnCall == nil {
+		fake.closeReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.closeReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeChannel) Confirm(arg1 bool) error {
+	fake.confirmMutex.Lock()
+	ret, specificReturn := fake.confirmReturnsOnCall[len(fake.confirmArgsForCall)]
+	fake.confirmArgsForCall = append(fake.confirmArgsForCall, struct {
+		arg1 bool
+	}{arg1})
+	stub := fake.ConfirmStub
+	fakeReturns := fake.confirmReturns
+	fake.recordInvocation("Confirm", []interface{}{arg1})
+	fake.confirmMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeChannel) ConfirmCallCount() int {
+	fake.confirmMutex.RLock()
+	defer fake.confirmMutex.RUnlock()
+	return len(fake.confirmArgsForCall)
+}
+
+func (fake *FakeChannel) ConfirmCalls(stub func(bool) error) {
+	fake.confirmMutex.Lock()
+	defer fake.confirmMutex.Unlock()
+	fake.ConfirmStub = stub
+}
+
+func (fake *FakeChannel) ConfirmArgsForCall(i int) bool {
+	fake.confirmMutex.RLock()
+	defer fake.confirmMutex.RUnlock()
+	argsForCall := fake.confirmArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeChannel) ConfirmReturns(result1 error) {
+	fake.confirmMutex.Lock()
+	defer fake.confirmMutex.Unlock()
+	fake.ConfirmStub = nil
+	fake.confirmReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeChannel) ConfirmReturnsOnCall(i int, result1 error) {
+	fake.confirmMutex.Lock()
+	defer fake.confirmMutex.Unlock()
+	fake.ConfirmStub = nil
+	if fake.confirmReturnsOnCall == nil {
+		fake.confirmReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.confirmReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeChannel) NotifyPublish(arg1 chan amqp.Confirmation) chan amqp.Confirmation {
+	fake.notifyPublishMutex.Lock()
+	ret, specificReturn := fake.notifyPublishReturnsOnCall[len(fake.notifyPublishArgsForCall)]
+	fake.notifyPublishArgsForCall = append(fake.notifyPublishArgsForCall, struct {
+		arg1 chan amqp.Confirmation
+	}{arg1})
+	stub := fake.NotifyPublishStub
+	fakeReturns := fake.notifyPublishReturns
+	fake.recordInvocation("NotifyPublish", []interface{}{arg1})
+	fake.notifyPublishMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeChannel) NotifyPublishCallCount() int {
+	fake.notifyPublishMutex.RLock()
+	defer fake.notifyPublishMutex.RUnlock()
+	return len(fake.notifyPublishArgsForCall)
+}
+
+func (fake *FakeChannel) NotifyPublishCalls(stub func(chan amqp.Confirmation) chan amqp.Confirmation) {
+	fake.notifyPublishMutex.Lock()
+	defer fake.notifyPublishMutex.Unlock()
+	fake.NotifyPublishStub = stub
+}
+
+func (fake *FakeChannel) NotifyPublishArgsForCall(i int) chan amqp.Confirmation {
+	fake.notifyPublishMutex.RLock()
+	defer fake.notifyPublishMutex.RUnlock()
+	argsForCall := fake.notifyPublishArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeChannel) NotifyPublishReturns(result1 chan amqp.Confirmation) {
+	fake.notifyPublishMutex.Lock()
+	defer fake.notifyPublishMutex.Unlock()
+	fake.NotifyPublishStub = nil
+	fake.notifyPublishReturns = struct {
+		result1 chan amqp.Confirmation
+	}{result1}
+}
+
+func (fake *FakeChannel) NotifyPublishReturnsOnCall(i int, result1 chan amqp.Confirmation) {
+	fake.notifyPublishMutex.Lock()
+	defer fake.notifyPublishMutex.Unlock()
+	fake.NotifyPublishStub = nil
+	if fake.notifyPublishReturnsOnCall == nil {
+		fake.notifyPublishReturnsOnCall = make(map[int]struct {
+			result1 chan amqp.Confirmation
+		})
+	}
+	fake.notifyPublishReturnsOnCall[i] = struct {
+		result1 chan amqp.Confirmation
+	}{result1}
+}
+
+func (fake *FakeChannel) NotifyReturn(arg1 chan amqp.Return) chan amqp.Return {
+	fake.notifyReturnMutex.Lock()
+	ret, specificReturn := fake.notifyReturnReturnsOnCall[len(fake.notifyReturnArgsForCall)]
+	fake.notifyReturnArgsForCall = append(fake.notifyReturnArgsForCall, struct {
+		arg1 chan amqp.Return
+	}{arg1})
+	stub := fake.NotifyReturnStub
+	fakeReturns := fake.notifyReturnReturns
+	fake.recordInvocation("NotifyReturn", []interface{}{arg1})
+	fake.notifyReturnMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeChannel) NotifyReturnCallCount() int {
+	fake.notifyReturnMutex.RLock()
+	defer fake.notifyReturnMutex.RUnlock()
+	return len(fake.notifyReturnArgsForCall)
+}
+
+func (fake *FakeChannel) NotifyReturnCalls(stub func(chan amqp.Return) chan amqp.Return) {
+	fake.notifyReturnMutex.Lock()
+	defer fake.notifyReturnMutex.Unlock()
+	fake.NotifyReturnStub = stub
+}
+
+func (fake *FakeChannel) NotifyReturnArgsForCall(i int) chan amqp.Return {
+	fake.notifyReturnMutex.RLock()
+	defer fake.notifyReturnMutex.RUnlock()
+	argsForCall := fake.notifyReturnArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeChannel) NotifyReturnReturns(result1 chan amqp.Return) {
+	fake.notifyReturnMutex.Lock()
+	defer fake.notifyReturnMutex.Unlock()
+	fake.NotifyReturnStub = nil
+	fake.notifyReturnReturns = struct {
+		result1 chan amqp.Return
+	}{result1}
+}
+
+func (fake *FakeChannel) NotifyReturnReturnsOnCall(i int, result1 chan amqp.Return) {
+	fake.notifyReturnMutex.Lock()
+	defer fake.notifyReturnMutex.Unlock()
+	fake.NotifyReturnStub = nil
+	if fake.notifyReturnReturnsOnCall == nil {
+		fake.notifyReturnReturnsOnCall = make(map[int]struct {
+			result1 chan amqp.Return
+		})
+	}
+	fake.notifyReturnReturnsOnCall[i] = struct {
+		result1 chan amqp.Return
+	}{result1}
+}
+
+func (fake *FakeChannel) Publish(arg1 string, arg2 string, arg3 bool, arg4 bool, arg5 amqp.Publishing) error {
+	fake.publishMutex.Lock()
+	ret, specificReturn := fake.publishReturnsOnCall[len(fake.publishArgsForCall)]
+	fake.publishArgsForCall = append(fake.publishArgsForCall, struct {
+		arg1 string
+		arg2 string
+		arg3 bool
+		arg4 bool
+		arg5 amqp.Publishing
+	}{arg1, arg2, arg3, arg4, arg5})
+	stub := fake.PublishStub
+	fakeReturns := fake.publishReturns
+	fake.recordInvocation("Publish", []interface{}{arg1, arg2, arg3, arg4, arg5})
+	fake.publishMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3, arg4, arg5)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeChannel) PublishCallCount() int {
+	fake.publishMutex.RLock()
+	defer fake.publishMutex.RUnlock()
+	return len(fake.publishArgsForCall)
+}
+
+func (fake *FakeChannel) PublishCalls(stub func(string, string, bool, bool, amqp.Publishing) error) {
+	fake.publishMutex.Lock()
+	defer fake.publishMutex.Unlock()
+	fake.PublishStub = stub
+}
+
+func (fake *FakeChannel) PublishArgsForCall(i int) (string, string, bool, bool, amqp.Publishing) {
+	fake.publishMutex.RLock()
+	defer fake.publishMutex.RUnlock()
+	argsForCall := fake.publishArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4, argsForCall.arg5
+}
+
+func (fake *FakeChannel) PublishReturns(result1 error) {
+	fake.publishMutex.Lock()
+	defer fake.publishMutex.Unlock()
+	fake.PublishStub = nil
+	fake.publishReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeChannel) PublishReturnsOnCall(i int, result1 error) {
+	fake.publishMutex.Lock()
+	defer fake.publishMutex.Unlock()
+	fake.PublishStub = nil
+	if fake.publishReturnsOnCall == nil {
+		fake.publishReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.publishReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeChannel) QueueDeclare(arg1 string, arg2 bool, arg3 bool, arg4 bool, arg5 bool, arg6 amqp.Table) (amqp.Queue, error) {
+	fake.queueDeclareMutex.Lock()
+	ret, specificReturn := fake.queueDeclareReturnsOnCall[len(fake.queueDeclareArgsForCall)]
+	fake.queueDeclareArgsForCall = append(fake.queueDeclareArgsForCall, struct {
+		arg1 string
+		arg2 bool
+		arg3 bool
+		arg4 bool
+		arg5 bool
+		arg6 amqp.Table
+	}{arg1, arg2, arg3, arg4, arg5, arg6})
+	stub := fake.QueueDeclareStub
+	fakeReturns := fake.queueDeclareReturns
+	fake.recordInvocation("QueueDeclare", []interface{}{arg1, arg2, arg3, arg4, arg5, arg6})
+	fake.queueDeclareMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3, arg4, arg5, arg6)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeChannel) QueueDeclareCallCount() int {
+	fake.queueDeclareMutex.RLock()
+	defer fake.queueDeclareMutex.RUnlock()
+	return len(fake.queueDeclareArgsForCall)
+}
+
+func (fake *FakeChannel) QueueDeclareCalls(stub func(string, bool, bool, bool, bool, amqp.Table) (amqp.Queue, error)) {
+	fake.queueDeclareMutex.Lock()
+	defer fake.queueDeclareMutex.Unlock()
+	fake.QueueDeclareStub = stub
+}
+
+func (fake *FakeChannel) QueueDeclareArgsForCall(i int) (string, bool, bool, bool, bool, amqp.Table) {
+	fake.queueDeclareMutex.RLock()
+	defer fake.queueDeclareMutex.RUnlock()
+	argsForCall := fake.queueDeclareArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4, argsForCall.arg5, argsForCall.arg6
+}
+
+func (fake *FakeChannel) QueueDeclareReturns(result1 amqp.Queue, result2 error) {
+	fake.queueDeclareMutex.Lock()
+	defer fake.queueDeclareMutex.Unlock()
+	fake.QueueDeclareStub = nil
+	fake.queueDeclareReturns = struct {
+		result1 amqp.Queue
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeChannel) QueueDeclareReturnsOnCall(i int, result1 amqp.Queue, result2 error) {
+	fake.queueDeclareMutex.Lock()
+	defer fake.queueDeclareMutex.Unlock()
+	fake.QueueDeclareStub = nil
+	if fake.queueDeclareReturnsOnCall == nil {
+		fake.queueDeclareReturnsOnCall = make(map[int]struct {
+			result1 amqp.Queue
+			result2 error
+		})
+	}
+	fake.queueDeclareReturnsOnCall[i] = struct {
+		result1 amqp.Queue
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeChannel) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.closeMutex.RLock()
+	defer fake.closeMutex.RUnlock()
+	fake.confirmMutex.RLock()
+	defer fake.confirmMutex.RUnlock()
+	fake.notifyPublishMutex.RLock()
+	defer fake.notifyPublishMutex.RUnlock()
+	fake.notifyReturnMutex.RLock()
+	defer fake.notifyReturnMutex.RUnlock()
+	fake.publishMutex.RLock()
+	defer fake.publishMutex.RUnlock()
+	fake.queueDeclareMutex.RLock()
+	defer fake.queueDeclareMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeChannel) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ publish.Channel = new(FakeChannel)
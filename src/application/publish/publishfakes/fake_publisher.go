@@ -3,16 +3,18 @@ package publishfakes
 
 import (
 	"chord-paper-be-workers/src/application/publish"
+	"context"
 	"sync"
 
 	"github.com/streadway/amqp"
 )
 
 type FakePublisher struct {
-	PublishStub        func(amqp.Publishing) error
+	PublishStub        func(context.Context, amqp.Publishing) error
 	publishMutex       sync.RWMutex
 	publishArgsForCall []struct {
-		arg1 amqp.Publishing
+		arg1 context.Context
+		arg2 amqp.Publishing
 	}
 	publishReturns struct {
 		result1 error
@@ -24,18 +26,19 @@ type FakePublisher struct {
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakePublisher) Publish(arg1 amqp.Publishing) error {
+func (fake *FakePublisher) Publish(arg1 context.Context, arg2 amqp.Publishing) error {
 	fake.publishMutex.Lock()
 	ret, specificReturn := fake.publishReturnsOnCall[len(fake.publishArgsForCall)]
 	fake.publishArgsForCall = append(fake.publishArgsForCall, struct {
-		arg1 amqp.Publishing
-	}{arg1})
+		arg1 context.Context
+		arg2 amqp.Publishing
+	}{arg1, arg2})
 	stub := fake.PublishStub
 	fakeReturns := fake.publishReturns
-	fake.recordInvocation("Publish", []interface{}{arg1})
+	fake.recordInvocation("Publish", []interface{}{arg1, arg2})
 	fake.publishMutex.Unlock()
 	if stub != nil {
-		return stub(arg1)
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1
@@ -49,17 +52,17 @@ func (fake *FakePublisher) PublishCallCount() int {
 	return len(fake.publishArgsForCall)
 }
 
-func (fake *FakePublisher) PublishCalls(stub func(amqp.Publishing) error) {
+func (fake *FakePublisher) PublishCalls(stub func(context.Context, amqp.Publishing) error) {
 	fake.publishMutex.Lock()
 	defer fake.publishMutex.Unlock()
 	fake.PublishStub = stub
 }
 
-func (fake *FakePublisher) PublishArgsForCall(i int) amqp.Publishing {
+func (fake *FakePublisher) PublishArgsForCall(i int) (context.Context, amqp.Publishing) {
 	fake.publishMutex.RLock()
 	defer fake.publishMutex.RUnlock()
 	argsForCall := fake.publishArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakePublisher) PublishReturns(result1 error) {
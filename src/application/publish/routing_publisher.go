@@ -0,0 +1,45 @@
+package publish
+
+import (
+	"chord-paper-be-workers/src/application/rabbitmq"
+	"context"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+var _ Publisher = &RoutingPublisher{}
+
+// RoutingPublisher sends every message to the work queue for its job type
+func NewRoutingPublisher(conn *rabbitmq.Connection, topology rabbitmq.Topology) *RoutingPublisher {
+	return &RoutingPublisher{
+		conn:       conn,
+		topology:   topology,
+		publishers: map[string]*RabbitMQPublisher{},
+	}
+}
+
+type RoutingPublisher struct {
+	conn     *rabbitmq.Connection
+	topology rabbitmq.Topology
+
+	mutex      sync.Mutex
+	publishers map[string]*RabbitMQPublisher
+}
+
+func (r *RoutingPublisher) Publish(ctx context.Context, msg amqp.Publishing) error {
+	return r.publisherFor(msg.Type).Publish(ctx, msg)
+}
+
+func (r *RoutingPublisher) publisherFor(jobType string) *RabbitMQPublisher {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	publisher, ok := r.publishers[jobType]
+	if !ok {
+		publisher = NewRabbitMQPublisher(r.conn, r.topology.JobQueue(jobType))
+		r.publishers[jobType] = publisher
+	}
+
+	return publisher
+}
@@ -0,0 +1,205 @@
+package rabbitmq
+
+import (
+	"chord-paper-be-workers/src/lib/cerr"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/streadway/amqp"
+)
+
+const (
+	initialBackoff = 500 * time.Millisecond
+	maxBackoff     = 30 * time.Second
+)
+
+// Connection supervises an AMQP connection, watching for it to close
+// and redialing with exponential backoff until it is back.
+// Channels should always be obtained through it instead of holding onto
+// a *amqp.Connection, so that callers pick up the new connection
+func NewConnection(url string) *Connection {
+	return &Connection{
+		url:   url,
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+type Connection struct {
+	url string
+
+	mutex sync.Mutex
+	conn  *amqp.Connection
+	// closed whenever conn is usable, replaced while reconnecting
+	ready chan struct{}
+	// closed when the connection is deliberately shut down
+	done chan struct{}
+}
+
+// Connect dials the broker, retrying until it succeeds or the context is done,
+// and then keeps the connection alive in the background
+func (c *Connection) Connect(ctx context.Context) error {
+	conn, err := c.dialWithBackoff(ctx)
+	if err != nil {
+		return cerr.Wrap(err).Error("Failed to connect to RabbitMQ")
+	}
+
+	c.setConnection(conn)
+	go c.supervise(conn)
+
+	return nil
+}
+
+// Channel opens a new channel, waiting for a reconnection if the connection is currently down
+func (c *Connection) Channel(ctx context.Context) (*amqp.Channel, error) {
+	for {
+		conn, err := c.waitForConnection(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		channel, err := conn.Channel()
+		if err == nil {
+			return channel, nil
+		}
+
+		if err != amqp.ErrClosed {
+			return nil, cerr.Wrap(err).Error("Failed to open channel")
+		}
+
+		// the connection died between us getting it and using it,
+		// wait for the supervisor to notice and bring it back
+		log.Warn("Connection closed while opening channel, waiting for reconnection")
+		c.markDown(conn)
+	}
+}
+
+func (c *Connection) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	select {
+	case <-c.done:
+		return nil
+	default:
+		close(c.done)
+	}
+
+	if c.conn == nil {
+		return nil
+	}
+
+	return c.conn.Close()
+}
+
+func (c *Connection) waitForConnection(ctx context.Context) (*amqp.Connection, error) {
+	for {
+		c.mutex.Lock()
+		conn, ready := c.conn, c.ready
+		c.mutex.Unlock()
+
+		select {
+		case <-c.done:
+			return nil, cerr.Error("Connection has been shut down")
+		case <-ctx.Done():
+			return nil, cerr.Wrap(ctx.Err()).Error("Gave up waiting for connection")
+		case <-ready:
+			if conn != nil && !conn.IsClosed() {
+				return conn, nil
+			}
+			c.markDown(conn)
+		}
+	}
+}
+
+func (c *Connection) supervise(conn *amqp.Connection) {
+	for {
+		closeNotifier := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+		select {
+		case <-c.done:
+			return
+		case amqpErr := <-closeNotifier:
+			select {
+			case <-c.done:
+				return
+			default:
+			}
+
+			log.WithField("amqp_error", amqpErr).Warn("RabbitMQ connection closed, reconnecting")
+		}
+
+		c.markDown(conn)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-c.done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		newConn, err := c.dialWithBackoff(ctx)
+		cancel()
+		if err != nil {
+			// only happens when the connection is shut down
+			return
+		}
+
+		log.Info("Reconnected to RabbitMQ")
+		c.setConnection(newConn)
+		conn = newConn
+	}
+}
+
+func (c *Connection) dialWithBackoff(ctx context.Context) (*amqp.Connection, error) {
+	backoff := initialBackoff
+
+	for {
+		conn, err := amqp.Dial(c.url)
+		if err == nil {
+			return conn, nil
+		}
+
+		log.WithField("retry_in", backoff).WithError(err).Warn("Failed to dial RabbitMQ")
+
+		select {
+		case <-ctx.Done():
+			return nil, cerr.Wrap(ctx.Err()).Error("Stopped dialing RabbitMQ")
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (c *Connection) setConnection(conn *amqp.Connection) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.conn = conn
+	close(c.ready)
+}
+
+// markDown makes new callers wait for a reconnection, unless the given
+// connection has already been replaced
+func (c *Connection) markDown(conn *amqp.Connection) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.conn != conn {
+		return
+	}
+
+	select {
+	case <-c.ready:
+		c.ready = make(chan struct{})
+	default:
+	}
+}
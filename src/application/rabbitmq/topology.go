@@ -0,0 +1,41 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"time"
+)
+
+// Topology names the queues that hang off a base queue name.
+// Every job type gets its own work queue, so each stage can be consumed
+// by a separately scaled set of workers
+type Topology struct {
+	BaseQueueName string
+	// the job type that's requested from outside the workers, its queue is the base queue,
+	// since that's where the API publishes the requests to
+	EntryJobType string
+}
+
+func NewTopology(baseQueueName string, entryJobType string) Topology {
+	return Topology{
+		BaseQueueName: baseQueueName,
+		EntryJobType:  entryJobType,
+	}
+}
+
+func (t Topology) JobQueue(jobType string) string {
+	if jobType == t.EntryJobType {
+		return t.BaseQueueName
+	}
+
+	return fmt.Sprintf("%s.%s", t.BaseQueueName, jobType)
+}
+
+// RetryQueue parks messages of a job type for the delay before they go back to its job queue
+func (t Topology) RetryQueue(jobType string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%dms", t.JobQueue(jobType), delay.Milliseconds())
+}
+
+// DeadLetterQueue is shared by all job types, the message type tells them apart
+func (t Topology) DeadLetterQueue() string {
+	return fmt.Sprintf("%s.dead", t.BaseQueueName)
+}
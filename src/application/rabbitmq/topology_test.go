@@ -0,0 +1,31 @@
+package rabbitmq_test
+
+import (
+	"chord-paper-be-workers/src/application/rabbitmq"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Topology", func() {
+	topology := rabbitmq.NewTopology("tracks", "start_job")
+
+	It("keeps the entry job on the base queue, where the API publishes it", func() {
+		Expect(topology.JobQueue("start_job")).To(Equal("tracks"))
+	})
+
+	It("gives every other job type its own queue", func() {
+		Expect(topology.JobQueue("split_track")).To(Equal("tracks.split_track"))
+		Expect(topology.JobQueue("transfer_original")).To(Equal("tracks.transfer_original"))
+	})
+
+	It("names retry queues after the job queue and their delay", func() {
+		Expect(topology.RetryQueue("split_track", time.Minute)).To(Equal("tracks.split_track.retry.60000ms"))
+		Expect(topology.RetryQueue("start_job", 10*time.Second)).To(Equal("tracks.retry.10000ms"))
+	})
+
+	It("shares one dead letter queue between the job types", func() {
+		Expect(topology.DeadLetterQueue()).To(Equal("tracks.dead"))
+	})
+})
@@ -0,0 +1,222 @@
+package resume
+
+import (
+	cloudstorage "chord-paper-be-workers/src/application/cloud_storage/entity"
+	"chord-paper-be-workers/src/application/jobs/job_message"
+	"chord-paper-be-workers/src/application/jobs/split"
+	"chord-paper-be-workers/src/application/jobs/split/splitter"
+	"chord-paper-be-workers/src/application/jobs/transfer"
+	"chord-paper-be-workers/src/application/pipeline"
+	"chord-paper-be-workers/src/application/tracks/entity"
+	"chord-paper-be-workers/src/lib/cerr"
+	"context"
+)
+
+// savedOutput rebuilds the output of a stage from the files it saved
+type savedOutput func(a Artifacts, ctx context.Context, identifier job_message.TrackIdentifier, track entity.Track) (pipeline.Output, error)
+
+// savedOutputs are the stages that save their output in the file store,
+// the stages after them can be picked up from it
+var savedOutputs = map[string]savedOutput{
+	transfer.JobType: Artifacts.savedOriginal,
+	split.JobType:    Artifacts.savedStems,
+}
+
+// the finished track types, and the split requests they were made from
+var splitRequestTypes = map[entity.TrackType]entity.TrackType{
+	entity.TwoStemsType:  entity.SplitTwoStemsType,
+	entity.FourStemsType: entity.SplitFourStemsType,
+	entity.FiveStemsType: entity.SplitFiveStemsType,
+}
+
+// Artifacts lets a track skip the stages whose output is already stored, e.g. splitting
+// again after a spleeter upgrade without downloading the original from its source site
+func NewArtifacts(fileStore cloudstorage.FileStore, bucketName string, stages pipeline.Registry) Artifacts {
+	return Artifacts{
+		fileStore:  fileStore,
+		bucketName: bucketName,
+		stages:     stages,
+	}
+}
+
+type Artifacts struct {
+	fileStore  cloudstorage.FileStore
+	bucketName string
+	stages     pipeline.Registry
+}
+
+// Stages can be picked up from the files that the stage before them left in the file store,
+// in the order they run
+func (a Artifacts) Stages() []string {
+	stages := []string{}
+	for _, jobType := range a.stages.JobTypes() {
+		if a.IsStage(jobType) {
+			stages = append(stages, jobType)
+		}
+	}
+
+	return stages
+}
+
+func (a Artifacts) IsStage(stage string) bool {
+	previous, ok := a.stages.Previous(stage)
+	if !ok {
+		return false
+	}
+
+	_, ok = savedOutputs[previous.JobType]
+	return ok
+}
+
+// Params builds the job params for picking the track up at the stage,
+// once every file that the stage before it saved is known to exist
+func (a Artifacts) Params(ctx context.Context, tracklistID string, trackID string, track entity.Track, stage string) (interface{}, error) {
+	errctx := cerr.Field("tracklist_id", tracklistID).Field("track_id", trackID).Field("stage", stage)
+
+	if !a.IsStage(stage) {
+		return nil, errctx.Field("stages", a.Stages()).Categorize(cerr.Permanent).
+			Error("Stage can't be resumed from stored artifacts")
+	}
+
+	identifier := job_message.TrackIdentifier{
+		TrackListID: tracklistID,
+		TrackID:     trackID,
+	}
+
+	previous, _ := a.stages.Previous(stage)
+	output, err := savedOutputs[previous.JobType](a, ctx, identifier, track)
+	if err != nil {
+		return nil, errctx.Field("previous_stage", previous.JobType).Wrap(err).Error("Failed to find what the stage before it saved")
+	}
+
+	nextStage, _ := a.stages.Stage(stage)
+	return nextStage.ParamsFrom(output)
+}
+
+// Latest is the furthest stage the track can be picked up at from what's stored, with its job params.
+// With nothing stored, that's the first stage with only the track to work on
+func (a Artifacts) Latest(ctx context.Context, tracklistID string, trackID string, track entity.Track) (string, interface{}, error) {
+	stages := a.Stages()
+	for i := len(stages) - 1; i >= 0; i-- {
+		params, err := a.Params(ctx, tracklistID, trackID, track, stages[i])
+		if err == nil {
+			return stages[i], params, nil
+		}
+
+		// anything but missing files, e.g. the file store being down, could be hiding a later stage
+		if cerr.CategoryOf(err) != cerr.Permanent {
+			return "", nil, err
+		}
+	}
+
+	output, err := pipeline.NewOutput(job_message.TrackIdentifier{
+		TrackListID: tracklistID,
+		TrackID:     trackID,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	first, _ := a.stages.Stage(a.stages.JobTypes()[0])
+	params, err := first.ParamsFrom(output)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return first.JobType, params, nil
+}
+
+func (a Artifacts) savedOriginal(ctx context.Context, identifier job_message.TrackIdentifier, _ entity.Track) (pipeline.Output, error) {
+	originalURL := transfer.OriginalURL(a.bucketName, identifier.TrackListID, identifier.TrackID)
+	if err := a.ensureExists(ctx, originalURL); err != nil {
+		return nil, cerr.Wrap(err).Error("The original hasn't been saved")
+	}
+
+	return pipeline.NewOutput(transfer.Output{
+		TrackIdentifier:  identifier,
+		SavedOriginalURL: originalURL,
+	})
+}
+
+func (a Artifacts) savedStems(ctx context.Context, identifier job_message.TrackIdentifier, track entity.Track) (pipeline.Output, error) {
+	stemURLs, err := a.stemURLs(ctx, identifier.TrackListID, identifier.TrackID, track)
+	if err != nil {
+		return nil, cerr.Wrap(err).Error("The stems haven't all been saved")
+	}
+
+	return pipeline.NewOutput(split.Output{
+		TrackIdentifier: identifier,
+		StemURLs:        stemURLs,
+	})
+}
+
+func (a Artifacts) stemURLs(ctx context.Context, tracklistID string, trackID string, track entity.Track) (map[string]string, error) {
+	splitRequest, err := Reopen(track)
+	if err != nil {
+		return nil, err
+	}
+
+	splitType, err := splitter.ConvertToSplitType(splitRequest.TrackType)
+	if err != nil {
+		return nil, cerr.Categorize(cerr.Permanent).Wrap(err).Error("Failed to recognize track type as split type")
+	}
+
+	stemDirURL, err := splitter.StemDirURL(a.bucketName, tracklistID, trackID, splitType)
+	if err != nil {
+		return nil, cerr.Field("split_type", splitType).Categorize(cerr.Permanent).
+			Wrap(err).Error("Failed to generate the stems' path")
+	}
+
+	stemURLs := map[string]string{}
+	for _, stemName := range splitter.StemNames[splitType] {
+		stemURL := splitter.StemURL(stemDirURL, stemName)
+		if err := a.ensureExists(ctx, stemURL); err != nil {
+			return nil, err
+		}
+
+		stemURLs[stemName] = stemURL
+	}
+
+	return stemURLs, nil
+}
+
+func (a Artifacts) ensureExists(ctx context.Context, fileURL string) error {
+	errctx := cerr.Field("file_url", fileURL)
+
+	exists, err := a.fileStore.FileExists(ctx, fileURL)
+	if err != nil {
+		return errctx.Wrap(err).Error("Failed to check the file store")
+	}
+
+	if !exists {
+		return errctx.Categorize(cerr.Permanent).Error("File doesn't exist")
+	}
+
+	return nil
+}
+
+// Reopen turns a track back into a split request, a finished track comes back without its stems
+// and the job status of a request that's still around is left for the caller to set
+func Reopen(track entity.Track) (entity.SplitStemTrack, error) {
+	switch typedTrack := track.(type) {
+	case entity.SplitStemTrack:
+		return typedTrack, nil
+
+	case entity.StemTrack:
+		requestType, ok := splitRequestTypes[typedTrack.TrackType]
+		if !ok {
+			return entity.SplitStemTrack{}, cerr.Field("track_type", typedTrack.TrackType).
+				Categorize(cerr.Permanent).Error("No split request type for the track type")
+		}
+
+		return entity.SplitStemTrack{
+			BaseTrack:    entity.BaseTrack{TrackType: requestType},
+			SourceFormat: typedTrack.SourceFormat,
+			JobHistory:   typedTrack.JobHistory,
+		}, nil
+
+	default:
+		return entity.SplitStemTrack{}, cerr.Field("track_type", track.GetTrackType()).
+			Categorize(cerr.Permanent).Error("Track can't be split")
+	}
+}
@@ -0,0 +1,219 @@
+package resume_test
+
+import (
+	"chord-paper-be-workers/src/application"
+	"chord-paper-be-workers/src/application/integration_test/dummy"
+	"chord-paper-be-workers/src/application/jobs/job_message"
+	"chord-paper-be-workers/src/application/jobs/save_stems_to_db"
+	"chord-paper-be-workers/src/application/jobs/split"
+	"chord-paper-be-workers/src/application/jobs/start"
+	"chord-paper-be-workers/src/application/jobs/transfer"
+	"chord-paper-be-workers/src/application/resume"
+	"chord-paper-be-workers/src/application/tracks/entity"
+	"chord-paper-be-workers/src/lib/cerr"
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Artifacts", func() {
+	const (
+		originalURL = "https://storage.googleapis.com/bucket/tracklist-1/track-1/original/original.mp3"
+		stemDirURL  = "https://storage.googleapis.com/bucket/tracklist-1/track-1/4stems"
+	)
+
+	var (
+		ctx       context.Context
+		fileStore *dummy.FileStore
+		artifacts resume.Artifacts
+		track     entity.Track
+
+		identifier = job_message.TrackIdentifier{TrackListID: "tracklist-1", TrackID: "track-1"}
+
+		stemURLs = map[string]string{
+			"vocals": stemDirURL + "/vocals.mp3",
+			"drums":  stemDirURL + "/drums.mp3",
+			"bass":   stemDirURL + "/bass.mp3",
+			"other":  stemDirURL + "/other.mp3",
+		}
+
+		saveOriginal = func() {
+			fileStore.State[originalURL] = []byte("original")
+		}
+
+		saveStems = func() {
+			for _, stemURL := range stemURLs {
+				fileStore.State[stemURL] = []byte("stem")
+			}
+		}
+
+		latest = func() (string, interface{}) {
+			stage, params, err := artifacts.Latest(ctx, "tracklist-1", "track-1", track)
+			Expect(err).NotTo(HaveOccurred())
+			return stage, params
+		}
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		fileStore = dummy.NewDummyFileStore()
+		artifacts = resume.NewArtifacts(fileStore, "bucket", application.Pipeline())
+		track = entity.SplitStemTrack{
+			BaseTrack: entity.BaseTrack{TrackType: entity.SplitFourStemsType},
+			JobStatus: entity.ErrorStatus,
+		}
+	})
+
+	It("picks up the stages after the ones that save files, in the order they run", func() {
+		Expect(artifacts.Stages()).To(Equal([]string{split.JobType, save_stems_to_db.JobType}))
+		Expect(artifacts.IsStage(split.JobType)).To(BeTrue())
+		Expect(artifacts.IsStage(start.JobType)).To(BeFalse())
+		Expect(artifacts.IsStage(transfer.JobType)).To(BeFalse())
+		Expect(artifacts.IsStage("unknown")).To(BeFalse())
+	})
+
+	Describe("Finding the furthest stage to resume from", func() {
+		It("starts from the beginning when nothing is stored", func() {
+			stage, params := latest()
+			Expect(stage).To(Equal(start.JobType))
+			Expect(params).To(Equal(&start.JobParams{TrackIdentifier: identifier}))
+		})
+
+		It("splits again when only the original is stored", func() {
+			saveOriginal()
+
+			stage, params := latest()
+			Expect(stage).To(Equal(split.JobType))
+			Expect(params).To(Equal(&split.JobParams{
+				TrackIdentifier:  identifier,
+				SavedOriginalURL: originalURL,
+			}))
+		})
+
+		It("saves the stems when they're stored", func() {
+			saveOriginal()
+			saveStems()
+
+			stage, params := latest()
+			Expect(stage).To(Equal(save_stems_to_db.JobType))
+			Expect(params).To(Equal(&save_stems_to_db.JobParams{
+				TrackIdentifier: identifier,
+				StemURLS:        stemURLs,
+			}))
+		})
+
+		It("splits again when only some of the stems are stored", func() {
+			saveOriginal()
+			saveStems()
+			delete(fileStore.State, stemDirURL+"/bass.mp3")
+
+			stage, _ := latest()
+			Expect(stage).To(Equal(split.JobType))
+		})
+
+		It("starts from the beginning when only some of the stems and no original are stored", func() {
+			saveStems()
+			delete(fileStore.State, stemDirURL+"/bass.mp3")
+
+			stage, _ := latest()
+			Expect(stage).To(Equal(start.JobType))
+		})
+
+		It("only counts the stems of the track's split type", func() {
+			saveOriginal()
+			saveStems()
+			track = entity.SplitStemTrack{BaseTrack: entity.BaseTrack{TrackType: entity.SplitTwoStemsType}}
+
+			stage, _ := latest()
+			Expect(stage).To(Equal(split.JobType))
+		})
+
+		It("fails rather than starting over when the file store can't be reached", func() {
+			saveOriginal()
+			fileStore.Unavailable = true
+
+			_, _, err := artifacts.Latest(ctx, "tracklist-1", "track-1", track)
+			Expect(err).To(HaveOccurred())
+			Expect(cerr.CategoryOf(err)).NotTo(Equal(cerr.Permanent))
+		})
+	})
+
+	Describe("Making the params for a stage", func() {
+		It("makes them from what the stage before it saved", func() {
+			saveStems()
+
+			params, err := artifacts.Params(ctx, "tracklist-1", "track-1", track, save_stems_to_db.JobType)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(params).To(Equal(&save_stems_to_db.JobParams{
+				TrackIdentifier: identifier,
+				StemURLS:        stemURLs,
+			}))
+		})
+
+		It("makes them for a finished track from its stems' type", func() {
+			saveStems()
+			track = entity.StemTrack{BaseTrack: entity.BaseTrack{TrackType: entity.FourStemsType}}
+
+			params, err := artifacts.Params(ctx, "tracklist-1", "track-1", track, save_stems_to_db.JobType)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(params.(*save_stems_to_db.JobParams).StemURLS).To(Equal(stemURLs))
+		})
+
+		It("refuses when a stem is missing", func() {
+			saveStems()
+			delete(fileStore.State, stemDirURL+"/vocals.mp3")
+
+			_, err := artifacts.Params(ctx, "tracklist-1", "track-1", track, save_stems_to_db.JobType)
+			Expect(err).To(HaveOccurred())
+			Expect(cerr.CategoryOf(err)).To(Equal(cerr.Permanent))
+		})
+
+		It("refuses when the original hasn't been saved", func() {
+			_, err := artifacts.Params(ctx, "tracklist-1", "track-1", track, split.JobType)
+			Expect(err).To(HaveOccurred())
+			Expect(cerr.CategoryOf(err)).To(Equal(cerr.Permanent))
+		})
+
+		It("refuses stages that don't follow one that saves files", func() {
+			saveOriginal()
+
+			_, err := artifacts.Params(ctx, "tracklist-1", "track-1", track, transfer.JobType)
+			Expect(err).To(HaveOccurred())
+			Expect(cerr.CategoryOf(err)).To(Equal(cerr.Permanent))
+		})
+	})
+})
+
+var _ = Describe("Reopen", func() {
+	It("leaves split requests as they are", func() {
+		request := entity.SplitStemTrack{
+			BaseTrack: entity.BaseTrack{TrackType: entity.SplitFiveStemsType},
+			JobStatus: entity.ErrorStatus,
+		}
+
+		reopened, err := resume.Reopen(request)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reopened).To(Equal(request))
+	})
+
+	It("turns a finished track back into the request it was made from, without its stems", func() {
+		history := []entity.JobHistoryEntry{{Stage: split.JobType, Outcome: entity.JobSucceeded}}
+
+		reopened, err := resume.Reopen(entity.StemTrack{
+			BaseTrack:  entity.BaseTrack{TrackType: entity.TwoStemsType},
+			StemURLs:   map[string]string{"vocals": "vocals.mp3"},
+			JobHistory: history,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reopened).To(Equal(entity.SplitStemTrack{
+			BaseTrack:  entity.BaseTrack{TrackType: entity.SplitTwoStemsType},
+			JobHistory: history,
+		}))
+	})
+
+	It("refuses tracks that can't be split", func() {
+		_, err := resume.Reopen(entity.BaseTrack{TrackType: entity.TrackType("player")})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,13 @@
+package resume_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestResume(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Resume Suite")
+}
@@ -0,0 +1,146 @@
+package retry
+
+import (
+	"chord-paper-be-workers/src/lib/cerr"
+	"time"
+	"unicode/utf8"
+
+	"github.com/streadway/amqp"
+)
+
+// AttemptHeader counts which attempt a delivery is on, starting at 1.
+// It is set by us when scheduling a retry, rather than relying on x-death,
+// because x-death is only populated by dead lettering and not by republishing
+const AttemptHeader = "x-attempt"
+
+// ErrorHeader carries the error of the last failed attempt on dead lettered messages
+const ErrorHeader = "x-last-error"
+
+// MaxErrorHeaderSize keeps the error header, which can carry a tool's whole stderr,
+// well within the broker's frame size
+const MaxErrorHeaderSize = 4 * 1024
+
+// ErrorCategoryHeader carries the cerr category of the last failed attempt on dead lettered messages
+const ErrorCategoryHeader = "x-last-error-category"
+
+type Policy struct {
+	MaxAttempts int
+	// how long to wait before the next attempt, indexed by the attempt that just failed.
+	// the last entry is reused if there are more attempts than entries
+	Backoff []time.Duration
+}
+
+func (p Policy) Delay(failedAttempt int) time.Duration {
+	if len(p.Backoff) == 0 {
+		return 0
+	}
+
+	index := failedAttempt - 1
+	if index < 0 {
+		index = 0
+	}
+
+	if index >= len(p.Backoff) {
+		index = len(p.Backoff) - 1
+	}
+
+	return p.Backoff[index]
+}
+
+// Policies holds the retry policy for every job type,
+// job types without their own policy fall back to the default
+type Policies struct {
+	Default Policy
+	Jobs    map[string]Policy
+}
+
+// NoRetries gives every job exactly one attempt
+func NoRetries() Policies {
+	return Policies{
+		Default: Policy{MaxAttempts: 1},
+	}
+}
+
+func (p Policies) For(jobType string) Policy {
+	if policy, ok := p.Jobs[jobType]; ok {
+		return policy
+	}
+
+	if p.Default.MaxAttempts < 1 {
+		return Policy{MaxAttempts: 1}
+	}
+
+	return p.Default
+}
+
+// ShouldRetry reports whether a failed message gets another attempt,
+// which it doesn't once it's out of attempts or if its error wouldn't go any differently
+func (p Policies) ShouldRetry(message amqp.Delivery, jobErr error) bool {
+	return !p.IsFinalAttempt(message) && cerr.IsRetryable(jobErr)
+}
+
+func (p Policies) IsFinalAttempt(message amqp.Delivery) bool {
+	return Attempt(message) >= p.For(message.Type).MaxAttempts
+}
+
+func Attempt(message amqp.Delivery) int {
+	switch attempt := message.Headers[AttemptHeader].(type) {
+	case int32:
+		return int(attempt)
+	case int64:
+		return int(attempt)
+	case int:
+		return attempt
+	default:
+		return 1
+	}
+}
+
+// NextAttempt turns a failed delivery back into a publishing for its next attempt
+func NextAttempt(message amqp.Delivery) amqp.Publishing {
+	publishing := ToPublishing(message)
+	publishing.Headers[AttemptHeader] = int32(Attempt(message) + 1)
+	return publishing
+}
+
+// ErrorHeaderValue is the error as it goes into the ErrorHeader, cut down to MaxErrorHeaderSize
+func ErrorHeaderValue(err error) string {
+	const suffix = "...(truncated)"
+
+	value := err.Error()
+	if len(value) <= MaxErrorHeaderSize {
+		return value
+	}
+
+	// cut on a character boundary, so that the header stays valid UTF-8
+	end := MaxErrorHeaderSize - len(suffix)
+	for end > 0 && !utf8.RuneStart(value[end]) {
+		end--
+	}
+
+	return value[:end] + suffix
+}
+
+// ToPublishing copies a delivery into a publishing, keeping its headers and properties
+func ToPublishing(message amqp.Delivery) amqp.Publishing {
+	headers := amqp.Table{}
+	for k, v := range message.Headers {
+		headers[k] = v
+	}
+
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     message.ContentType,
+		ContentEncoding: message.ContentEncoding,
+		DeliveryMode:    message.DeliveryMode,
+		Priority:        message.Priority,
+		CorrelationId:   message.CorrelationId,
+		ReplyTo:         message.ReplyTo,
+		MessageId:       message.MessageId,
+		Timestamp:       message.Timestamp,
+		Type:            message.Type,
+		UserId:          message.UserId,
+		AppId:           message.AppId,
+		Body:            message.Body,
+	}
+}
@@ -0,0 +1,83 @@
+package retry_test
+
+import (
+	"chord-paper-be-workers/src/application/retry"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Policy", func() {
+	Describe("Delay", func() {
+		policy := retry.Policy{
+			MaxAttempts: 5,
+			Backoff:     []time.Duration{time.Second, time.Minute},
+		}
+
+		It("waits the backoff of the attempt that just failed", func() {
+			Expect(policy.Delay(1)).To(Equal(time.Second))
+			Expect(policy.Delay(2)).To(Equal(time.Minute))
+		})
+
+		It("reuses the last backoff once it runs out", func() {
+			Expect(policy.Delay(4)).To(Equal(time.Minute))
+		})
+
+		It("doesn't wait without a backoff", func() {
+			Expect(retry.Policy{MaxAttempts: 3}.Delay(1)).To(BeZero())
+		})
+	})
+
+	Describe("Policies", func() {
+		policies := retry.Policies{
+			Default: retry.Policy{MaxAttempts: 3},
+			Jobs: map[string]retry.Policy{
+				"split_track": {MaxAttempts: 1},
+			},
+		}
+
+		It("picks the job type's own policy over the default", func() {
+			Expect(policies.For("split_track").MaxAttempts).To(Equal(1))
+			Expect(policies.For("transfer_original").MaxAttempts).To(Equal(3))
+		})
+
+		It("gives every job at least one attempt", func() {
+			Expect(retry.Policies{}.For("transfer_original").MaxAttempts).To(Equal(1))
+		})
+
+		It("knows the final attempt from the attempt header", func() {
+			message := amqp.Delivery{Type: "transfer_original"}
+			Expect(policies.IsFinalAttempt(message)).To(BeFalse())
+
+			message.Headers = amqp.Table{retry.AttemptHeader: int32(3)}
+			Expect(policies.IsFinalAttempt(message)).To(BeTrue())
+		})
+	})
+
+	Describe("NextAttempt", func() {
+		It("counts the attempt up and keeps the rest of the message", func() {
+			message := amqp.Delivery{
+				Headers:   amqp.Table{"traceparent": "trace", retry.AttemptHeader: int64(2)},
+				MessageId: "message-id",
+				Type:      "transfer_original",
+				Body:      []byte("{}"),
+			}
+
+			publishing := retry.NextAttempt(message)
+			Expect(publishing.Headers).To(Equal(amqp.Table{"traceparent": "trace", retry.AttemptHeader: int32(3)}))
+			Expect(publishing.MessageId).To(Equal("message-id"))
+			Expect(publishing.Type).To(Equal("transfer_original"))
+			Expect(publishing.Body).To(Equal([]byte("{}")))
+
+			Expect(message.Headers[retry.AttemptHeader]).To(Equal(int64(2)))
+		})
+
+		It("starts counting from the first attempt", func() {
+			Expect(retry.Attempt(amqp.Delivery{})).To(Equal(1))
+			Expect(retry.NextAttempt(amqp.Delivery{}).Headers[retry.AttemptHeader]).To(Equal(int32(2)))
+		})
+	})
+})
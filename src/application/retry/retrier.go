@@ -0,0 +1,129 @@
+package retry
+
+import (
+	"chord-paper-be-workers/src/application/publish"
+	"chord-paper-be-workers/src/application/rabbitmq"
+	"chord-paper-be-workers/src/lib/cerr"
+	"chord-paper-be-workers/src/lib/logging"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/streadway/amqp"
+)
+
+var _ Retrier = &RabbitMQRetrier{}
+
+type Retrier interface {
+	// Retry schedules another attempt of a failed message, or sends it to the dead letter queue
+	// if it has no attempts left or its error says another attempt wouldn't help.
+	// Messages that failed because of what the user gave us are dropped
+	Retry(ctx context.Context, message amqp.Delivery, jobErr error) error
+}
+
+// PublisherFactory gives a publisher for the queue, which is declared with the arguments if it doesn't exist yet
+type PublisherFactory func(queueName string, queueArgs amqp.Table) publish.Publisher
+
+// RabbitMQRetrier delays retries by parking messages in a queue per job type and backoff duration.
+// Those queues have no consumers, their messages expire after the backoff and are
+// dead lettered straight back onto the job's work queue
+func NewRabbitMQRetrier(conn *rabbitmq.Connection, topology rabbitmq.Topology, policies Policies) *RabbitMQRetrier {
+	return NewRabbitMQRetrierWithPublishers(topology, policies, func(queueName string, queueArgs amqp.Table) publish.Publisher {
+		return publish.NewRabbitMQPublisherWithQueueArgs(conn, queueName, queueArgs)
+	})
+}
+
+func NewRabbitMQRetrierWithPublishers(topology rabbitmq.Topology, policies Policies, newPublisher PublisherFactory) *RabbitMQRetrier {
+	return &RabbitMQRetrier{
+		topology:     topology,
+		policies:     policies,
+		newPublisher: newPublisher,
+		publishers:   map[string]publish.Publisher{},
+	}
+}
+
+type RabbitMQRetrier struct {
+	topology     rabbitmq.Topology
+	policies     Policies
+	newPublisher PublisherFactory
+
+	mutex sync.Mutex
+	// keyed by queue name
+	publishers map[string]publish.Publisher
+}
+
+func (r *RabbitMQRetrier) Retry(ctx context.Context, message amqp.Delivery, jobErr error) error {
+	attempt := Attempt(message)
+	errctx := cerr.Field("message_type", message.Type).Field("attempt", attempt)
+
+	category := cerr.CategoryOf(jobErr)
+	errctx = errctx.Field("error_category", category)
+
+	logger := logging.FromContext(ctx)
+
+	// there's nothing for us to look into, the user has already been told
+	if category == cerr.UserInput {
+		logger.WithField("attempt", attempt).Info("Message failed on user input, dropping it")
+		return nil
+	}
+
+	if !r.policies.ShouldRetry(message, jobErr) {
+		logger.WithFields(log.Fields{
+			"attempt":        attempt,
+			"error_category": category,
+		}).Warn("Message won't be attempted again, dead lettering")
+
+		deadLetter := ToPublishing(message)
+		deadLetter.Headers[ErrorHeader] = ErrorHeaderValue(jobErr)
+		deadLetter.Headers[ErrorCategoryHeader] = string(category)
+
+		if err := r.publisherFor(r.topology.DeadLetterQueue(), nil).Publish(ctx, deadLetter); err != nil {
+			return errctx.Wrap(err).Error("Failed to publish to the dead letter queue")
+		}
+
+		return nil
+	}
+
+	delay := r.policies.For(message.Type).Delay(attempt)
+	logger.WithFields(log.Fields{
+		"attempt": attempt,
+		"delay":   delay,
+	}).Info("Scheduling retry")
+
+	var publisher publish.Publisher
+	if delay > 0 {
+		publisher = r.retryPublisher(message.Type, delay)
+	} else {
+		publisher = r.publisherFor(r.topology.JobQueue(message.Type), nil)
+	}
+
+	if err := publisher.Publish(ctx, NextAttempt(message)); err != nil {
+		return errctx.Field("delay", delay).Wrap(err).Error("Failed to publish retry")
+	}
+
+	return nil
+}
+
+func (r *RabbitMQRetrier) retryPublisher(jobType string, delay time.Duration) publish.Publisher {
+	queueArgs := amqp.Table{
+		"x-message-ttl":             delay.Milliseconds(),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": r.topology.JobQueue(jobType),
+	}
+
+	return r.publisherFor(r.topology.RetryQueue(jobType, delay), queueArgs)
+}
+
+func (r *RabbitMQRetrier) publisherFor(queueName string, queueArgs amqp.Table) publish.Publisher {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	publisher, ok := r.publishers[queueName]
+	if !ok {
+		publisher = r.newPublisher(queueName, queueArgs)
+		r.publishers[queueName] = publisher
+	}
+
+	return publisher
+}
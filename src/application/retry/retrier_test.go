@@ -0,0 +1,248 @@
+package retry_test
+
+import (
+	"chord-paper-be-workers/src/application/publish"
+	"chord-paper-be-workers/src/application/publish/publishfakes"
+	"chord-paper-be-workers/src/application/rabbitmq"
+	"chord-paper-be-workers/src/application/retry"
+	"chord-paper-be-workers/src/lib/cerr"
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RabbitMQRetrier", func() {
+	var (
+		topology rabbitmq.Topology
+		policies retry.Policies
+
+		// keyed by queue name
+		publishers map[string]*publishfakes.FakePublisher
+		queueArgs  map[string]amqp.Table
+
+		retrier *retry.RabbitMQRetrier
+		message amqp.Delivery
+		jobErr  error
+
+		publishedTo = func(queueName string) []amqp.Publishing {
+			publisher, ok := publishers[queueName]
+			if !ok {
+				return nil
+			}
+
+			published := []amqp.Publishing{}
+			for i := 0; i < publisher.PublishCallCount(); i++ {
+				_, msg := publisher.PublishArgsForCall(i)
+				published = append(published, msg)
+			}
+
+			return published
+		}
+	)
+
+	BeforeEach(func() {
+		topology = rabbitmq.NewTopology("tracks", "start_job")
+		policies = retry.Policies{
+			Default: retry.Policy{
+				MaxAttempts: 3,
+				Backoff:     []time.Duration{10 * time.Second, time.Minute},
+			},
+		}
+
+		publishers = map[string]*publishfakes.FakePublisher{}
+		queueArgs = map[string]amqp.Table{}
+
+		message = amqp.Delivery{
+			Headers:   amqp.Table{},
+			MessageId: "message-id",
+			Type:      "transfer_original",
+			Body:      []byte("{}"),
+		}
+		jobErr = cerr.Error("i failed")
+	})
+
+	JustBeforeEach(func() {
+		retrier = retry.NewRabbitMQRetrierWithPublishers(topology, policies, func(queueName string, args amqp.Table) publish.Publisher {
+			publisher := &publishfakes.FakePublisher{}
+			publishers[queueName] = publisher
+			queueArgs[queueName] = args
+			return publisher
+		})
+	})
+
+	Describe("With attempts left", func() {
+		It("parks the next attempt in a retry queue that expires back onto the job queue", func() {
+			Expect(retrier.Retry(context.Background(), message, jobErr)).To(Succeed())
+
+			Expect(publishers).To(HaveLen(1))
+			Expect(queueArgs).To(HaveKeyWithValue("tracks.transfer_original.retry.10000ms", amqp.Table{
+				"x-message-ttl":             int64(10000),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": "tracks.transfer_original",
+			}))
+
+			published := publishedTo("tracks.transfer_original.retry.10000ms")
+			Expect(published).To(HaveLen(1))
+			Expect(published[0].MessageId).To(Equal("message-id"))
+			Expect(published[0].Headers[retry.AttemptHeader]).To(Equal(int32(2)))
+		})
+
+		It("backs off further on later attempts", func() {
+			message.Headers[retry.AttemptHeader] = int32(2)
+
+			Expect(retrier.Retry(context.Background(), message, jobErr)).To(Succeed())
+
+			Expect(publishedTo("tracks.transfer_original.retry.60000ms")).To(HaveLen(1))
+		})
+
+		It("reuses the retry queue's publisher", func() {
+			Expect(retrier.Retry(context.Background(), message, jobErr)).To(Succeed())
+			Expect(retrier.Retry(context.Background(), message, jobErr)).To(Succeed())
+
+			Expect(publishers).To(HaveLen(1))
+			Expect(publishedTo("tracks.transfer_original.retry.10000ms")).To(HaveLen(2))
+		})
+
+		Describe("Without a backoff", func() {
+			BeforeEach(func() {
+				policies.Default.Backoff = nil
+			})
+
+			It("puts the next attempt straight back on the job queue", func() {
+				Expect(retrier.Retry(context.Background(), message, jobErr)).To(Succeed())
+
+				Expect(queueArgs).To(HaveKeyWithValue("tracks.transfer_original", BeNil()))
+				Expect(publishedTo("tracks.transfer_original")).To(HaveLen(1))
+			})
+		})
+
+		Describe("When the retry can't be published", func() {
+			JustBeforeEach(func() {
+				retrier = retry.NewRabbitMQRetrierWithPublishers(topology, policies, func(_ string, _ amqp.Table) publish.Publisher {
+					publisher := &publishfakes.FakePublisher{}
+					publisher.PublishReturns(cerr.Error("broker is down"))
+					return publisher
+				})
+			})
+
+			It("returns an error, so that the message is requeued instead", func() {
+				Expect(retrier.Retry(context.Background(), message, jobErr)).NotTo(Succeed())
+			})
+		})
+	})
+
+	Describe("On the final attempt", func() {
+		BeforeEach(func() {
+			message.Headers[retry.AttemptHeader] = int32(3)
+		})
+
+		It("dead letters the message with the error it failed on", func() {
+			Expect(retrier.Retry(context.Background(), message, jobErr)).To(Succeed())
+
+			Expect(publishers).To(HaveLen(1))
+			Expect(queueArgs).To(HaveKeyWithValue("tracks.dead", BeNil()))
+
+			deadLetters := publishedTo("tracks.dead")
+			Expect(deadLetters).To(HaveLen(1))
+			Expect(deadLetters[0].MessageId).To(Equal("message-id"))
+			Expect(deadLetters[0].Type).To(Equal("transfer_original"))
+			Expect(deadLetters[0].Headers[retry.AttemptHeader]).To(Equal(int32(3)))
+			Expect(deadLetters[0].Headers[retry.ErrorHeader]).To(ContainSubstring("i failed"))
+		})
+
+		It("records the category of the error it failed on", func() {
+			jobErr = cerr.Categorize(cerr.Infrastructure).Error("bucket is missing")
+
+			Expect(retrier.Retry(context.Background(), message, jobErr)).To(Succeed())
+
+			deadLetters := publishedTo("tracks.dead")
+			Expect(deadLetters).To(HaveLen(1))
+			Expect(deadLetters[0].Headers[retry.ErrorCategoryHeader]).To(Equal("infrastructure"))
+		})
+
+		It("cuts down an error too large for a header", func() {
+			jobErr = cerr.Wrap(errors.New(strings.Repeat("spleeter output ", 10000))).Error("spleeter failed")
+
+			Expect(retrier.Retry(context.Background(), message, jobErr)).To(Succeed())
+
+			deadLetters := publishedTo("tracks.dead")
+			Expect(deadLetters).To(HaveLen(1))
+
+			lastError, ok := deadLetters[0].Headers[retry.ErrorHeader].(string)
+			Expect(ok).To(BeTrue())
+			Expect(len(lastError)).To(BeNumerically("<=", retry.MaxErrorHeaderSize))
+			Expect(lastError).To(HavePrefix("spleeter failed"))
+			Expect(lastError).To(HaveSuffix("(truncated)"))
+		})
+	})
+
+	Describe("When the failure is only passing", func() {
+		BeforeEach(func() {
+			jobErr = cerr.Categorize(cerr.Transient).Error("throttled")
+		})
+
+		It("attempts the message again", func() {
+			Expect(retrier.Retry(context.Background(), message, jobErr)).To(Succeed())
+
+			Expect(publishedTo("tracks.transfer_original.retry.10000ms")).To(HaveLen(1))
+			Expect(publishedTo("tracks.dead")).To(BeEmpty())
+		})
+	})
+
+	Describe("When the failure won't go away with another attempt", func() {
+		BeforeEach(func() {
+			jobErr = cerr.Categorize(cerr.Permanent).Error("track doesn't exist")
+		})
+
+		It("dead letters the message straight away, even with attempts left", func() {
+			Expect(retrier.Retry(context.Background(), message, jobErr)).To(Succeed())
+
+			Expect(publishers).To(HaveLen(1))
+			deadLetters := publishedTo("tracks.dead")
+			Expect(deadLetters).To(HaveLen(1))
+			Expect(deadLetters[0].Headers[retry.AttemptHeader]).To(BeNil())
+			Expect(deadLetters[0].Headers[retry.ErrorHeader]).To(ContainSubstring("track doesn't exist"))
+			Expect(deadLetters[0].Headers[retry.ErrorCategoryHeader]).To(Equal("permanent"))
+		})
+
+		It("goes by the outermost category", func() {
+			jobErr = cerr.Categorize(cerr.Transient).Wrap(jobErr).Error("recategorized")
+
+			Expect(retrier.Retry(context.Background(), message, jobErr)).To(Succeed())
+
+			Expect(publishedTo("tracks.transfer_original.retry.10000ms")).To(HaveLen(1))
+			Expect(publishedTo("tracks.dead")).To(BeEmpty())
+		})
+	})
+
+	Describe("When the failure is down to what the user gave us", func() {
+		BeforeEach(func() {
+			jobErr = cerr.Categorize(cerr.UserInput).Error("URL can't be downloaded")
+		})
+
+		It("drops the message without attempting it again", func() {
+			Expect(retrier.Retry(context.Background(), message, jobErr)).To(Succeed())
+			Expect(publishers).To(BeEmpty())
+		})
+
+		It("doesn't dead letter it on the final attempt either", func() {
+			message.Headers[retry.AttemptHeader] = int32(3)
+
+			Expect(retrier.Retry(context.Background(), message, jobErr)).To(Succeed())
+			Expect(publishers).To(BeEmpty())
+		})
+
+		It("drops it even when it's wrapped in an uncategorized error", func() {
+			jobErr = cerr.Wrap(jobErr).Error("Failed to handle job")
+
+			Expect(retrier.Retry(context.Background(), message, jobErr)).To(Succeed())
+			Expect(publishers).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,118 @@
+package tracing
+
+import (
+	"chord-paper-be-workers/src/lib/cerr"
+	"context"
+	"os"
+
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const serviceName = "chord-paper-be-workers"
+
+// the trace context travels between stages in the message headers, in the W3C format
+var propagator = propagation.TraceContext{}
+
+// Start installs a tracer provider sending spans to the exporter, any SpanExporter will do.
+// Until it is called, spans are no-ops. Shutting down the provider flushes and stops the exporter
+func Start(exporter sdktrace.SpanExporter) *sdktrace.TracerProvider {
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(serviceName),
+		)),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+
+	return provider
+}
+
+// NewStdoutExporter pretty prints spans, to stdout or to a file when a path is given,
+// so traces can be looked at without any collector running
+func NewStdoutExporter(filePath string) (sdktrace.SpanExporter, error) {
+	if filePath == "" {
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	}
+
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, cerr.Field("file_path", filePath).Wrap(err).Error("Failed to open trace file")
+	}
+
+	return stdouttrace.New(stdouttrace.WithWriter(file))
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(serviceName)
+}
+
+// StartSpan starts a child span of whatever span is on the context
+func StartSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer().Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// StartJobSpan continues the trace that the message was published under, if there is one
+func StartJobSpan(ctx context.Context, message amqp.Delivery, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx = Extract(ctx, message.Headers)
+	return tracer().Start(ctx, "job "+message.Type,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attributes...))
+}
+
+// End records the error on the span, if any, before ending it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// Inject writes the trace context of the span on ctx into the message headers
+func Inject(ctx context.Context, headers amqp.Table) {
+	propagator.Inject(ctx, headerCarrier(headers))
+}
+
+func Extract(ctx context.Context, headers amqp.Table) context.Context {
+	return propagator.Extract(ctx, headerCarrier(headers))
+}
+
+// headerCarrier lets the propagator read and write AMQP headers
+type headerCarrier amqp.Table
+
+var _ propagation.TextMapCarrier = headerCarrier{}
+
+func (h headerCarrier) Get(key string) string {
+	value, ok := h[key].(string)
+	if !ok {
+		return ""
+	}
+
+	return value
+}
+
+func (h headerCarrier) Set(key string, value string) {
+	h[key] = value
+}
+
+func (h headerCarrier) Keys() []string {
+	keys := []string{}
+	for key := range h {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
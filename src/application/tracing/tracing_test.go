@@ -0,0 +1,68 @@
+package tracing_test
+
+import (
+	"chord-paper-be-workers/src/application/tracing"
+	"context"
+
+	"github.com/streadway/amqp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tracing", func() {
+	var (
+		exporter      *tracetest.InMemoryExporter
+		provider      *sdktrace.TracerProvider
+		publishingCtx context.Context
+		publishSpan   trace.Span
+	)
+
+	BeforeEach(func() {
+		exporter = tracetest.NewInMemoryExporter()
+		provider = tracing.Start(exporter)
+
+		publishingCtx, publishSpan = tracing.StartSpan(context.Background(), "transfer")
+	})
+
+	AfterEach(func() {
+		Expect(provider.Shutdown(context.Background())).To(Succeed())
+	})
+
+	It("continues the publisher's trace in the consumer's job span", func() {
+		headers := amqp.Table{}
+		tracing.Inject(publishingCtx, headers)
+		Expect(headers).To(HaveKey("traceparent"))
+
+		_, jobSpan := tracing.StartJobSpan(context.Background(), amqp.Delivery{
+			Type:    "split_track",
+			Headers: headers,
+		})
+
+		Expect(jobSpan.SpanContext().TraceID()).To(Equal(publishSpan.SpanContext().TraceID()))
+		jobSpan.End()
+		publishSpan.End()
+	})
+
+	It("starts a new trace for messages without a trace context", func() {
+		_, jobSpan := tracing.StartJobSpan(context.Background(), amqp.Delivery{
+			Type: "start_job",
+		})
+
+		Expect(jobSpan.SpanContext().IsValid()).To(BeTrue())
+		Expect(jobSpan.SpanContext().TraceID()).NotTo(Equal(publishSpan.SpanContext().TraceID()))
+		jobSpan.End()
+	})
+
+	It("exports ended spans", func() {
+		tracing.End(publishSpan, nil)
+		Expect(provider.ForceFlush(context.Background())).To(Succeed())
+
+		spans := exporter.GetSpans()
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].Name).To(Equal("transfer"))
+	})
+})
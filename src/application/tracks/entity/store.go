@@ -4,8 +4,20 @@ import "context"
 
 type TrackUpdater func(track Track) (Track, error)
 
+type TrackVisitor func(tracklistID string, trackID string, track Track) error
+
 type TrackStore interface {
 	GetTrack(ctx context.Context, tracklistID string, trackID string) (Track, error)
 	SetTrack(ctx context.Context, trackListID string, trackID string, track Track) error
+	// UpdateTrack never overwrites a write made since the track was read,
+	// so the updater can be run more than once and shouldn't have side effects
 	UpdateTrack(ctx context.Context, trackListID string, trackID string, updater TrackUpdater) error
+	// SetJobLease only writes the lease, so that heartbeats don't overwrite what the job itself is saving.
+	// A zero lease releases it
+	SetJobLease(ctx context.Context, trackListID string, trackID string, lease JobLease) error
+	// ClaimJobLease swaps an expired lease for a claimed one, but only if the lease still expires when it did.
+	// Whoever gets there first wins, everyone else gets an error
+	ClaimJobLease(ctx context.Context, trackListID string, trackID string, expired JobLease, claimed JobLease) error
+	// ScanTracks walks every track in the store, for background jobs that look for tracks in a certain state
+	ScanTracks(ctx context.Context, visitor TrackVisitor) error
 }
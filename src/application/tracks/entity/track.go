@@ -1,6 +1,9 @@
 package entity
 
-import "chord-paper-be-workers/src/lib/cerr"
+import (
+	"chord-paper-be-workers/src/lib/cerr"
+	"time"
+)
 
 type TrackType string
 
@@ -73,7 +76,9 @@ var _ Track = StemTrack{}
 
 type StemTrack struct {
 	BaseTrack
-	StemURLs map[string]string
+	StemURLs     map[string]string
+	SourceFormat SourceFormat
+	JobHistory   []JobHistoryEntry
 }
 
 var _ Track = SplitStemTrack{}
@@ -81,8 +86,93 @@ var _ Track = SplitStemTrack{}
 type SplitStemTrack struct {
 	BaseTrack
 	OriginalURL       string
+	SourceFormat      SourceFormat
 	JobStatus         SplitTrackStatus
 	JobStatusMessage  string
 	JobStatusDebugLog string
 	JobProgress       int
+	Outbox            []OutboxMessage
+	JobLease          JobLease
+	JobHistory        []JobHistoryEntry
+}
+
+// SourceFormat is what the original was when it was downloaded, before it was converted for splitting.
+// It's zero until the original has been transferred
+type SourceFormat struct {
+	Container  string
+	Codec      string
+	Duration   time.Duration
+	SampleRate int
+	Channels   int
+	// whether the audio was taken out of a video
+	FromVideo bool
+}
+
+func (s SourceFormat) IsKnown() bool {
+	return s.Container != ""
+}
+
+// JobLease is held by the job currently working on the track, and kept alive by its heartbeats.
+// It carries enough of the job to enqueue it again if its worker disappears
+type JobLease struct {
+	JobType   string
+	MessageID string
+	Body      []byte
+	Attempt   int
+	ExpiresAt time.Time
+}
+
+func (j JobLease) IsHeld() bool {
+	return !j.ExpiresAt.IsZero()
+}
+
+func (j JobLease) IsExpired(now time.Time) bool {
+	return j.IsHeld() && now.After(j.ExpiresAt)
+}
+
+// OutboxMessage is a next job that was saved together with a track update,
+// it stays on the track until it has been published
+type OutboxMessage struct {
+	ID        string
+	JobType   string
+	Headers   map[string]string
+	Body      []byte
+	CreatedAt time.Time
+}
+
+// MaxJobHistory bounds the history of a track whose jobs keep failing and being retried,
+// the oldest entries are dropped first
+const MaxJobHistory = 50
+
+type JobOutcome string
+
+const (
+	JobSucceeded   JobOutcome = "succeeded"
+	JobFailed      JobOutcome = "failed"
+	JobRetried     JobOutcome = "retried"
+	JobInterrupted JobOutcome = "interrupted"
+)
+
+// JobHistoryEntry records one attempt of a stage on the track, for working out afterwards where the time went
+type JobHistoryEntry struct {
+	Stage        string
+	Attempt      int
+	StartedAt    time.Time
+	FinishedAt   time.Time
+	Outcome      JobOutcome
+	WorkerID     string
+	ToolVersions map[string]string
+}
+
+func (j JobHistoryEntry) Duration() time.Duration {
+	return j.FinishedAt.Sub(j.StartedAt)
+}
+
+func AppendJobHistory(history []JobHistoryEntry, entry JobHistoryEntry) []JobHistoryEntry {
+	history = append(history, entry)
+	if len(history) > MaxJobHistory {
+		history = history[len(history)-MaxJobHistory:]
+	}
+
+	return history
 }
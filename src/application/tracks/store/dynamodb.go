@@ -1,12 +1,15 @@
 package store
 
 import (
+	"chord-paper-be-workers/src/application/metrics"
+	"chord-paper-be-workers/src/application/tracing"
 	"chord-paper-be-workers/src/application/tracks/entity"
 	"chord-paper-be-workers/src/lib/cerr"
 	"chord-paper-be-workers/src/lib/env"
 	"context"
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws/credentials"
 
@@ -15,6 +18,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var (
@@ -27,6 +31,13 @@ const (
 	jobStatusMessageAttr  = "job_status_message"
 	jobStatusDebugLogAttr = "job_status_debug_log"
 	jobProgressAttr       = "job_progress"
+	jobOutboxAttr         = "job_outbox"
+	jobLeaseAttr          = "job_lease"
+	jobHistoryAttr        = "job_history"
+	stemURLsAttr          = "stem_urls"
+	sourceFormatAttr      = "source_format"
+	// bumped by every write to the track, so that read-modify-writes can tell if they raced another write
+	versionAttr = "version"
 
 	newTrackTypeValueName      = ":newTrackType"
 	newStemURLsValueName       = ":newStemURLs"
@@ -34,8 +45,19 @@ const (
 	newStatusMessageValueName  = ":newStatusMessage"
 	newStatusDebugLogValueName = ":newStatusDebugLog"
 	newStatusProgressValueName = ":newStatusProgress"
+	newOutboxValueName         = ":newOutbox"
+	newLeaseValueName          = ":newLease"
+	newJobHistoryValueName     = ":newJobHistory"
+	newSourceFormatValueName   = ":newSourceFormat"
 	trackIDValueName           = ":trackID"
+	expiredAtValueName         = ":expiredAt"
+	versionValueName           = ":version"
+	zeroValueName              = ":zero"
+	oneValueName               = ":one"
 	MaxTrackIndex              = 10
+
+	// how often an update is made again on top of the writes that raced it, before giving up
+	maxUpdateAttempts = 5
 )
 
 var _ entity.TrackStore = DynamoDBTrackStore{}
@@ -67,50 +89,94 @@ type DynamoDBTrackStore struct {
 	dynamoDBClient *dynamodb.DynamoDB
 }
 
-func (d DynamoDBTrackStore) GetTrack(_ context.Context, tracklistID string, trackID string) (entity.Track, error) {
+func (d DynamoDBTrackStore) GetTrack(ctx context.Context, tracklistID string, trackID string) (entity.Track, error) {
+	defer metrics.TrackStoreCall("get_track", time.Now())
+
+	ctx, span := tracing.StartSpan(ctx, "dynamodb.get_track", attribute.String("tracklist_id", tracklistID), attribute.String("track_id", trackID))
+	defer span.End()
+
+	tracklist, err := d.getTrackList(ctx, tracklistID)
+	if err != nil {
+		return entity.BaseTrack{}, err
+	}
+
+	track, err := trackFromDynamoTrackList(trackID, tracklist)
+	if err != nil {
+		// a track that's missing or that we can't read stays that way
+		return entity.BaseTrack{}, cerr.Categorize(cerr.Permanent).
+			Wrap(err).Error("Failed to extract track from output items")
+	}
+
+	return track, nil
+}
+
+func (d DynamoDBTrackStore) getTrackList(ctx context.Context, tracklistID string) (map[string]*dynamodb.AttributeValue, error) {
 	consistentRead := true
 	key := makeKey(tracklistID)
 
-	output, err := d.dynamoDBClient.GetItem(&dynamodb.GetItemInput{
+	output, err := d.dynamoDBClient.GetItemWithContext(ctx, &dynamodb.GetItemInput{
 		ConsistentRead: &consistentRead,
 		Key:            key,
 		TableName:      &tableName,
 	})
 
 	if err != nil {
-		return entity.BaseTrack{}, cerr.Wrap(err).Error("Failed to get TrackList from DynamoDB")
+		return nil, cerr.Categorize(dynamoDBErrorCategory(err)).
+			Wrap(err).Error("Failed to get TrackList from DynamoDB")
 	}
 
-	track, err := trackFromDynamoTrackList(trackID, output.Item)
+	return output.Item, nil
+}
+
+// Ping makes a cheap read, to check that DynamoDB can be reached with our credentials
+func (d DynamoDBTrackStore) Ping(ctx context.Context) error {
+	defer metrics.TrackStoreCall("ping", time.Now())
+
+	_, err := d.dynamoDBClient.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		Key:       makeKey("healthcheck"),
+		TableName: &tableName,
+	})
+
 	if err != nil {
-		return entity.BaseTrack{}, cerr.Wrap(err).Error("Failed to extract track from output items")
+		return cerr.Categorize(dynamoDBErrorCategory(err)).
+			Wrap(err).Error("Failed to reach DynamoDB")
 	}
 
-	return track, nil
+	return nil
 }
 
 func trackFromDynamoTrackList(targetTrackID string, tracklist map[string]*dynamodb.AttributeValue) (entity.Track, error) {
+	_, trackItem, err := findTrack(targetTrackID, tracklist)
+	if err != nil {
+		return entity.BaseTrack{}, err
+	}
+
+	return trackFromDynamoTrack(trackItem)
+}
+
+// findTrack gives the track's index in the tracklist, along with the track itself
+func findTrack(targetTrackID string, tracklist map[string]*dynamodb.AttributeValue) (int, map[string]*dynamodb.AttributeValue, error) {
 	tracks, ok := tracklist["tracks"]
 	if !ok || tracks.L == nil {
-		return entity.BaseTrack{}, cerr.Error("Missing tracks field")
+		return 0, nil, cerr.Error("Missing tracks field")
 	}
 
-	for _, trackItem := range tracks.L {
+	for i, trackItem := range tracks.L {
 		if trackItem.M == nil {
-			return entity.BaseTrack{}, cerr.Error("Track is not an object")
+			return 0, nil, cerr.Error("Track is not an object")
 		}
 
 		trackID, err := getStringField(trackItem.M, "id")
 		if err != nil {
-			return entity.BaseTrack{}, cerr.Wrap(err).Error("Failed to get string field")
+			return 0, nil, cerr.Wrap(err).Error("Failed to get string field")
 		}
 
 		if trackID == targetTrackID {
-			return trackFromDynamoTrack(trackItem.M)
+			return i, trackItem.M, nil
 		}
 	}
 
-	return entity.BaseTrack{}, cerr.Error("No matching track IDs found")
+	return 0, nil, cerr.Error("No matching track IDs found")
 }
 
 func trackFromDynamoTrack(track map[string]*dynamodb.AttributeValue) (entity.Track, error) {
@@ -125,7 +191,7 @@ func trackFromDynamoTrack(track map[string]*dynamodb.AttributeValue) (entity.Tra
 		string(entity.FourStemsType),
 		string(entity.FiveStemsType):
 		{
-			return entity.BaseTrack{}, cerr.Error("Not implemented at the moment")
+			return stemTrackFromDynamoTrack(track)
 		}
 	case
 		string(entity.SplitTwoStemsType),
@@ -182,173 +248,543 @@ func splitStemTrackFromDynamoTrack(track map[string]*dynamodb.AttributeValue) (e
 		return entity.SplitStemTrack{}, cerr.Wrap(err).Error("Failed to get progress")
 	}
 
+	outbox, err := getOutboxField(track, jobOutboxAttr)
+	if err != nil {
+		return entity.SplitStemTrack{}, cerr.Wrap(err).Error("Failed to get outbox")
+	}
+
+	lease, err := getLeaseField(track, jobLeaseAttr)
+	if err != nil {
+		return entity.SplitStemTrack{}, cerr.Wrap(err).Error("Failed to get job lease")
+	}
+
+	history, err := getJobHistoryField(track, jobHistoryAttr)
+	if err != nil {
+		return entity.SplitStemTrack{}, cerr.Wrap(err).Error("Failed to get job history")
+	}
+
+	sourceFormat, err := getSourceFormatField(track, sourceFormatAttr)
+	if err != nil {
+		return entity.SplitStemTrack{}, cerr.Wrap(err).Error("Failed to get source format")
+	}
+
 	return entity.SplitStemTrack{
 		BaseTrack: entity.BaseTrack{
 			TrackType: trackType,
 		},
 		OriginalURL:       originalURL,
+		SourceFormat:      sourceFormat,
 		JobStatus:         status,
 		JobStatusMessage:  message,
 		JobStatusDebugLog: debugLog,
 		JobProgress:       progress,
+		Outbox:            outbox,
+		JobLease:          lease,
+		JobHistory:        history,
 	}, nil
 }
 
-func (d DynamoDBTrackStore) SetTrack(_ context.Context, trackListID string, trackID string, track entity.Track) error {
+func stemTrackFromDynamoTrack(track map[string]*dynamodb.AttributeValue) (entity.StemTrack, error) {
+	trackTypeVal, err := getStringField(track, "track_type")
+	if err != nil {
+		return entity.StemTrack{}, cerr.Wrap(err).Error("Failed to get track type")
+	}
+
+	trackType, err := entity.ConvertToTrackType(trackTypeVal)
+	if err != nil {
+		return entity.StemTrack{}, cerr.Wrap(err).Error("Failed to convert track type string value to enum")
+	}
+
+	stemURLsVal, ok := track[stemURLsAttr]
+	if !ok || stemURLsVal.M == nil {
+		return entity.StemTrack{}, cerr.Error("Missing stem URLs")
+	}
+
+	stemURLs := map[string]string{}
+	for stem, url := range stemURLsVal.M {
+		if url.S == nil {
+			return entity.StemTrack{}, cerr.Field("stem", stem).Error("Stem URL is not a string")
+		}
+
+		stemURLs[stem] = *url.S
+	}
+
+	history, err := getJobHistoryField(track, jobHistoryAttr)
+	if err != nil {
+		return entity.StemTrack{}, cerr.Wrap(err).Error("Failed to get job history")
+	}
+
+	sourceFormat, err := getSourceFormatField(track, sourceFormatAttr)
+	if err != nil {
+		return entity.StemTrack{}, cerr.Wrap(err).Error("Failed to get source format")
+	}
+
+	return entity.StemTrack{
+		BaseTrack: entity.BaseTrack{
+			TrackType: trackType,
+		},
+		StemURLs:     stemURLs,
+		SourceFormat: sourceFormat,
+		JobHistory:   history,
+	}, nil
+}
+
+func (d DynamoDBTrackStore) SetTrack(ctx context.Context, trackListID string, trackID string, track entity.Track) error {
+	defer metrics.TrackStoreCall("set_track", time.Now())
+
+	ctx, span := tracing.StartSpan(ctx, "dynamodb.set_track", attribute.String("tracklist_id", trackListID), attribute.String("track_id", trackID))
+	defer span.End()
+
 	switch typedTrack := track.(type) {
 	case entity.StemTrack:
-		return d.updateStemTrack(trackListID, trackID, typedTrack)
+		return d.updateStemTrack(ctx, trackListID, trackID, typedTrack)
 
 	case entity.SplitStemTrack:
-		return d.updateSplitStemTrack(trackListID, trackID, typedTrack)
+		return d.updateSplitStemTrack(ctx, trackListID, trackID, typedTrack)
 
 	default:
-		return cerr.Error("Unrecognized track type, cannot write")
+		return cerr.Categorize(cerr.Permanent).Error("Unrecognized track type, cannot write")
 	}
 }
 
+// UpdateTrack only writes the updated track if nobody else wrote it since it was read,
+// otherwise the updater is run again on what they wrote
 func (d DynamoDBTrackStore) UpdateTrack(ctx context.Context, trackListID string, trackID string, updater entity.TrackUpdater) error {
-	track, err := d.GetTrack(ctx, trackListID, trackID)
+	defer metrics.TrackStoreCall("update_track", time.Now())
+
+	ctx, span := tracing.StartSpan(ctx, "dynamodb.update_track", attribute.String("tracklist_id", trackListID), attribute.String("track_id", trackID))
+	defer span.End()
+
+	for attempt := 1; ; attempt++ {
+		tracklist, err := d.getTrackList(ctx, trackListID)
+		if err != nil {
+			return cerr.Wrap(err).Error("Failed to get track from DB")
+		}
+
+		index, trackItem, err := findTrack(trackID, tracklist)
+		if err != nil {
+			return cerr.Categorize(cerr.Permanent).Wrap(err).Error("Failed to find track in tracklist")
+		}
+
+		track, err := trackFromDynamoTrack(trackItem)
+		if err != nil {
+			return cerr.Categorize(cerr.Permanent).Wrap(err).Error("Failed to extract track from output items")
+		}
+
+		version, err := getVersionField(trackItem, versionAttr)
+		if err != nil {
+			return cerr.Categorize(cerr.Permanent).Wrap(err).Error("Failed to get track version")
+		}
+
+		updatedTrack, err := updater(track)
+		if err != nil {
+			return cerr.Wrap(err).Error("Track update function failed")
+		}
+
+		err = d.setTrackAtVersion(ctx, index, trackListID, trackID, version, updatedTrack)
+		if err == nil {
+			return nil
+		}
+
+		errctx := cerr.Field("attempt", attempt)
+		if !conditionFailed(err) {
+			return errctx.Wrap(err).Error("Failed to set the updated track")
+		}
+
+		if attempt == maxUpdateAttempts {
+			return errctx.Categorize(cerr.Transient).Wrap(err).Error("Track kept changing while it was being updated")
+		}
+	}
+}
+
+// setTrackAtVersion writes the track at the index it was read from, as long as it's still at the version it was read at
+func (d DynamoDBTrackStore) setTrackAtVersion(ctx context.Context, index int, trackListID string, trackID string, version int, track entity.Track) error {
+	var updateExpression string
+	var expressionAttributeValues map[string]*dynamodb.AttributeValue
+
+	switch typedTrack := track.(type) {
+	case entity.StemTrack:
+		updateExpression, expressionAttributeValues = stemTrackUpdate(index, typedTrack)
+
+	case entity.SplitStemTrack:
+		updateExpression, expressionAttributeValues = splitStemTrackUpdate(index, typedTrack)
+
+	default:
+		return cerr.Categorize(cerr.Permanent).Error("Unrecognized track type, cannot write")
+	}
+
+	versionExpression := fmt.Sprintf("tracks[%d].%s", index, versionAttr)
+	condition := fmt.Sprintf("attribute_not_exists(%s)", versionExpression)
+
+	if version > 0 {
+		condition = fmt.Sprintf("%s = %s", versionExpression, versionValueName)
+
+		versionValue := dynamodb.AttributeValue{}
+		versionValue.SetN(strconv.Itoa(version))
+		expressionAttributeValues[versionValueName] = &versionValue
+	}
+
+	return d.updateTrackWhere(ctx, index, trackListID, trackID, condition, updateExpression, expressionAttributeValues)
+}
+
+func (d DynamoDBTrackStore) SetJobLease(ctx context.Context, trackListID string, trackID string, lease entity.JobLease) error {
+	defer metrics.TrackStoreCall("set_job_lease", time.Now())
+
+	ctx, span := tracing.StartSpan(ctx, "dynamodb.set_job_lease", attribute.String("tracklist_id", trackListID), attribute.String("track_id", trackID))
+	defer span.End()
+
+	var err error
+	for i := 0; i < MaxTrackIndex; i++ {
+		// update every track conditionally, because we're not sure which index of the tracklist it is
+		if err = d.setJobLeaseForIndex(ctx, i, trackListID, trackID, lease); err == nil {
+			return nil
+		}
+
+		if !trackMissing(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (d DynamoDBTrackStore) setJobLeaseForIndex(ctx context.Context, index int, trackListID string, trackID string, lease entity.JobLease) error {
+	leaseExpression := fmt.Sprintf("tracks[%d].%s", index, jobLeaseAttr)
+
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{}
+	versionUpdate := bumpVersion(index, expressionAttributeValues)
+
+	updateExpression := fmt.Sprintf("SET %s REMOVE %s", versionUpdate, leaseExpression)
+	if lease.IsHeld() {
+		updateExpression = fmt.Sprintf("SET %s = %s, %s", leaseExpression, newLeaseValueName, versionUpdate)
+		expressionAttributeValues[newLeaseValueName] = convertLeaseToAttributeValue(lease)
+	}
+
+	err := d.updateTrack(ctx, index, trackListID, trackID, updateExpression, expressionAttributeValues)
 	if err != nil {
-		return cerr.Wrap(err).Error("Failed to get track from DB")
+		return cerr.Wrap(err).Error("Failed to update job lease")
+	}
+
+	return nil
+}
+
+func (d DynamoDBTrackStore) ClaimJobLease(ctx context.Context, trackListID string, trackID string, expired entity.JobLease, claimed entity.JobLease) error {
+	defer metrics.TrackStoreCall("claim_job_lease", time.Now())
+
+	ctx, span := tracing.StartSpan(ctx, "dynamodb.claim_job_lease", attribute.String("tracklist_id", trackListID), attribute.String("track_id", trackID))
+	defer span.End()
+
+	var err error
+	for i := 0; i < MaxTrackIndex; i++ {
+		// a failed condition can't tell the wrong index from a changed lease, so every index gets tried
+		if err = d.claimJobLeaseForIndex(ctx, i, trackListID, trackID, expired, claimed); err == nil {
+			return nil
+		}
+
+		if !trackMissing(err) {
+			return err
+		}
 	}
 
-	updatedTrack, err := updater(track)
+	return cerr.Wrap(err).Error("Job lease was not found as it was when it expired")
+}
+
+func (d DynamoDBTrackStore) claimJobLeaseForIndex(ctx context.Context, index int, trackListID string, trackID string, expired entity.JobLease, claimed entity.JobLease) error {
+	leaseExpression := fmt.Sprintf("tracks[%d].%s", index, jobLeaseAttr)
+
+	conditionExpression := fmt.Sprintf("%s.expires_at = %s", leaseExpression, expiredAtValueName)
+
+	expiredAt := dynamodb.AttributeValue{}
+	expiredAt.SetS(expired.ExpiresAt.UTC().Format(time.RFC3339Nano))
+
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
+		newLeaseValueName:  convertLeaseToAttributeValue(claimed),
+		expiredAtValueName: &expiredAt,
+	}
+
+	updateExpression := fmt.Sprintf("SET %s = %s, %s", leaseExpression, newLeaseValueName, bumpVersion(index, expressionAttributeValues))
+
+	err := d.updateTrackWhere(ctx, index, trackListID, trackID, conditionExpression, updateExpression, expressionAttributeValues)
 	if err != nil {
-		return cerr.Wrap(err).Error("Track update function failed")
+		return cerr.Wrap(err).Error("Failed to claim job lease")
+	}
+
+	return nil
+}
+
+func (d DynamoDBTrackStore) ScanTracks(ctx context.Context, visitor entity.TrackVisitor) error {
+	defer metrics.TrackStoreCall("scan_tracks", time.Now())
+
+	var visitErr error
+
+	err := d.dynamoDBClient.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName: &tableName,
+	}, func(output *dynamodb.ScanOutput, _ bool) bool {
+		for _, tracklist := range output.Items {
+			if visitErr = visitTrackList(tracklist, visitor); visitErr != nil {
+				return false
+			}
+		}
+
+		return true
+	})
+
+	if visitErr != nil {
+		return cerr.Wrap(visitErr).Error("Track visitor failed")
 	}
 
-	err = d.SetTrack(ctx, trackListID, trackID, updatedTrack)
 	if err != nil {
-		return cerr.Wrap(err).Error("Failed to set the updated track")
+		return cerr.Categorize(dynamoDBErrorCategory(err)).
+			Wrap(err).Error("Failed to scan TrackLists in DynamoDB")
 	}
 
 	return nil
 }
 
-func (d DynamoDBTrackStore) updateSplitStemTrack(trackListID string, trackID string, splitStemTrack entity.SplitStemTrack) error {
+func visitTrackList(tracklist map[string]*dynamodb.AttributeValue, visitor entity.TrackVisitor) error {
+	tracklistID, err := getStringField(tracklist, idField)
+	if err != nil {
+		return nil
+	}
+
+	tracks, ok := tracklist["tracks"]
+	if !ok || tracks.L == nil {
+		return nil
+	}
+
+	for _, trackItem := range tracks.L {
+		if trackItem.M == nil {
+			continue
+		}
+
+		trackID, err := getStringField(trackItem.M, "id")
+		if err != nil {
+			continue
+		}
+
+		// tracks that can't be read, e.g. finished stem tracks, aren't of interest to anyone scanning
+		track, err := trackFromDynamoTrack(trackItem.M)
+		if err != nil {
+			continue
+		}
+
+		if err := visitor(tracklistID, trackID, track); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d DynamoDBTrackStore) updateSplitStemTrack(ctx context.Context, trackListID string, trackID string, splitStemTrack entity.SplitStemTrack) error {
 	var err error
 	for i := 0; i < MaxTrackIndex; i++ {
 		// update every track conditionally, because we're not sure which index of the tracklist it is
-		if err = d.updateSplitStemTrackForIndex(i, trackListID, trackID, splitStemTrack); err == nil {
+		if err = d.updateSplitStemTrackForIndex(ctx, i, trackListID, trackID, splitStemTrack); err == nil {
 			return nil
 		}
+
+		if !trackMissing(err) {
+			return err
+		}
 	}
 
 	return err
 }
 
-func (d DynamoDBTrackStore) updateSplitStemTrackForIndex(index int, trackListID string, trackID string, splitStemTrack entity.SplitStemTrack) error {
+func (d DynamoDBTrackStore) updateSplitStemTrackForIndex(ctx context.Context, index int, trackListID string, trackID string, splitStemTrack entity.SplitStemTrack) error {
+	updateExpression, expressionAttributeValues := splitStemTrackUpdate(index, splitStemTrack)
+
+	err := d.updateTrack(ctx, index, trackListID, trackID, updateExpression, expressionAttributeValues)
+	if err != nil {
+		return cerr.Wrap(err).Error("Failed to update track")
+	}
+
+	return nil
+}
+
+func splitStemTrackUpdate(index int, splitStemTrack entity.SplitStemTrack) (string, map[string]*dynamodb.AttributeValue) {
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{}
+
 	updateExpression := func() string {
 		statusExpression := fmt.Sprintf("tracks[%d].%s", index, jobStatusAttr)
 		statusMessageExpression := fmt.Sprintf("tracks[%d].%s", index, jobStatusMessageAttr)
 		statusDebugLogExpression := fmt.Sprintf("tracks[%d].%s", index, jobStatusDebugLogAttr)
 		statusProgressExpression := fmt.Sprintf("tracks[%d].%s", index, jobProgressAttr)
+		outboxExpression := fmt.Sprintf("tracks[%d].%s", index, jobOutboxAttr)
+		historyExpression := fmt.Sprintf("tracks[%d].%s", index, jobHistoryAttr)
+		trackTypeExpression := fmt.Sprintf("tracks[%d].track_type", index)
+		stemURLsExpression := fmt.Sprintf("tracks[%d].%s", index, stemURLsAttr)
 
+		// the outbox is written in the same update as the status,
+		// so the next job is never lost once the progress has moved on.
+		// the track type is written too, for a finished track that's being split again
 		val := fmt.Sprintf(
-			"SET %s = %s, %s = %s, %s = %s, %s = %s",
+			"SET %s = %s, %s = %s, %s = %s, %s = %s, %s = %s, %s = %s, %s = %s",
+			trackTypeExpression, newTrackTypeValueName,
 			statusExpression, newStatusValueName,
 			statusMessageExpression, newStatusMessageValueName,
 			statusDebugLogExpression, newStatusDebugLogValueName,
-			statusProgressExpression, newStatusProgressValueName)
+			statusProgressExpression, newStatusProgressValueName,
+			outboxExpression, newOutboxValueName,
+			historyExpression, newJobHistoryValueName)
+
+		val = fmt.Sprintf("%s, %s", val, bumpVersion(index, expressionAttributeValues))
+
+		// tracks whose original hasn't been transferred yet have no source format to write
+		if splitStemTrack.SourceFormat.IsKnown() {
+			sourceFormatExpression := fmt.Sprintf("tracks[%d].%s", index, sourceFormatAttr)
+			val = fmt.Sprintf("%s, %s = %s", val, sourceFormatExpression, newSourceFormatValueName)
+		}
+
+		// handing off to the next stage or failing releases the lease in the same write
+		leaseExpression := fmt.Sprintf("tracks[%d].%s", index, jobLeaseAttr)
+		if splitStemTrack.JobLease.IsHeld() {
+			val = fmt.Sprintf("%s, %s = %s REMOVE %s", val, leaseExpression, newLeaseValueName, stemURLsExpression)
+		} else {
+			val = fmt.Sprintf("%s REMOVE %s, %s", val, stemURLsExpression, leaseExpression)
+		}
+
 		return val
 	}()
 
-	expressionAttributeValues := func() map[string]*dynamodb.AttributeValue {
-		newStatus := dynamodb.AttributeValue{}
-		newStatus.SetS(string(splitStemTrack.JobStatus))
+	newTrackType := dynamodb.AttributeValue{}
+	newTrackType.SetS(string(splitStemTrack.TrackType))
 
-		newStatusMessage := dynamodb.AttributeValue{}
-		newStatusMessage.SetS(splitStemTrack.JobStatusMessage)
+	newStatus := dynamodb.AttributeValue{}
+	newStatus.SetS(string(splitStemTrack.JobStatus))
 
-		newStatusDebugLog := dynamodb.AttributeValue{}
-		newStatusDebugLog.SetS(splitStemTrack.JobStatusDebugLog)
+	newStatusMessage := dynamodb.AttributeValue{}
+	newStatusMessage.SetS(splitStemTrack.JobStatusMessage)
 
-		newStatusProgress := dynamodb.AttributeValue{}
-		newStatusProgress.SetN(strconv.Itoa(splitStemTrack.JobProgress))
+	newStatusDebugLog := dynamodb.AttributeValue{}
+	newStatusDebugLog.SetS(splitStemTrack.JobStatusDebugLog)
 
-		return map[string]*dynamodb.AttributeValue{
-			newStatusValueName:         &newStatus,
-			newStatusMessageValueName:  &newStatusMessage,
-			newStatusDebugLogValueName: &newStatusDebugLog,
-			newStatusProgressValueName: &newStatusProgress,
-		}
-	}()
+	newStatusProgress := dynamodb.AttributeValue{}
+	newStatusProgress.SetN(strconv.Itoa(splitStemTrack.JobProgress))
 
-	err := d.updateTrack(index, trackListID, trackID, updateExpression, expressionAttributeValues)
+	expressionAttributeValues[newTrackTypeValueName] = &newTrackType
+	expressionAttributeValues[newStatusValueName] = &newStatus
+	expressionAttributeValues[newStatusMessageValueName] = &newStatusMessage
+	expressionAttributeValues[newStatusDebugLogValueName] = &newStatusDebugLog
+	expressionAttributeValues[newStatusProgressValueName] = &newStatusProgress
+	expressionAttributeValues[newOutboxValueName] = convertOutboxToAttributeValue(splitStemTrack.Outbox)
+	expressionAttributeValues[newJobHistoryValueName] = convertJobHistoryToAttributeValue(splitStemTrack.JobHistory)
 
-	if err != nil {
-		return cerr.Wrap(err).Error("Failed to update track")
+	if splitStemTrack.JobLease.IsHeld() {
+		expressionAttributeValues[newLeaseValueName] = convertLeaseToAttributeValue(splitStemTrack.JobLease)
 	}
 
-	return nil
+	if splitStemTrack.SourceFormat.IsKnown() {
+		expressionAttributeValues[newSourceFormatValueName] = convertSourceFormatToAttributeValue(splitStemTrack.SourceFormat)
+	}
+
+	return updateExpression, expressionAttributeValues
 }
 
-func (d DynamoDBTrackStore) updateStemTrack(trackListID string, trackID string, stemTrack entity.StemTrack) error {
+func (d DynamoDBTrackStore) updateStemTrack(ctx context.Context, trackListID string, trackID string, stemTrack entity.StemTrack) error {
 	var err error
 	for i := 0; i < MaxTrackIndex; i++ {
 		// update every track conditionally, because we're not sure which index of the tracklist it is
-		if err = d.updateStemTrackForIndex(i, trackListID, trackID, stemTrack); err == nil {
+		if err = d.updateStemTrackForIndex(ctx, i, trackListID, trackID, stemTrack); err == nil {
 			return nil
 		}
+
+		if !trackMissing(err) {
+			return err
+		}
 	}
 
 	return err
 }
 
-func (d DynamoDBTrackStore) updateStemTrackForIndex(index int, trackListID string, trackID string, stemTrack entity.StemTrack) error {
+func (d DynamoDBTrackStore) updateStemTrackForIndex(ctx context.Context, index int, trackListID string, trackID string, stemTrack entity.StemTrack) error {
+	updateExpression, expressionAttributeValues := stemTrackUpdate(index, stemTrack)
+
+	err := d.updateTrack(ctx, index, trackListID, trackID, updateExpression, expressionAttributeValues)
+	if err != nil {
+		return cerr.Wrap(err).Error("Failed to update track")
+	}
+
+	return nil
+}
+
+func stemTrackUpdate(index int, stemTrack entity.StemTrack) (string, map[string]*dynamodb.AttributeValue) {
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{}
+
 	updateExpression := func() string {
 		trackTypeExpression := fmt.Sprintf("tracks[%d].track_type", index)
-		stemURLsExpression := fmt.Sprintf("tracks[%d].stem_urls", index)
+		stemURLsExpression := fmt.Sprintf("tracks[%d].%s", index, stemURLsAttr)
+		historyExpression := fmt.Sprintf("tracks[%d].%s", index, jobHistoryAttr)
 
-		setNewValuesExpression := fmt.Sprintf("SET %s = %s, %s = %s",
+		// the history is carried over from the split request, so that it outlives the job status
+		setNewValuesExpression := fmt.Sprintf("SET %s = %s, %s = %s, %s = %s, %s",
 			trackTypeExpression, newTrackTypeValueName,
 			stemURLsExpression, newStemURLsValueName,
+			historyExpression, newJobHistoryValueName,
+			bumpVersion(index, expressionAttributeValues),
 		)
 
+		if stemTrack.SourceFormat.IsKnown() {
+			sourceFormatExpression := fmt.Sprintf("tracks[%d].%s", index, sourceFormatAttr)
+			setNewValuesExpression = fmt.Sprintf("%s, %s = %s", setNewValuesExpression, sourceFormatExpression, newSourceFormatValueName)
+		}
+
 		removeJobStatusExpression := makeRemoveJobStatusExpression(index)
 
 		return fmt.Sprintf("%s %s", setNewValuesExpression, removeJobStatusExpression)
 	}()
 
-	expressionAttributeValues := func() map[string]*dynamodb.AttributeValue {
-		newTrackType := dynamodb.AttributeValue{}
-		newTrackType.SetS(string(stemTrack.TrackType))
+	newTrackType := dynamodb.AttributeValue{}
+	newTrackType.SetS(string(stemTrack.TrackType))
 
-		newStemURLs := dynamodb.AttributeValue{}
-		newStemURLs.SetM(convertToAttributeValues(stemTrack.StemURLs))
+	newStemURLs := dynamodb.AttributeValue{}
+	newStemURLs.SetM(convertToAttributeValues(stemTrack.StemURLs))
 
-		return map[string]*dynamodb.AttributeValue{
-			newTrackTypeValueName: &newTrackType,
-			newStemURLsValueName:  &newStemURLs,
-		}
-	}()
+	expressionAttributeValues[newTrackTypeValueName] = &newTrackType
+	expressionAttributeValues[newStemURLsValueName] = &newStemURLs
+	expressionAttributeValues[newJobHistoryValueName] = convertJobHistoryToAttributeValue(stemTrack.JobHistory)
 
-	err := d.updateTrack(index, trackListID, trackID, updateExpression, expressionAttributeValues)
-
-	if err != nil {
-		return cerr.Wrap(err).Error("Failed to update track")
+	if stemTrack.SourceFormat.IsKnown() {
+		expressionAttributeValues[newSourceFormatValueName] = convertSourceFormatToAttributeValue(stemTrack.SourceFormat)
 	}
 
-	return nil
+	return updateExpression, expressionAttributeValues
 }
 
 func (d DynamoDBTrackStore) updateTrack(
+	ctx context.Context,
+	index int,
+	trackListID string,
+	trackID string,
+	updateExpression string,
+	expressionAttributeValues map[string]*dynamodb.AttributeValue,
+) error {
+	return d.updateTrackWhere(ctx, index, trackListID, trackID, "", updateExpression, expressionAttributeValues)
+}
+
+// updateTrackWhere only updates the track if the extra condition holds as well, an empty condition always holds
+func (d DynamoDBTrackStore) updateTrackWhere(
+	ctx context.Context,
 	index int,
 	trackListID string,
 	trackID string,
+	condition string,
 	updateExpression string,
 	expressionAttributeValues map[string]*dynamodb.AttributeValue,
 ) error {
 	key := makeKey(trackListID)
 
 	conditionExpression := fmt.Sprintf("tracks[%d].id = %s", index, trackIDValueName)
+	if condition != "" {
+		conditionExpression = fmt.Sprintf("%s AND %s", conditionExpression, condition)
+	}
 
 	trackIDValue := dynamodb.AttributeValue{}
 	trackIDValue.SetS(trackID)
 	expressionAttributeValues[trackIDValueName] = &trackIDValue
 
-	_, err := d.dynamoDBClient.UpdateItem(&dynamodb.UpdateItemInput{
+	_, err := d.dynamoDBClient.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
 		ConditionExpression:       &conditionExpression,
 		ExpressionAttributeValues: expressionAttributeValues,
 		Key:                       key,
@@ -357,12 +793,27 @@ func (d DynamoDBTrackStore) updateTrack(
 	})
 
 	if err != nil {
-		return cerr.Wrap(err).Error("Failed to update dynamoDB item")
+		return cerr.Categorize(dynamoDBErrorCategory(err)).
+			Wrap(err).Error("Failed to update dynamoDB item")
 	}
 
 	return nil
 }
 
+// bumpVersion is the SET action that moves the track on to its next version
+func bumpVersion(index int, expressionAttributeValues map[string]*dynamodb.AttributeValue) string {
+	zero := dynamodb.AttributeValue{}
+	zero.SetN("0")
+	one := dynamodb.AttributeValue{}
+	one.SetN("1")
+
+	expressionAttributeValues[zeroValueName] = &zero
+	expressionAttributeValues[oneValueName] = &one
+
+	versionExpression := fmt.Sprintf("tracks[%d].%s", index, versionAttr)
+	return fmt.Sprintf("%s = if_not_exists(%s, %s) + %s", versionExpression, versionExpression, zeroValueName, oneValueName)
+}
+
 func convertToAttributeValues(m map[string]string) map[string]*dynamodb.AttributeValue {
 	output := map[string]*dynamodb.AttributeValue{}
 
@@ -389,6 +840,8 @@ func makeRemoveJobStatusExpression(trackIndex int) string {
 	jobStatusMessageExpression := fmt.Sprintf("%s.%s", trackElementPrefix, jobStatusMessageAttr)
 	jobStatusDebugLogExpression := fmt.Sprintf("%s.%s", trackElementPrefix, jobStatusDebugLogAttr)
 	jobProgressExpression := fmt.Sprintf("%s.%s", trackElementPrefix, jobProgressAttr)
+	jobOutboxExpression := fmt.Sprintf("%s.%s", trackElementPrefix, jobOutboxAttr)
+	jobLeaseExpression := fmt.Sprintf("%s.%s", trackElementPrefix, jobLeaseAttr)
 
-	return fmt.Sprintf("REMOVE %s, %s, %s, %s", jobStatusExpression, jobStatusMessageExpression, jobStatusDebugLogExpression, jobProgressExpression)
+	return fmt.Sprintf("REMOVE %s, %s, %s, %s, %s, %s", jobStatusExpression, jobStatusMessageExpression, jobStatusDebugLogExpression, jobProgressExpression, jobOutboxExpression, jobLeaseExpression)
 }
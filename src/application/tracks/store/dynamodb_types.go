@@ -1,8 +1,10 @@
 package store
 
 import (
+	"chord-paper-be-workers/src/application/tracks/entity"
 	"chord-paper-be-workers/src/lib/cerr"
 	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 )
@@ -37,3 +39,336 @@ func getIntField(object map[string]*dynamodb.AttributeValue, fieldKey string) (i
 
 	return value, nil
 }
+
+// getVersionField treats a missing version as 0, tracks that were never written by us don't have one
+func getVersionField(object map[string]*dynamodb.AttributeValue, fieldKey string) (int, error) {
+	if _, ok := object[fieldKey]; !ok {
+		return 0, nil
+	}
+
+	return getIntField(object, fieldKey)
+}
+
+// getOutboxField treats a missing outbox as an empty one, tracks written before it existed don't have it
+func getOutboxField(object map[string]*dynamodb.AttributeValue, fieldKey string) ([]entity.OutboxMessage, error) {
+	outboxVal, ok := object[fieldKey]
+	if !ok || outboxVal.L == nil {
+		return nil, nil
+	}
+
+	outbox := []entity.OutboxMessage{}
+	for _, item := range outboxVal.L {
+		if item.M == nil {
+			return nil, cerr.Error("Outbox message is not an object")
+		}
+
+		id, err := getStringField(item.M, "id")
+		if err != nil {
+			return nil, cerr.Wrap(err).Error("Failed to get outbox message id")
+		}
+
+		jobType, err := getStringField(item.M, "job_type")
+		if err != nil {
+			return nil, cerr.Wrap(err).Error("Failed to get outbox message job type")
+		}
+
+		body, err := getStringField(item.M, "body")
+		if err != nil {
+			return nil, cerr.Wrap(err).Error("Failed to get outbox message body")
+		}
+
+		createdAtVal, err := getStringField(item.M, "created_at")
+		if err != nil {
+			return nil, cerr.Wrap(err).Error("Failed to get outbox message creation time")
+		}
+
+		createdAt, err := time.Parse(time.RFC3339Nano, createdAtVal)
+		if err != nil {
+			return nil, cerr.Wrap(err).Error("Failed to parse outbox message creation time")
+		}
+
+		headers := map[string]string{}
+		if headersVal, ok := item.M["headers"]; ok && headersVal.M != nil {
+			for key, value := range headersVal.M {
+				if value.S != nil {
+					headers[key] = *value.S
+				}
+			}
+		}
+
+		outbox = append(outbox, entity.OutboxMessage{
+			ID:        id,
+			JobType:   jobType,
+			Headers:   headers,
+			Body:      []byte(body),
+			CreatedAt: createdAt,
+		})
+	}
+
+	return outbox, nil
+}
+
+func convertOutboxToAttributeValue(outbox []entity.OutboxMessage) *dynamodb.AttributeValue {
+	items := []*dynamodb.AttributeValue{}
+
+	for _, message := range outbox {
+		item := convertToAttributeValues(map[string]string{
+			"id":         message.ID,
+			"job_type":   message.JobType,
+			"body":       string(message.Body),
+			"created_at": message.CreatedAt.UTC().Format(time.RFC3339Nano),
+		})
+
+		headers := dynamodb.AttributeValue{}
+		headers.SetM(convertToAttributeValues(message.Headers))
+		item["headers"] = &headers
+
+		items = append(items, &dynamodb.AttributeValue{M: item})
+	}
+
+	outboxVal := dynamodb.AttributeValue{}
+	outboxVal.SetL(items)
+	return &outboxVal
+}
+
+// getLeaseField treats a missing lease as released
+func getLeaseField(object map[string]*dynamodb.AttributeValue, fieldKey string) (entity.JobLease, error) {
+	leaseVal, ok := object[fieldKey]
+	if !ok || leaseVal.M == nil {
+		return entity.JobLease{}, nil
+	}
+
+	jobType, err := getStringField(leaseVal.M, "job_type")
+	if err != nil {
+		return entity.JobLease{}, cerr.Wrap(err).Error("Failed to get lease job type")
+	}
+
+	messageID, err := getStringField(leaseVal.M, "message_id")
+	if err != nil {
+		return entity.JobLease{}, cerr.Wrap(err).Error("Failed to get lease message id")
+	}
+
+	body, err := getStringField(leaseVal.M, "body")
+	if err != nil {
+		return entity.JobLease{}, cerr.Wrap(err).Error("Failed to get lease job body")
+	}
+
+	attempt, err := getIntField(leaseVal.M, "attempt")
+	if err != nil {
+		return entity.JobLease{}, cerr.Wrap(err).Error("Failed to get lease attempt")
+	}
+
+	expiresAtVal, err := getStringField(leaseVal.M, "expires_at")
+	if err != nil {
+		return entity.JobLease{}, cerr.Wrap(err).Error("Failed to get lease expiry")
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339Nano, expiresAtVal)
+	if err != nil {
+		return entity.JobLease{}, cerr.Wrap(err).Error("Failed to parse lease expiry")
+	}
+
+	return entity.JobLease{
+		JobType:   jobType,
+		MessageID: messageID,
+		Body:      []byte(body),
+		Attempt:   attempt,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func convertLeaseToAttributeValue(lease entity.JobLease) *dynamodb.AttributeValue {
+	leaseVal := dynamodb.AttributeValue{}
+	leaseVal.SetM(convertToAttributeValues(map[string]string{
+		"job_type":   lease.JobType,
+		"message_id": lease.MessageID,
+		"body":       string(lease.Body),
+		"expires_at": lease.ExpiresAt.UTC().Format(time.RFC3339Nano),
+	}))
+
+	attempt := dynamodb.AttributeValue{}
+	attempt.SetN(strconv.Itoa(lease.Attempt))
+	leaseVal.M["attempt"] = &attempt
+
+	return &leaseVal
+}
+
+// getJobHistoryField treats a missing history as an empty one, tracks written before it existed don't have it
+func getJobHistoryField(object map[string]*dynamodb.AttributeValue, fieldKey string) ([]entity.JobHistoryEntry, error) {
+	historyVal, ok := object[fieldKey]
+	if !ok || historyVal.L == nil {
+		return nil, nil
+	}
+
+	history := []entity.JobHistoryEntry{}
+	for _, item := range historyVal.L {
+		if item.M == nil {
+			return nil, cerr.Error("Job history entry is not an object")
+		}
+
+		stage, err := getStringField(item.M, "stage")
+		if err != nil {
+			return nil, cerr.Wrap(err).Error("Failed to get job history stage")
+		}
+
+		attempt, err := getIntField(item.M, "attempt")
+		if err != nil {
+			return nil, cerr.Wrap(err).Error("Failed to get job history attempt")
+		}
+
+		startedAt, err := getTimeField(item.M, "started_at")
+		if err != nil {
+			return nil, cerr.Wrap(err).Error("Failed to get job history start time")
+		}
+
+		finishedAt, err := getTimeField(item.M, "finished_at")
+		if err != nil {
+			return nil, cerr.Wrap(err).Error("Failed to get job history finish time")
+		}
+
+		outcome, err := getStringField(item.M, "outcome")
+		if err != nil {
+			return nil, cerr.Wrap(err).Error("Failed to get job history outcome")
+		}
+
+		workerID, err := getStringField(item.M, "worker_id")
+		if err != nil {
+			return nil, cerr.Wrap(err).Error("Failed to get job history worker id")
+		}
+
+		toolVersions := map[string]string{}
+		if toolVersionsVal, ok := item.M["tool_versions"]; ok {
+			for tool, version := range toolVersionsVal.M {
+				if version.S != nil {
+					toolVersions[tool] = *version.S
+				}
+			}
+		}
+
+		history = append(history, entity.JobHistoryEntry{
+			Stage:        stage,
+			Attempt:      attempt,
+			StartedAt:    startedAt,
+			FinishedAt:   finishedAt,
+			Outcome:      entity.JobOutcome(outcome),
+			WorkerID:     workerID,
+			ToolVersions: toolVersions,
+		})
+	}
+
+	return history, nil
+}
+
+func convertJobHistoryToAttributeValue(history []entity.JobHistoryEntry) *dynamodb.AttributeValue {
+	items := []*dynamodb.AttributeValue{}
+
+	for _, entry := range history {
+		item := convertToAttributeValues(map[string]string{
+			"stage":       entry.Stage,
+			"started_at":  entry.StartedAt.UTC().Format(time.RFC3339Nano),
+			"finished_at": entry.FinishedAt.UTC().Format(time.RFC3339Nano),
+			"outcome":     string(entry.Outcome),
+			"worker_id":   entry.WorkerID,
+		})
+
+		attempt := dynamodb.AttributeValue{}
+		attempt.SetN(strconv.Itoa(entry.Attempt))
+		item["attempt"] = &attempt
+
+		toolVersions := dynamodb.AttributeValue{}
+		toolVersions.SetM(convertToAttributeValues(entry.ToolVersions))
+		item["tool_versions"] = &toolVersions
+
+		items = append(items, &dynamodb.AttributeValue{M: item})
+	}
+
+	historyVal := dynamodb.AttributeValue{}
+	historyVal.SetL(items)
+	return &historyVal
+}
+
+// getSourceFormatField treats a missing source format as unknown, the original may not have been transferred yet
+func getSourceFormatField(object map[string]*dynamodb.AttributeValue, fieldKey string) (entity.SourceFormat, error) {
+	formatVal, ok := object[fieldKey]
+	if !ok || formatVal.M == nil {
+		return entity.SourceFormat{}, nil
+	}
+
+	container, err := getStringField(formatVal.M, "container")
+	if err != nil {
+		return entity.SourceFormat{}, cerr.Wrap(err).Error("Failed to get source container")
+	}
+
+	codec, err := getStringField(formatVal.M, "codec")
+	if err != nil {
+		return entity.SourceFormat{}, cerr.Wrap(err).Error("Failed to get source codec")
+	}
+
+	durationMillis, err := getIntField(formatVal.M, "duration_ms")
+	if err != nil {
+		return entity.SourceFormat{}, cerr.Wrap(err).Error("Failed to get source duration")
+	}
+
+	sampleRate, err := getIntField(formatVal.M, "sample_rate")
+	if err != nil {
+		return entity.SourceFormat{}, cerr.Wrap(err).Error("Failed to get source sample rate")
+	}
+
+	channels, err := getIntField(formatVal.M, "channels")
+	if err != nil {
+		return entity.SourceFormat{}, cerr.Wrap(err).Error("Failed to get source channels")
+	}
+
+	fromVideo := false
+	if fromVideoVal, ok := formatVal.M["from_video"]; ok && fromVideoVal.BOOL != nil {
+		fromVideo = *fromVideoVal.BOOL
+	}
+
+	return entity.SourceFormat{
+		Container:  container,
+		Codec:      codec,
+		Duration:   time.Duration(durationMillis) * time.Millisecond,
+		SampleRate: sampleRate,
+		Channels:   channels,
+		FromVideo:  fromVideo,
+	}, nil
+}
+
+func convertSourceFormatToAttributeValue(format entity.SourceFormat) *dynamodb.AttributeValue {
+	formatVal := dynamodb.AttributeValue{}
+	formatVal.SetM(convertToAttributeValues(map[string]string{
+		"container": format.Container,
+		"codec":     format.Codec,
+	}))
+
+	numbers := map[string]int64{
+		"duration_ms": format.Duration.Milliseconds(),
+		"sample_rate": int64(format.SampleRate),
+		"channels":    int64(format.Channels),
+	}
+	for key, number := range numbers {
+		numberVal := dynamodb.AttributeValue{}
+		numberVal.SetN(strconv.FormatInt(number, 10))
+		formatVal.M[key] = &numberVal
+	}
+
+	fromVideo := dynamodb.AttributeValue{}
+	fromVideo.SetBOOL(format.FromVideo)
+	formatVal.M["from_video"] = &fromVideo
+
+	return &formatVal
+}
+
+func getTimeField(object map[string]*dynamodb.AttributeValue, fieldKey string) (time.Time, error) {
+	timeVal, err := getStringField(object, fieldKey)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	parsed, err := time.Parse(time.RFC3339Nano, timeVal)
+	if err != nil {
+		return time.Time{}, cerr.Wrap(err).Error("Failed to parse time")
+	}
+
+	return parsed, nil
+}
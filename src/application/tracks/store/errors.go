@@ -0,0 +1,58 @@
+package store
+
+import (
+	"chord-paper-be-workers/src/lib/cerr"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// dynamoDBErrorCategory tells throttling and outages, which are worth waiting out,
+// apart from requests that will never succeed
+func dynamoDBErrorCategory(err error) cerr.Category {
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) {
+		return cerr.Transient
+	}
+
+	switch awsErr.Code() {
+	case dynamodb.ErrCodeProvisionedThroughputExceededException,
+		dynamodb.ErrCodeRequestLimitExceeded,
+		dynamodb.ErrCodeInternalServerError,
+		dynamodb.ErrCodeTransactionConflictException,
+		"ThrottlingException",
+		"ServiceUnavailable",
+		request.ErrCodeRequestError,
+		request.ErrCodeResponseTimeout,
+		request.ErrCodeSerialization:
+		return cerr.Transient
+
+	case dynamodb.ErrCodeConditionalCheckFailedException:
+		// every update is conditional on the track being at the index tried
+		return cerr.Permanent
+
+	case dynamodb.ErrCodeResourceNotFoundException,
+		"AccessDeniedException",
+		"UnrecognizedClientException":
+		return cerr.Infrastructure
+
+	default:
+		return cerr.Uncategorized
+	}
+}
+
+// trackMissing reports whether the update at one index failed in a way that's worth trying
+// the next index for. Throttling and outages would only fail there too
+func trackMissing(err error) bool {
+	category := cerr.CategoryOf(err)
+	return category != cerr.Transient && category != cerr.Infrastructure
+}
+
+// conditionFailed reports whether an update was turned down by its condition,
+// rather than by DynamoDB being unavailable
+func conditionFailed(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
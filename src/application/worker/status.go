@@ -0,0 +1,89 @@
+package worker
+
+import (
+	"chord-paper-be-workers/src/application/jobs/job_message"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// JobStatus describes a job the worker is running right now
+type JobStatus struct {
+	JobType     string
+	MessageID   string
+	TrackListID string
+	TrackID     string
+	StartedAt   time.Time
+}
+
+// activity keeps track of what the worker is up to, for reporting on it from the outside
+type activity struct {
+	mutex     sync.Mutex
+	consuming bool
+	nextJobID int
+	jobs      map[int]JobStatus
+}
+
+func newActivity() *activity {
+	return &activity{
+		jobs: map[int]JobStatus{},
+	}
+}
+
+func (a *activity) setConsuming(consuming bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.consuming = consuming
+}
+
+func (a *activity) isConsuming() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.consuming
+}
+
+// jobStarted returns a function to call once the job is done
+func (a *activity) jobStarted(message amqp.Delivery) func() {
+	// not every message has to be about a track, in which case the IDs are left empty
+	var trackParams job_message.TrackIdentifier
+	_ = json.Unmarshal(message.Body, &trackParams)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	jobID := a.nextJobID
+	a.nextJobID++
+	a.jobs[jobID] = JobStatus{
+		JobType:     message.Type,
+		MessageID:   message.MessageId,
+		TrackListID: trackParams.TrackListID,
+		TrackID:     trackParams.TrackID,
+		StartedAt:   time.Now(),
+	}
+
+	return func() {
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+		delete(a.jobs, jobID)
+	}
+}
+
+// activeJobs lists the running jobs, longest running first
+func (a *activity) activeJobs() []JobStatus {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	jobs := []JobStatus{}
+	for _, job := range a.jobs {
+		jobs = append(jobs, job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartedAt.Before(jobs[j].StartedAt)
+	})
+
+	return jobs
+}
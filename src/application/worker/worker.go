@@ -1,8 +1,18 @@
 package worker
 
 import (
+	"chord-paper-be-workers/src/application/dedup"
 	"chord-paper-be-workers/src/application/jobs/job_router"
+	"chord-paper-be-workers/src/application/rabbitmq"
+	"chord-paper-be-workers/src/application/retry"
 	"chord-paper-be-workers/src/lib/cerr"
+	"chord-paper-be-workers/src/lib/ids"
+	"chord-paper-be-workers/src/lib/logging"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/apex/log"
 
@@ -10,88 +20,529 @@ import (
 )
 
 type MessageChannel interface {
+	Qos(prefetchCount, prefetchSize int, global bool) error
 	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Cancel(consumer string, noWait bool) error
 	Close() error
 }
 
+// Queue is a queue the worker consumes, along with the job type that's published to it
+type Queue struct {
+	Name    string
+	JobType string
+}
+
+type Config struct {
+	// the maximum number of jobs handled at the same time,
+	// this is also used as the prefetch count of the channel
+	Concurrency int
+	// optional caps for specific job types, e.g. only ever 1 split job at a time,
+	// which are the prefetch counts of the consumers of their queues.
+	// Job types that aren't listed are only limited by Concurrency
+	JobConcurrency map[string]int
+	// how long in-flight jobs are given to finish on shutdown
+	// before they are cancelled and put back on the queue
+	ShutdownTimeout time.Duration
+	// how long a job's claim on its message outlives the worker, it's renewed while the job runs.
+	// Copies of the message that arrive meanwhile are skipped
+	ClaimDuration time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Concurrency:     1,
+		JobConcurrency:  map[string]int{},
+		ShutdownTimeout: 25 * time.Second,
+		ClaimDuration:   time.Minute,
+	}
+}
+
+const consumerTag = "chord-paper-be-workers"
+
+// ChannelOpener hands out a channel to consume from,
+// it is called again whenever the previous channel dies
+type ChannelOpener func(ctx context.Context) (MessageChannel, error)
+
 type QueueWorker struct {
-	channel   MessageChannel
-	jobRouter job_router.JobRouter
-	queueName string
+	openChannel ChannelOpener
+	jobRouter   job_router.JobRouter
+	retrier     retry.Retrier
+	processed   dedup.Store
+	queues      []Queue
+	config      Config
+	activity    *activity
 }
 
-func NewQueueWorker(channel MessageChannel, queueName string, jobRouter job_router.JobRouter) QueueWorker {
+// NewQueueWorker consumes from a fixed channel, if the channel goes away the worker stops
+func NewQueueWorker(channel MessageChannel, queues []Queue, jobRouter job_router.JobRouter, retrier retry.Retrier, processed dedup.Store, config Config) QueueWorker {
+	opened := false
+	openChannel := func(_ context.Context) (MessageChannel, error) {
+		if opened {
+			return nil, cerr.Error("Channel was closed and cannot be reopened")
+		}
+
+		opened = true
+		return channel, nil
+	}
+
 	return QueueWorker{
-		channel:   channel,
-		queueName: queueName,
-		jobRouter: jobRouter,
+		openChannel: openChannel,
+		queues:      queues,
+		jobRouter:   jobRouter,
+		retrier:     retrier,
+		processed:   processed,
+		config:      config,
+		activity:    newActivity(),
 	}
 }
 
-func NewQueueWorkerFromConnection(conn *amqp.Connection, queueName string, jobRouter job_router.JobRouter) (QueueWorker, error) {
-	rabbitChannel, err := conn.Channel()
-	if err != nil {
-		_ = conn.Close()
-		return QueueWorker{}, cerr.Wrap(err).Error("Failed to get channel")
-	}
+// NewQueueWorkerFromConnection consumes from channels opened on the connection,
+// carrying on with a new channel whenever the connection has to be re-established
+func NewQueueWorkerFromConnection(conn *rabbitmq.Connection, queues []Queue, jobRouter job_router.JobRouter, retrier retry.Retrier, processed dedup.Store, config Config) QueueWorker {
+	openChannel := func(ctx context.Context) (MessageChannel, error) {
+		rabbitChannel, err := conn.Channel(ctx)
+		if err != nil {
+			return nil, cerr.Wrap(err).Error("Failed to get channel")
+		}
 
-	queue, err := rabbitChannel.QueueDeclare(
-		queueName,
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
+		// redeclare every time, in case the broker came back without them
+		for _, queue := range queues {
+			_, err = rabbitChannel.QueueDeclare(
+				queue.Name,
+				true,
+				false,
+				false,
+				false,
+				nil,
+			)
 
-	if err != nil {
-		_ = rabbitChannel.Close()
-		return QueueWorker{}, cerr.Wrap(err).Error("Failed to declare queue")
+			if err != nil {
+				_ = rabbitChannel.Close()
+				return nil, cerr.Field("queue_name", queue.Name).
+					Wrap(err).Error("Failed to declare queue")
+			}
+		}
+
+		return rabbitChannel, nil
 	}
 
-	return NewQueueWorker(rabbitChannel, queue.Name, jobRouter), nil
+	return QueueWorker{
+		openChannel: openChannel,
+		queues:      queues,
+		jobRouter:   jobRouter,
+		retrier:     retrier,
+		processed:   processed,
+		config:      config,
+		activity:    newActivity(),
+	}
 }
 
-func (q *QueueWorker) Start() error {
-	log.Info("Starting worker")
+// Start consumes and handles messages until the stop context is done,
+// at which point it stops consuming and drains the in-flight jobs
+func (q *QueueWorker) Start(stop context.Context) error {
+	log.WithField("concurrency", q.config.Concurrency).Info("Starting worker")
 
-	defer q.channel.Close()
+	if q.config.Concurrency < 1 {
+		return cerr.Field("concurrency", q.config.Concurrency).
+			Error("Worker concurrency has to be at least 1")
+	}
 
-	messageStream, err := q.channel.Consume(
-		q.queueName,
-		"",
-		false,
-		false,
-		false,
-		false,
-		nil,
-	)
+	// jobs get their own root context, so that they can carry on
+	// for a while after the stop signal is received
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
 
-	if err != nil {
-		return cerr.Field("queue_name", q.queueName).
-			Wrap(err).Error("Failed to start consuming from channel")
+	pool := newJobPool(q.config)
+	wg := sync.WaitGroup{}
+
+	for {
+		channel, err := q.openChannel(stop)
+		if err != nil {
+			q.drain(&wg, cancelJobs)
+			if stop.Err() != nil {
+				return nil
+			}
+
+			return cerr.Wrap(err).Error("Failed to open a channel to consume from")
+		}
+
+		err = q.consume(stop, jobCtx, channel, pool, &wg)
+		if errors.Is(err, amqp.ErrClosed) {
+			log.Warn("Channel closed before consuming could start, trying again")
+			_ = channel.Close()
+			continue
+		}
+
+		if err != nil {
+			q.drain(&wg, cancelJobs)
+			_ = channel.Close()
+			return err
+		}
+
+		if stop.Err() != nil {
+			log.Info("Stop signal received, no longer consuming messages")
+			for _, queue := range q.queues {
+				if err := channel.Cancel(consumerTagFor(queue.Name), false); err != nil {
+					cerr.Log(cerr.Field("queue_name", queue.Name).
+						Wrap(err).Error("Failed to cancel consumer"))
+				}
+			}
+
+			// in-flight jobs still ack on this channel, so only close it after they're done
+			q.drain(&wg, cancelJobs)
+			_ = channel.Close()
+			return nil
+		}
+
+		// jobs that were running on the dead channel can't ack anymore,
+		// the broker redelivers their messages to whoever is consuming next
+		log.Warn("Delivery stream closed, consuming from a new channel")
+		_ = channel.Close()
+	}
+}
+
+// IsConsuming reports whether the worker currently has a live channel it is consuming from
+func (q *QueueWorker) IsConsuming() bool {
+	return q.activity.isConsuming()
+}
+
+// ActiveJobs lists the jobs that are being handled right now
+func (q *QueueWorker) ActiveJobs() []JobStatus {
+	return q.activity.activeJobs()
+}
+
+func (q *QueueWorker) consume(stop context.Context, jobCtx context.Context, channel MessageChannel, pool jobPool, wg *sync.WaitGroup) error {
+	// the broker won't hand out more unacked messages than we can work on at once,
+	// shared across the consumers of all the queues on this channel
+	if err := channel.Qos(q.config.Concurrency, 0, true); err != nil {
+		return cerr.Field("prefetch_count", q.config.Concurrency).
+			Wrap(err).Error("Failed to set the prefetch count on the channel")
 	}
 
-	for message := range messageStream {
-		logger := log.WithField("message_type", message.Type)
-		logger.Info("Handling message")
-		err := q.jobRouter.HandleMessage(message)
+	messageStreams := []<-chan amqp.Delivery{}
+	for _, queue := range q.queues {
+		// a capped job type is never handed more messages than it may run, so that a backlog
+		// of it can't take up the channel's prefetch and hold up the other queues.
+		// The consumer prefetch applies to the consumers started after it's set
+		consumerPrefetch := q.config.JobConcurrency[queue.JobType]
+		if consumerPrefetch < 0 {
+			consumerPrefetch = 0
+		}
+
+		if err := channel.Qos(consumerPrefetch, 0, false); err != nil {
+			return cerr.Field("queue_name", queue.Name).Field("prefetch_count", consumerPrefetch).
+				Wrap(err).Error("Failed to set the prefetch count of the consumer")
+		}
+
+		messageStream, err := channel.Consume(
+			queue.Name,
+			consumerTagFor(queue.Name),
+			false,
+			false,
+			false,
+			false,
+			nil,
+		)
+
 		if err != nil {
-			err = cerr.Field("message_type", message.Type).
-				Wrap(err).Error("Failed to process message")
+			return cerr.Field("queue_name", queue.Name).
+				Wrap(err).Error("Failed to start consuming from channel")
+		}
+
+		messageStreams = append(messageStreams, messageStream)
+	}
 
-			cerr.Log(err)
+	q.activity.setConsuming(true)
+	defer q.activity.setConsuming(false)
 
-			if err = message.Nack(false, false); err != nil {
-				logger.Error("Failed to nack message")
+	q.dispatch(stop, jobCtx, mergeStreams(stop, messageStreams), pool, wg)
+	return nil
+}
+
+func consumerTagFor(queueName string) string {
+	return fmt.Sprintf("%s-%s", consumerTag, queueName)
+}
+
+// mergeStreams funnels the deliveries of every queue into one stream,
+// which is closed once all of the queue streams are closed
+func mergeStreams(stop context.Context, messageStreams []<-chan amqp.Delivery) <-chan amqp.Delivery {
+	merged := make(chan amqp.Delivery)
+	wg := sync.WaitGroup{}
+
+	for _, messageStream := range messageStreams {
+		wg.Add(1)
+		go func(messageStream <-chan amqp.Delivery) {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-stop.Done():
+					return
+
+				case message, ok := <-messageStream:
+					if !ok {
+						return
+					}
+
+					select {
+					case merged <- message:
+					case <-stop.Done():
+						requeue(stop, message)
+						return
+					}
+				}
 			}
-		} else {
-			logger.Info("Successfully processed message")
-			if err = message.Ack(false); err != nil {
-				logger.Error("Failed to ack message")
+		}(messageStream)
+	}
+
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+
+	return merged
+}
+
+func (q *QueueWorker) dispatch(stop context.Context, jobCtx context.Context, messageStream <-chan amqp.Delivery, pool jobPool, wg *sync.WaitGroup) {
+	for {
+		select {
+		case <-stop.Done():
+			return
+
+		case message, ok := <-messageStream:
+			if !ok {
+				return
 			}
+
+			wg.Add(1)
+			go func(message amqp.Delivery) {
+				defer wg.Done()
+
+				release, ok := pool.acquire(stop, message.Type)
+				if !ok {
+					// shutting down before the job even started, leave it for another worker
+					requeue(jobCtx, message)
+					return
+				}
+				defer release()
+
+				q.handleMessage(jobCtx, message)
+			}(message)
 		}
 	}
+}
 
-	return nil
+func (q *QueueWorker) drain(wg *sync.WaitGroup, cancelJobs context.CancelFunc) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-time.After(q.config.ShutdownTimeout):
+		log.WithField("shutdown_timeout", q.config.ShutdownTimeout).
+			Warn("In-flight jobs did not finish in time, cancelling them")
+		cancelJobs()
+	}
+
+	<-done
+}
+
+func (q *QueueWorker) handleMessage(ctx context.Context, message amqp.Delivery) {
+	ctx = job_router.WithJobLogger(ctx, message)
+	logger := logging.FromContext(ctx)
+
+	// a copy of the message, e.g. the broker's redelivery next to the watchdog's rescue,
+	// only ever runs on one worker at a time
+	claimID := ids.New()
+	if !q.claim(ctx, message, claimID) {
+		logger.Info("Message was already processed or is being processed, skipping it")
+		if err := message.Ack(false); err != nil {
+			logger.Error("Failed to ack message")
+		}
+		return
+	}
+
+	logger.Info("Handling message")
+	jobDone := q.activity.jobStarted(message)
+	stopRenewing := q.keepClaim(ctx, message, claimID)
+	err := q.jobRouter.HandleMessage(ctx, message)
+	stopRenewing()
+	jobDone()
+
+	if err != nil {
+		err = cerr.Field("message_type", message.Type).
+			Wrap(err).Error("Failed to process message")
+
+		cerr.LogContext(ctx, err)
+
+		// the next attempt carries the same message ID, so it has to be able to claim it
+		q.release(ctx, message, claimID)
+
+		if ctx.Err() != nil {
+			logger.Info("Job was cancelled, requeueing message")
+			requeue(ctx, message)
+			return
+		}
+
+		// the retry is a new message, this delivery is done with either way
+		if retryErr := q.retrier.Retry(ctx, message, err); retryErr != nil {
+			cerr.LogContext(ctx, cerr.Wrap(retryErr).Error("Failed to schedule retry, requeueing message instead"))
+			requeue(ctx, message)
+			return
+		}
+
+		if err = message.Ack(false); err != nil {
+			logger.Error("Failed to ack message")
+		}
+	} else {
+		logger.Info("Successfully processed message")
+		q.markProcessed(ctx, message)
+		if err = message.Ack(false); err != nil {
+			logger.Error("Failed to ack message")
+		}
+	}
+}
+
+// claim errs on the side of running the job when the store can't be reached
+func (q *QueueWorker) claim(ctx context.Context, message amqp.Delivery, claimID string) bool {
+	if message.MessageId == "" {
+		return true
+	}
+
+	claimed, err := q.processed.Claim(ctx, message.MessageId, claimID, q.config.ClaimDuration)
+	if err != nil {
+		cerr.LogContext(ctx, cerr.Field("message_id", message.MessageId).
+			Wrap(err).Error("Failed to claim message, handling it anyway"))
+		return true
+	}
+
+	return claimed
+}
+
+// keepClaim renews the claim well before it lapses until it's stopped, so that it only lapses for a dead worker
+func (q *QueueWorker) keepClaim(ctx context.Context, message amqp.Delivery, claimID string) func() {
+	if message.MessageId == "" {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(q.config.ClaimDuration / 4)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				claimed, err := q.processed.Claim(ctx, message.MessageId, claimID, q.config.ClaimDuration)
+				if err != nil {
+					cerr.LogContext(ctx, cerr.Field("message_id", message.MessageId).
+						Wrap(err).Error("Failed to renew message claim"))
+				} else if !claimed {
+					logging.FromContext(ctx).Warn("Message claim lapsed and was taken by another worker")
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// release is best effort, a claim that's left behind lapses on its own
+func (q *QueueWorker) release(ctx context.Context, message amqp.Delivery, claimID string) {
+	if message.MessageId == "" {
+		return
+	}
+
+	// the job context might be cancelled already, so it only lends its logger here
+	if err := q.processed.Release(context.Background(), message.MessageId, claimID); err != nil {
+		cerr.LogContext(ctx, cerr.Field("message_id", message.MessageId).
+			Wrap(err).Error("Failed to release message claim"))
+	}
+}
+
+func (q *QueueWorker) markProcessed(ctx context.Context, message amqp.Delivery) {
+	if message.MessageId == "" {
+		return
+	}
+
+	if err := q.processed.MarkProcessed(ctx, message.MessageId); err != nil {
+		cerr.LogContext(ctx, cerr.Field("message_id", message.MessageId).
+			Wrap(err).Error("Failed to mark message as processed"))
+	}
+}
+
+// jobPool hands out slots for running jobs, bounded by the overall
+// concurrency as well as the per job type caps. The prefetch counts already keep
+// deliveries within them, the pool still does when jobs from a dead channel
+// are running alongside the deliveries of the new one
+type jobPool struct {
+	slots    chan struct{}
+	jobSlots map[string]chan struct{}
+}
+
+func newJobPool(config Config) jobPool {
+	jobSlots := map[string]chan struct{}{}
+	for jobType, limit := range config.JobConcurrency {
+		if limit < 1 {
+			continue
+		}
+
+		jobSlots[jobType] = make(chan struct{}, limit)
+	}
+
+	return jobPool{
+		slots:    make(chan struct{}, config.Concurrency),
+		jobSlots: jobSlots,
+	}
+}
+
+func (j jobPool) acquire(stop context.Context, jobType string) (func(), bool) {
+	// take the job type slot first, so that a job waiting on its own type
+	// isn't holding up a general slot that another job type could use
+	jobSlot, hasJobLimit := j.jobSlots[jobType]
+	if hasJobLimit {
+		select {
+		case jobSlot <- struct{}{}:
+		case <-stop.Done():
+			return nil, false
+		}
+	}
+
+	select {
+	case j.slots <- struct{}{}:
+	case <-stop.Done():
+		if hasJobLimit {
+			<-jobSlot
+		}
+		return nil, false
+	}
+
+	return func() {
+		<-j.slots
+		if hasJobLimit {
+			<-jobSlot
+		}
+	}, true
+}
+
+func requeue(ctx context.Context, message amqp.Delivery) {
+	if err := message.Nack(false, true); err != nil {
+		logging.FromContext(ctx).WithField("delivery_tag", message.DeliveryTag).
+			WithError(err).Error("Failed to requeue message")
+	}
 }
@@ -0,0 +1,383 @@
+package worker_test
+
+import (
+	"chord-paper-be-workers/src/application/dedup/dedupfakes"
+	"chord-paper-be-workers/src/application/integration_test/dummy"
+	"chord-paper-be-workers/src/application/jobs/job_message"
+	"chord-paper-be-workers/src/application/jobs/job_router"
+	"chord-paper-be-workers/src/application/lease"
+	"chord-paper-be-workers/src/application/pipeline"
+	"chord-paper-be-workers/src/application/retry"
+	"chord-paper-be-workers/src/application/tracks/entity"
+	"chord-paper-be-workers/src/application/worker"
+	"chord-paper-be-workers/src/lib/cerr"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+	"github.com/streadway/amqp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("QueueWorker", func() {
+	const analyzeJobType = "analyze_track"
+
+	var (
+		processed *dedupfakes.FakeStore
+		rabbitMQ  *dummy.RabbitMQ
+
+		mutex    sync.Mutex
+		handled  int
+		stageErr error
+		// how long the stage takes to run
+		stageDuration time.Duration
+		config        worker.Config
+
+		stopWorker context.CancelFunc
+		workerDone chan struct{}
+
+		handledCount = func() int {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return handled
+		}
+
+		// run handles a single message with the given ID on a worker whose only stage counts how often it runs
+		run = func(messageID string) {
+			trackStore := dummy.NewDummyTrackStore()
+			err := trackStore.SetTrack(context.Background(), "tracklist-id", "track-id", entity.SplitStemTrack{
+				BaseTrack: entity.BaseTrack{TrackType: entity.SplitFourStemsType},
+				JobStatus: entity.ProcessingStatus,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			registry, err := pipeline.NewRegistry(pipeline.Stage{
+				JobType:       analyzeJobType,
+				StatusMessage: "Analyzing the track",
+				Weight:        100,
+				Handler: func(_ context.Context, _ []byte) (pipeline.Output, error) {
+					time.Sleep(stageDuration)
+
+					mutex.Lock()
+					defer mutex.Unlock()
+					handled++
+					return nil, stageErr
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			router := job_router.NewJobRouter(trackStore, rabbitMQ, registry, retry.NoRetries(), lease.NewKeeper(trackStore, time.Minute), nil)
+
+			body, err := json.Marshal(job_message.TrackIdentifier{TrackListID: "tracklist-id", TrackID: "track-id"})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = rabbitMQ.Publish(context.Background(), amqp.Publishing{
+				MessageId: messageID,
+				Type:      analyzeJobType,
+				Body:      body,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			queues := []worker.Queue{{Name: "test-queue", JobType: analyzeJobType}}
+			queueWorker := worker.NewQueueWorker(rabbitMQ, queues, router, rabbitMQ, processed, config)
+
+			var stop context.Context
+			stop, stopWorker = context.WithCancel(context.Background())
+			workerDone = make(chan struct{})
+
+			go func() {
+				defer GinkgoRecover()
+				defer close(workerDone)
+				Expect(queueWorker.Start(stop)).To(Succeed())
+			}()
+
+			// failed messages are acked too, once they're handed to the retrier
+			Eventually(rabbitMQ.AckCount).Should(Equal(1))
+		}
+	)
+
+	BeforeEach(func() {
+		processed = &dedupfakes.FakeStore{}
+		processed.ClaimReturns(true, nil)
+		rabbitMQ = dummy.NewRabbitMQ()
+
+		handled = 0
+		stageErr = nil
+		stageDuration = 0
+		config = worker.DefaultConfig()
+		stopWorker = nil
+	})
+
+	AfterEach(func() {
+		if stopWorker != nil {
+			stopWorker()
+			Eventually(workerDone).Should(BeClosed())
+		}
+	})
+
+	Describe("A message that was already processed or is being processed", func() {
+		BeforeEach(func() {
+			processed.ClaimReturns(false, nil)
+			run("message-id")
+		})
+
+		It("acks the message without running its job", func() {
+			Expect(rabbitMQ.AckCount()).To(Equal(1))
+			Expect(handledCount()).To(BeZero())
+		})
+
+		It("tries to claim the message by its ID", func() {
+			Expect(processed.ClaimCallCount()).To(Equal(1))
+			_, messageID, claimID, _ := processed.ClaimArgsForCall(0)
+			Expect(messageID).To(Equal("message-id"))
+			Expect(claimID).NotTo(BeEmpty())
+		})
+
+		It("doesn't mark or release it", func() {
+			Expect(processed.MarkProcessedCallCount()).To(BeZero())
+			Expect(processed.ReleaseCallCount()).To(BeZero())
+		})
+	})
+
+	Describe("A new message", func() {
+		Describe("When its job succeeds", func() {
+			BeforeEach(func() {
+				run("message-id")
+			})
+
+			It("runs the job", func() {
+				Expect(handledCount()).To(Equal(1))
+			})
+
+			It("claims the message before running the job", func() {
+				Expect(processed.ClaimCallCount()).To(BeNumerically(">=", 1))
+				_, messageID, _, duration := processed.ClaimArgsForCall(0)
+				Expect(messageID).To(Equal("message-id"))
+				Expect(duration).To(Equal(worker.DefaultConfig().ClaimDuration))
+			})
+
+			It("marks the message as processed", func() {
+				Eventually(processed.MarkProcessedCallCount).Should(Equal(1))
+				_, messageID := processed.MarkProcessedArgsForCall(0)
+				Expect(messageID).To(Equal("message-id"))
+			})
+
+			It("keeps its claim", func() {
+				Consistently(processed.ReleaseCallCount).Should(BeZero())
+			})
+		})
+
+		Describe("When its job outlasts the claim", func() {
+			BeforeEach(func() {
+				config.ClaimDuration = 40 * time.Millisecond
+				stageDuration = 100 * time.Millisecond
+				run("message-id")
+			})
+
+			It("renews the claim while the job runs, so that it doesn't lapse", func() {
+				Expect(processed.ClaimCallCount()).To(BeNumerically(">", 1))
+
+				_, _, claimID, _ := processed.ClaimArgsForCall(0)
+				for i := 1; i < processed.ClaimCallCount(); i++ {
+					_, messageID, renewedID, duration := processed.ClaimArgsForCall(i)
+					Expect(messageID).To(Equal("message-id"))
+					Expect(renewedID).To(Equal(claimID))
+					Expect(duration).To(Equal(config.ClaimDuration))
+				}
+			})
+
+			It("stops renewing the claim once the job is done", func() {
+				renewals := processed.ClaimCallCount()
+				Consistently(processed.ClaimCallCount, 100*time.Millisecond).Should(Equal(renewals))
+			})
+		})
+
+		Describe("When its job fails", func() {
+			var (
+				handler  *memory.Handler
+				original log.Handler
+			)
+
+			BeforeEach(func() {
+				original = log.Log.(*log.Logger).Handler
+				handler = memory.New()
+				log.SetHandler(handler)
+
+				stageErr = cerr.Error("i failed")
+				run("message-id")
+			})
+
+			AfterEach(func() {
+				// the worker logs until it's stopped
+				stopWorker()
+				Eventually(workerDone).Should(BeClosed())
+				log.SetHandler(original)
+			})
+
+			It("doesn't mark the message as processed, so that its retry still runs", func() {
+				Expect(rabbitMQ.DeadLetterCount()).To(Equal(1))
+				Consistently(processed.MarkProcessedCallCount).Should(BeZero())
+			})
+
+			It("releases its claim, so that its retry can claim the message", func() {
+				Expect(processed.ReleaseCallCount()).To(Equal(1))
+				_, messageID, claimID := processed.ReleaseArgsForCall(0)
+				_, _, claimedID, _ := processed.ClaimArgsForCall(0)
+				Expect(messageID).To(Equal("message-id"))
+				Expect(claimID).To(Equal(claimedID))
+			})
+
+			It("logs the failure with the job's track", func() {
+				var failure *log.Entry
+				for _, entry := range handler.Entries {
+					if strings.HasPrefix(entry.Message, "Failed to process message") {
+						failure = entry
+					}
+				}
+
+				Expect(failure).NotTo(BeNil())
+				Expect(failure.Fields).To(HaveKeyWithValue("tracklist_id", "tracklist-id"))
+				Expect(failure.Fields).To(HaveKeyWithValue("track_id", "track-id"))
+				Expect(failure.Fields).To(HaveKeyWithValue("job_type", analyzeJobType))
+			})
+		})
+	})
+
+	Describe("When the store can't be reached", func() {
+		BeforeEach(func() {
+			processed.ClaimReturns(false, cerr.Error("store is down"))
+			processed.MarkProcessedReturns(cerr.Error("store is down"))
+			run("message-id")
+		})
+
+		It("runs the job anyway", func() {
+			Expect(handledCount()).To(Equal(1))
+		})
+
+		It("still acks the message", func() {
+			Expect(rabbitMQ.AckCount()).To(Equal(1))
+			Expect(rabbitMQ.RequeueCount()).To(BeZero())
+		})
+	})
+
+	Describe("A message without an ID", func() {
+		BeforeEach(func() {
+			run("")
+		})
+
+		It("runs the job without consulting the store", func() {
+			Expect(handledCount()).To(Equal(1))
+			Expect(processed.ClaimCallCount()).To(BeZero())
+			Expect(processed.MarkProcessedCallCount()).To(BeZero())
+			Expect(processed.ReleaseCallCount()).To(BeZero())
+		})
+	})
+
+	Describe("A job type that's at its cap", func() {
+		const (
+			splitJobType = "split_track"
+			splitQueue   = "split-queue"
+		)
+
+		var (
+			splitStarted  chan struct{}
+			releaseSplits chan struct{}
+		)
+
+		BeforeEach(func() {
+			splitStarted = make(chan struct{}, 2)
+			releaseSplits = make(chan struct{})
+
+			trackStore := dummy.NewDummyTrackStore()
+			err := trackStore.SetTrack(context.Background(), "tracklist-id", "track-id", entity.SplitStemTrack{
+				BaseTrack: entity.BaseTrack{TrackType: entity.SplitFourStemsType},
+				JobStatus: entity.ProcessingStatus,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			registry, err := pipeline.NewRegistry(
+				pipeline.Stage{
+					JobType: splitJobType,
+					Weight:  50,
+					Handler: func(ctx context.Context, _ []byte) (pipeline.Output, error) {
+						splitStarted <- struct{}{}
+						select {
+						case <-releaseSplits:
+						case <-ctx.Done():
+						}
+						return nil, nil
+					},
+				},
+				pipeline.Stage{
+					JobType: analyzeJobType,
+					Weight:  50,
+					Handler: func(_ context.Context, _ []byte) (pipeline.Output, error) {
+						mutex.Lock()
+						defer mutex.Unlock()
+						handled++
+						return nil, nil
+					},
+				},
+			)
+			Expect(err).NotTo(HaveOccurred())
+
+			router := job_router.NewJobRouter(trackStore, rabbitMQ, registry, retry.NoRetries(), lease.NewKeeper(trackStore, time.Minute), nil)
+
+			body, err := json.Marshal(job_message.TrackIdentifier{TrackListID: "tracklist-id", TrackID: "track-id"})
+			Expect(err).NotTo(HaveOccurred())
+
+			// a backlog of splits, more than the worker may run at once
+			for i := 0; i < 2; i++ {
+				rabbitMQ.PublishTo(splitQueue, amqp.Publishing{Type: splitJobType, Body: body})
+			}
+
+			queues := []worker.Queue{
+				{Name: splitQueue, JobType: splitJobType},
+				{Name: "analyze-queue", JobType: analyzeJobType},
+			}
+			config := worker.DefaultConfig()
+			config.Concurrency = 2
+			config.JobConcurrency = map[string]int{splitJobType: 1}
+
+			queueWorker := worker.NewQueueWorker(rabbitMQ, queues, router, rabbitMQ, processed, config)
+
+			var stop context.Context
+			stop, stopWorker = context.WithCancel(context.Background())
+			workerDone = make(chan struct{})
+
+			go func() {
+				defer GinkgoRecover()
+				defer close(workerDone)
+				Expect(queueWorker.Start(stop)).To(Succeed())
+			}()
+
+			Eventually(splitStarted).Should(Receive())
+
+			// published once the split is running, so that the backlog had the chance to take up the prefetch
+			Expect(rabbitMQ.Publish(context.Background(), amqp.Publishing{Type: analyzeJobType, Body: body})).To(Succeed())
+		})
+
+		AfterEach(func() {
+			close(releaseSplits)
+		})
+
+		It("doesn't run more of it than its cap", func() {
+			Consistently(splitStarted).ShouldNot(Receive())
+		})
+
+		It("doesn't hold up the other job types", func() {
+			Eventually(handledCount).Should(Equal(1))
+		})
+
+		It("gets to the rest of the backlog once the running job is done", func() {
+			releaseSplits <- struct{}{}
+			Eventually(splitStarted).Should(Receive())
+		})
+	})
+})
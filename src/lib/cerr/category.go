@@ -0,0 +1,56 @@
+package cerr
+
+import (
+	"errors"
+)
+
+// Category says what kind of failure an error is, which decides whether it's worth trying again
+// and whether it's the user's business
+type Category string
+
+const (
+	// Uncategorized errors are treated as retryable, it's how everything was before categories
+	Uncategorized Category = ""
+	// Transient failures are expected to go away by themselves, e.g. throttling or a dropped connection
+	Transient Category = "transient"
+	// Infrastructure failures are on our side and need someone to fix them, e.g. a missing bucket or binary
+	Infrastructure Category = "infrastructure"
+	// Permanent failures will fail the same way however many times they're tried, e.g. a track that doesn't exist
+	Permanent Category = "permanent"
+	// UserInput failures are down to what the user gave us, e.g. a URL that can't be downloaded
+	UserInput Category = "user_input"
+)
+
+// Retryable reports whether another attempt could go differently
+func (c Category) Retryable() bool {
+	return c != Permanent && c != UserInput
+}
+
+// Categorizer is implemented by errors that know what kind of failure they are
+type Categorizer interface {
+	Category() Category
+}
+
+func Categorize(category Category) *ErrorContext {
+	ctx := &ErrorContext{}
+	return ctx.Categorize(category)
+}
+
+// CategoryOf returns the outermost category in the error chain,
+// so that a caller who knows better can recategorize what it wraps
+func CategoryOf(err error) Category {
+	for err != nil {
+		if categorizer, ok := err.(Categorizer); ok && categorizer.Category() != Uncategorized {
+			return categorizer.Category()
+		}
+
+		err = errors.Unwrap(err)
+	}
+
+	return Uncategorized
+}
+
+// IsRetryable reports whether the error's category makes it worth another attempt
+func IsRetryable(err error) bool {
+	return CategoryOf(err).Retryable()
+}
@@ -0,0 +1,40 @@
+package cerr_test
+
+import (
+	"chord-paper-be-workers/src/lib/cerr"
+	"errors"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Categories", func() {
+	It("leaves errors uncategorized by default, which are retryable", func() {
+		err := cerr.Wrap(errors.New("plain")).Error("wrapped")
+
+		Expect(cerr.CategoryOf(err)).To(Equal(cerr.Uncategorized))
+		Expect(cerr.IsRetryable(err)).To(BeTrue())
+	})
+
+	It("finds the category through wrapping", func() {
+		inner := cerr.Categorize(cerr.Transient).Field("key", "value").Error("throttled")
+		err := fmt.Errorf("formatted: %w", cerr.Wrap(inner).Error("failed further up"))
+
+		Expect(cerr.CategoryOf(err)).To(Equal(cerr.Transient))
+		Expect(cerr.IsRetryable(err)).To(BeTrue())
+	})
+
+	It("lets a caller recategorize what it wraps", func() {
+		inner := cerr.Categorize(cerr.Transient).Error("throttled")
+		err := cerr.Categorize(cerr.Permanent).Wrap(inner).Error("gave up")
+
+		Expect(cerr.CategoryOf(err)).To(Equal(cerr.Permanent))
+		Expect(cerr.IsRetryable(err)).To(BeFalse())
+	})
+
+	It("doesn't retry failures down to the user", func() {
+		Expect(cerr.UserInput.Retryable()).To(BeFalse())
+		Expect(cerr.Infrastructure.Retryable()).To(BeTrue())
+	})
+})
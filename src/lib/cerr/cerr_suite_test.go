@@ -0,0 +1,13 @@
+package cerr_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestCerr(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Cerr Suite")
+}
@@ -9,6 +9,15 @@ import (
 //	cerr.Field("requestURL", someURL).
 //		Wrap(err).
 //		Error("Failed to connect to the homepage")
+//
+// Errors can be categorized, see Category, e.g.
+//	cerr.Categorize(cerr.Transient).Wrap(err).Error("Storage is throttling us")
+//
+// and can carry an explanation for the user, see Explain, e.g.
+//	cerr.Categorize(cerr.UserInput).Explain("The link is to a web page, not a song").Error("Source isn't audio")
+//
+// Errors record the stack they were created at, or keep the stack of the error they wrap
+// if it has one. Use NoStack to skip capturing on hot paths
 
 var _ error = ContextualError{}
 
@@ -17,11 +26,15 @@ type F map[string]interface{}
 type ContextualError struct {
 	Context ErrorContext
 	Msg     string
+	stack   Stack
 }
 
 type ErrorContext struct {
 	ContextFields map[string]interface{}
 	WrappedError  error
+	category      Category
+	explanation   string
+	noStack       bool
 }
 
 func Field(key string, value interface{}) *ErrorContext {
@@ -39,10 +52,20 @@ func Wrap(err error) *ErrorContext {
 	return ctx.Wrap(err)
 }
 
+func NoStack() *ErrorContext {
+	ctx := &ErrorContext{}
+	return ctx.NoStack()
+}
+
 func Error(msg string) ContextualError {
+	return newContextualError(ErrorContext{}, msg)
+}
+
+func newContextualError(ctx ErrorContext, msg string) ContextualError {
 	return ContextualError{
-		Context: ErrorContext{},
+		Context: ctx,
 		Msg:     msg,
+		stack:   errorStack(ctx, 2),
 	}
 }
 
@@ -54,6 +77,18 @@ func (c ContextualError) Fields() map[string]interface{} {
 	return c.Context.ContextFields
 }
 
+func (c ContextualError) Category() Category {
+	return c.Context.category
+}
+
+func (c ContextualError) Explanation() string {
+	return c.Context.explanation
+}
+
+func (c ContextualError) StackTrace() Stack {
+	return c.stack
+}
+
 func (c ContextualError) Error() string {
 	return c.String()
 }
@@ -67,10 +102,7 @@ func (c ContextualError) String() string {
 }
 
 func (e ErrorContext) Error(msg string) ContextualError {
-	return ContextualError{
-		Context: e.Clone(),
-		Msg:     msg,
-	}
+	return newContextualError(e.Clone(), msg)
 }
 
 func (e ErrorContext) Clone() ErrorContext {
@@ -82,6 +114,9 @@ func (e ErrorContext) Clone() ErrorContext {
 	return ErrorContext{
 		ContextFields: clonedFields,
 		WrappedError:  e.WrappedError,
+		category:      e.category,
+		explanation:   e.explanation,
+		noStack:       e.noStack,
 	}
 }
 
@@ -111,6 +146,25 @@ func (e *ErrorContext) Wrap(err error) *ErrorContext {
 	return &newCtx
 }
 
+func (e *ErrorContext) Categorize(category Category) *ErrorContext {
+	newCtx := e.Clone()
+	newCtx.category = category
+	return &newCtx
+}
+
+func (e *ErrorContext) Explain(explanation string) *ErrorContext {
+	newCtx := e.Clone()
+	newCtx.explanation = explanation
+	return &newCtx
+}
+
+// NoStack skips capturing a stack trace for the error, for hot paths where it isn't worth the cost
+func (e *ErrorContext) NoStack() *ErrorContext {
+	newCtx := e.Clone()
+	newCtx.noStack = true
+	return &newCtx
+}
+
 func (e *ErrorContext) ensureFields() {
 	if e.ContextFields == nil {
 		e.ContextFields = make(map[string]interface{})
@@ -0,0 +1,30 @@
+package cerr
+
+import (
+	"errors"
+)
+
+// Explainer is implemented by errors that know what the user should be told about them
+type Explainer interface {
+	Explanation() string
+}
+
+// Explain attaches a message fit for the user, for failures where the generic message
+// of the job they happened in wouldn't tell them what to do differently
+func Explain(explanation string) *ErrorContext {
+	ctx := &ErrorContext{}
+	return ctx.Explain(explanation)
+}
+
+// ExplanationOf returns the outermost explanation in the error chain, or "" if there isn't one
+func ExplanationOf(err error) string {
+	for err != nil {
+		if explainer, ok := err.(Explainer); ok && explainer.Explanation() != "" {
+			return explainer.Explanation()
+		}
+
+		err = errors.Unwrap(err)
+	}
+
+	return ""
+}
@@ -0,0 +1,33 @@
+package cerr_test
+
+import (
+	"chord-paper-be-workers/src/lib/cerr"
+	"errors"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Explanations", func() {
+	It("has no explanation by default", func() {
+		err := cerr.Wrap(errors.New("plain")).Error("wrapped")
+
+		Expect(cerr.ExplanationOf(err)).To(BeEmpty())
+	})
+
+	It("finds the explanation through wrapping", func() {
+		inner := cerr.Categorize(cerr.UserInput).Explain("That link is to a web page").Error("not audio")
+		err := fmt.Errorf("formatted: %w", cerr.Field("key", "value").Wrap(inner).Error("failed further up"))
+
+		Expect(cerr.ExplanationOf(err)).To(Equal("That link is to a web page"))
+		Expect(cerr.CategoryOf(err)).To(Equal(cerr.UserInput))
+	})
+
+	It("lets a caller explain what it wraps differently", func() {
+		inner := cerr.Explain("That link is to a web page").Error("not audio")
+		err := cerr.Explain("That song is too long").Wrap(inner).Error("gave up")
+
+		Expect(cerr.ExplanationOf(err)).To(Equal("That song is too long"))
+	})
+})
@@ -1,6 +1,8 @@
 package cerr
 
 import (
+	"chord-paper-be-workers/src/lib/logging"
+	"context"
 	"errors"
 
 	"github.com/apex/log"
@@ -10,7 +12,13 @@ type Fielder interface {
 	Fields() map[string]interface{}
 }
 
+// Log logs through the global logger, for errors that don't happen on behalf of a job
 func Log(err error) {
+	LogContext(context.Background(), err)
+}
+
+// LogContext logs through the context's logger, so that the error carries e.g. the track it happened to
+func LogContext(ctx context.Context, err error) {
 	fields := log.Fields{}
 
 	wrappedErr := err
@@ -18,7 +26,16 @@ func Log(err error) {
 		appendField(fields, wrappedErr)
 		wrappedErr = errors.Unwrap(wrappedErr)
 	}
-	log.WithFields(fields).Error(err.Error())
+
+	if category := CategoryOf(err); category != Uncategorized {
+		fields["category"] = category
+	}
+
+	if stack := StackTrace(err); len(stack) > 0 {
+		fields["stack"] = stack.Lines()
+	}
+
+	logging.FromContext(ctx).WithFields(fields).Error(err.Error())
 }
 
 func appendField(fields log.Fields, err error) {
@@ -0,0 +1,43 @@
+package cerr_test
+
+import (
+	"chord-paper-be-workers/src/lib/cerr"
+	"chord-paper-be-workers/src/lib/logging"
+	"context"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Logging errors", func() {
+	It("logs through the context's logger along with its fields", func() {
+		handler := memory.New()
+		ctx := logging.WithLogger(context.Background(), &log.Logger{Handler: handler, Level: log.InfoLevel})
+		ctx = logging.WithFields(ctx, log.Fields{"track_id": "track-1"})
+
+		cerr.LogContext(ctx, cerr.Field("key", "value").Categorize(cerr.Transient).Error("failed"))
+
+		Expect(handler.Entries).To(HaveLen(1))
+		Expect(handler.Entries[0].Level).To(Equal(log.ErrorLevel))
+		Expect(handler.Entries[0].Message).To(Equal("failed"))
+		Expect(handler.Entries[0].Fields).To(HaveKeyWithValue("track_id", "track-1"))
+		Expect(handler.Entries[0].Fields).To(HaveKeyWithValue("key", "value"))
+		Expect(handler.Entries[0].Fields).To(HaveKeyWithValue("category", cerr.Transient))
+	})
+
+	It("logs through the global logger when the context has none", func() {
+		logger := log.Log.(*log.Logger)
+		original := logger.Handler
+		defer func() { logger.Handler = original }()
+
+		handler := memory.New()
+		log.SetHandler(handler)
+
+		cerr.LogContext(context.Background(), cerr.Error("failed"))
+
+		Expect(handler.Entries).To(HaveLen(1))
+		Expect(handler.Entries[0].Message).To(Equal("failed"))
+	})
+})
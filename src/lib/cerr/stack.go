@@ -0,0 +1,107 @@
+package cerr
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync/atomic"
+)
+
+const maxStackDepth = 32
+
+// set to 1 to turn capture off, it's on by default
+var stackCaptureDisabled int32
+
+// SetStackCapture turns stack trace capture on or off for the whole process.
+// To only skip it on a hot path, use NoStack instead
+func SetStackCapture(enabled bool) {
+	disabled := int32(1)
+	if enabled {
+		disabled = 0
+	}
+
+	atomic.StoreInt32(&stackCaptureDisabled, disabled)
+}
+
+func stackCaptureEnabled() bool {
+	return atomic.LoadInt32(&stackCaptureDisabled) == 0
+}
+
+// StackTracer is implemented by errors that know where they came from
+type StackTracer interface {
+	StackTrace() Stack
+}
+
+// Stack is the call stack at the point an error was created
+type Stack []uintptr
+
+// Frames resolves the program counters into functions and source locations
+func (s Stack) Frames() []runtime.Frame {
+	if len(s) == 0 {
+		return nil
+	}
+
+	frames := []runtime.Frame{}
+	callers := runtime.CallersFrames(s)
+	for {
+		frame, more := callers.Next()
+		frames = append(frames, frame)
+
+		if !more {
+			return frames
+		}
+	}
+}
+
+// Lines formats the stack one "function file:line" entry per frame, handy for log fields
+func (s Stack) Lines() []string {
+	lines := []string{}
+	for _, frame := range s.Frames() {
+		lines = append(lines, fmt.Sprintf("%s %s:%d", frame.Function, frame.File, frame.Line))
+	}
+
+	return lines
+}
+
+func (s Stack) String() string {
+	return strings.Join(s.Lines(), "\n")
+}
+
+// StackTrace returns the stack of the deepest error in the chain that recorded one,
+// i.e. the closest to where things first went wrong
+func StackTrace(err error) Stack {
+	var stack Stack
+
+	for err != nil {
+		if tracer, ok := err.(StackTracer); ok && len(tracer.StackTrace()) > 0 {
+			stack = tracer.StackTrace()
+		}
+
+		err = errors.Unwrap(err)
+	}
+
+	return stack
+}
+
+// callerStack captures the stack of whoever called into cerr, skip counts the cerr frames on top
+func callerStack(skip int) Stack {
+	pcs := make([]uintptr, maxStackDepth)
+	n := runtime.Callers(skip+2, pcs)
+
+	return pcs[:n]
+}
+
+// errorStack decides the stack of an error about to be created, skip as for callerStack.
+// An error wrapping one that already knows its origin keeps that stack rather than capturing its own
+func errorStack(e ErrorContext, skip int) Stack {
+	if stack := StackTrace(e.WrappedError); len(stack) > 0 {
+		return stack
+	}
+
+	if e.noStack || !stackCaptureEnabled() {
+		return nil
+	}
+
+	return callerStack(skip + 1)
+}
@@ -0,0 +1,83 @@
+package cerr_test
+
+import (
+	"chord-paper-be-workers/src/lib/cerr"
+	"errors"
+	"fmt"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func failDeepDown() error {
+	return cerr.Field("key", "value").Error("failed deep down")
+}
+
+var _ = Describe("Stack traces", func() {
+	It("records where the error was created", func() {
+		err := failDeepDown()
+
+		frames := cerr.StackTrace(err).Frames()
+		Expect(frames).NotTo(BeEmpty())
+		Expect(frames[0].Function).To(HaveSuffix("cerr_test.failDeepDown"))
+		Expect(frames[0].File).To(HaveSuffix("stack_test.go"))
+	})
+
+	It("captures on the first wrap of an error that has no stack", func() {
+		err := cerr.Wrap(errors.New("plain")).Error("wrapped")
+
+		Expect(err.StackTrace()).NotTo(BeEmpty())
+		Expect(err.StackTrace().Frames()[0].File).To(HaveSuffix("stack_test.go"))
+	})
+
+	It("keeps the original stack when wrapped again", func() {
+		inner := failDeepDown()
+		outer := cerr.Wrap(inner).Error("failed further up")
+
+		Expect(outer.StackTrace()).To(Equal(cerr.StackTrace(inner)))
+		Expect(outer.StackTrace().Frames()[0].Function).To(HaveSuffix("cerr_test.failDeepDown"))
+	})
+
+	It("survives errors.Is and errors.As", func() {
+		sentinel := errors.New("sentinel")
+		err := fmt.Errorf("formatted: %w", cerr.Wrap(sentinel).Error("wrapped"))
+
+		Expect(errors.Is(err, sentinel)).To(BeTrue())
+
+		var contextual cerr.ContextualError
+		Expect(errors.As(err, &contextual)).To(BeTrue())
+		Expect(contextual.StackTrace()).NotTo(BeEmpty())
+		Expect(cerr.StackTrace(err)).To(Equal(contextual.StackTrace()))
+	})
+
+	It("skips capture with NoStack", func() {
+		Expect(cerr.NoStack().Field("key", "value").Error("hot path").StackTrace()).To(BeEmpty())
+	})
+
+	It("skips capture when turned off for the process", func() {
+		cerr.SetStackCapture(false)
+		defer cerr.SetStackCapture(true)
+
+		Expect(cerr.Error("not traced").StackTrace()).To(BeEmpty())
+	})
+
+	It("logs the stack along with the fields", func() {
+		logger := log.Log.(*log.Logger)
+		original := logger.Handler
+		defer func() { logger.Handler = original }()
+
+		handler := memory.New()
+		log.SetHandler(handler)
+
+		cerr.Log(cerr.Wrap(failDeepDown()).Error("failed further up"))
+
+		Expect(handler.Entries).To(HaveLen(1))
+		Expect(handler.Entries[0].Fields).To(HaveKeyWithValue("key", "value"))
+
+		stack, ok := handler.Entries[0].Fields["stack"].([]string)
+		Expect(ok).To(BeTrue())
+		Expect(stack[0]).To(ContainSubstring("cerr_test.failDeepDown"))
+	})
+})
@@ -0,0 +1,41 @@
+package ids
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// New returns a random 128 bit ID, hex encoded
+func New() string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		panic("Failed to read from the random source")
+	}
+
+	return hex.EncodeToString(bytes)
+}
+
+// Derive returns the same 128 bit ID every time it's given the same parts, hex encoded like New
+func Derive(parts ...string) string {
+	// the separator can't appear in a part, so that e.g. ("a:b", "c") and ("a", "b:c") differ
+	hash := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(hash[:16])
+}
+
+var workerID = func() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}()
+
+// WorkerID identifies this process, the hostname is the pod name on kubernetes
+func WorkerID() string {
+	return workerID
+}
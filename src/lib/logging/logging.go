@@ -0,0 +1,55 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/json"
+	"github.com/apex/log/handlers/text"
+)
+
+type contextKey struct{}
+
+// WithLogger attaches a logger to the context, for everything downstream to log through
+func WithLogger(ctx context.Context, logger log.Interface) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger attached to the context,
+// or the global logger when there isn't one, e.g. outside of a job
+func FromContext(ctx context.Context) log.Interface {
+	if logger, ok := ctx.Value(contextKey{}).(log.Interface); ok {
+		return logger
+	}
+
+	return log.Log
+}
+
+// WithFields adds fields to the context's logger
+func WithFields(ctx context.Context, fields log.Fields) context.Context {
+	return WithLogger(ctx, FromContext(ctx).WithFields(fields))
+}
+
+// Configure sets up the global apex logger, which every context logger is derived from.
+// format is either "json" or "text", level one of apex's level names e.g. "info".
+// cerr logs through the context's logger, so errors here can't be cerr errors
+func Configure(format string, level string) error {
+	parsedLevel, err := log.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("Unrecognized log level %q: %w", level, err)
+	}
+
+	switch format {
+	case "json":
+		log.SetHandler(json.New(os.Stderr))
+	case "text":
+		log.SetHandler(text.New(os.Stderr))
+	default:
+		return fmt.Errorf("Unrecognized log format %q", format)
+	}
+
+	log.SetLevel(parsedLevel)
+	return nil
+}
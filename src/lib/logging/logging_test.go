@@ -0,0 +1,67 @@
+package logging_test
+
+import (
+	"chord-paper-be-workers/src/lib/logging"
+	"context"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Logging", func() {
+	var (
+		handler *memory.Handler
+		ctx     context.Context
+	)
+
+	BeforeEach(func() {
+		handler = memory.New()
+		ctx = logging.WithLogger(context.Background(), &log.Logger{
+			Handler: handler,
+			Level:   log.DebugLevel,
+		})
+	})
+
+	It("falls back to the global logger when the context has none", func() {
+		Expect(logging.FromContext(context.Background())).To(Equal(log.Log))
+	})
+
+	It("logs through the logger attached to the context", func() {
+		logging.FromContext(ctx).Info("hello")
+
+		Expect(handler.Entries).To(HaveLen(1))
+		Expect(handler.Entries[0].Message).To(Equal("hello"))
+	})
+
+	It("carries added fields to everything logged downstream", func() {
+		ctx = logging.WithFields(ctx, log.Fields{"tracklist_id": "tracklist-1"})
+		ctx = logging.WithFields(ctx, log.Fields{"track_id": "track-1"})
+
+		logging.FromContext(ctx).WithField("extra", "value").Info("hello")
+
+		Expect(handler.Entries).To(HaveLen(1))
+		Expect(handler.Entries[0].Fields).To(Equal(log.Fields{
+			"tracklist_id": "tracklist-1",
+			"track_id":     "track-1",
+			"extra":        "value",
+		}))
+	})
+
+	Describe("Configure", func() {
+		AfterEach(func() {
+			Expect(logging.Configure("text", "info")).To(Succeed())
+		})
+
+		It("accepts the json and text formats", func() {
+			Expect(logging.Configure("json", "debug")).To(Succeed())
+			Expect(logging.Configure("text", "warn")).To(Succeed())
+		})
+
+		It("rejects unknown formats and levels", func() {
+			Expect(logging.Configure("xml", "info")).NotTo(Succeed())
+			Expect(logging.Configure("json", "loud")).NotTo(Succeed())
+		})
+	})
+})
@@ -2,11 +2,18 @@ package main
 
 import (
 	"chord-paper-be-workers/src/application"
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
+	stop, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer cancel()
+
 	app := application.NewApp()
-	if err := app.Start(); err != nil {
+	if err := app.Start(stop); err != nil {
 		panic(err)
 	}
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"chord-paper-be-workers/src/application"
+	"chord-paper-be-workers/src/application/rabbitmq"
+	"chord-paper-be-workers/src/lib/cerr"
+	"context"
+	"time"
+)
+
+// the connection retries forever, which is right for a worker but not for someone at a terminal
+const connectTimeout = 30 * time.Second
+
+// deadQueue is what the dead letter queue is called on the command line,
+// any other queue is named by its job type
+const deadQueue = "dead"
+
+type broker struct {
+	conn     *rabbitmq.Connection
+	topology rabbitmq.Topology
+}
+
+func connect(ctx context.Context) (broker, error) {
+	conn := rabbitmq.NewConnection(application.RabbitURL())
+
+	dialCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+
+	if err := conn.Connect(dialCtx); err != nil {
+		return broker{}, cerr.Wrap(err).Error("Failed to connect to RabbitMQ")
+	}
+
+	return broker{
+		conn:     conn,
+		topology: application.Topology(),
+	}, nil
+}
+
+func (b broker) Close() {
+	_ = b.conn.Close()
+}
+
+func (b broker) queueName(name string) (string, error) {
+	if name == deadQueue {
+		return b.topology.DeadLetterQueue(), nil
+	}
+
+	if _, ok := stages.Stage(name); ok {
+		return b.topology.JobQueue(name), nil
+	}
+
+	return "", usagef("Unknown queue %q, expected %q or one of the stages %v", name, deadQueue, stages.JobTypes())
+}
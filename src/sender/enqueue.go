@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"chord-paper-be-workers/src/application"
+	"chord-paper-be-workers/src/application/jobs/job_message"
+	"chord-paper-be-workers/src/application/jobs/start"
+	"chord-paper-be-workers/src/application/publish"
+	"chord-paper-be-workers/src/lib/cerr"
+	"chord-paper-be-workers/src/lib/ids"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// stages are what can be enqueued, custom params are decoded into the stage's own
+// params so that they're checked against what the job actually reads
+var stages = application.Pipeline()
+
+// parseParams rejects params with fields the stage doesn't know about or without a track to work on
+func parseParams(stage string, rawParams []byte) (interface{}, error) {
+	pipelineStage, ok := stages.Stage(stage)
+	if !ok {
+		return nil, usagef("Unknown stage %q, expected one of %v", stage, stages.JobTypes())
+	}
+
+	params, err := pipelineStage.ParseParams(rawParams)
+	if err != nil {
+		// the decoding error on its own says what's wrong with the params
+		if cause := errors.Unwrap(err); cause != nil {
+			err = cause
+		}
+
+		return nil, usagef("Params don't match the %s job: %s", stage, err)
+	}
+
+	trackIdentifier := job_message.TrackIdentifier{}
+	if err := json.Unmarshal(rawParams, &trackIdentifier); err != nil {
+		return nil, usagef("Params don't match the %s job: %s", stage, err)
+	}
+
+	if trackIdentifier.TrackListID == "" || trackIdentifier.TrackID == "" {
+		return nil, usagef("Params need both a tracklist_id and a track_id")
+	}
+
+	return params, nil
+}
+
+func enqueueStart(ctx context.Context, args []string) error {
+	flags := newFlagSet("enqueue-start")
+	tracklistID := flags.String("tracklist-id", "", "the tracklist the track is in")
+	trackID := flags.String("track-id", "", "the track to process")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if *tracklistID == "" || *trackID == "" {
+		return usagef("Both -tracklist-id and -track-id are required")
+	}
+
+	params := start.JobParams{
+		TrackIdentifier: job_message.TrackIdentifier{
+			TrackListID: *tracklistID,
+			TrackID:     *trackID,
+		},
+	}
+
+	return publishJobs(ctx, start.JobType, []interface{}{params})
+}
+
+func enqueue(ctx context.Context, args []string) error {
+	flags := newFlagSet("enqueue")
+	stage := flags.String("stage", "", fmt.Sprintf("the job type to enqueue, one of %v", stages.JobTypes()))
+	rawParams := flags.String("params", "", `the job params as JSON, e.g. {"tracklist_id": "...", "track_id": "..."}`)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if *stage == "" || *rawParams == "" {
+		return usagef("Both -stage and -params are required")
+	}
+
+	params, err := parseParams(*stage, []byte(*rawParams))
+	if err != nil {
+		return err
+	}
+
+	return publishJobs(ctx, *stage, []interface{}{params})
+}
+
+// bulkEnqueue checks every line before publishing anything,
+// so that a typo halfway through the file doesn't leave it half enqueued
+func bulkEnqueue(ctx context.Context, args []string) error {
+	flags := newFlagSet("bulk")
+	path := flags.String("file", "", "a JSONL file with the params of one job per line, - for stdin")
+	stage := flags.String("stage", start.JobType, fmt.Sprintf("the job type to enqueue, one of %v", stages.JobTypes()))
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if *path == "" {
+		return usagef("-file is required")
+	}
+
+	var input io.Reader = os.Stdin
+	if *path != "-" {
+		file, err := os.Open(*path)
+		if err != nil {
+			return cerr.Field("path", *path).Wrap(err).Error("Failed to open file")
+		}
+		defer file.Close()
+
+		input = file
+	}
+
+	allParams := []interface{}{}
+	scanner := bufio.NewScanner(input)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		params, err := parseParams(*stage, line)
+		if err != nil {
+			return usagef("Line %d: %s", lineNumber, err)
+		}
+
+		allParams = append(allParams, params)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return cerr.Field("path", *path).Wrap(err).Error("Failed to read file")
+	}
+
+	return publishJobs(ctx, *stage, allParams)
+}
+
+func publishJobs(ctx context.Context, jobType string, allParams []interface{}) error {
+	b, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	publisher := publish.NewRoutingPublisher(b.conn, b.topology)
+
+	for i, params := range allParams {
+		errctx := cerr.Field("job_type", jobType).Field("job_params", params)
+
+		// every enqueue is a deliberate run, a track that's enqueued again has to be processed again
+		message, err := job_message.NewPublishing(ctx, jobType, ids.New(), params)
+		if err != nil {
+			return errctx.Wrap(err).Error("Failed to create job message")
+		}
+
+		if err := publisher.Publish(ctx, message); err != nil {
+			return errctx.Field("enqueued", i).Wrap(err).Error("Failed to publish job message")
+		}
+
+		fmt.Printf("Enqueued %s job %s on %s\n", jobType, message.MessageId, b.topology.JobQueue(jobType))
+	}
+
+	return nil
+}
@@ -1,66 +1,115 @@
+// The sender is the operator CLI for the job queues, it reads the same
+// ENVIRONMENT, RABBITMQ_URL and RABBITMQ_QUEUE_NAME env vars as the workers
+//
+//	sender <command> [flags]
 package main
 
 import (
-	"chord-paper-be-workers/src/application/jobs/job_message"
-	"chord-paper-be-workers/src/application/jobs/start"
-	"encoding/json"
+	"chord-paper-be-workers/src/lib/cerr"
+	"context"
+	"flag"
+	"fmt"
 	"os"
-
-	"github.com/streadway/amqp"
+	"os/signal"
+	"sort"
+	"syscall"
 )
 
+type command struct {
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"enqueue-start": {"enqueue a start job for a track", enqueueStart},
+	"enqueue":       {"enqueue a job for any stage with custom params", enqueue},
+	"bulk":          {"enqueue a job for every line of a JSONL file of params", bulkEnqueue},
+	"status":        {"show a track's status and job history", status},
+	"peek":          {"show messages on a queue without taking them off it", peek},
+	"purge":         {"delete every message on a queue", purge},
+	"move":          {"move messages between a job queue and the dead letter queue", move},
+}
+
+// usageError is a mistake in the command line, rather than something that went wrong running it
+type usageError struct {
+	msg string
+}
+
+func (u usageError) Error() string {
+	return u.msg
+}
+
+func usagef(format string, args ...interface{}) error {
+	return usageError{msg: fmt.Sprintf(format, args...)}
+}
+
 func main() {
-	rabbitURL := os.Getenv("RABBITMQ_URL")
-	if rabbitURL == "" {
-		panic("Can't get rabbitmq url")
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
 	}
 
-	conn, err := amqp.Dial(rabbitURL)
-	if err != nil {
-		panic(err)
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %s\n\n", os.Args[1])
+		printUsage()
+		os.Exit(2)
 	}
-	defer conn.Close()
 
-	rabbitChannel, err := conn.Channel()
-	if err != nil {
-		panic(err)
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer cancel()
+
+	err := cmd.run(ctx, os.Args[2:])
+	if err == nil {
+		return
 	}
-	defer rabbitChannel.Close()
-
-	queue, err := rabbitChannel.QueueDeclare(
-		"chord-paper-dev",
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
-
-	if err != nil {
-		panic(err)
+
+	if _, ok := err.(usageError); ok {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
 
-	startJobParams := start.JobParams{
-		TrackIdentifier: job_message.TrackIdentifier{
-			TrackListID: "ad2fca6d-8c32-4030-86c0-8b5339347253",
-			TrackID:     "440a7737-bcda-4761-ae89-d85880f4bce3",
-		},
+	if err == flag.ErrHelp {
+		os.Exit(2)
 	}
 
-	jobBody, err := json.Marshal(startJobParams)
+	cerr.Log(err)
+	os.Exit(1)
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: sender <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-	if err != nil {
-		panic(err)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", name, commands[name].summary)
 	}
 
-	job := amqp.Publishing{Type: start.JobType, Body: jobBody}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run sender <command> -h for the command's flags")
+}
 
-	job.DeliveryMode = amqp.Persistent
-	job.ContentType = "application/json"
+// newFlagSet reports its own parse errors, which are returned as flag.ErrHelp
+// so that they aren't logged a second time
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet(name, flag.ContinueOnError)
+}
 
-	err = rabbitChannel.Publish("", queue.Name, true, false, job)
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return flag.ErrHelp
+	}
 
-	if err != nil {
-		panic(err)
+	if flags.NArg() > 0 {
+		return usagef("Unexpected arguments %v", flags.Args())
 	}
+
+	return nil
 }
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"chord-paper-be-workers/src/application/admin"
+	"chord-paper-be-workers/src/application/jobs/job_message"
+	"chord-paper-be-workers/src/application/outbox"
+	"chord-paper-be-workers/src/application/publish"
+	"chord-paper-be-workers/src/application/retry"
+	"chord-paper-be-workers/src/application/tracks/entity"
+	trackstore "chord-paper-be-workers/src/application/tracks/store"
+	"chord-paper-be-workers/src/lib/cerr"
+	"chord-paper-be-workers/src/lib/env"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/streadway/amqp"
+)
+
+const movedToDeadLetterError = "Moved to the dead letter queue by an operator"
+
+type peekedMessage struct {
+	MessageID         string      `json:"message_id"`
+	JobType           string      `json:"job_type"`
+	Attempt           int         `json:"attempt"`
+	Redelivered       bool        `json:"redelivered"`
+	LastError         interface{} `json:"last_error,omitempty"`
+	LastErrorCategory interface{} `json:"last_error_category,omitempty"`
+	// the raw body when it isn't JSON
+	Params interface{} `json:"params"`
+}
+
+// openQueue checks that the queue exists rather than declaring it,
+// so that a typo doesn't leave an empty queue behind
+func (b broker) openQueue(ctx context.Context, name string) (*amqp.Channel, amqp.Queue, error) {
+	queueName, err := b.queueName(name)
+	if err != nil {
+		return nil, amqp.Queue{}, err
+	}
+
+	channel, err := b.conn.Channel(ctx)
+	if err != nil {
+		return nil, amqp.Queue{}, cerr.Wrap(err).Error("Failed to open channel")
+	}
+
+	queue, err := channel.QueueDeclarePassive(queueName, true, false, false, false, nil)
+	if err != nil {
+		_ = channel.Close()
+		return nil, amqp.Queue{}, cerr.Field("queue_name", queueName).Wrap(err).Error("Failed to find queue")
+	}
+
+	return channel, queue, nil
+}
+
+// peek takes messages without acking them, they all go back on the queue in order when the channel closes
+func peek(ctx context.Context, args []string) error {
+	flags := newFlagSet("peek")
+	queueFlag := flags.String("queue", deadQueue, fmt.Sprintf("%q or the stage of a job queue", deadQueue))
+	limit := flags.Int("n", 10, "how many messages to show at most")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	b, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	channel, queue, err := b.openQueue(ctx, *queueFlag)
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	fmt.Fprintf(os.Stderr, "%d messages on %s\n", queue.Messages, queue.Name)
+
+	encoder := json.NewEncoder(os.Stdout)
+	for i := 0; i < *limit; i++ {
+		delivery, ok, err := channel.Get(queue.Name, false)
+		if err != nil {
+			return cerr.Field("queue_name", queue.Name).Wrap(err).Error("Failed to get message")
+		}
+
+		if !ok {
+			break
+		}
+
+		var params interface{} = string(delivery.Body)
+		if json.Valid(delivery.Body) {
+			params = json.RawMessage(delivery.Body)
+		}
+
+		err = encoder.Encode(peekedMessage{
+			MessageID:         delivery.MessageId,
+			JobType:           delivery.Type,
+			Attempt:           retry.Attempt(delivery),
+			Redelivered:       delivery.Redelivered,
+			LastError:         delivery.Headers[retry.ErrorHeader],
+			LastErrorCategory: delivery.Headers[retry.ErrorCategoryHeader],
+			Params:            params,
+		})
+		if err != nil {
+			return cerr.Wrap(err).Error("Failed to print message")
+		}
+	}
+
+	return nil
+}
+
+func purge(ctx context.Context, args []string) error {
+	flags := newFlagSet("purge")
+	queueFlag := flags.String("queue", "", fmt.Sprintf("%q or the stage of a job queue", deadQueue))
+	confirmed := flags.Bool("yes", false, "confirm that the messages should be deleted")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if *queueFlag == "" {
+		return usagef("-queue is required")
+	}
+
+	if !*confirmed {
+		return usagef("Purging can't be undone, pass -yes to go ahead")
+	}
+
+	b, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	channel, queue, err := b.openQueue(ctx, *queueFlag)
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	purged, err := channel.QueuePurge(queue.Name, false)
+	if err != nil {
+		return cerr.Field("queue_name", queue.Name).Wrap(err).Error("Failed to purge queue")
+	}
+
+	fmt.Printf("Purged %d messages from %s\n", purged, queue.Name)
+	return nil
+}
+
+// move takes dead letters back to their job queues, starting their attempts over and their errored tracks with them,
+// or takes messages off a job queue to the dead letter queue
+func move(ctx context.Context, args []string) error {
+	flags := newFlagSet("move")
+	from := flags.String("from", deadQueue, fmt.Sprintf("%q to move dead letters back to their job queues, or the stage of a job queue to move to the dead letter queue", deadQueue))
+	jobType := flags.String("type", "", "only move messages of this job type")
+	limit := flags.Int("n", 0, "how many messages to move at most, 0 for all of them")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	b, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	channel, queue, err := b.openQueue(ctx, *from)
+	if err != nil {
+		return err
+	}
+	// skipped messages are never acked, closing the channel puts them back
+	defer channel.Close()
+
+	var send func(ctx context.Context, delivery amqp.Delivery) error
+	if *from == deadQueue {
+		publisher := publish.NewRoutingPublisher(b.conn, b.topology)
+		trackStore := trackstore.NewDynamoDBTrackStore(env.Get())
+		jobs := outbox.NewOutbox(trackStore, publisher)
+
+		send = func(ctx context.Context, delivery amqp.Delivery) error {
+			return requeue(ctx, trackStore, jobs, publisher, revive(delivery))
+		}
+	} else {
+		publisher := publish.NewRabbitMQPublisher(b.conn, b.topology.DeadLetterQueue())
+
+		send = func(ctx context.Context, delivery amqp.Delivery) error {
+			return publisher.Publish(ctx, deadLetter(delivery))
+		}
+	}
+
+	moved := 0
+	for *limit == 0 || moved < *limit {
+		if err := ctx.Err(); err != nil {
+			return cerr.Field("moved", moved).Wrap(err).Error("Stopped moving messages")
+		}
+
+		delivery, ok, err := channel.Get(queue.Name, false)
+		if err != nil {
+			return cerr.Field("queue_name", queue.Name).Field("moved", moved).
+				Wrap(err).Error("Failed to get message")
+		}
+
+		if !ok {
+			break
+		}
+
+		if *jobType != "" && delivery.Type != *jobType {
+			continue
+		}
+
+		errctx := cerr.Field("message_id", delivery.MessageId).Field("moved", moved)
+
+		if err := send(ctx, delivery); err != nil {
+			_ = delivery.Nack(false, true)
+			return errctx.Wrap(err).Error("Failed to move message")
+		}
+
+		// the message is on both queues if this fails, it keeps its message ID either way
+		if err := delivery.Ack(false); err != nil {
+			return errctx.Wrap(err).Error("Failed to ack moved message")
+		}
+
+		moved++
+	}
+
+	fmt.Printf("Moved %d messages from %s\n", moved, queue.Name)
+	return nil
+}
+
+// revive gives a dead letter a fresh set of attempts
+func revive(delivery amqp.Delivery) amqp.Publishing {
+	publishing := retry.ToPublishing(delivery)
+	delete(publishing.Headers, retry.AttemptHeader)
+	delete(publishing.Headers, retry.ErrorHeader)
+	delete(publishing.Headers, retry.ErrorCategoryHeader)
+
+	return publishing
+}
+
+// requeue puts a revived job's errored track back into processing, the same way retrying it through the admin API does,
+// otherwise the track would stay errored while its job runs. Tracks that aren't errored, e.g. because an operator
+// moved the job to the dead letter queue, only get the job
+func requeue(ctx context.Context, trackStore entity.TrackStore, jobs outbox.Outbox, publisher publish.Publisher, publishing amqp.Publishing) error {
+	var track job_message.TrackIdentifier
+	if err := json.Unmarshal(publishing.Body, &track); err != nil || track.TrackListID == "" || track.TrackID == "" {
+		return publisher.Publish(ctx, publishing)
+	}
+
+	err := admin.Enqueue(ctx, trackStore, jobs, track.TrackListID, track.TrackID, publishing, false)
+	if errors.Is(err, admin.ErrNotResumable) {
+		return publisher.Publish(ctx, publishing)
+	}
+
+	return err
+}
+
+func deadLetter(delivery amqp.Delivery) amqp.Publishing {
+	publishing := retry.ToPublishing(delivery)
+	publishing.Headers[retry.ErrorHeader] = movedToDeadLetterError
+
+	return publishing
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"chord-paper-be-workers/src/application/admin"
+	trackstore "chord-paper-be-workers/src/application/tracks/store"
+	"chord-paper-be-workers/src/lib/cerr"
+	"chord-paper-be-workers/src/lib/env"
+	"context"
+	"encoding/json"
+	"os"
+)
+
+func status(ctx context.Context, args []string) error {
+	flags := newFlagSet("status")
+	tracklistID := flags.String("tracklist-id", "", "the tracklist the track is in")
+	trackID := flags.String("track-id", "", "the track to show")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if *tracklistID == "" || *trackID == "" {
+		return usagef("Both -tracklist-id and -track-id are required")
+	}
+
+	trackStore := trackstore.NewDynamoDBTrackStore(env.Get())
+	track, err := trackStore.GetTrack(ctx, *tracklistID, *trackID)
+	if err != nil {
+		return cerr.Field("tracklist_id", *tracklistID).Field("track_id", *trackID).
+			Wrap(err).Error("Failed to get track")
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(admin.NewTrackResponse(track))
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"chord-paper-be-workers/src/application"
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	stop, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer cancel()
+
+	app := application.NewWatchdogApp()
+	if err := app.Start(stop); err != nil {
+		panic(err)
+	}
+}